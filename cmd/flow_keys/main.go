@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"whatsapp-gateway/internal/config"
+	"whatsapp-gateway/internal/flowdata"
+	"whatsapp-gateway/internal/whatsapp"
+)
+
+// Manages the key pair used by the WhatsApp Flows data endpoint.
+//
+//	go run ./cmd/flow_keys generate [-force]  create a new key pair
+//	go run ./cmd/flow_keys upload             register the public key with Meta
+//	go run ./cmd/flow_keys status             show the key registered with Meta
+//	go run ./cmd/flow_keys check              round-trip a ping through the local key
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatalf("usage: flow_keys generate [-force] | upload | status | check")
+	}
+
+	cfg := config.LoadConfig()
+	privatePath := cfg.FlowsPrivateKeyPath
+	publicPath := filepath.Join(filepath.Dir(privatePath), "flows_public.pem")
+
+	switch os.Args[1] {
+	case "generate":
+		force := len(os.Args) > 2 && os.Args[2] == "-force"
+		if _, err := os.Stat(privatePath); err == nil && !force {
+			log.Fatalf("%s already exists, pass -force to replace it (the new public key must be uploaded again)", privatePath)
+		}
+
+		privatePEM, publicPEM, err := flowdata.GenerateKeyPair()
+		if err != nil {
+			log.Fatalf("Failed to generate key pair: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(privatePath), 0o700); err != nil {
+			log.Fatalf("Failed to create key directory: %v", err)
+		}
+		if err := os.WriteFile(privatePath, privatePEM, 0o600); err != nil {
+			log.Fatalf("Failed to write private key: %v", err)
+		}
+		if err := os.WriteFile(publicPath, publicPEM, 0o644); err != nil {
+			log.Fatalf("Failed to write public key: %v", err)
+		}
+		log.Printf("Wrote %s and %s", privatePath, publicPath)
+		log.Println("Run 'flow_keys upload' to register the public key with Meta")
+
+	case "upload":
+		key, err := flowdata.LoadPrivateKey(privatePath)
+		if err != nil {
+			log.Fatalf("Failed to load private key: %v", err)
+		}
+		publicPEM, err := flowdata.PublicKeyPEM(key)
+		if err != nil {
+			log.Fatalf("Failed to derive public key: %v", err)
+		}
+
+		client := whatsapp.NewClient(cfg, nil)
+		if err := client.SetBusinessPublicKey(context.Background(), string(publicPEM)); err != nil {
+			log.Fatalf("Failed to upload public key: %v", err)
+		}
+		log.Println("Public key uploaded")
+
+	case "status":
+		client := whatsapp.NewClient(cfg, nil)
+		result, err := client.GetBusinessPublicKey(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to fetch public key: %v", err)
+		}
+		out, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(out))
+
+	case "check":
+		key, err := flowdata.LoadPrivateKey(privatePath)
+		if err != nil {
+			log.Fatalf("Failed to load private key: %v", err)
+		}
+
+		encrypted, clientSession, err := flowdata.EncryptRequest(&flowdata.Request{Version: "3.0", Action: flowdata.ActionPing}, &key.PublicKey)
+		if err != nil {
+			log.Fatalf("Failed to encrypt request: %v", err)
+		}
+		req, session, err := flowdata.DecryptRequest(encrypted, key)
+		if err != nil {
+			log.Fatalf("Failed to decrypt request: %v", err)
+		}
+		resp, err := flowdata.NewRegistry().Dispatch(req)
+		if err != nil {
+			log.Fatalf("Dispatch failed: %v", err)
+		}
+		body, err := session.EncryptResponse(resp)
+		if err != nil {
+			log.Fatalf("Failed to encrypt response: %v", err)
+		}
+
+		var decoded flowdata.Response
+		if err := clientSession.DecryptResponse(body, &decoded); err != nil {
+			log.Fatalf("Failed to decrypt response: %v", err)
+		}
+		log.Printf("Key pair OK, ping answered with %v", decoded.Data)
+
+	default:
+		log.Fatalf("unknown command '%s'", os.Args[1])
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"whatsapp-gateway/internal/config"
+	"whatsapp-gateway/internal/conversation"
+	"whatsapp-gateway/internal/database"
+)
+
+// Moves messages stored with the old wa_id/sender columns into
+// conversations, then drops those columns.
+//
+//	go run ./cmd/migrate_conversations [-batch 500] [-keep-legacy]
+func main() {
+	batch := flag.Int("batch", 500, "messages per transaction")
+	keepLegacy := flag.Bool("keep-legacy", false, "keep the wa_id and sender columns after backfilling")
+	flag.Parse()
+
+	cfg := config.LoadConfig()
+	database.InitGorm(cfg)
+	db := database.GormDB
+
+	if !conversation.HasLegacyColumns(db) {
+		log.Println("messages has no legacy columns, nothing to do")
+		return
+	}
+	if cfg.PhoneNumberID == "" {
+		log.Fatalf("PHONE_NUMBER_ID is required to assign conversations")
+	}
+
+	log.Println("Backfilling conversations...")
+	count, err := conversation.Backfill(db, cfg.PhoneNumberID, *batch)
+	if err != nil {
+		log.Fatalf("Backfill stopped after %d messages: %v", count, err)
+	}
+	log.Printf("Backfilled %d messages", count)
+
+	if *keepLegacy {
+		log.Println("Keeping legacy columns (-keep-legacy)")
+		return
+	}
+	if err := conversation.DropLegacyColumns(db); err != nil {
+		log.Fatalf("Failed to drop legacy columns: %v", err)
+	}
+	log.Println("Dropped messages.wa_id and messages.sender")
+	log.Println("DONE!")
+}
@@ -4,8 +4,14 @@ import (
 	"log"
 	"whatsapp-gateway/internal/api"
 	"whatsapp-gateway/internal/automation"
+	"whatsapp-gateway/internal/campaign"
 	"whatsapp-gateway/internal/config"
 	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/flowdata"
+	"whatsapp-gateway/internal/importer"
+	"whatsapp-gateway/internal/media"
+	"whatsapp-gateway/internal/scheduler"
+	"whatsapp-gateway/internal/sequence"
 	"whatsapp-gateway/internal/webhook"
 	"whatsapp-gateway/internal/whatsapp"
 	"whatsapp-gateway/internal/ws"
@@ -26,6 +32,7 @@ func main() {
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Next-Cursor")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -39,18 +46,43 @@ func main() {
 	go hub.Run()
 
 	whatsappClient := whatsapp.NewClient(cfg, hub)
-	automationEngine := automation.NewEngine(whatsappClient, hub)
-	webhookHandler := webhook.NewHandler(cfg, automationEngine, hub)
+	mediaLibrary := media.NewLibrary(whatsappClient, cfg)
+	automationEngine := automation.NewEngine(whatsappClient, hub, mediaLibrary)
+	webhookHandler := webhook.NewHandler(cfg, whatsappClient, automationEngine, hub)
 	dashboardHandler := api.NewDashboardHandler(whatsappClient)
-	contactHandler := api.NewContactHandler()
-	broadcastHandler := api.NewBroadcastHandler(whatsappClient, cfg)
+	contactHandler := api.NewContactHandler(cfg)
+	contactImporter := importer.NewImporter(cfg, hub)
+	contactImporter.Recover()
+	importHandler := api.NewImportHandler(contactImporter)
+	conversationHandler := api.NewConversationHandler()
+	searchHandler := api.NewSearchHandler()
+	campaignRunner := campaign.NewRunner(whatsappClient, cfg, mediaLibrary, hub)
+	campaignRunner.Recover()
+	sched := scheduler.NewScheduler(whatsappClient, cfg, mediaLibrary, campaignRunner, hub)
+	sched.Add(sequence.NewRunner(whatsappClient, cfg, mediaLibrary, automationEngine, hub))
+	sched.Start()
+	broadcastHandler := api.NewBroadcastHandler(whatsappClient, cfg, mediaLibrary, campaignRunner)
+	campaignHandler := api.NewCampaignHandler(campaignRunner)
 	automationHandler := api.NewAutomationHandler()
-	whatsappHandler := api.NewWhatsAppHandler(whatsappClient)
+	scheduledHandler := api.NewScheduledHandler(cfg)
+	sequenceHandler := api.NewSequenceHandler()
+	whatsappHandler := api.NewWhatsAppHandler(whatsappClient, mediaLibrary)
+	profileHandler := api.NewProfileHandler(whatsappClient, cfg)
+	linkHandler := api.NewLinkHandler(whatsappClient, cfg)
+	pricingHandler := api.NewPricingHandler()
+	consentHandler := api.NewConsentHandler()
+
+	flowRegistry := flowdata.NewRegistry()
+	flowdata.RegisterBuiltins(flowRegistry, sequence.TagsChanged)
+	flowDataHandler := api.NewFlowDataHandler(cfg, flowRegistry)
 
 	// Webhook Routes
 	r.GET("/webhook", webhookHandler.VerifyWebhook)
 	r.POST("/webhook", webhookHandler.HandleMessage)
 
+	// WhatsApp Flows data channel, called by the WhatsApp client
+	r.POST("/flows/data", flowDataHandler.HandleDataExchange)
+
 	// WebSocket Route
 	r.GET("/ws", func(c *gin.Context) {
 		hub.ServeWs(c.Writer, c.Request)
@@ -61,6 +93,7 @@ func main() {
 	{
 		apiGroup.GET("/messages", dashboardHandler.GetMessages)
 		apiGroup.POST("/send", dashboardHandler.SendMessage)
+		apiGroup.GET("/search", searchHandler.Search)
 
 		// CRM Routes
 		apiGroup.GET("/contacts", contactHandler.GetContacts)
@@ -68,13 +101,84 @@ func main() {
 		apiGroup.PUT("/contacts/:waId", contactHandler.UpdateContact)
 		apiGroup.DELETE("/contacts/:waId", contactHandler.DeleteContact)
 		apiGroup.GET("/contacts/export", contactHandler.ExportContacts)
+		apiGroup.POST("/contacts/import", importHandler.ImportContacts)
+		apiGroup.GET("/contacts/imports", importHandler.GetImports)
+		apiGroup.GET("/contacts/imports/:id", importHandler.GetImport)
+		apiGroup.GET("/contacts/imports/:id/rows", importHandler.GetImportRows)
+		apiGroup.GET("/contacts/:waId/messages", conversationHandler.GetContactMessages)
+		apiGroup.GET("/contacts/:waId/conversations", conversationHandler.GetContactConversations)
+
+		// Consent Routes
+		apiGroup.GET("/contacts/:waId/consent", consentHandler.GetContactConsent)
+		apiGroup.POST("/contacts/:waId/opt-out", consentHandler.OptOut)
+		apiGroup.POST("/contacts/:waId/opt-in", consentHandler.OptIn)
+		apiGroup.GET("/suppressions", consentHandler.GetSuppressions)
+		apiGroup.GET("/consent/export", consentHandler.ExportConsent)
+
+		// Conversation Routes
+		apiGroup.GET("/conversations", conversationHandler.GetConversations)
+		apiGroup.GET("/conversations/:id", conversationHandler.GetConversation)
+		apiGroup.GET("/conversations/:id/messages", conversationHandler.GetConversationMessages)
+		apiGroup.POST("/conversations/:id/read", conversationHandler.MarkConversationRead)
 
 		// Broadcast Routes
 		apiGroup.GET("/templates", broadcastHandler.GetTemplates)
 		apiGroup.GET("/templates/meta", broadcastHandler.GetTemplatesFromMeta)
 		apiGroup.POST("/templates/sync", broadcastHandler.SyncTemplates)
+		apiGroup.POST("/templates/:id/preview", broadcastHandler.PreviewTemplate)
+		apiGroup.PUT("/templates/:id/header-media", broadcastHandler.SetTemplateHeaderMedia)
 		apiGroup.POST("/broadcast", broadcastHandler.SendBroadcast)
 
+		// Campaign Routes
+		apiGroup.GET("/campaigns", campaignHandler.GetCampaigns)
+		apiGroup.POST("/campaigns", campaignHandler.CreateCampaign)
+		apiGroup.POST("/campaigns/audience/preview", campaignHandler.PreviewAudience)
+		apiGroup.GET("/campaigns/compare", campaignHandler.CompareCampaigns)
+		apiGroup.GET("/campaigns/:id", campaignHandler.GetCampaign)
+		apiGroup.GET("/campaigns/:id/recipients", campaignHandler.GetCampaignRecipients)
+		apiGroup.GET("/campaigns/:id/export", campaignHandler.ExportCampaign)
+		apiGroup.GET("/campaigns/:id/analytics", campaignHandler.GetCampaignFunnel)
+		apiGroup.GET("/campaigns/:id/analytics/timeseries", campaignHandler.GetCampaignTimeSeries)
+		apiGroup.GET("/campaigns/:id/variants", campaignHandler.GetCampaignVariants)
+		apiGroup.POST("/campaigns/:id/pause", campaignHandler.PauseCampaign)
+		apiGroup.POST("/campaigns/:id/resume", campaignHandler.ResumeCampaign)
+		apiGroup.POST("/campaigns/:id/cancel", campaignHandler.CancelCampaign)
+
+		// Chat Link (wa.me / QR) Routes
+		apiGroup.GET("/links", linkHandler.GetLinks)
+		apiGroup.POST("/links", linkHandler.CreateLink)
+		apiGroup.GET("/links/:id", linkHandler.GetLink)
+		apiGroup.PUT("/links/:id", linkHandler.UpdateLink)
+		apiGroup.DELETE("/links/:id", linkHandler.DeleteLink)
+		apiGroup.GET("/links/:id/qr", linkHandler.GetLinkQR)
+		apiGroup.GET("/links/:id/scans", linkHandler.GetLinkScans)
+
+		// Pricing Routes
+		apiGroup.GET("/pricing/rates", pricingHandler.GetRates)
+		apiGroup.PUT("/pricing/rates", pricingHandler.SetRate)
+		apiGroup.DELETE("/pricing/rates/:id", pricingHandler.DeleteRate)
+		apiGroup.GET("/pricing/events", pricingHandler.GetPricingEvents)
+		apiGroup.GET("/analytics/costs", pricingHandler.GetCosts)
+
+		// Scheduled Message Routes
+		apiGroup.GET("/scheduled-messages", scheduledHandler.GetScheduledMessages)
+		apiGroup.POST("/scheduled-messages", scheduledHandler.CreateScheduledMessage)
+		apiGroup.GET("/scheduled-messages/:id", scheduledHandler.GetScheduledMessage)
+		apiGroup.PUT("/scheduled-messages/:id", scheduledHandler.UpdateScheduledMessage)
+		apiGroup.DELETE("/scheduled-messages/:id", scheduledHandler.DeleteScheduledMessage)
+
+		// Sequence Routes
+		apiGroup.GET("/sequences", sequenceHandler.GetSequences)
+		apiGroup.POST("/sequences", sequenceHandler.CreateSequence)
+		apiGroup.GET("/sequences/:id", sequenceHandler.GetSequence)
+		apiGroup.PUT("/sequences/:id", sequenceHandler.UpdateSequence)
+		apiGroup.DELETE("/sequences/:id", sequenceHandler.DeleteSequence)
+		apiGroup.POST("/sequences/:id/enroll", sequenceHandler.EnrollContacts)
+		apiGroup.GET("/sequences/:id/enrollments", sequenceHandler.GetEnrollments)
+		apiGroup.GET("/sequences/:id/enrollments/:enrollmentId", sequenceHandler.GetEnrollment)
+		apiGroup.POST("/sequences/:id/enrollments/:enrollmentId/exit", sequenceHandler.ExitEnrollment)
+		apiGroup.GET("/sequences/:id/report", sequenceHandler.GetSequenceReport)
+
 		// Automation Routes
 		apiGroup.GET("/automation/rules", automationHandler.GetRules)
 		apiGroup.POST("/automation/rules", automationHandler.CreateRule)
@@ -95,13 +199,26 @@ func main() {
 			whatsappGroup.POST("/send", whatsappHandler.SendMessage)
 			whatsappGroup.POST("/media", whatsappHandler.UploadMedia)
 			whatsappGroup.GET("/media", whatsappHandler.ListMedia)
+			whatsappGroup.GET("/media/folders", whatsappHandler.ListMediaFolders)
 			whatsappGroup.GET("/media/:id", whatsappHandler.RetrieveMediaURL)
+			whatsappGroup.PUT("/media/:id", whatsappHandler.UpdateMedia)
 			whatsappGroup.GET("/media/:id/proxy", whatsappHandler.DownloadMediaProxy)
+			whatsappGroup.GET("/media/:id/thumbnail", whatsappHandler.GetMediaThumbnail)
+			whatsappGroup.GET("/media/:id/usage", whatsappHandler.GetMediaUsage)
 			whatsappGroup.DELETE("/media/:id", whatsappHandler.DeleteMedia)
 			whatsappGroup.GET("/templates", whatsappHandler.GetTemplates)
 			whatsappGroup.POST("/templates", whatsappHandler.CreateTemplate)
 			whatsappGroup.DELETE("/templates", whatsappHandler.DeleteTemplate)
 
+			// Business Profile & Phone Number Routes
+			whatsappGroup.GET("/profile", profileHandler.GetProfile)
+			whatsappGroup.PUT("/profile", profileHandler.UpdateProfile)
+			whatsappGroup.POST("/profile/photo", profileHandler.UploadProfilePhoto)
+			whatsappGroup.GET("/profile/history", profileHandler.GetProfileHistory)
+			whatsappGroup.GET("/phone-numbers", profileHandler.GetPhoneNumbers)
+			whatsappGroup.GET("/phone-numbers/:id", profileHandler.GetPhoneNumber)
+			whatsappGroup.POST("/phone-numbers/:id/pin", profileHandler.SetPhoneNumberPin)
+
 			// Local Flow Routes
 			whatsappGroup.GET("/flows/local", whatsappHandler.GetLocalFlows)
 			whatsappGroup.POST("/flows/local", whatsappHandler.SaveLocalFlow)
@@ -111,10 +228,13 @@ func main() {
 			// WhatsApp Flow Routes
 			whatsappGroup.GET("/flows", whatsappHandler.GetFlows)
 			whatsappGroup.POST("/flows", whatsappHandler.CreateFlow)
+			whatsappGroup.POST("/flows/validate", whatsappHandler.ValidateFlowJSON)
 			whatsappGroup.GET("/flows/:id", whatsappHandler.GetFlow)
 			whatsappGroup.POST("/flows/:id", whatsappHandler.UpdateFlowMetadata)
 			whatsappGroup.POST("/flows/:id/assets", whatsappHandler.UploadFlowJSON)
 			whatsappGroup.POST("/flows/:id/publish", whatsappHandler.PublishFlow)
+			whatsappGroup.POST("/flows/:id/send", whatsappHandler.SendFlow)
+			whatsappGroup.GET("/flows/:id/submissions", whatsappHandler.GetFlowSubmissions)
 			whatsappGroup.DELETE("/flows/:id", whatsappHandler.DeleteFlow)
 		}
 	}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+	"whatsapp-gateway/internal/automation"
 	"whatsapp-gateway/internal/database"
 	"whatsapp-gateway/internal/models"
 
@@ -43,6 +44,11 @@ func (h *AutomationHandler) CreateRule(c *gin.Context) {
 		return
 	}
 
+	if err := automation.ValidateActions(string(req.Actions)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	rule := models.AutomationRule{
 		Name:       req.Name,
 		Type:       req.Type,
@@ -88,6 +94,10 @@ func (h *AutomationHandler) UpdateRule(c *gin.Context) {
 		updateData["conditions"] = string(req.Conditions)
 	}
 	if len(req.Actions) > 0 {
+		if err := automation.ValidateActions(string(req.Actions)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		updateData["actions"] = string(req.Actions)
 	}
 
@@ -202,7 +212,7 @@ func (h *AutomationHandler) GetSessionMessages(c *gin.Context) {
 	startedAtStr := c.Query("started_at")
 
 	// Filter messages for this contact (both sent and received)
-	query := database.GormDB.Where("wa_id = ? OR sender = ?", waID, waID)
+	query := database.GormDB.Where("contact_wa_id = ?", waID)
 
 	if startedAtStr != "" {
 		startedAt, err := time.Parse(time.RFC3339, startedAtStr)
@@ -2,23 +2,30 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"whatsapp-gateway/internal/campaign"
 	"whatsapp-gateway/internal/config"
 	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/media"
 	"whatsapp-gateway/internal/models"
 	"whatsapp-gateway/internal/whatsapp"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
 )
 
 type BroadcastHandler struct {
-	Client *whatsapp.Client
-	Config *config.Config
+	Client    whatsapp.Messenger
+	Config    *config.Config
+	Media     *media.Library
+	Campaigns *campaign.Runner
 }
 
-func NewBroadcastHandler(client *whatsapp.Client, cfg *config.Config) *BroadcastHandler {
-	return &BroadcastHandler{Client: client, Config: cfg}
+func NewBroadcastHandler(client whatsapp.Messenger, cfg *config.Config, library *media.Library, campaigns *campaign.Runner) *BroadcastHandler {
+	return &BroadcastHandler{Client: client, Config: cfg, Media: library, Campaigns: campaigns}
 }
 
 // SyncTemplates fetches templates from Meta and stores them locally
@@ -29,7 +36,7 @@ func (h *BroadcastHandler) SyncTemplates(c *gin.Context) {
 	}
 
 	// Fetch from Meta API
-	rawTemplates, err := h.Client.GetTemplates()
+	rawTemplates, err := h.Client.GetTemplates(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch templates from Meta: " + err.Error()})
 		return
@@ -89,8 +96,11 @@ func (h *BroadcastHandler) SyncTemplates(c *gin.Context) {
 			Components: componentsJSON,
 		}
 
-		// Upsert into database
-		if err := database.GormDB.Save(&template).Error; err != nil {
+		// Upsert into database, keeping the default header media set locally
+		if err := database.GormDB.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"name", "language", "category", "status", "components"}),
+		}).Create(&template).Error; err != nil {
 			log.Printf("Error saving template %s: %v", name, err)
 			continue
 		}
@@ -107,7 +117,7 @@ func (h *BroadcastHandler) GetTemplatesFromMeta(c *gin.Context) {
 		return
 	}
 
-	templates, err := h.Client.GetTemplates()
+	templates, err := h.Client.GetTemplates(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -126,12 +136,92 @@ func (h *BroadcastHandler) GetTemplates(c *gin.Context) {
 	c.JSON(http.StatusOK, templates)
 }
 
+// PreviewTemplate renders a stored template with sample parameters or a
+// contact's values. The body is optional; wa_id alone fills the contact's
+// {{name}}, {{first_name}}, {{phone}} and {{wa_id}} placeholders.
+func (h *BroadcastHandler) PreviewTemplate(c *gin.Context) {
+	id := c.Param("id")
+
+	var req struct {
+		Parameters whatsapp.TemplateParams `json:"parameters"`
+		WaID       string                  `json:"wa_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.WaID == "" {
+		req.WaID = c.Query("wa_id")
+	}
+
+	var template models.Template
+	if err := database.GormDB.First(&template, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+
+	params := req.Parameters.WithDefaultHeaderMedia(template)
+	if req.WaID != "" {
+		var contact models.Contact
+		if err := database.GormDB.Where("wa_id = ?", req.WaID).First(&contact).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Contact not found"})
+			return
+		}
+		params = params.WithContact(contact)
+	}
+
+	rendered, err := whatsapp.RenderTemplate(template, params)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, rendered)
+}
+
+// SetTemplateHeaderMedia sets the library asset used as a template's default header media
+func (h *BroadcastHandler) SetTemplateHeaderMedia(c *gin.Context) {
+	var req struct {
+		MediaRef string `json:"media_ref"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.MediaRef != "" {
+		stored, err := h.Media.Find(req.MediaRef)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Media not found in library"})
+			return
+		}
+		req.MediaRef = fmt.Sprintf("%d", stored.ID)
+	}
+
+	result := database.GormDB.Model(&models.Template{}).Where("id = ?", c.Param("id")).Update("header_media_ref", req.MediaRef)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "Template header media updated", "media_ref": req.MediaRef})
+}
+
 type BroadcastRequest struct {
-	TemplateName string   `json:"template_name"`
-	Language     string   `json:"language"`
-	Contacts     []string `json:"contacts"` // List of WA IDs
+	TemplateName string                   `json:"template_name"`
+	Language     string                   `json:"language"`
+	Contacts     []string                 `json:"contacts"`   // List of WA IDs
+	Parameters   *whatsapp.TemplateParams `json:"parameters"` // Optional, may use {{contact.name}} / {{contact.phone}}
+	Campaign     string                   `json:"campaign"`   // Campaign name, defaults to the template name
+	ABTest       *campaign.ABTest         `json:"ab_test"`    // Optional, two variants tested before sending the winner
 }
 
+// SendBroadcast queues the template for the contacts as a campaign and
+// returns immediately; progress is reported on the campaign and over the hub
 func (h *BroadcastHandler) SendBroadcast(c *gin.Context) {
 	var req BroadcastRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -139,21 +229,26 @@ func (h *BroadcastHandler) SendBroadcast(c *gin.Context) {
 		return
 	}
 
-	// Iterate and send (in a real app, use a queue)
-	successCount := 0
-	for _, waID := range req.Contacts {
-		// logic to send template message via Client
-		err := h.Client.SendTemplateMessage(waID, req.TemplateName, req.Language)
-		if err == nil {
-			successCount++
-		} else {
-			log.Printf("Failed to broadcast to %s: %v", waID, err)
-		}
+	camp, err := campaign.Create(campaign.Request{
+		Name:         req.Campaign,
+		TemplateName: req.TemplateName,
+		Language:     req.Language,
+		Parameters:   req.Parameters,
+		Audience:     campaign.Audience{Contacts: req.Contacts},
+		ABTest:       req.ABTest,
+	})
+	if isCampaignRequestError(err) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
+	h.Campaigns.Start(camp.ID)
 
-	c.JSON(http.StatusOK, gin.H{
-		"status":  "Broadcast processed",
-		"sent_to": successCount,
-		"total":   len(req.Contacts),
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":      "Broadcast queued",
+		"campaign_id": camp.ID,
 	})
 }
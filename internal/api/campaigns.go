@@ -0,0 +1,392 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"whatsapp-gateway/internal/campaign"
+	"whatsapp-gateway/internal/consent"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/localtime"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/whatsapp"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CampaignHandler struct {
+	Runner *campaign.Runner
+}
+
+func NewCampaignHandler(runner *campaign.Runner) *CampaignHandler {
+	return &CampaignHandler{Runner: runner}
+}
+
+type CampaignRequest struct {
+	Name         string                   `json:"name"` // Defaults to the template name
+	TemplateName string                   `json:"template_name"`
+	Language     string                   `json:"language"`
+	Audience     campaign.Audience        `json:"audience"`
+	Contacts     []string                 `json:"contacts"`   // Shorthand for audience.contacts
+	Parameters   *whatsapp.TemplateParams `json:"parameters"` // May use {{contact.name}} / {{contact.phone}}
+	// MessagesPerSecond sends this campaign slower than CAMPAIGN_MESSAGES_PER_SECOND
+	MessagesPerSecond int `json:"messages_per_second"`
+	// ABTest replaces template_name and parameters with two variants
+	ABTest *campaign.ABTest `json:"ab_test"`
+	// LocalTime (HH:MM) sends to each contact at that time in their timezone
+	LocalTime        string `json:"local_time"`
+	IgnoreQuietHours bool   `json:"ignore_quiet_hours"`
+}
+
+// CreateCampaign stores a campaign and starts sending it in the background.
+// The body is JSON, or multipart with the JSON in "campaign" and a CSV of
+// phone numbers in "file" that is added to the audience's contacts.
+func (h *CampaignHandler) CreateCampaign(c *gin.Context) {
+	var req CampaignRequest
+	if err := bindWithCSV(c, "campaign", &req, &req.Audience.Contacts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.TemplateName == "" && req.ABTest == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "template_name or ab_test is required"})
+		return
+	}
+	req.Audience.Contacts = append(req.Audience.Contacts, req.Contacts...)
+
+	camp, err := campaign.Create(campaign.Request{
+		Name:              req.Name,
+		TemplateName:      req.TemplateName,
+		Language:          req.Language,
+		Parameters:        req.Parameters,
+		Audience:          req.Audience,
+		MessagesPerSecond: req.MessagesPerSecond,
+		ABTest:            req.ABTest,
+		LocalTime:         req.LocalTime,
+		IgnoreQuietHours:  req.IgnoreQuietHours,
+	})
+	if isCampaignRequestError(err) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.Runner.Start(camp.ID)
+
+	c.JSON(http.StatusAccepted, camp)
+}
+
+// isCampaignRequestError reports whether campaign.Create refused the request
+func isCampaignRequestError(err error) bool {
+	return errors.Is(err, campaign.ErrInvalidAudience) || errors.Is(err, campaign.ErrInvalidABTest) ||
+		err == campaign.ErrTemplateNotSynced || err == campaign.ErrInvalidRate || errors.Is(err, localtime.ErrInvalidClock)
+}
+
+// PreviewAudience resolves an audience without sending anything and returns
+// its size and a sample. ?template= applies that template's category
+// suppressions. Accepts JSON, or multipart with "audience" and "file".
+func (h *CampaignHandler) PreviewAudience(c *gin.Context) {
+	var audience campaign.Audience
+	if err := bindWithCSV(c, "audience", &audience, &audience.Contacts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	category := ""
+	if name := c.Query("template"); name != "" {
+		category = consent.TemplateCategory(name)
+	}
+	res, err := campaign.Resolve(audience, category)
+	if errors.Is(err, campaign.ErrInvalidAudience) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sampleIDs := res.WaIDs[:min(10, len(res.WaIDs))]
+	var found []models.Contact
+	database.GormDB.Where("wa_id IN ?", sampleIDs).Find(&found)
+	byID := make(map[string]models.Contact, len(found))
+	for _, contact := range found {
+		byID[contact.WaID] = contact
+	}
+	sample := make([]models.Contact, 0, len(sampleIDs))
+	for _, waID := range sampleIDs {
+		contact, ok := byID[waID]
+		if !ok {
+			contact = models.Contact{WaID: waID}
+		}
+		sample = append(sample, contact)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count":      len(res.WaIDs),
+		"suppressed": len(res.Suppressed),
+		"duplicates": res.Duplicates,
+		"invalid":    res.Invalid,
+		"sample":     sample,
+	})
+}
+
+// bindWithCSV binds a JSON body into dst. For multipart requests the JSON is
+// read from the form field and the numbers of an uploaded CSV "file" are
+// appended to contacts.
+func bindWithCSV(c *gin.Context, field string, dst interface{}, contacts *[]string) error {
+	if !strings.HasPrefix(c.ContentType(), "multipart/") {
+		return c.ShouldBindJSON(dst)
+	}
+	if raw := c.PostForm(field); raw != "" {
+		if err := json.Unmarshal([]byte(raw), dst); err != nil {
+			return fmt.Errorf("invalid %s: %v", field, err)
+		}
+	}
+	file, _, err := c.Request.FormFile("file")
+	if err == http.ErrMissingFile {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	numbers, err := campaign.ParseContactsCSV(file)
+	if err != nil {
+		return fmt.Errorf("invalid CSV: %v", err)
+	}
+	*contacts = append(*contacts, numbers...)
+	return nil
+}
+
+// GetCampaigns lists campaigns, newest first, optionally by ?status=
+func (h *CampaignHandler) GetCampaigns(c *gin.Context) {
+	query := database.GormDB.Order("created_at DESC").Limit(200)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var campaigns []models.Campaign
+	if err := query.Find(&campaigns).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, campaigns)
+}
+
+// GetCampaign returns a campaign with its recipient count per status
+func (h *CampaignHandler) GetCampaign(c *gin.Context) {
+	var camp models.Campaign
+	if err := database.GormDB.Preload("Variants").First(&camp, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+		return
+	}
+	counts, err := campaign.StatusCounts(camp.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"campaign": camp, "recipients": counts})
+}
+
+// GetCampaignRecipients pages through a campaign's recipients with
+// ?status=, ?limit= (default 100, max 1000) and ?offset=
+func (h *CampaignHandler) GetCampaignRecipients(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	query := database.GormDB.Where("campaign_id = ?", c.Param("id"))
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var recipients []models.CampaignRecipient
+	if err := query.Order("id").Limit(limit).Offset(offset).Find(&recipients).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, recipients)
+}
+
+// GetCampaignFunnel returns the campaign's funnel: sent, delivered, read,
+// replied, clicked and opted out, with rates and failures by error code
+func (h *CampaignHandler) GetCampaignFunnel(c *gin.Context) {
+	var camp models.Campaign
+	if err := database.GormDB.First(&camp, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+		return
+	}
+	funnel, err := campaign.GetFunnel(&camp)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, funnel)
+}
+
+// GetCampaignTimeSeries returns the campaign's events per ?interval= hour
+// (default) or day, for charts
+func (h *CampaignHandler) GetCampaignTimeSeries(c *gin.Context) {
+	var camp models.Campaign
+	if err := database.GormDB.First(&camp, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+		return
+	}
+	points, err := campaign.TimeSeries(camp.ID, c.DefaultQuery("interval", "hour"))
+	if err == campaign.ErrUnknownInterval {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, points)
+}
+
+// GetCampaignVariants returns the results of an A/B tested campaign per
+// variant, scored by the campaign's winner metric
+func (h *CampaignHandler) GetCampaignVariants(c *gin.Context) {
+	var camp models.Campaign
+	if err := database.GormDB.First(&camp, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+		return
+	}
+	results, err := campaign.VariantResults(&camp)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"metric":            camp.WinnerMetric,
+		"test_percent":      camp.TestPercent,
+		"test_ends_at":      camp.TestEndsAt,
+		"winner_variant_id": camp.WinnerVariantID,
+		"variants":          results,
+	})
+}
+
+// CompareCampaigns returns the funnels of ?ids=1,2,3 side by side, or of the
+// 10 latest campaigns without ids
+func (h *CampaignHandler) CompareCampaigns(c *gin.Context) {
+	var ids []uint
+	if raw := c.Query("ids"); raw != "" {
+		for _, part := range strings.Split(raw, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ids must be comma separated campaign IDs"})
+				return
+			}
+			ids = append(ids, uint(id))
+		}
+	} else {
+		database.GormDB.Model(&models.Campaign{}).Order("created_at DESC").Limit(10).Pluck("id", &ids)
+	}
+	if len(ids) > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "compare at most 50 campaigns"})
+		return
+	}
+
+	funnels, err := campaign.Compare(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, funnels)
+}
+
+// PauseCampaign stops sending after the message in flight. An optional
+// {"reason": "..."} is stored with the campaign.
+func (h *CampaignHandler) PauseCampaign(c *gin.Context) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Reason == "" {
+		req.Reason = "paused by user"
+	}
+	h.transition(c, func(id uint) (*models.Campaign, error) { return h.Runner.Pause(id, req.Reason) })
+}
+
+// ResumeCampaign continues a paused campaign with its pending recipients
+func (h *CampaignHandler) ResumeCampaign(c *gin.Context) {
+	h.transition(c, h.Runner.Resume)
+}
+
+// CancelCampaign stops a campaign for good; unsent recipients are cancelled
+func (h *CampaignHandler) CancelCampaign(c *gin.Context) {
+	h.transition(c, h.Runner.Cancel)
+}
+
+func (h *CampaignHandler) transition(c *gin.Context, apply func(id uint) (*models.Campaign, error)) {
+	var camp models.Campaign
+	if err := database.GormDB.First(&camp, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+		return
+	}
+	updated, err := apply(camp.ID)
+	if err == campaign.ErrNotRunning || err == campaign.ErrNotPaused || err == campaign.ErrFinished {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": camp.Status})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// ExportCampaign downloads the per-recipient results of a campaign as CSV
+func (h *CampaignHandler) ExportCampaign(c *gin.Context) {
+	var camp models.Campaign
+	if err := database.GormDB.First(&camp, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename=campaign_"+strconv.Itoa(int(camp.ID))+".csv")
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"WhatsApp ID", "Status", "Message ID", "Sent At", "Delivered At", "Read At", "Replied At", "Clicked At", "Opted Out At", "Error Code", "Error"})
+	var batch []models.CampaignRecipient
+	database.GormDB.Where("campaign_id = ?", camp.ID).Order("id").FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+		for _, r := range batch {
+			errorCode := ""
+			if r.ErrorCode != 0 {
+				errorCode = strconv.Itoa(r.ErrorCode)
+			}
+			w.Write([]string{
+				r.ContactWaID, r.Status, r.WamID,
+				csvTime(r.SentAt), csvTime(r.DeliveredAt), csvTime(r.ReadAt), csvTime(r.RepliedAt), csvTime(r.ClickedAt), csvTime(r.OptedOutAt),
+				errorCode, r.Error,
+			})
+		}
+		return nil
+	})
+	w.Flush()
+}
+
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/csv"
+	"net/http"
+	"time"
+	"whatsapp-gateway/internal/consent"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ConsentHandler struct{}
+
+func NewConsentHandler() *ConsentHandler {
+	return &ConsentHandler{}
+}
+
+// GetContactConsent returns a contact's consent history and active suppressions
+func (h *ConsentHandler) GetContactConsent(c *gin.Context) {
+	waID := c.Param("waId")
+	history, err := consent.History(waID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var suppressions []models.Suppression
+	if err := database.GormDB.Where("contact_wa_id = ?", waID).Find(&suppressions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"history": history, "suppressions": suppressions})
+}
+
+type ConsentRequest struct {
+	Category string `json:"category"` // all (default) or a template category such as marketing
+	Note     string `json:"note"`
+}
+
+// OptOut suppresses a contact on their behalf, e.g. after a request by phone
+func (h *ConsentHandler) OptOut(c *gin.Context) {
+	h.change(c, consent.OptOut, "Contact opted out")
+}
+
+// OptIn records a contact's consent and lifts the matching suppression
+func (h *ConsentHandler) OptIn(c *gin.Context) {
+	h.change(c, consent.OptIn, "Contact opted in")
+}
+
+func (h *ConsentHandler) change(c *gin.Context, apply func(consent.Change) error, status string) {
+	var req ConsentRequest
+	// The body is optional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	err := apply(consent.Change{
+		WaID:     c.Param("waId"),
+		Category: req.Category,
+		Source:   consent.SourceAPI,
+		Note:     req.Note,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": status})
+}
+
+// GetSuppressions lists the suppression list, optionally for one ?category=
+func (h *ConsentHandler) GetSuppressions(c *gin.Context) {
+	query := database.GormDB.Order("created_at DESC")
+	if category := c.Query("category"); category != "" {
+		query = query.Where("category = ?", category)
+	}
+	var suppressions []models.Suppression
+	if err := query.Find(&suppressions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, suppressions)
+}
+
+// ExportConsent downloads the consent history as CSV for audits, optionally
+// limited to ?since= and ?until=
+func (h *ConsentHandler) ExportConsent(c *gin.Context) {
+	since, until, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query := database.GormDB.Order("created_at ASC, id ASC")
+	if since != nil {
+		query = query.Where("created_at >= ?", *since)
+	}
+	if until != nil {
+		query = query.Where("created_at < ?", *until)
+	}
+	var changes []models.Consent
+	if err := query.Find(&changes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename=consent_history.csv")
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"WhatsApp ID", "Channel", "Category", "Status", "Source", "Note", "Timestamp"})
+	for _, change := range changes {
+		w.Write([]string{
+			change.ContactWaID,
+			change.Channel,
+			change.Category,
+			change.Status,
+			change.Source,
+			change.Note,
+			change.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	w.Flush()
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/importer"
+	"whatsapp-gateway/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxImportSize is the largest contact file accepted
+const maxImportSize = 50 << 20
+
+type ImportHandler struct {
+	Importer *importer.Importer
+}
+
+func NewImportHandler(im *importer.Importer) *ImportHandler {
+	return &ImportHandler{Importer: im}
+}
+
+// ImportContacts starts importing an uploaded CSV or XLSX "file" in the
+// background. The multipart field "options" holds the JSON options, e.g.
+// {"mode": "merge", "default_country": "GB", "mapping": {"wa_id": "Mobile"}, "tags": ["fair"]}
+func (h *ImportHandler) ImportContacts(c *gin.Context) {
+	var opts importer.Options
+	if raw := c.PostForm("options"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &opts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid options: " + err.Error()})
+			return
+		}
+	}
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxImportSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(data) > maxImportSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file is larger than 50 MB"})
+		return
+	}
+
+	job, err := h.Importer.Start(header.Filename, data, opts)
+	if errors.Is(err, importer.ErrInvalidImport) || errors.Is(err, importer.ErrUnsupportedFormat) || err == importer.ErrNoPhoneColumn {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetImports lists contact imports, newest first
+func (h *ImportHandler) GetImports(c *gin.Context) {
+	var jobs []models.ContactImport
+	if err := database.GormDB.Order("created_at DESC").Limit(200).Find(&jobs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// GetImport returns an import with its progress
+func (h *ImportHandler) GetImport(c *gin.Context) {
+	var job models.ContactImport
+	if err := database.GormDB.First(&job, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// GetImportRows pages through the rows of an import that were skipped or
+// invalid, with the reason. Filter with ?status=, page with ?limit= and ?offset=
+func (h *ImportHandler) GetImportRows(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	query := database.GormDB.Where("import_id = ?", c.Param("id"))
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var rows []models.ContactImportRow
+	if err := query.Order("line").Limit(limit).Offset(offset).Find(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rows)
+}
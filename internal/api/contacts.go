@@ -3,16 +3,22 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"time"
+	"whatsapp-gateway/internal/config"
 	"whatsapp-gateway/internal/database"
 	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/phone"
+	"whatsapp-gateway/internal/sequence"
 
 	"github.com/gin-gonic/gin"
 )
 
-type ContactHandler struct{}
+type ContactHandler struct {
+	Config *config.Config
+}
 
-func NewContactHandler() *ContactHandler {
-	return &ContactHandler{}
+func NewContactHandler(cfg *config.Config) *ContactHandler {
+	return &ContactHandler{Config: cfg}
 }
 
 func (h *ContactHandler) GetContacts(c *gin.Context) {
@@ -26,8 +32,9 @@ func (h *ContactHandler) GetContacts(c *gin.Context) {
 }
 
 type UpdateContactRequest struct {
-	Name string `json:"name"`
-	Tags string `json:"tags"`
+	Name     string  `json:"name"`
+	Tags     string  `json:"tags"`
+	Timezone *string `json:"timezone"` // IANA name, e.g. Europe/Madrid; "" infers it from the phone number again
 }
 
 func (h *ContactHandler) UpdateContact(c *gin.Context) {
@@ -37,22 +44,41 @@ func (h *ContactHandler) UpdateContact(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	updates := map[string]interface{}{}
+	if req.Name != "" {
+		updates["name"] = req.Name
+	}
+	if req.Tags != "" {
+		updates["tags"] = req.Tags
+	}
+	if req.Timezone != nil {
+		if !validTimezone(c, *req.Timezone) {
+			return
+		}
+		updates["timezone"] = *req.Timezone
+	}
 
-	if err := database.GormDB.Model(&models.Contact{}).Where("wa_id = ?", waID).Updates(models.Contact{
-		Name: req.Name,
-		Tags: req.Tags,
-	}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update contact"})
-		return
+	var before models.Contact
+	database.GormDB.Where("wa_id = ?", waID).Limit(1).Find(&before)
+
+	if len(updates) > 0 {
+		if err := database.GormDB.Model(&models.Contact{}).Where("wa_id = ?", waID).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update contact"})
+			return
+		}
+	}
+	if req.Tags != "" {
+		tagsAdded(waID, before, req.Tags)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"status": "Contact updated"})
 }
 
 type CreateContactRequest struct {
-	WaID string `json:"wa_id" binding:"required"`
-	Name string `json:"name"`
-	Tags string `json:"tags"`
+	WaID     string `json:"wa_id" binding:"required"` // Normalized, national numbers use DEFAULT_COUNTRY
+	Name     string `json:"name"`
+	Tags     string `json:"tags"`
+	Timezone string `json:"timezone"` // IANA name, empty infers it from the phone number
 }
 
 func (h *ContactHandler) CreateContact(c *gin.Context) {
@@ -61,22 +87,36 @@ func (h *ContactHandler) CreateContact(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !validTimezone(c, req.Timezone) {
+		return
+	}
+	waID, err := phone.Normalize(req.WaID, h.Config.DefaultCountry)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid wa_id: " + err.Error()})
+		return
+	}
+
+	var before models.Contact
+	database.GormDB.Where("wa_id = ?", waID).Limit(1).Find(&before)
 
 	contact := models.Contact{
-		WaID: req.WaID,
-		Name: req.Name,
-		Tags: req.Tags,
+		WaID:     waID,
+		Name:     req.Name,
+		Tags:     req.Tags,
+		Timezone: req.Timezone,
 	}
 
-	// Use Save for upsert
-	if err := database.GormDB.Save(&contact).Error; err != nil {
+	// Use Save for upsert, keeping the service window of existing contacts
+	if err := database.GormDB.Omit("LastInboundAt").Save(&contact).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create contact"})
 		return
 	}
+	tagsAdded(waID, before, req.Tags)
 
-	c.JSON(http.StatusCreated, gin.H{"status": "Contact created", "wa_id": req.WaID})
+	c.JSON(http.StatusCreated, gin.H{"status": "Contact created", "wa_id": waID})
 }
 
+// DeleteContact removes a contact together with its conversations and messages
 func (h *ContactHandler) DeleteContact(c *gin.Context) {
 	waID := c.Param("waId")
 
@@ -111,3 +151,20 @@ func (h *ContactHandler) ExportContacts(c *gin.Context) {
 	c.Header("Content-Disposition", "attachment; filename=contacts.csv")
 	c.String(http.StatusOK, csv)
 }
+
+// tagsAdded lets sequences react to the tags a contact just got
+func tagsAdded(waID string, before models.Contact, tags string) {
+	sequence.TagsChanged(waID, before.TagList(), models.Contact{Tags: tags}.TagList())
+}
+
+// validTimezone answers 400 unless name is empty or a known IANA timezone
+func validTimezone(c *gin.Context, name string) bool {
+	if name == "" {
+		return true
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone " + name})
+		return false
+	}
+	return true
+}
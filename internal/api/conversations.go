@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+	"whatsapp-gateway/internal/conversation"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ConversationHandler struct{}
+
+func NewConversationHandler() *ConversationHandler {
+	return &ConversationHandler{}
+}
+
+// GetConversations lists conversations with their contact, most recent first.
+// ?unread=true limits the list to conversations with unread messages.
+func (h *ConversationHandler) GetConversations(c *gin.Context) {
+	query := database.GormDB.Preload("Contact").Order("last_message_at DESC NULLS LAST")
+	if c.Query("unread") == "true" {
+		query = query.Where("unread_count > 0")
+	}
+
+	var conversations []models.Conversation
+	if err := query.Limit(500).Find(&conversations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, conversations)
+}
+
+// GetConversation returns a conversation and its messages, oldest first
+func (h *ConversationHandler) GetConversation(c *gin.Context) {
+	var conv models.Conversation
+	if err := database.GormDB.Preload("Contact").First(&conv, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+
+	var messages []models.Message
+	if err := database.GormDB.Where("conversation_id = ?", conv.ID).Order("created_at ASC").Find(&messages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"conversation": conv, "messages": messages})
+}
+
+// MarkConversationRead resets the unread count of a conversation
+func (h *ConversationHandler) MarkConversationRead(c *gin.Context) {
+	var conv models.Conversation
+	if err := database.GormDB.First(&conv, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+	if err := conversation.MarkRead(conv.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Conversation marked as read"})
+}
+
+// GetConversationMessages pages through a conversation's messages, newest
+// first, with the same filters and cursor as GET /api/messages
+func (h *ConversationHandler) GetConversationMessages(c *gin.Context) {
+	var conv models.Conversation
+	if err := database.GormDB.First(&conv, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+
+	filter, err := parseMessageFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.ConversationID = conv.ID
+	writeMessagePage(c, filter)
+}
+
+// GetContactMessages pages through every message exchanged with a contact
+func (h *ConversationHandler) GetContactMessages(c *gin.Context) {
+	filter, err := parseMessageFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.ContactWaID = c.Param("waId")
+	writeMessagePage(c, filter)
+}
+
+// GetContactConversations lists a contact's conversations, one per business number
+func (h *ConversationHandler) GetContactConversations(c *gin.Context) {
+	var conversations []models.Conversation
+	if err := database.GormDB.Where("contact_wa_id = ?", c.Param("waId")).
+		Order("last_message_at DESC NULLS LAST").Find(&conversations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, conversations)
+}
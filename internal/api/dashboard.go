@@ -1,29 +1,112 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
-	"whatsapp-gateway/internal/database"
-	"whatsapp-gateway/internal/models"
+	"strconv"
+	"time"
+	"whatsapp-gateway/internal/conversation"
 	"whatsapp-gateway/internal/whatsapp"
 
 	"github.com/gin-gonic/gin"
 )
 
 type DashboardHandler struct {
-	Client *whatsapp.Client
+	Client whatsapp.Messenger
 }
 
-func NewDashboardHandler(client *whatsapp.Client) *DashboardHandler {
+func NewDashboardHandler(client whatsapp.Messenger) *DashboardHandler {
 	return &DashboardHandler{Client: client}
 }
 
+// GetMessages lists messages newest first, one page at a time. The response
+// is still a plain array; when there are more messages the X-Next-Cursor
+// header holds the value to pass as ?cursor= for the next page.
+//
+// Filters: contact, conversation_id, direction, type, status, since, until
+// (RFC3339 or YYYY-MM-DD) and limit (default 100, max 500).
 func (h *DashboardHandler) GetMessages(c *gin.Context) {
-	var messages []models.Message
-	if err := database.GormDB.Order("created_at desc").Find(&messages).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	filter, err := parseMessageFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	writeMessagePage(c, filter)
+}
+
+// parseMessageFilter reads the message listing filters from the query string
+func parseMessageFilter(c *gin.Context) (conversation.Filter, error) {
+	filter := conversation.Filter{
+		ContactWaID: c.Query("contact"),
+		Direction:   c.Query("direction"),
+		Type:        c.Query("type"),
+		Status:      c.Query("status"),
+	}
+	if filter.Direction != "" && filter.Direction != conversation.Inbound && filter.Direction != conversation.Outbound {
+		return filter, fmt.Errorf("direction must be inbound or outbound")
+	}
+	if raw := c.Query("conversation_id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid conversation_id")
+		}
+		filter.ConversationID = uint(id)
+	}
+	var err error
+	filter.Since, filter.Until, err = parseTimeRange(c)
+	return filter, err
+}
+
+// parseTimeRange reads the optional ?since= and ?until= bounds
+func parseTimeRange(c *gin.Context) (since, until *time.Time, err error) {
+	for _, bound := range []struct {
+		name   string
+		target **time.Time
+	}{{"since", &since}, {"until", &until}} {
+		raw := c.Query(bound.name)
+		if raw == "" {
+			continue
+		}
+		t, err := parseTimeParam(raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s, use RFC3339 or YYYY-MM-DD", bound.name)
+		}
+		*bound.target = &t
+	}
+	return since, until, nil
+}
+
+func parseTimeParam(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", raw)
+}
+
+// writeMessagePage responds with one page of messages matching filter
+func writeMessagePage(c *gin.Context, filter conversation.Filter) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit < 1 || limit > 500 {
+		limit = 100
+	}
 
+	var cursor *conversation.Cursor
+	if raw := c.Query("cursor"); raw != "" {
+		var err error
+		if cursor, err = conversation.DecodeCursor(raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	messages, next, err := conversation.ListMessages(filter, cursor, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if next != nil {
+		c.Header("X-Next-Cursor", next.Encode())
+	}
 	c.JSON(http.StatusOK, messages)
 }
 
@@ -39,10 +122,11 @@ func (h *DashboardHandler) SendMessage(c *gin.Context) {
 		return
 	}
 
-	if err := h.Client.SendMessage(req.To, req.Content); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message: " + err.Error()})
+	resp, err := h.Client.SendMessage(c.Request.Context(), req.To, req.Content)
+	if err != nil {
+		respondSendError(c, err, "Failed to send message: ")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "Message sent"})
+	c.JSON(http.StatusOK, sentResponse(resp))
 }
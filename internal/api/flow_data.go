@@ -0,0 +1,119 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"whatsapp-gateway/internal/config"
+	"whatsapp-gateway/internal/flowdata"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Status codes defined by the Flows data_exchange protocol
+const (
+	flowStatusDecryptionFailed  = 421
+	flowStatusInvalidFlowToken  = 427
+	flowStatusInvalidSignature  = 432
+	flowStatusUnexpectedFailure = http.StatusInternalServerError
+)
+
+// FlowDataHandler serves the data channel endpoint for WhatsApp Flows
+type FlowDataHandler struct {
+	Config     *config.Config
+	Registry   *flowdata.Registry
+	PrivateKey *rsa.PrivateKey
+}
+
+func NewFlowDataHandler(cfg *config.Config, registry *flowdata.Registry) *FlowDataHandler {
+	h := &FlowDataHandler{Config: cfg, Registry: registry}
+
+	key, err := flowdata.LoadPrivateKey(cfg.FlowsPrivateKeyPath)
+	if err != nil {
+		log.Printf("Warning: Flows data endpoint disabled, could not load private key: %v", err)
+	} else {
+		h.PrivateKey = key
+		if cfg.AppSecret == "" {
+			log.Printf("WARNING: APP_SECRET is not set, the Flows data endpoint accepts requests without verifying their signature. Set APP_SECRET in production.")
+		}
+	}
+	return h
+}
+
+// HandleDataExchange decrypts a request from the WhatsApp client, dispatches
+// it to the registered flow handler and returns the encrypted response
+func (h *FlowDataHandler) HandleDataExchange(c *gin.Context) {
+	if h.PrivateKey == nil {
+		c.JSON(flowStatusUnexpectedFailure, gin.H{"error": "Flows private key not configured"})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if h.Config.AppSecret != "" && !validSignature(h.Config.AppSecret, body, c.GetHeader("X-Hub-Signature-256")) {
+		c.Status(flowStatusInvalidSignature)
+		return
+	}
+
+	var encrypted flowdata.EncryptedRequest
+	if err := json.Unmarshal(body, &encrypted); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req, session, err := flowdata.DecryptRequest(encrypted, h.PrivateKey)
+	if err != nil {
+		log.Printf("[FlowData] %v", err)
+		if errors.Is(err, flowdata.ErrDecryption) {
+			c.Status(flowStatusDecryptionFailed)
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	resp, err := h.Registry.Dispatch(req)
+	if err != nil {
+		if errors.Is(err, flowdata.ErrInvalidFlowToken) {
+			c.JSON(flowStatusInvalidFlowToken, gin.H{"error_msg": "This flow is no longer available"})
+			return
+		}
+		log.Printf("[FlowData] Handler failed for %s/%s: %v", req.Action, req.Screen, err)
+		c.Status(flowStatusUnexpectedFailure)
+		return
+	}
+	if resp.Version == "" {
+		resp.Version = req.Version
+	}
+
+	encoded, err := session.EncryptResponse(resp)
+	if err != nil {
+		log.Printf("[FlowData] Failed to encrypt response: %v", err)
+		c.Status(flowStatusUnexpectedFailure)
+		return
+	}
+
+	c.String(http.StatusOK, encoded)
+}
+
+// validSignature checks Meta's X-Hub-Signature-256 header against the app secret
+func validSignature(secret string, body []byte, header string) bool {
+	signature, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
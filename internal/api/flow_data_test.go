@@ -0,0 +1,136 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"whatsapp-gateway/internal/config"
+	"whatsapp-gateway/internal/flowdata"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testAppSecret = "app-secret"
+
+func newTestFlowDataHandler(t *testing.T) *FlowDataHandler {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, flowdata.KeySize)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	registry := flowdata.NewRegistry()
+	registry.ResolveToken = func(token string) (flowdata.FlowToken, error) {
+		if token == "expired" {
+			return flowdata.FlowToken{}, flowdata.ErrInvalidFlowToken
+		}
+		return flowdata.ParseFlowToken(token), nil
+	}
+	registry.Register("survey", func(req *flowdata.Request, token flowdata.FlowToken) (*flowdata.Response, error) {
+		return flowdata.Screen("THANKS", map[string]interface{}{"name": req.Data["name"]}), nil
+	})
+	return &FlowDataHandler{Config: &config.Config{AppSecret: testAppSecret}, Registry: registry, PrivateKey: key}
+}
+
+func postFlowData(h *FlowDataHandler, body []byte, secret string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/flows/data", h.HandleDataExchange)
+
+	req := httptest.NewRequest(http.MethodPost, "/flows/data", bytes.NewReader(body))
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func encryptFlowRequest(t *testing.T, h *FlowDataHandler, req *flowdata.Request) ([]byte, *flowdata.Session) {
+	t.Helper()
+	encrypted, session, err := flowdata.EncryptRequest(req, &h.PrivateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("EncryptRequest: %v", err)
+	}
+	body, _ := json.Marshal(encrypted)
+	return body, session
+}
+
+func TestFlowDataPing(t *testing.T) {
+	h := newTestFlowDataHandler(t)
+	body, session := encryptFlowRequest(t, h, &flowdata.Request{Version: "3.0", Action: flowdata.ActionPing})
+
+	w := postFlowData(h, body, testAppSecret)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", w.Code, w.Body.String())
+	}
+	var resp flowdata.Response
+	if err := session.DecryptResponse(w.Body.String(), &resp); err != nil {
+		t.Fatalf("DecryptResponse: %v", err)
+	}
+	if resp.Data["status"] != "active" || resp.Version != "3.0" {
+		t.Fatalf("unexpected ping response %+v", resp)
+	}
+}
+
+func TestFlowDataExchange(t *testing.T) {
+	h := newTestFlowDataHandler(t)
+	body, session := encryptFlowRequest(t, h, &flowdata.Request{
+		Version:   "3.0",
+		Action:    flowdata.ActionDataExchange,
+		Screen:    "FORM",
+		Data:      map[string]interface{}{"name": "Ana"},
+		FlowToken: "survey:447700900123:ab",
+	})
+
+	w := postFlowData(h, body, testAppSecret)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", w.Code, w.Body.String())
+	}
+	var resp flowdata.Response
+	if err := session.DecryptResponse(w.Body.String(), &resp); err != nil {
+		t.Fatalf("DecryptResponse: %v", err)
+	}
+	if resp.Screen != "THANKS" || resp.Data["name"] != "Ana" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+}
+
+func TestFlowDataDecryptionFailed(t *testing.T) {
+	h := newTestFlowDataHandler(t)
+	other := newTestFlowDataHandler(t)
+	body, _ := encryptFlowRequest(t, other, &flowdata.Request{Action: flowdata.ActionPing})
+
+	if w := postFlowData(h, body, testAppSecret); w.Code != flowStatusDecryptionFailed {
+		t.Fatalf("got status %d, want %d", w.Code, flowStatusDecryptionFailed)
+	}
+}
+
+func TestFlowDataInvalidFlowToken(t *testing.T) {
+	h := newTestFlowDataHandler(t)
+	for _, token := range []string{"expired", "unknown:447700900123:ab"} {
+		body, _ := encryptFlowRequest(t, h, &flowdata.Request{Action: flowdata.ActionDataExchange, FlowToken: token})
+		if w := postFlowData(h, body, testAppSecret); w.Code != flowStatusInvalidFlowToken {
+			t.Errorf("token %q: got status %d, want %d", token, w.Code, flowStatusInvalidFlowToken)
+		}
+	}
+}
+
+func TestFlowDataInvalidSignature(t *testing.T) {
+	h := newTestFlowDataHandler(t)
+	body, _ := encryptFlowRequest(t, h, &flowdata.Request{Action: flowdata.ActionPing})
+
+	for name, secret := range map[string]string{"wrong secret": "other-secret", "unsigned": ""} {
+		if w := postFlowData(h, body, secret); w.Code != flowStatusInvalidSignature {
+			t.Errorf("%s: got status %d, want %d", name, w.Code, flowStatusInvalidSignature)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"whatsapp-gateway/internal/config"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/links"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/whatsapp"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LinkHandler struct {
+	Client whatsapp.Messenger
+	Config *config.Config
+}
+
+func NewLinkHandler(client whatsapp.Messenger, cfg *config.Config) *LinkHandler {
+	return &LinkHandler{Client: client, Config: cfg}
+}
+
+// linkResponse adds the wa.me URL and counts to a stored link
+type linkResponse struct {
+	models.ChatLink
+	URL   string      `json:"url"`
+	Stats links.Stats `json:"stats"`
+}
+
+func (h *LinkHandler) describe(ctx context.Context, link models.ChatLink) (linkResponse, error) {
+	resp := linkResponse{ChatLink: link}
+	phone, err := links.BusinessPhone(ctx, h.Client, h.Config)
+	if err != nil {
+		return resp, err
+	}
+	resp.URL = links.URL(phone, link.PrefilledText)
+	resp.Stats, err = links.StatsFor(&link)
+	return resp, err
+}
+
+type linkRequest struct {
+	Name          string `json:"name"`
+	Keyword       string `json:"keyword"`
+	PrefilledText string `json:"prefilled_text"`
+	RuleID        *uint  `json:"rule_id"`
+	FlowID        string `json:"flow_id"`
+}
+
+// GetLinks lists chat links with their URL and scan counts
+func (h *LinkHandler) GetLinks(c *gin.Context) {
+	var all []models.ChatLink
+	if err := database.GormDB.Order("created_at DESC").Find(&all).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]linkResponse, 0, len(all))
+	for _, link := range all {
+		resp, err := h.describe(c.Request.Context(), link)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		result = append(result, resp)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetLink returns a single chat link
+func (h *LinkHandler) GetLink(c *gin.Context) {
+	var link models.ChatLink
+	if err := database.GormDB.First(&link, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		return
+	}
+
+	resp, err := h.describe(c.Request.Context(), link)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// CreateLink creates a named chat link
+func (h *LinkHandler) CreateLink(c *gin.Context) {
+	var req linkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	link := models.ChatLink{
+		Name:          strings.TrimSpace(req.Name),
+		Keyword:       strings.TrimSpace(req.Keyword),
+		PrefilledText: strings.TrimSpace(req.PrefilledText),
+		RuleID:        req.RuleID,
+		FlowID:        req.FlowID,
+	}
+	if link.PrefilledText == "" {
+		link.PrefilledText = link.Keyword
+	}
+	if msg := validateLink(link, 0); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := database.GormDB.Create(&link).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := h.describe(c.Request.Context(), link)
+	if err != nil {
+		// The link is saved; only the URL couldn't be built
+		c.JSON(http.StatusCreated, gin.H{"link": link, "warning": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// UpdateLink changes a chat link. Printed QR codes keep working only if the
+// keyword and prefilled text stay the same.
+func (h *LinkHandler) UpdateLink(c *gin.Context) {
+	var link models.ChatLink
+	if err := database.GormDB.First(&link, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		return
+	}
+
+	var req linkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Name != "" {
+		link.Name = strings.TrimSpace(req.Name)
+	}
+	if req.Keyword != "" {
+		link.Keyword = strings.TrimSpace(req.Keyword)
+	}
+	if req.PrefilledText != "" {
+		link.PrefilledText = strings.TrimSpace(req.PrefilledText)
+	}
+	link.RuleID = req.RuleID
+	link.FlowID = req.FlowID
+
+	if msg := validateLink(link, link.ID); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := database.GormDB.Save(&link).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, link)
+}
+
+// DeleteLink removes a chat link and its scans
+func (h *LinkHandler) DeleteLink(c *gin.Context) {
+	id := c.Param("id")
+	if err := database.GormDB.Where("link_id = ?", id).Delete(&models.ChatLinkScan{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := database.GormDB.Delete(&models.ChatLink{}, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Link deleted successfully"})
+}
+
+// GetLinkQR renders the link as a QR code (?format=png|svg, ?size=pixels)
+func (h *LinkHandler) GetLinkQR(c *gin.Context) {
+	var link models.ChatLink
+	if err := database.GormDB.First(&link, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		return
+	}
+
+	phone, err := links.BusinessPhone(c.Request.Context(), h.Client, h.Config)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	url := links.URL(phone, link.PrefilledText)
+
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "512"))
+	if size < 64 || size > 4096 {
+		size = 512
+	}
+
+	if c.DefaultQuery("format", "png") == "svg" {
+		svg, err := links.QRSVG(url, size)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "image/svg+xml", svg)
+		return
+	}
+
+	png, err := links.QRPNG(url, size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// GetLinkScans lists recent scans of a link
+func (h *LinkHandler) GetLinkScans(c *gin.Context) {
+	var scans []models.ChatLinkScan
+	if err := database.GormDB.Where("link_id = ?", c.Param("id")).Order("created_at DESC").Limit(500).Find(&scans).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, scans)
+}
+
+// validateLink returns a message describing what is wrong with link, if anything
+func validateLink(link models.ChatLink, id uint) string {
+	if link.Name == "" || link.Keyword == "" {
+		return "name and keyword are required"
+	}
+	if !strings.Contains(strings.ToLower(link.PrefilledText), strings.ToLower(link.Keyword)) {
+		return "prefilled_text must contain the keyword"
+	}
+	if link.RuleID != nil && link.FlowID != "" {
+		return "a link can target either a rule or a flow, not both"
+	}
+
+	var count int64
+	database.GormDB.Model(&models.ChatLink{}).Where("LOWER(keyword) = LOWER(?) AND id <> ?", link.Keyword, id).Count(&count)
+	if count > 0 {
+		return "keyword is already used by another link"
+	}
+	return ""
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/pricing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+type PricingHandler struct{}
+
+func NewPricingHandler() *PricingHandler {
+	return &PricingHandler{}
+}
+
+// GetRates lists the rate card
+func (h *PricingHandler) GetRates(c *gin.Context) {
+	var rates []models.RateCard
+	if err := database.GormDB.Order("country, category").Find(&rates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rates)
+}
+
+type RateRequest struct {
+	Country  string  `json:"country" binding:"required"` // ISO code, or "*" for all other countries
+	Category string  `json:"category" binding:"required"`
+	Rate     float64 `json:"rate"`
+	Currency string  `json:"currency"`
+}
+
+// SetRate creates or replaces the rate of a category in a country. Only
+// messages priced afterwards use the new rate.
+func (h *PricingHandler) SetRate(c *gin.Context) {
+	var req RateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rate := models.RateCard{
+		Country:  strings.ToUpper(req.Country),
+		Category: strings.ToLower(req.Category),
+		Rate:     req.Rate,
+		Currency: strings.ToUpper(req.Currency),
+	}
+	if rate.Country != "*" && len(rate.Country) != 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "country must be a two-letter ISO code or *"})
+		return
+	}
+	if rate.Rate < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rate must not be negative"})
+		return
+	}
+	if rate.Currency == "" {
+		rate.Currency = "USD"
+	}
+
+	err := database.GormDB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "country"}, {Name: "category"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "currency", "updated_at"}),
+	}).Create(&rate).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rate)
+}
+
+// DeleteRate removes a rate from the rate card
+func (h *PricingHandler) DeleteRate(c *gin.Context) {
+	result := database.GormDB.Delete(&models.RateCard{}, c.Param("id"))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rate not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Rate deleted"})
+}
+
+// GetCosts reports conversations and estimated cost grouped by ?group_by=
+// category (default), day, campaign, phone_number or country, optionally
+// limited to ?since= and ?until=. Groups are split per currency.
+func (h *PricingHandler) GetCosts(c *gin.Context) {
+	since, until, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	groupBy := c.DefaultQuery("group_by", "category")
+	rows, err := pricing.Report(groupBy, since, until)
+	if err == pricing.ErrUnknownDimension {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if rows == nil {
+		rows = []pricing.Row{}
+	}
+	c.JSON(http.StatusOK, gin.H{"group_by": groupBy, "rows": rows})
+}
+
+// GetPricingEvents lists the stored pricing of individual messages
+func (h *PricingHandler) GetPricingEvents(c *gin.Context) {
+	query := database.GormDB.Order("created_at DESC").Limit(500)
+	if campaignID := c.Query("campaign_id"); campaignID != "" {
+		query = query.Where("campaign_id = ?", campaignID)
+	}
+	if contact := c.Query("contact"); contact != "" {
+		query = query.Where("contact_wa_id = ?", contact)
+	}
+
+	var events []models.PricingEvent
+	if err := query.Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
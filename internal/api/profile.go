@@ -0,0 +1,337 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"whatsapp-gateway/internal/config"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/media"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/whatsapp"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ProfileHandler struct {
+	Client whatsapp.Messenger
+	Config *config.Config
+}
+
+func NewProfileHandler(client whatsapp.Messenger, cfg *config.Config) *ProfileHandler {
+	return &ProfileHandler{Client: client, Config: cfg}
+}
+
+var pinPattern = regexp.MustCompile(`^\d{6}$`)
+
+// GetProfile returns the cached business profile, fetching it from Meta when
+// there is no cached copy or ?refresh=true
+func (h *ProfileHandler) GetProfile(c *gin.Context) {
+	var profile models.BusinessProfile
+	err := database.GormDB.First(&profile, "phone_number_id = ?", h.Config.PhoneNumberID).Error
+	if err == nil && c.Query("refresh") != "true" {
+		c.JSON(http.StatusOK, profile)
+		return
+	}
+	if err != nil && err != gorm.ErrRecordNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	synced, err := h.syncProfile(c.Request.Context(), "meta")
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, synced)
+}
+
+// UpdateProfile changes the given profile fields on Meta and refreshes the cache
+func (h *ProfileHandler) UpdateProfile(c *gin.Context) {
+	var req whatsapp.BusinessProfileUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ProfilePictureHandle = ""
+
+	if err := validateProfileUpdate(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Make sure changes made outside the gateway are recorded as such first
+	if _, err := h.syncProfile(c.Request.Context(), "meta"); err != nil {
+		log.Printf("[Profile] Failed to sync profile before update: %v", err)
+	}
+
+	if err := h.Client.UpdateBusinessProfile(c.Request.Context(), req); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile, err := h.syncProfile(c.Request.Context(), "gateway")
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// UploadProfilePhoto sets a new profile picture from a "file" upload
+func (h *ProfileHandler) UploadProfilePhoto(c *gin.Context) {
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return
+	}
+
+	prepared, err := media.Prepare(data, header.Filename, header.Header.Get("Content-Type"), media.CategoryImage)
+	if err != nil {
+		var validationErr *media.ValidationError
+		if errors.As(err, &validationErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": validationErr.Message, "validation": validationErr})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	handle, err := h.Client.UploadProfilePicture(c.Request.Context(), prepared.Data, prepared.MimeType)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.Client.UpdateBusinessProfile(c.Request.Context(), whatsapp.BusinessProfileUpdate{ProfilePictureHandle: handle}); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	var previous models.BusinessProfile
+	database.GormDB.First(&previous, "phone_number_id = ?", h.Config.PhoneNumberID)
+
+	profile, err := h.syncProfile(c.Request.Context(), "gateway")
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The picture URL is a signed CDN link that changes on every fetch, so
+	// it is only recorded in the history when we upload a new one
+	database.GormDB.Create(&models.BusinessProfileChange{
+		PhoneNumberID: profile.PhoneNumberID,
+		Field:         "profile_picture",
+		OldValue:      previous.ProfilePictureURL,
+		NewValue:      profile.ProfilePictureURL,
+		Source:        "gateway",
+	})
+
+	c.JSON(http.StatusOK, gin.H{"profile": profile, "conversions": prepared.Conversions})
+}
+
+// GetProfileHistory lists recorded profile changes, newest first
+func (h *ProfileHandler) GetProfileHistory(c *gin.Context) {
+	var changes []models.BusinessProfileChange
+	if err := database.GormDB.Where("phone_number_id = ?", h.Config.PhoneNumberID).
+		Order("created_at DESC").Limit(200).Find(&changes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, changes)
+}
+
+// GetPhoneNumbers lists the business account's phone numbers, refreshed from Meta
+// when ?refresh=true or nothing is cached
+func (h *ProfileHandler) GetPhoneNumbers(c *gin.Context) {
+	var cached []models.PhoneNumber
+	if err := database.GormDB.Order("display_phone_number").Find(&cached).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(cached) > 0 && c.Query("refresh") != "true" {
+		c.JSON(http.StatusOK, cached)
+		return
+	}
+
+	numbers, err := h.Client.GetPhoneNumbers(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]models.PhoneNumber, 0, len(numbers))
+	for _, n := range numbers {
+		result = append(result, savePhoneNumber(n))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetPhoneNumber fetches one phone number from Meta and updates the cache
+func (h *ProfileHandler) GetPhoneNumber(c *gin.Context) {
+	number, err := h.Client.GetPhoneNumber(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		var cached models.PhoneNumber
+		if dbErr := database.GormDB.First(&cached, "id = ?", c.Param("id")).Error; dbErr == nil {
+			c.JSON(http.StatusOK, cached)
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, savePhoneNumber(*number))
+}
+
+// SetPhoneNumberPin sets the two-step verification PIN
+func (h *ProfileHandler) SetPhoneNumberPin(c *gin.Context) {
+	var req struct {
+		Pin string `json:"pin"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !pinPattern.MatchString(req.Pin) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PIN must be 6 digits"})
+		return
+	}
+
+	if err := h.Client.SetTwoStepPin(c.Request.Context(), c.Param("id"), req.Pin); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "PIN updated"})
+}
+
+// syncProfile fetches the profile from Meta, records any field changes
+// against the cached copy and saves it
+func (h *ProfileHandler) syncProfile(ctx context.Context, source string) (*models.BusinessProfile, error) {
+	remote, err := h.Client.GetBusinessProfile(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	phoneNumberID := h.Config.PhoneNumberID
+	var cached models.BusinessProfile
+	existing := database.GormDB.First(&cached, "phone_number_id = ?", phoneNumberID).Error == nil
+
+	profile := models.BusinessProfile{
+		PhoneNumberID:     phoneNumberID,
+		About:             remote.About,
+		Address:           remote.Address,
+		Description:       remote.Description,
+		Email:             remote.Email,
+		Websites:          remote.Websites,
+		Vertical:          remote.Vertical,
+		ProfilePictureURL: remote.ProfilePictureURL,
+		SyncedAt:          time.Now(),
+	}
+	if profile.Websites == nil {
+		profile.Websites = []string{}
+	}
+
+	if existing {
+		changes := []struct{ field, old, new string }{
+			{"about", cached.About, profile.About},
+			{"address", cached.Address, profile.Address},
+			{"description", cached.Description, profile.Description},
+			{"email", cached.Email, profile.Email},
+			{"websites", strings.Join(cached.Websites, ", "), strings.Join(profile.Websites, ", ")},
+			{"vertical", cached.Vertical, profile.Vertical},
+		}
+		for _, change := range changes {
+			if change.old == change.new {
+				continue
+			}
+			database.GormDB.Create(&models.BusinessProfileChange{
+				PhoneNumberID: phoneNumberID,
+				Field:         change.field,
+				OldValue:      change.old,
+				NewValue:      change.new,
+				Source:        source,
+			})
+		}
+	}
+
+	if err := database.GormDB.Save(&profile).Error; err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func savePhoneNumber(n whatsapp.PhoneNumber) models.PhoneNumber {
+	number := models.PhoneNumber{
+		ID:                     n.ID,
+		DisplayPhoneNumber:     n.DisplayPhoneNumber,
+		VerifiedName:           n.VerifiedName,
+		QualityRating:          n.QualityRating,
+		CodeVerificationStatus: n.CodeVerificationStatus,
+		NameStatus:             n.NameStatus,
+		PlatformType:           n.PlatformType,
+		MessagingLimitTier:     n.MessagingLimitTier,
+		ThroughputLevel:        n.Throughput.Level,
+		SyncedAt:               time.Now(),
+	}
+	if err := database.GormDB.Save(&number).Error; err != nil {
+		log.Printf("[Profile] Failed to cache phone number %s: %v", n.ID, err)
+	}
+	return number
+}
+
+// validateProfileUpdate applies Meta's field limits before calling the API
+func validateProfileUpdate(req whatsapp.BusinessProfileUpdate) error {
+	if req.About != nil && (len(*req.About) < 1 || len(*req.About) > 139) {
+		return fmt.Errorf("about must be between 1 and 139 characters")
+	}
+	if req.Address != nil && len(*req.Address) > 256 {
+		return fmt.Errorf("address must be at most 256 characters")
+	}
+	if req.Description != nil && len(*req.Description) > 512 {
+		return fmt.Errorf("description must be at most 512 characters")
+	}
+	if req.Email != nil && *req.Email != "" {
+		if len(*req.Email) > 128 {
+			return fmt.Errorf("email must be at most 128 characters")
+		}
+		if _, err := mail.ParseAddress(*req.Email); err != nil {
+			return fmt.Errorf("email is not a valid address")
+		}
+	}
+	if req.Websites != nil {
+		if len(*req.Websites) > 2 {
+			return fmt.Errorf("at most 2 websites are allowed")
+		}
+		for _, site := range *req.Websites {
+			if len(site) > 256 || !(strings.HasPrefix(site, "http://") || strings.HasPrefix(site, "https://")) {
+				return fmt.Errorf("website '%s' must be an http(s) URL of at most 256 characters", site)
+			}
+		}
+	}
+	if req.Vertical != nil {
+		valid := false
+		for _, v := range whatsapp.BusinessVerticals {
+			if v == *req.Vertical {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("vertical must be one of: %s", strings.Join(whatsapp.BusinessVerticals, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"whatsapp-gateway/internal/campaign"
+	"whatsapp-gateway/internal/config"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/localtime"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/scheduler"
+	"whatsapp-gateway/internal/whatsapp"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ScheduledHandler struct {
+	Config *config.Config
+}
+
+func NewScheduledHandler(cfg *config.Config) *ScheduledHandler {
+	return &ScheduledHandler{Config: cfg}
+}
+
+type ScheduledRequest struct {
+	Kind           string                   `json:"kind"` // message (default) or campaign
+	RecipientWaID  string                   `json:"recipient_wa_id"`
+	MessageContent string                   `json:"message_content"`
+	TemplateID     string                   `json:"template_id"` // ID of a synced template, instead of message_content
+	Parameters     *whatsapp.TemplateParams `json:"parameters"`
+	Campaign       *CampaignRequest         `json:"campaign"` // For kind campaign
+	// ScheduledTime is the first run, RFC 3339 or 2006-01-02T15:04 in timezone.
+	// Recurring schedules without one start now.
+	ScheduledTime string `json:"scheduled_time"`
+	Timezone      string `json:"timezone"`    // IANA name, default UTC; contact uses the recipient's
+	Recurrence    string `json:"recurrence"`  // once, daily, weekly, monthly or a cron expression
+	MissedRuns    string `json:"missed_runs"` // run (default) or skip
+	// IgnoreQuietHours sends messages due in the recipient's quiet hours anyway
+	IgnoreQuietHours bool `json:"ignore_quiet_hours"`
+}
+
+// GetScheduledMessages lists scheduled messages by next run. Filters:
+// ?status= and ?kind=
+func (h *ScheduledHandler) GetScheduledMessages(c *gin.Context) {
+	query := database.GormDB.Order("scheduled_time")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+
+	var messages []models.ScheduledMessage
+	if err := query.Find(&messages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, messages)
+}
+
+// GetScheduledMessage returns a scheduled message with its next runs
+func (h *ScheduledHandler) GetScheduledMessage(c *gin.Context) {
+	var msg models.ScheduledMessage
+	if err := database.GormDB.First(&msg, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled message not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"scheduled_message": msg, "next_runs": scheduler.Upcoming(&msg, 5)})
+}
+
+// CreateScheduledMessage schedules a message or a campaign
+func (h *ScheduledHandler) CreateScheduledMessage(c *gin.Context) {
+	var req ScheduledRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	msg := models.ScheduledMessage{Status: scheduler.StatusPending}
+	if err := h.apply(&msg, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := database.GormDB.Create(&msg).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, msg)
+}
+
+// UpdateScheduledMessage replaces a pending scheduled message. The body is
+// the same as for creating one; for recurring messages it changes future runs.
+func (h *ScheduledHandler) UpdateScheduledMessage(c *gin.Context) {
+	var msg models.ScheduledMessage
+	if err := database.GormDB.First(&msg, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled message not found"})
+		return
+	}
+	if msg.Status != scheduler.StatusPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only pending scheduled messages can be changed"})
+		return
+	}
+
+	var req ScheduledRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.apply(&msg, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The scheduler may have claimed it meanwhile
+	result := database.GormDB.Model(&msg).Where("status = ?", scheduler.StatusPending).Select(
+		"kind", "recipient_wa_id", "message_content", "template_id", "parameters", "campaign",
+		"scheduled_time", "start_time", "timezone", "recurrence", "missed_runs", "ignore_quiet_hours", "updated_at",
+	).Updates(&msg)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Scheduled message is being sent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, msg)
+}
+
+// DeleteScheduledMessage removes a scheduled message and all its future runs
+func (h *ScheduledHandler) DeleteScheduledMessage(c *gin.Context) {
+	result := database.GormDB.Where("id = ? AND status <> ?", c.Param("id"), scheduler.StatusSending).Delete(&models.ScheduledMessage{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		var count int64
+		database.GormDB.Model(&models.ScheduledMessage{}).Where("id = ?", c.Param("id")).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Scheduled message is being sent"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled message not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Scheduled message deleted successfully"})
+}
+
+// apply validates req and copies it into msg
+func (h *ScheduledHandler) apply(msg *models.ScheduledMessage, req ScheduledRequest) error {
+	msg.Kind = req.Kind
+	if msg.Kind == "" {
+		msg.Kind = scheduler.KindMessage
+	}
+	msg.RecipientWaID, msg.MessageContent, msg.TemplateID = req.RecipientWaID, req.MessageContent, req.TemplateID
+	msg.Parameters, msg.Campaign = "", ""
+
+	switch msg.Kind {
+	case scheduler.KindMessage:
+		if msg.RecipientWaID == "" {
+			return errors.New("recipient_wa_id is required")
+		}
+		if msg.TemplateID == "" && msg.MessageContent == "" {
+			return errors.New("message_content or template_id is required")
+		}
+		if msg.TemplateID != "" {
+			err := database.GormDB.Where("id = ?", msg.TemplateID).First(&models.Template{}).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("template_id not found, sync templates first")
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if req.Parameters != nil && !req.Parameters.IsEmpty() {
+			params, err := json.Marshal(req.Parameters)
+			if err != nil {
+				return err
+			}
+			msg.Parameters = string(params)
+		}
+	case scheduler.KindCampaign:
+		if req.Campaign == nil {
+			return errors.New("campaign is required")
+		}
+		creq := campaign.Request{
+			Name:              req.Campaign.Name,
+			TemplateName:      req.Campaign.TemplateName,
+			Language:          req.Campaign.Language,
+			Parameters:        req.Campaign.Parameters,
+			Audience:          req.Campaign.Audience,
+			MessagesPerSecond: req.Campaign.MessagesPerSecond,
+			ABTest:            req.Campaign.ABTest,
+			LocalTime:         req.Campaign.LocalTime,
+			IgnoreQuietHours:  req.Campaign.IgnoreQuietHours,
+		}
+		creq.Audience.Contacts = append(creq.Audience.Contacts, req.Campaign.Contacts...)
+		if creq.TemplateName == "" && creq.ABTest == nil {
+			return errors.New("campaign.template_name or campaign.ab_test is required")
+		}
+		if err := creq.Validate(); err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(creq)
+		if err != nil {
+			return err
+		}
+		msg.Campaign = string(encoded)
+	default:
+		return errors.New("kind must be message or campaign")
+	}
+
+	msg.Timezone, msg.Recurrence, msg.MissedRuns = req.Timezone, req.Recurrence, req.MissedRuns
+	msg.IgnoreQuietHours = req.IgnoreQuietHours
+	if msg.Timezone == "" {
+		msg.Timezone = "UTC"
+	}
+	if msg.Timezone == "contact" {
+		if msg.Kind != scheduler.KindMessage {
+			return errors.New("timezone contact needs a recipient, campaigns use campaign.local_time")
+		}
+		// Resolved now, so later timezone changes of the contact do not move it
+		msg.Timezone = localtime.ForContact(msg.RecipientWaID, h.Config).String()
+	}
+	loc, err := time.LoadLocation(msg.Timezone)
+	if err != nil {
+		return scheduler.ErrInvalidTimezone
+	}
+	now := time.Now()
+	first := now
+	if req.ScheduledTime != "" {
+		if first, err = scheduler.ParseTime(req.ScheduledTime, loc); err != nil {
+			return err
+		}
+	} else if req.Recurrence == "" || req.Recurrence == "once" {
+		return errors.New("scheduled_time is required")
+	}
+	return scheduler.Plan(msg, first, now)
+}
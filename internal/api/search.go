@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"whatsapp-gateway/internal/search"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SearchHandler struct{}
+
+func NewSearchHandler() *SearchHandler {
+	return &SearchHandler{}
+}
+
+// Search looks for ?q= in message content, contact names, tags and phone
+// numbers. Optional: type=all|messages|contacts, contact, since, until, limit.
+// Snippets are HTML-escaped with matches wrapped in <mark>.
+func (h *SearchHandler) Search(c *gin.Context) {
+	opts := search.Options{
+		Query:       c.Query("q"),
+		Scope:       c.DefaultQuery("type", "all"),
+		ContactWaID: c.Query("contact"),
+	}
+	if opts.Scope != "all" && opts.Scope != "messages" && opts.Scope != "contacts" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be all, messages or contacts"})
+		return
+	}
+	opts.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	var err error
+	if opts.Since, opts.Until, err = parseTimeRange(c); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := search.Search(opts)
+	if err == search.ErrEmptyQuery {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
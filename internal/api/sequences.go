@@ -0,0 +1,306 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/sequence"
+	"whatsapp-gateway/internal/whatsapp"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SequenceHandler struct{}
+
+func NewSequenceHandler() *SequenceHandler {
+	return &SequenceHandler{}
+}
+
+type SequenceRequest struct {
+	Name          string                `json:"name"`
+	Description   string                `json:"description"`
+	Enabled       *bool                 `json:"enabled"` // Default true
+	TriggerTag    string                `json:"trigger_tag"`
+	ExitOnReply   bool                  `json:"exit_on_reply"`
+	ExitOnOptOut  *bool                 `json:"exit_on_opt_out"` // Default true
+	ExitOnTags    []string              `json:"exit_on_tags"`
+	AllowReenroll bool                  `json:"allow_reenroll"`
+	Steps         []SequenceStepRequest `json:"steps"`
+	// LocalTime (HH:MM) sends delayed steps at that time in the contact's timezone
+	LocalTime        string `json:"local_time"`
+	IgnoreQuietHours bool   `json:"ignore_quiet_hours"`
+}
+
+type SequenceStepRequest struct {
+	// The delays add up and count from enrollment, e.g. delay_days 7 is a week in
+	DelayDays     int                      `json:"delay_days"`
+	DelayHours    int                      `json:"delay_hours"`
+	DelayMinutes  int                      `json:"delay_minutes"`
+	Type          string                   `json:"type"` // template, message, flow or chatbot
+	TemplateName  string                   `json:"template_name"`
+	Language      string                   `json:"language"`
+	Parameters    *whatsapp.TemplateParams `json:"parameters"`
+	Message       string                   `json:"message"` // Text of a message step, body of a flow step
+	FlowID        string                   `json:"flow_id"`
+	FlowCTA       string                   `json:"flow_cta"`
+	FlowScreen    string                   `json:"flow_screen"`
+	FlowHandler   string                   `json:"flow_handler"`
+	SkipIfReplied bool                     `json:"skip_if_replied"`
+}
+
+// GetSequences lists the sequences with their steps
+func (h *SequenceHandler) GetSequences(c *gin.Context) {
+	var sequences []models.Sequence
+	if err := database.GormDB.Preload("Steps", func(db *gorm.DB) *gorm.DB { return db.Order("position") }).Order("created_at DESC").Find(&sequences).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, sequences)
+}
+
+// GetSequence returns a sequence with its steps
+func (h *SequenceHandler) GetSequence(c *gin.Context) {
+	seq, ok := h.load(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, seq)
+}
+
+// CreateSequence stores a sequence
+func (h *SequenceHandler) CreateSequence(c *gin.Context) {
+	var req SequenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var seq models.Sequence
+	if err := applySequenceRequest(&seq, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := sequence.Save(&seq); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, seq)
+}
+
+// UpdateSequence replaces a sequence and its steps. Active enrollments carry
+// on with the new steps from the position they reached.
+func (h *SequenceHandler) UpdateSequence(c *gin.Context) {
+	seq, ok := h.load(c)
+	if !ok {
+		return
+	}
+	var req SequenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := applySequenceRequest(seq, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := sequence.Save(seq); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, seq)
+}
+
+// DeleteSequence removes a sequence and its enrollments
+func (h *SequenceHandler) DeleteSequence(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sequence ID"})
+		return
+	}
+	deleted, err := sequence.Delete(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sequence not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Sequence deleted successfully"})
+}
+
+// EnrollContacts enrolls contacts in a sequence. Contacts that are already
+// enrolled are skipped with the reason.
+func (h *SequenceHandler) EnrollContacts(c *gin.Context) {
+	seq, ok := h.load(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Contacts []string `json:"contacts" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !seq.Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": sequence.ErrDisabled.Error()})
+		return
+	}
+
+	enrolled := []models.SequenceEnrollment{}
+	skipped := []gin.H{}
+	for _, waID := range req.Contacts {
+		waID = strings.TrimPrefix(strings.TrimSpace(waID), "+")
+		if waID == "" {
+			continue
+		}
+		enrollment, err := sequence.Enroll(seq.ID, waID, sequence.SourceAPI)
+		if err != nil {
+			skipped = append(skipped, gin.H{"wa_id": waID, "reason": err.Error()})
+			continue
+		}
+		enrolled = append(enrolled, *enrollment)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"enrolled": enrolled, "skipped": skipped})
+}
+
+// GetEnrollments lists a sequence's enrollments, newest first. Filter with
+// ?status= and ?wa_id=
+func (h *SequenceHandler) GetEnrollments(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	query := database.GormDB.Where("sequence_id = ?", c.Param("id"))
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if waID := c.Query("wa_id"); waID != "" {
+		query = query.Where("contact_wa_id = ?", waID)
+	}
+
+	var enrollments []models.SequenceEnrollment
+	if err := query.Order("enrolled_at DESC, id DESC").Limit(limit).Offset(offset).Find(&enrollments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollments)
+}
+
+// GetEnrollment returns an enrollment with what happened at each step
+func (h *SequenceHandler) GetEnrollment(c *gin.Context) {
+	var enrollment models.SequenceEnrollment
+	if err := database.GormDB.Where("sequence_id = ?", c.Param("id")).First(&enrollment, c.Param("enrollmentId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Enrollment not found"})
+		return
+	}
+	var deliveries []models.SequenceDelivery
+	if err := database.GormDB.Where("enrollment_id = ?", enrollment.ID).Order("id").Find(&deliveries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"enrollment": enrollment, "deliveries": deliveries})
+}
+
+// ExitEnrollment takes a contact out of a sequence before its last step
+func (h *SequenceHandler) ExitEnrollment(c *gin.Context) {
+	var enrollment models.SequenceEnrollment
+	if err := database.GormDB.Where("sequence_id = ?", c.Param("id")).First(&enrollment, c.Param("enrollmentId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Enrollment not found"})
+		return
+	}
+	if err := sequence.Exit(enrollment.ID, sequence.ExitCancelled); err != nil {
+		status := http.StatusInternalServerError
+		if err == sequence.ErrNotActive {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Enrollment exited"})
+}
+
+// GetSequenceReport counts enrollments by status and exit reason and each
+// step's sends
+func (h *SequenceHandler) GetSequenceReport(c *gin.Context) {
+	seq, ok := h.load(c)
+	if !ok {
+		return
+	}
+	report, err := sequence.GetReport(seq)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *SequenceHandler) load(c *gin.Context) (*models.Sequence, bool) {
+	var seq models.Sequence
+	err := database.GormDB.Preload("Steps", func(db *gorm.DB) *gorm.DB { return db.Order("position") }).First(&seq, c.Param("id")).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sequence not found"})
+		return nil, false
+	}
+	return &seq, true
+}
+
+// applySequenceRequest validates req and copies it into seq
+func applySequenceRequest(seq *models.Sequence, req SequenceRequest) error {
+	seq.Name, seq.Description, seq.TriggerTag = req.Name, req.Description, strings.TrimSpace(req.TriggerTag)
+	seq.ExitOnReply, seq.AllowReenroll = req.ExitOnReply, req.AllowReenroll
+	seq.LocalTime, seq.IgnoreQuietHours = strings.TrimSpace(req.LocalTime), req.IgnoreQuietHours
+	seq.Enabled = req.Enabled == nil || *req.Enabled
+	seq.ExitOnOptOut = req.ExitOnOptOut == nil || *req.ExitOnOptOut
+	seq.ExitOnTags = ""
+	if len(req.ExitOnTags) > 0 {
+		tags, err := json.Marshal(req.ExitOnTags)
+		if err != nil {
+			return err
+		}
+		seq.ExitOnTags = string(tags)
+	}
+
+	seq.Steps = make([]models.SequenceStep, 0, len(req.Steps))
+	for _, s := range req.Steps {
+		step := models.SequenceStep{
+			DelayMinutes:  s.DelayDays*24*60 + s.DelayHours*60 + s.DelayMinutes,
+			Type:          s.Type,
+			TemplateName:  s.TemplateName,
+			Language:      s.Language,
+			Message:       s.Message,
+			FlowID:        s.FlowID,
+			FlowCTA:       s.FlowCTA,
+			FlowScreen:    s.FlowScreen,
+			FlowHandler:   s.FlowHandler,
+			SkipIfReplied: s.SkipIfReplied,
+		}
+		if s.Parameters != nil && !s.Parameters.IsEmpty() {
+			params, err := json.Marshal(s.Parameters)
+			if err != nil {
+				return err
+			}
+			step.Parameters = string(params)
+		}
+		seq.Steps = append(seq.Steps, step)
+	}
+	return sequence.Validate(seq)
+}
@@ -2,13 +2,20 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/flowdata"
+	"whatsapp-gateway/internal/flowjson"
+	"whatsapp-gateway/internal/media"
 	"whatsapp-gateway/internal/models"
 	"whatsapp-gateway/internal/whatsapp"
 
@@ -19,11 +26,12 @@ import (
 )
 
 type WhatsAppHandler struct {
-	Client *whatsapp.Client
+	Client whatsapp.Messenger
+	Media  *media.Library
 }
 
-func NewWhatsAppHandler(client *whatsapp.Client) *WhatsAppHandler {
-	return &WhatsAppHandler{Client: client}
+func NewWhatsAppHandler(client whatsapp.Messenger, library *media.Library) *WhatsAppHandler {
+	return &WhatsAppHandler{Client: client, Media: library}
 }
 
 // SendMessage handles unified message sending
@@ -39,12 +47,46 @@ func (h *WhatsAppHandler) SendMessage(c *gin.Context) {
 		msg.MessagingProduct = "whatsapp"
 	}
 
-	if err := h.Client.SendRawMessage(msg); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	resp, err := h.Client.SendRawMessage(c.Request.Context(), msg)
+	if err != nil {
+		respondSendError(c, err, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, sentResponse(resp))
+}
+
+// respondSendError answers a failed send. Sends refused because the contact
+// opted out get 422 with code "suppressed"; sends refused because of the
+// service window get 422 with code "outside_service_window" so callers can
+// switch to a template.
+func respondSendError(c *gin.Context, err error, prefix string) {
+	if errors.Is(err, whatsapp.ErrSuppressed) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "code": "suppressed"})
+		return
+	}
+	if errors.Is(err, whatsapp.ErrOutsideServiceWindow) {
+		body := gin.H{"error": err.Error(), "code": "outside_service_window"}
+		var windowErr *whatsapp.ServiceWindowError
+		if errors.As(err, &windowErr) {
+			body["window_expired_at"] = windowErr.ExpiredAt
+		}
+		c.JSON(http.StatusUnprocessableEntity, body)
 		return
 	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": prefix + err.Error()})
+}
 
-	c.JSON(http.StatusOK, gin.H{"status": "Message sent"})
+// sentResponse is the success body of a send, noting a template fallback
+func sentResponse(resp *whatsapp.MessageResponse) gin.H {
+	body := gin.H{"status": "Message sent"}
+	if resp != nil {
+		body["message_id"] = resp.MessageID()
+		if resp.FallbackTemplate != "" {
+			body["fallback_template"] = resp.FallbackTemplate
+		}
+	}
+	return body
 }
 
 // UploadMedia handles media file uploads
@@ -72,35 +114,38 @@ func (h *WhatsAppHandler) UploadMedia(c *gin.Context) {
 		}
 	}
 
-	resp, err := h.Client.UploadMedia(fileBytes, mimeType, header.Filename)
+	// Sniff the real content type, convert what we can and enforce WhatsApp limits
+	prepared, err := media.Prepare(fileBytes, header.Filename, mimeType, c.PostForm("type"))
 	if err != nil {
+		var validationErr *media.ValidationError
+		if errors.As(err, &validationErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": validationErr.Message, "validation": validationErr})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Save to database for persistence
-	media := models.Media{
-		MediaID:  resp.ID,
-		Filename: header.Filename,
-		MimeType: mimeType,
-		FileSize: header.Size,
-	}
-
-	if err := database.GormDB.Create(&media).Error; err != nil {
-		// Log but don't fail - upload to WhatsApp succeeded
-		c.JSON(http.StatusOK, gin.H{
-			"id":       resp.ID,
-			"filename": header.Filename,
-			"warning":  "Upload succeeded but failed to save to local database",
-		})
+	// Keep the file in the library so the Meta media ID can be refreshed later
+	stored, duplicate, err := h.Media.Store(c.Request.Context(), prepared.Data, prepared.Filename, prepared.MimeType, media.StoreOptions{
+		Folder: strings.TrimSpace(c.PostForm("folder")),
+		Tags:   parseTagList(c.PostForm("tags")),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"id":        resp.ID,
-		"filename":  header.Filename,
-		"mime_type": mimeType,
-		"file_size": header.Size,
+		"id":          fmt.Sprintf("%d", stored.ID), // Library ID, use this in flow steps and templates
+		"media_id":    stored.MediaID,
+		"filename":    stored.Filename,
+		"mime_type":   stored.MimeType,
+		"file_size":   stored.FileSize,
+		"sha256":      stored.SHA256,
+		"category":    prepared.Category,
+		"conversions": prepared.Conversions,
+		"duplicate":   duplicate,
 	})
 }
 
@@ -112,7 +157,13 @@ func (h *WhatsAppHandler) RetrieveMediaURL(c *gin.Context) {
 		return
 	}
 
-	url, err := h.Client.RetrieveMediaURL(mediaID)
+	resolvedID, err := h.Media.Resolve(c.Request.Context(), mediaID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	url, err := h.Client.RetrieveMediaURL(c.Request.Context(), resolvedID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -129,23 +180,24 @@ func (h *WhatsAppHandler) DownloadMediaProxy(c *gin.Context) {
 		return
 	}
 
-	// Get the media URL from WhatsApp
-	mediaURL, err := h.Client.RetrieveMediaURL(mediaID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	// Serve from the local library when we have the file
+	if stored, err := h.Media.Find(mediaID); err == nil && stored.StoragePath != "" {
+		if _, err := os.Stat(stored.StoragePath); err == nil {
+			c.Header("Content-Type", stored.MimeType)
+			c.File(stored.StoragePath)
+			return
+		}
 	}
 
-	// Download the media with authentication
-	req, err := http.NewRequest("GET", mediaURL, nil)
+	// Get the media URL from WhatsApp
+	mediaURL, err := h.Client.RetrieveMediaURL(c.Request.Context(), mediaID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	req.Header.Set("Authorization", "Bearer "+h.Client.Config.WhatsAppToken)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	// Download the media with authentication
+	resp, err := h.Client.DownloadMedia(c.Request.Context(), mediaURL)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -171,31 +223,223 @@ func (h *WhatsAppHandler) DeleteMedia(c *gin.Context) {
 		return
 	}
 
-	if err := h.Client.DeleteMedia(mediaID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if stored, err := h.Media.Find(mediaID); err == nil {
+		// Refuse to delete assets still referenced by flows or templates
+		usage, err := h.Media.Usage(stored)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(usage) > 0 && c.Query("force") != "true" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Media is in use", "usage": usage})
+			return
+		}
+
+		if err := h.Media.Delete(c.Request.Context(), stored); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "Media deleted"})
 		return
 	}
 
-	// Also remove from local database
-	database.GormDB.Where("media_id = ?", mediaID).Delete(&models.Media{})
+	// Not in the library, treat as a raw Meta media ID
+	if err := h.Client.DeleteMedia(c.Request.Context(), mediaID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"status": "Media deleted"})
 }
 
-// ListMedia lists all stored media
+// ListMedia lists stored media with search and folder/tag filters, newest
+// first. The total is reported in X-Total-Count; ?page= and ?page_size= page
+// through the list, which is returned whole without them.
 func (h *WhatsAppHandler) ListMedia(c *gin.Context) {
+	_, paged := c.GetQuery("page")
+	if _, ok := c.GetQuery("page_size"); ok {
+		paged = true
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if pageSize < 1 || pageSize > 200 {
+		pageSize = 50
+	}
+
+	query := database.GormDB.Model(&models.Media{})
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		query = query.Where(`LOWER(filename) LIKE ? ESCAPE '\'`, "%"+escapeLike(strings.ToLower(q))+"%")
+	}
+	if folder, ok := c.GetQuery("folder"); ok {
+		query = query.Where("folder = ?", folder)
+	}
+	if tag := c.Query("tag"); tag != "" {
+		// Tags are stored as a JSON array, match the quoted tag
+		quoted, _ := json.Marshal(tag)
+		query = query.Where(`tags LIKE ? ESCAPE '\'`, "%"+escapeLike(string(quoted))+"%")
+	}
+	if kind := c.Query("type"); kind != "" {
+		query = query.Where(`mime_type LIKE ? ESCAPE '\'`, escapeLike(kind)+"/%")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	query = query.Order("uploaded_at DESC")
+	if paged {
+		query = query.Offset((page - 1) * pageSize).Limit(pageSize)
+	}
 	var mediaList []models.Media
-	if err := database.GormDB.Order("uploaded_at DESC").Find(&mediaList).Error; err != nil {
+	if err := query.Find(&mediaList).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, mediaList)
+	type MediaItem struct {
+		models.Media
+		ThumbnailURL string        `json:"thumbnail_url,omitempty"`
+		Usage        []media.Usage `json:"usage,omitempty"`
+	}
+	includeUsage := c.Query("include_usage") == "true"
+	items := make([]MediaItem, 0, len(mediaList))
+	for i := range mediaList {
+		item := MediaItem{Media: mediaList[i]}
+		if strings.HasPrefix(item.MimeType, "image/") && item.StoragePath != "" {
+			item.ThumbnailURL = fmt.Sprintf("/api/whatsapp/media/%d/thumbnail", item.ID)
+		}
+		if includeUsage {
+			item.Usage, _ = h.Media.Usage(&mediaList[i])
+		}
+		items = append(items, item)
+	}
+
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+	c.JSON(http.StatusOK, items)
+}
+
+// escapeLike escapes the LIKE wildcards in s, for patterns using ESCAPE '\'
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// UpdateMedia renames, moves or retags a library entry
+func (h *WhatsAppHandler) UpdateMedia(c *gin.Context) {
+	stored, err := h.Media.Find(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+		return
+	}
+
+	var req struct {
+		Filename *string  `json:"filename"`
+		Folder   *string  `json:"folder"`
+		Tags     []string `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updateData := map[string]interface{}{}
+	if req.Filename != nil && *req.Filename != "" {
+		updateData["filename"] = *req.Filename
+	}
+	if req.Folder != nil {
+		updateData["folder"] = strings.TrimSpace(*req.Folder)
+	}
+	if req.Tags != nil {
+		tagsJSON, _ := json.Marshal(req.Tags)
+		updateData["tags"] = string(tagsJSON)
+	}
+
+	if err := database.GormDB.Model(stored).Updates(updateData).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, stored)
+}
+
+// ListMediaFolders returns the folders in use with their asset counts
+func (h *WhatsAppHandler) ListMediaFolders(c *gin.Context) {
+	var folders []struct {
+		Folder string `json:"folder"`
+		Count  int64  `json:"count"`
+	}
+	if err := database.GormDB.Model(&models.Media{}).
+		Select("folder, COUNT(*) as count").
+		Group("folder").
+		Order("folder").
+		Scan(&folders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, folders)
+}
+
+// GetMediaThumbnail serves a server-generated thumbnail for image assets
+func (h *WhatsAppHandler) GetMediaThumbnail(c *gin.Context) {
+	stored, err := h.Media.Find(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+		return
+	}
+
+	path, err := h.Media.Thumbnail(stored)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "image/jpeg")
+	c.File(path)
+}
+
+// GetMediaUsage lists the flows and templates referencing a library entry
+func (h *WhatsAppHandler) GetMediaUsage(c *gin.Context) {
+	stored, err := h.Media.Find(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+		return
+	}
+
+	usage, err := h.Media.Usage(stored)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// parseTagList accepts either a JSON array or a comma separated list
+func parseTagList(raw string) []string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil
+	}
+	var tags []string
+	if err := json.Unmarshal([]byte(raw), &tags); err == nil {
+		return tags
+	}
+	for _, t := range strings.Split(raw, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
 }
 
 // GetTemplates retrieves templates from Meta
 func (h *WhatsAppHandler) GetTemplates(c *gin.Context) {
-	templates, err := h.Client.GetTemplates()
+	templates, err := h.Client.GetTemplates(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -211,7 +455,7 @@ func (h *WhatsAppHandler) CreateTemplate(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.Client.CreateTemplate(templateData)
+	resp, err := h.Client.CreateTemplate(c.Request.Context(), templateData)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -228,7 +472,7 @@ func (h *WhatsAppHandler) DeleteTemplate(c *gin.Context) {
 		return
 	}
 
-	if err := h.Client.DeleteTemplate(name); err != nil {
+	if err := h.Client.DeleteTemplate(c.Request.Context(), name); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -240,7 +484,7 @@ func (h *WhatsAppHandler) DeleteTemplate(c *gin.Context) {
 
 // GetFlows lists all flows
 func (h *WhatsAppHandler) GetFlows(c *gin.Context) {
-	flows, err := h.Client.GetFlows()
+	flows, err := h.Client.GetFlows(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -251,7 +495,7 @@ func (h *WhatsAppHandler) GetFlows(c *gin.Context) {
 // GetFlow gets a specific flow
 func (h *WhatsAppHandler) GetFlow(c *gin.Context) {
 	flowID := c.Param("id")
-	flow, err := h.Client.GetFlow(flowID)
+	flow, err := h.Client.GetFlow(c.Request.Context(), flowID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -288,7 +532,7 @@ func (h *WhatsAppHandler) CreateFlow(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.Client.CreateFlow(req.Name, req.Categories, req.CloneFlowID)
+	resp, err := h.Client.CreateFlow(c.Request.Context(), req.Name, req.Categories, req.CloneFlowID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -308,7 +552,7 @@ func (h *WhatsAppHandler) UpdateFlowMetadata(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.Client.UpdateFlowMetadata(flowID, req.Name, req.Categories)
+	resp, err := h.Client.UpdateFlowMetadata(c.Request.Context(), flowID, req.Name, req.Categories)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -332,7 +576,15 @@ func (h *WhatsAppHandler) UploadFlowJSON(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.Client.UploadFlowJSON(flowID, fileBytes)
+	// Catch schema mistakes locally instead of after the round trip to Meta
+	if c.Query("skip_validation") != "true" {
+		if errs := flowjson.Validate(fileBytes); len(errs) > 0 {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Flow JSON is invalid", "validation_errors": errs})
+			return
+		}
+	}
+
+	resp, err := h.Client.UploadFlowJSON(c.Request.Context(), flowID, fileBytes)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -357,10 +609,36 @@ func (h *WhatsAppHandler) UploadFlowJSON(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// ValidateFlowJSON checks a Flow JSON document, sent as the request body or
+// as a "file" upload, without sending it to Meta
+func (h *WhatsAppHandler) ValidateFlowJSON(c *gin.Context) {
+	var data []byte
+	if file, _, err := c.Request.FormFile("file"); err == nil {
+		defer file.Close()
+		data, err = io.ReadAll(file)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+			return
+		}
+	} else {
+		data, err = io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	errs := flowjson.Validate(data)
+	if errs == nil {
+		errs = []flowjson.Error{}
+	}
+	c.JSON(http.StatusOK, gin.H{"valid": len(errs) == 0, "validation_errors": errs})
+}
+
 // PublishFlow publishes a flow
 func (h *WhatsAppHandler) PublishFlow(c *gin.Context) {
 	flowID := c.Param("id")
-	resp, err := h.Client.PublishFlow(flowID)
+	resp, err := h.Client.PublishFlow(c.Request.Context(), flowID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -368,10 +646,68 @@ func (h *WhatsAppHandler) PublishFlow(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+type SendFlowRequest struct {
+	To      string      `json:"to"`
+	Body    string      `json:"body"`
+	CTA     string      `json:"cta"`
+	Header  string      `json:"header"`
+	Footer  string      `json:"footer"`
+	Screen  string      `json:"screen"`  // First screen, omit to let the data endpoint decide
+	Data    interface{} `json:"data"`    // Initial data for screen
+	Handler string      `json:"handler"` // Data endpoint handler name
+	Draft   bool        `json:"draft"`
+}
+
+// SendFlow sends a published flow as an interactive message
+func (h *WhatsAppHandler) SendFlow(c *gin.Context) {
+	var req SendFlowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.To == "" || req.Body == "" || req.CTA == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to, body and cta are required"})
+		return
+	}
+
+	send, err := flowdata.Send(c.Request.Context(), h.Client, req.To, whatsapp.FlowMessage{
+		FlowID: c.Param("id"),
+		CTA:    req.CTA,
+		Body:   req.Body,
+		Header: req.Header,
+		Footer: req.Footer,
+		Screen: req.Screen,
+		Data:   req.Data,
+		Draft:  req.Draft,
+	}, req.Handler, nil)
+	if err != nil {
+		respondSendError(c, err, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, send)
+}
+
+// GetFlowSubmissions lists the form submissions received for a flow
+func (h *WhatsAppHandler) GetFlowSubmissions(c *gin.Context) {
+	query := database.GormDB.Where("flow_id = ?", c.Param("id"))
+	if waID := c.Query("wa_id"); waID != "" {
+		query = query.Where("wa_id = ?", waID)
+	}
+
+	var submissions []models.FlowSubmission
+	if err := query.Order("created_at DESC").Find(&submissions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, submissions)
+}
+
 // DeleteFlow deletes a flow
 func (h *WhatsAppHandler) DeleteFlow(c *gin.Context) {
 	flowID := c.Param("id")
-	resp, err := h.Client.DeleteFlow(flowID)
+	resp, err := h.Client.DeleteFlow(c.Request.Context(), flowID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -1,13 +1,19 @@
 package automation
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"regexp"
 	"strings"
 	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/flowdata"
+	"whatsapp-gateway/internal/media"
 	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/sequence"
 	"whatsapp-gateway/internal/whatsapp"
 	"whatsapp-gateway/internal/ws"
 
@@ -15,12 +21,13 @@ import (
 )
 
 type Engine struct {
-	WhatsAppClient *whatsapp.Client
+	WhatsAppClient whatsapp.Messenger
 	Hub            *ws.Hub
+	Media          *media.Library
 }
 
-func NewEngine(client *whatsapp.Client, hub *ws.Hub) *Engine {
-	return &Engine{WhatsAppClient: client, Hub: hub}
+func NewEngine(client whatsapp.Messenger, hub *ws.Hub, library *media.Library) *Engine {
+	return &Engine{WhatsAppClient: client, Hub: hub, Media: library}
 }
 
 // Condition represents a rule condition
@@ -32,12 +39,12 @@ type Condition struct {
 
 // Action represents an automation action
 type Action struct {
-	Type   string                 `json:"type"`   // send_message, add_tag, start_flow
+	Type   string                 `json:"type"`   // send_message, add_tag, start_flow, enroll_sequence, exit_sequence
 	Params map[string]interface{} `json:"params"` // action-specific parameters
 }
 
 // ProcessIncomingMessage processes a message through automation rules
-func (e *Engine) ProcessIncomingMessage(waID, messageContent string) error {
+func (e *Engine) ProcessIncomingMessage(ctx context.Context, waID, messageContent string) error {
 	// 0. Check if user is in an active Flow Session
 	var session models.ConversationSession
 	err := database.GormDB.Where("wa_id = ? AND status = 'active'", waID).First(&session).Error
@@ -45,7 +52,7 @@ func (e *Engine) ProcessIncomingMessage(waID, messageContent string) error {
 	if err == nil {
 		// Active, continue flow
 		log.Printf("[Flow] Continuing flow %s for %s at node %s", session.FlowID, waID, session.CurrentNode)
-		return e.ContinueFlow(waID, int(session.ID), session.FlowID, session.CurrentNode, messageContent)
+		return e.ContinueFlow(ctx, waID, int(session.ID), session.FlowID, session.CurrentNode, messageContent)
 	}
 
 	// 1. Fetch all enabled rules ordered by priority
@@ -61,7 +68,7 @@ func (e *Engine) ProcessIncomingMessage(waID, messageContent string) error {
 			log.Printf("Rule '%s' matched for message from %s", rule.Name, waID)
 
 			// Execute actions
-			if err := e.executeActions(int(rule.ID), rule.Actions, waID, messageContent); err != nil {
+			if err := e.executeActions(ctx, int(rule.ID), rule.Actions, waID, messageContent); err != nil {
 				log.Printf("Error executing actions for rule %s: %v", rule.Name, err)
 				e.logAutomation(int(rule.ID), waID, rule.Type, "action_failed", false, err.Error())
 			} else {
@@ -80,13 +87,81 @@ func (e *Engine) ProcessIncomingMessage(waID, messageContent string) error {
 		err := database.GormDB.Order("updated_at DESC").First(&latestFlow).Error
 		if err == nil && latestFlow.ID != "" {
 			log.Printf("[TEST] Starting latest flow: %s", latestFlow.ID)
-			return e.StartFlow(waID, latestFlow.ID)
+			return e.StartFlow(ctx, waID, latestFlow.ID)
 		}
 	}
 
 	return nil
 }
 
+// FlowResponsePrefix marks a WhatsApp Flow submission in message content
+const FlowResponsePrefix = "[flow_response]:"
+
+// ProcessFlowReply stores a WhatsApp Flow submission and, if a chatbot session
+// is waiting on that flow, saves the fields as variables and resumes it
+func (e *Engine) ProcessFlowReply(ctx context.Context, waID, messageID, responsePayload string) error {
+	submission, send, fields, err := flowdata.RecordReply(waID, messageID, responsePayload)
+	if err != nil {
+		log.Printf("[Flow] Failed to record flow response from %s: %v", waID, err)
+		return err
+	}
+	log.Printf("[Flow] Recorded submission %d from %s (token %s)", submission.ID, waID, submission.FlowToken)
+
+	if send == nil || send.SessionID == nil {
+		return nil
+	}
+
+	var session models.ConversationSession
+	if err := database.GormDB.Where("id = ? AND status = 'active'", *send.SessionID).First(&session).Error; err != nil {
+		return nil
+	}
+
+	for key, value := range fields {
+		if str, ok := value.(string); ok {
+			e.UpdateSessionContext(int(session.ID), key, str)
+		} else {
+			encoded, _ := json.Marshal(value)
+			e.UpdateSessionContext(int(session.ID), key, string(encoded))
+		}
+	}
+
+	return e.ContinueFlow(ctx, waID, int(session.ID), session.FlowID, session.CurrentNode, FlowResponsePrefix+responsePayload)
+}
+
+// ProcessLinkScan runs the automation rule or flow a chat link points to.
+// Contacts in an active chatbot session stay in it.
+func (e *Engine) ProcessLinkScan(ctx context.Context, link models.ChatLink, scan models.ChatLinkScan, messageContent string) error {
+	var active int64
+	database.GormDB.Model(&models.ConversationSession{}).Where("wa_id = ? AND status = 'active'", scan.WaID).Count(&active)
+
+	var err error
+	switch {
+	case active > 0:
+		return e.ProcessIncomingMessage(ctx, scan.WaID, messageContent)
+	case link.FlowID != "":
+		err = e.StartFlow(ctx, scan.WaID, link.FlowID)
+	case link.RuleID != nil:
+		var rule models.AutomationRule
+		if err = database.GormDB.First(&rule, *link.RuleID).Error; err == nil {
+			err = e.executeActions(ctx, int(rule.ID), rule.Actions, scan.WaID, messageContent)
+			if err != nil {
+				e.logAutomation(int(rule.ID), scan.WaID, "chat_link", "action_failed", false, err.Error())
+			} else {
+				e.logAutomation(int(rule.ID), scan.WaID, "chat_link", "action_executed", true, "")
+			}
+		}
+	default:
+		// No target, fall back to the regular rules
+		return e.ProcessIncomingMessage(ctx, scan.WaID, messageContent)
+	}
+
+	if err != nil {
+		log.Printf("[ChatLink] Failed to run target of link %d for %s: %v", link.ID, scan.WaID, err)
+		return err
+	}
+	return database.GormDB.Model(&scan).Update("triggered", true).Error
+}
+
 // evaluateConditions checks if all conditions are met
 func (e *Engine) evaluateConditions(conditionsJSON, waID, messageContent string) bool {
 	var conditions []Condition
@@ -156,14 +231,14 @@ func (e *Engine) hasContactTag(waID, tag string) bool {
 }
 
 // executeActions executes all actions for a matched rule
-func (e *Engine) executeActions(ruleID int, actionsJSON, waID, messageContent string) error {
+func (e *Engine) executeActions(ctx context.Context, ruleID int, actionsJSON, waID, messageContent string) error {
 	var actions []Action
 	if err := json.Unmarshal([]byte(actionsJSON), &actions); err != nil {
 		return err
 	}
 
 	for _, action := range actions {
-		if err := e.executeSingleAction(action, waID, messageContent); err != nil {
+		if err := e.executeSingleAction(ctx, action, waID, messageContent); err != nil {
 			return err
 		}
 	}
@@ -172,7 +247,7 @@ func (e *Engine) executeActions(ruleID int, actionsJSON, waID, messageContent st
 }
 
 // executeSingleAction executes a single action
-func (e *Engine) executeSingleAction(action Action, waID, messageContent string) error {
+func (e *Engine) executeSingleAction(ctx context.Context, action Action, waID, messageContent string) error {
 	switch action.Type {
 	case "send_message":
 		message, ok := action.Params["message"].(string)
@@ -183,7 +258,29 @@ func (e *Engine) executeSingleAction(action Action, waID, messageContent string)
 		message = strings.ReplaceAll(message, "{{contact_name}}", waID)
 		message = strings.ReplaceAll(message, "{{message}}", messageContent)
 
-		return e.WhatsAppClient.SendMessage(waID, message)
+		_, err := e.WhatsAppClient.SendMessage(ctx, waID, message)
+		return err
+
+	case "send_flow":
+		flowID, _ := action.Params["flow_id"].(string)
+		if flowID == "" {
+			return nil
+		}
+		body, _ := action.Params["body"].(string)
+		cta, _ := action.Params["cta"].(string)
+		if cta == "" {
+			cta = "Open"
+		}
+		screen, _ := action.Params["screen"].(string)
+		handler, _ := action.Params["handler"].(string)
+
+		_, err := flowdata.Send(ctx, e.WhatsAppClient, waID, whatsapp.FlowMessage{
+			FlowID: flowID,
+			CTA:    cta,
+			Body:   strings.ReplaceAll(body, "{{message}}", messageContent),
+			Screen: screen,
+		}, handler, nil)
+		return err
 
 	case "add_tag":
 		tag, ok := action.Params["tag"].(string)
@@ -195,7 +292,7 @@ func (e *Engine) executeSingleAction(action Action, waID, messageContent string)
 	case "start_flow":
 		// Support new string-based Flow IDs (UUIDs)
 		if flowID, ok := action.Params["flow_id"].(string); ok {
-			return e.StartFlow(waID, flowID)
+			return e.StartFlow(ctx, waID, flowID)
 		}
 
 		// Legacy support (integer IDs)
@@ -204,6 +301,24 @@ func (e *Engine) executeSingleAction(action Action, waID, messageContent string)
 		}
 		return nil
 
+	case "enroll_sequence":
+		sequenceID, err := sequenceParam(action)
+		if err != nil {
+			return err
+		}
+		_, err = sequence.Enroll(sequenceID, waID, sequence.SourceAutomation)
+		if err == sequence.ErrAlreadyEnrolled {
+			return nil
+		}
+		return err
+
+	case "exit_sequence":
+		sequenceID, err := sequenceParam(action)
+		if err != nil {
+			return err
+		}
+		return sequence.ExitContact(sequenceID, waID, sequence.ExitCancelled)
+
 	default:
 		log.Printf("Unknown action type: %s", action.Type)
 	}
@@ -211,6 +326,44 @@ func (e *Engine) executeSingleAction(action Action, waID, messageContent string)
 	return nil
 }
 
+// ErrInvalidSequenceID means a sequence action has no usable sequence_id
+var ErrInvalidSequenceID = errors.New("sequence_id must be the ID of a sequence")
+
+// sequenceParam reads the sequence_id of an enroll_sequence or exit_sequence action
+func sequenceParam(action Action) (uint, error) {
+	id, ok := action.Params["sequence_id"].(float64)
+	if !ok || id < 1 || id != math.Trunc(id) {
+		return 0, fmt.Errorf("%w: %s has sequence_id %#v", ErrInvalidSequenceID, action.Type, action.Params["sequence_id"])
+	}
+	return uint(id), nil
+}
+
+// ValidateActions checks the actions JSON of a rule before it is saved:
+// sequence actions must name an existing sequence
+func ValidateActions(actionsJSON string) error {
+	var actions []Action
+	if err := json.Unmarshal([]byte(actionsJSON), &actions); err != nil {
+		return fmt.Errorf("actions must be a list of actions: %w", err)
+	}
+	for _, action := range actions {
+		if action.Type != "enroll_sequence" && action.Type != "exit_sequence" {
+			continue
+		}
+		id, err := sequenceParam(action)
+		if err != nil {
+			return err
+		}
+		var count int64
+		if err := database.GormDB.Model(&models.Sequence{}).Where("id = ?", id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("%w: sequence %d not found", ErrInvalidSequenceID, id)
+		}
+	}
+	return nil
+}
+
 // addTagToContact adds a tag to a contact
 func (e *Engine) addTagToContact(waID, tag string) error {
 	var contact models.Contact
@@ -236,7 +389,10 @@ func (e *Engine) addTagToContact(waID, tag string) error {
 	newTags, _ := json.Marshal(tags)
 	contact.Tags = string(newTags)
 
-	return database.GormDB.Save(&contact).Error
+	if err := database.GormDB.Save(&contact).Error; err != nil {
+		return err
+	}
+	return sequence.TagsAdded(waID, []string{tag})
 }
 
 // startChatbotFlow initiates a chatbot conversation flow
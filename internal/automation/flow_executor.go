@@ -1,6 +1,7 @@
 package automation
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -9,12 +10,13 @@ import (
 	"strings"
 	"time"
 	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/flowdata"
 	"whatsapp-gateway/internal/models"
 	"whatsapp-gateway/internal/whatsapp"
 )
 
 // StartFlow initiates a flow for a user
-func (e *Engine) StartFlow(waID string, flowID string) error {
+func (e *Engine) StartFlow(ctx context.Context, waID string, flowID string) error {
 	// 1. Fetch Graph Data Relationally
 	graph, err := e.LoadGraph(flowID)
 	if err != nil {
@@ -60,11 +62,11 @@ func (e *Engine) StartFlow(waID string, flowID string) error {
 	}
 
 	// 5. Execute Start Node
-	return e.ExecuteNode(waID, *startNode, *graph)
+	return e.ExecuteNode(ctx, waID, *startNode, *graph)
 }
 
 // ContinueFlow handles user input in an active flow
-func (e *Engine) ContinueFlow(waID string, sessionID int, flowID, currentNodeID string, messageContent string) error {
+func (e *Engine) ContinueFlow(ctx context.Context, waID string, sessionID int, flowID, currentNodeID string, messageContent string) error {
 	log.Printf("[ContinueFlow] waID=%s, sessionID=%d, flowID=%s, currentNodeID=%s, messageContent='%s'",
 		waID, sessionID, flowID, currentNodeID, messageContent)
 
@@ -109,6 +111,15 @@ func (e *Engine) ContinueFlow(waID string, sessionID int, flowID, currentNodeID
 		stepType = lastStep.Type
 	}
 
+	// A WhatsApp Flow step only continues once the flow is submitted
+	if stepType == "WhatsApp Flow" {
+		if !strings.HasPrefix(messageContent, FlowResponsePrefix) {
+			log.Printf("[ContinueFlow] Waiting for flow response from %s, ignoring '%s'", waID, messageContent)
+			return nil
+		}
+		messageContent = strings.TrimPrefix(messageContent, FlowResponsePrefix)
+	}
+
 	// Check Validation
 	isValid := true
 	errorMessage := "Invalid input. Please try again."
@@ -156,14 +167,14 @@ func (e *Engine) ContinueFlow(waID string, sessionID int, flowID, currentNodeID
 
 		if currentRetries < maxRetries {
 			// Send Error Message
-			e.WhatsAppClient.SendMessage(waID, errorMessage)
+			e.WhatsAppClient.SendMessage(ctx, waID, errorMessage)
 			// Increment Retries
 			e.UpdateSessionContext(sessionID, retryKey, fmt.Sprintf("%d", currentRetries+1))
 			// Stay on current node (Return)
 			return nil
 		} else {
 			// Retries exhausted
-			e.WhatsAppClient.SendMessage(waID, "Too many invalid attempts. Session ended.")
+			e.WhatsAppClient.SendMessage(ctx, waID, "Too many invalid attempts. Session ended.")
 			e.TerminateSessionByID(sessionID)
 			return nil
 		}
@@ -211,7 +222,7 @@ func (e *Engine) ContinueFlow(waID string, sessionID int, flowID, currentNodeID
 			}
 
 			log.Printf("[ContinueFlow] Executing next node: %s (label: %s)", nextNodeID, nextNode.Data.Label)
-			return e.ExecuteNode(waID, *nextNode, *graph)
+			return e.ExecuteNode(ctx, waID, *nextNode, *graph)
 		} else {
 			// End of Flow?
 			log.Printf("[ContinueFlow] No next node found, terminating session")
@@ -351,13 +362,13 @@ func (e *Engine) FindNextNodeID(currentNode *ReactFlowNode, edges []ReactFlowEdg
 	return ""
 }
 
-func (e *Engine) ExecuteNode(waID string, node ReactFlowNode, graph FlowGraphData) error {
+func (e *Engine) ExecuteNode(ctx context.Context, waID string, node ReactFlowNode, graph FlowGraphData) error {
 	// Iterate through steps and execute them
 	for _, step := range node.Data.Steps {
 		switch step.Type {
 		case "Text", "Text Message":
 			text := e.ReplaceVariables(waID, step.Content)
-			e.WhatsAppClient.SendMessage(waID, text)
+			e.WhatsAppClient.SendMessage(ctx, waID, text)
 
 		case "Quick Reply":
 			// Send Interactive Button Message
@@ -379,7 +390,7 @@ func (e *Engine) ExecuteNode(waID string, node ReactFlowNode, graph FlowGraphDat
 				})
 			}
 
-			e.WhatsAppClient.SendInteractiveButtons(waID, text, buttons)
+			e.WhatsAppClient.SendInteractiveButtons(ctx, waID, text, buttons)
 
 		case "List":
 			// Send Interactive List Message
@@ -403,7 +414,7 @@ func (e *Engine) ExecuteNode(waID string, node ReactFlowNode, graph FlowGraphDat
 			}
 
 			if len(options) > 0 {
-				e.WhatsAppClient.SendInteractiveList(waID, text, buttonText, options)
+				e.WhatsAppClient.SendInteractiveList(ctx, waID, text, buttonText, options)
 			}
 
 		case "Chatbot":
@@ -448,7 +459,7 @@ func (e *Engine) ExecuteNode(waID string, node ReactFlowNode, graph FlowGraphDat
 					}
 					if targetNode == nil {
 						log.Printf("[ExecuteNode] Target node %s not found", step.TargetNodeId)
-						e.WhatsAppClient.SendMessage(waID, "Error: Target node not found.")
+						e.WhatsAppClient.SendMessage(ctx, waID, "Error: Target node not found.")
 						return fmt.Errorf("target node not found")
 					}
 				} else {
@@ -461,26 +472,29 @@ func (e *Engine) ExecuteNode(waID string, node ReactFlowNode, graph FlowGraphDat
 					}
 					if targetNode == nil {
 						log.Printf("[ExecuteNode] Start node not found in target flow")
-						e.WhatsAppClient.SendMessage(waID, "Error: Start node not found in target flow.")
+						e.WhatsAppClient.SendMessage(ctx, waID, "Error: Start node not found in target flow.")
 						return fmt.Errorf("start node not found")
 					}
 				}
 
 				// Execute target node
-				return e.ExecuteNode(waID, *targetNode, *targetGraph)
+				return e.ExecuteNode(ctx, waID, *targetNode, *targetGraph)
 			}
 
 		case "Image":
 			caption := e.ReplaceVariables(waID, step.Content)
-			err := e.WhatsAppClient.SendRawMessage(whatsapp.GenericMessage{
-				MessagingProduct: "whatsapp",
-				RecipientType:    "individual",
-				To:               waID,
-				Type:             "image",
-				Image: &whatsapp.MediaObj{
-					ID:      step.MediaId,
-					Caption: caption,
-				},
+			err := e.sendMedia(ctx, step.MediaId, func(mediaID string) error {
+				_, err := e.WhatsAppClient.SendRawMessage(ctx, whatsapp.GenericMessage{
+					MessagingProduct: "whatsapp",
+					RecipientType:    "individual",
+					To:               waID,
+					Type:             "image",
+					Image: &whatsapp.MediaObj{
+						ID:      mediaID,
+						Caption: caption,
+					},
+				})
+				return err
 			})
 			if err != nil {
 				log.Printf("[ExecuteNode] Error sending Image: %v", err)
@@ -489,15 +503,18 @@ func (e *Engine) ExecuteNode(waID string, node ReactFlowNode, graph FlowGraphDat
 
 		case "Video":
 			caption := e.ReplaceVariables(waID, step.Content)
-			err := e.WhatsAppClient.SendRawMessage(whatsapp.GenericMessage{
-				MessagingProduct: "whatsapp",
-				RecipientType:    "individual",
-				To:               waID,
-				Type:             "video",
-				Video: &whatsapp.MediaObj{
-					ID:      step.MediaId,
-					Caption: caption,
-				},
+			err := e.sendMedia(ctx, step.MediaId, func(mediaID string) error {
+				_, err := e.WhatsAppClient.SendRawMessage(ctx, whatsapp.GenericMessage{
+					MessagingProduct: "whatsapp",
+					RecipientType:    "individual",
+					To:               waID,
+					Type:             "video",
+					Video: &whatsapp.MediaObj{
+						ID:      mediaID,
+						Caption: caption,
+					},
+				})
+				return err
 			})
 			if err != nil {
 				log.Printf("[ExecuteNode] Error sending Video: %v", err)
@@ -505,14 +522,17 @@ func (e *Engine) ExecuteNode(waID string, node ReactFlowNode, graph FlowGraphDat
 			time.Sleep(1 * time.Second)
 
 		case "Audio":
-			err := e.WhatsAppClient.SendRawMessage(whatsapp.GenericMessage{
-				MessagingProduct: "whatsapp",
-				RecipientType:    "individual",
-				To:               waID,
-				Type:             "audio",
-				Audio: &whatsapp.MediaObj{
-					ID: step.MediaId,
-				},
+			err := e.sendMedia(ctx, step.MediaId, func(mediaID string) error {
+				_, err := e.WhatsAppClient.SendRawMessage(ctx, whatsapp.GenericMessage{
+					MessagingProduct: "whatsapp",
+					RecipientType:    "individual",
+					To:               waID,
+					Type:             "audio",
+					Audio: &whatsapp.MediaObj{
+						ID: mediaID,
+					},
+				})
+				return err
 			})
 			if err != nil {
 				log.Printf("[ExecuteNode] Error sending Audio: %v", err)
@@ -520,16 +540,19 @@ func (e *Engine) ExecuteNode(waID string, node ReactFlowNode, graph FlowGraphDat
 			time.Sleep(1 * time.Second)
 
 		case "File":
-			err := e.WhatsAppClient.SendRawMessage(whatsapp.GenericMessage{
-				MessagingProduct: "whatsapp",
-				RecipientType:    "individual",
-				To:               waID,
-				Type:             "document",
-				Document: &whatsapp.MediaObj{
-					ID:       step.MediaId,
-					Caption:  step.Content,
-					Filename: "File",
-				},
+			err := e.sendMedia(ctx, step.MediaId, func(mediaID string) error {
+				_, err := e.WhatsAppClient.SendRawMessage(ctx, whatsapp.GenericMessage{
+					MessagingProduct: "whatsapp",
+					RecipientType:    "individual",
+					To:               waID,
+					Type:             "document",
+					Document: &whatsapp.MediaObj{
+						ID:       mediaID,
+						Caption:  step.Content,
+						Filename: "File",
+					},
+				})
+				return err
 			})
 			if err != nil {
 				log.Printf("[ExecuteNode] Error sending File: %v", err)
@@ -542,7 +565,7 @@ func (e *Engine) ExecuteNode(waID string, node ReactFlowNode, graph FlowGraphDat
 			name := e.ReplaceVariables(waID, step.Name)
 			address := e.ReplaceVariables(waID, step.Address)
 
-			err := e.WhatsAppClient.SendRawMessage(whatsapp.GenericMessage{
+			_, err := e.WhatsAppClient.SendRawMessage(ctx, whatsapp.GenericMessage{
 				MessagingProduct: "whatsapp",
 				RecipientType:    "individual",
 				To:               waID,
@@ -561,7 +584,7 @@ func (e *Engine) ExecuteNode(waID string, node ReactFlowNode, graph FlowGraphDat
 
 		case "YouTube":
 			url := e.ReplaceVariables(waID, step.Url)
-			err := e.WhatsAppClient.SendRawMessage(whatsapp.GenericMessage{
+			_, err := e.WhatsAppClient.SendRawMessage(ctx, whatsapp.GenericMessage{
 				MessagingProduct: "whatsapp",
 				RecipientType:    "individual",
 				To:               waID,
@@ -575,6 +598,28 @@ func (e *Engine) ExecuteNode(waID string, node ReactFlowNode, graph FlowGraphDat
 				log.Printf("[ExecuteNode] Error sending YouTube: %v", err)
 			}
 
+		case "WhatsApp Flow":
+			var session models.ConversationSession
+			database.GormDB.Where("wa_id = ? AND status='active'", waID).First(&session)
+			var sessionID *uint
+			if session.ID != 0 {
+				sessionID = &session.ID
+			}
+
+			cta := step.FlowCta
+			if cta == "" {
+				cta = "Open"
+			}
+			_, err := flowdata.Send(ctx, e.WhatsAppClient, waID, whatsapp.FlowMessage{
+				FlowID: step.WaFlowId,
+				CTA:    cta,
+				Body:   e.ReplaceVariables(waID, step.Content),
+				Screen: step.FlowScreen,
+			}, step.FlowHandler, sessionID)
+			if err != nil {
+				log.Printf("[ExecuteNode] Error sending WhatsApp Flow: %v", err)
+			}
+
 		case "Text Input", "Number Input", "Email Input":
 			// Input steps don't send messages - they just wait for user input
 			// The user should add a Text step before the Input step to ask the question
@@ -588,7 +633,7 @@ func (e *Engine) ExecuteNode(waID string, node ReactFlowNode, graph FlowGraphDat
 		if strings.Contains(lastStep.Type, "Input") {
 			return nil // Stop and wait.
 		}
-		if lastStep.Type == "Quick Reply" || lastStep.Type == "List" {
+		if lastStep.Type == "Quick Reply" || lastStep.Type == "List" || lastStep.Type == "WhatsApp Flow" {
 			return nil // Stop and wait.
 		}
 	}
@@ -607,7 +652,7 @@ func (e *Engine) ExecuteNode(waID string, node ReactFlowNode, graph FlowGraphDat
 				break
 			}
 		}
-		return e.ExecuteNode(waID, nextNode, graph)
+		return e.ExecuteNode(ctx, waID, nextNode, graph)
 	} else {
 		// End of Flow
 		var session models.ConversationSession
@@ -618,6 +663,15 @@ func (e *Engine) ExecuteNode(waID string, node ReactFlowNode, graph FlowGraphDat
 	return nil
 }
 
+// sendMedia resolves a media library reference (refreshing expired Meta IDs)
+// before sending. Steps may still hold raw Meta IDs, which pass through.
+func (e *Engine) sendMedia(ctx context.Context, ref string, send func(mediaID string) error) error {
+	if e.Media == nil {
+		return send(ref)
+	}
+	return e.Media.SendWithRetry(ctx, ref, send)
+}
+
 func (e *Engine) TerminateSession(waID string) {
 	database.GormDB.Model(&models.ConversationSession{}).Where("wa_id = ? AND status = 'active'", waID).Update("status", "completed")
 }
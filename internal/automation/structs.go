@@ -35,12 +35,16 @@ type ReactFlowStep struct {
 	Validation   *StepValidation `json:"validation,omitempty"`
 	TargetFlowId string          `json:"targetFlowId,omitempty"` // For Chatbot step
 	TargetNodeId string          `json:"targetNodeId,omitempty"` // For Chatbot step
-	MediaId      string          `json:"mediaId,omitempty"`      // For Image, Video, Audio, File
+	MediaId      string          `json:"mediaId,omitempty"`      // Media library ID (or raw Meta ID) for Image, Video, Audio, File
 	Url          string          `json:"url,omitempty"`          // For YouTube
 	Latitude     string          `json:"latitude,omitempty"`     // For Location
 	Longitude    string          `json:"longitude,omitempty"`    // For Location
 	Name         string          `json:"name,omitempty"`         // For Location
 	Address      string          `json:"address,omitempty"`      // For Location
+	WaFlowId     string          `json:"waFlowId,omitempty"`     // Meta flow ID for WhatsApp Flow
+	FlowCta      string          `json:"flowCta,omitempty"`      // Button text for WhatsApp Flow
+	FlowScreen   string          `json:"flowScreen,omitempty"`   // First screen for WhatsApp Flow (empty uses the data endpoint)
+	FlowHandler  string          `json:"flowHandler,omitempty"`  // Data endpoint handler for WhatsApp Flow
 }
 
 type QuickReplyBtn struct {
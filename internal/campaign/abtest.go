@@ -0,0 +1,107 @@
+package campaign
+
+import (
+	"context"
+	"log"
+	"time"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// VariantResult is how one variant of an A/B test did
+type VariantResult struct {
+	models.CampaignVariant
+	Funnel *Funnel `json:"funnel"`
+	Score  float64 `json:"score"` // Rate of the campaign's winner metric
+}
+
+// VariantResults returns the funnel of each variant of an A/B tested campaign.
+// Once the winner is picked, its funnel includes the recipients sent to after
+// the test.
+func VariantResults(c *models.Campaign) ([]VariantResult, error) {
+	var variants []models.CampaignVariant
+	if err := database.GormDB.Where("campaign_id = ?", c.ID).Order("id").Find(&variants).Error; err != nil {
+		return nil, err
+	}
+	results := make([]VariantResult, 0, len(variants))
+	for _, v := range variants {
+		f, err := countFunnel(func() *gorm.DB {
+			return database.GormDB.Model(&models.CampaignRecipient{}).Where("campaign_id = ? AND variant_id = ?", c.ID, v.ID)
+		})
+		if err != nil {
+			return nil, err
+		}
+		score := f.Rates.Read
+		if c.WinnerMetric == MetricReply {
+			score = f.Rates.Reply
+		}
+		results = append(results, VariantResult{CampaignVariant: v, Funnel: f, Score: score})
+	}
+	return results, nil
+}
+
+// pickWinner waits until the test period after the test sends is over, then
+// picks the variant with the best score and releases the held recipients to
+// it. A tie goes to the first variant. It returns early when ctx is cancelled.
+func (r *Runner) pickWinner(ctx context.Context, c *models.Campaign) error {
+	if c.TestEndsAt == nil {
+		ends := time.Now().Add(time.Duration(c.DecideAfter) * time.Minute)
+		c.TestEndsAt = &ends
+		if err := database.GormDB.Model(c).Update("test_ends_at", ends).Error; err != nil {
+			return err
+		}
+		refreshCounts(c)
+		r.notify("campaign_testing", c)
+		log.Printf("[Campaign] Campaign %d test sends done, picking the winner at %s", c.ID, ends.Format(time.RFC3339))
+	}
+
+	timer := time.NewTimer(time.Until(*c.TestEndsAt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-timer.C:
+	}
+
+	results, err := VariantResults(c)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return ErrInvalidABTest
+	}
+	winner := results[0]
+	for _, result := range results[1:] {
+		if result.Score > winner.Score {
+			winner = result
+		}
+	}
+
+	err = database.GormDB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(c).Where("status = ?", StatusRunning).Updates(map[string]interface{}{
+			"winner_variant_id": winner.ID,
+			"template_name":     winner.TemplateName,
+			"language":          winner.Language,
+			"parameters":        winner.Parameters,
+		})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error // Paused or cancelled meanwhile
+		}
+		if err := tx.Model(&models.CampaignVariant{}).Where("id = ?", winner.ID).Update("winner", true).Error; err != nil {
+			return err
+		}
+		c.WinnerVariantID = &winner.ID
+		return tx.Model(&models.CampaignRecipient{}).
+			Where("campaign_id = ? AND status = ?", c.ID, RecipientHeld).
+			Updates(map[string]interface{}{"status": RecipientPending, "variant_id": winner.ID}).Error
+	})
+	if err != nil || c.WinnerVariantID == nil {
+		return err
+	}
+	c.TemplateName, c.Language, c.Parameters = winner.TemplateName, winner.Language, winner.Parameters
+	r.notify("campaign_winner_selected", c)
+	log.Printf("[Campaign] Campaign %d picked variant %s (%s) with a %s rate of %.1f%%", c.ID, winner.Label, winner.TemplateName, c.WinnerMetric, winner.Score*100)
+	return nil
+}
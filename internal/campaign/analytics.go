@@ -0,0 +1,356 @@
+package campaign
+
+import (
+	"errors"
+	"sort"
+	"time"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var ErrUnknownInterval = errors.New("interval must be hour or day")
+
+// earlyStatusTTL is how long a status that arrived before its wamid was saved
+// is kept. Sends time out long before that.
+const earlyStatusTTL = time.Hour
+
+// TrackStatus applies a status webhook to the campaign recipient the message
+// was sent to. Messages that were not sent by a campaign are ignored. A status
+// can arrive before the runner saved the wamid; while a campaign message to
+// recipientWaID is being sent, it is kept and applied once the wamid is saved.
+func TrackStatus(wamID, recipientWaID, status string, at time.Time, errorCode int, errorTitle string) error {
+	if wamID == "" {
+		return nil
+	}
+	var known int64
+	if err := database.GormDB.Model(&models.CampaignRecipient{}).Where("wamid = ?", wamID).Count(&known).Error; err != nil {
+		return err
+	}
+	if known > 0 {
+		return applyStatus(wamID, status, at, errorCode, errorTitle)
+	}
+
+	var sending int64
+	err := database.GormDB.Model(&models.CampaignRecipient{}).
+		Where("contact_wa_id = ? AND status = ?", recipientWaID, RecipientSending).Count(&sending).Error
+	if err != nil || sending == 0 {
+		return err
+	}
+	database.GormDB.Where("created_at < ?", time.Now().Add(-earlyStatusTTL)).Delete(&models.CampaignStatusEvent{})
+	event := models.CampaignStatusEvent{WamID: wamID, Status: status, ErrorCode: errorCode, ErrorTitle: errorTitle, At: at}
+	if err := database.GormDB.Create(&event).Error; err != nil {
+		return err
+	}
+	// The runner may have saved the wamid meanwhile
+	return applyEarlyStatuses(wamID)
+}
+
+// applyEarlyStatuses applies the statuses that arrived for wamID before it was
+// saved on its recipient. Applying a status twice changes nothing, so the
+// runner and the webhook may both get here.
+func applyEarlyStatuses(wamID string) error {
+	var events []models.CampaignStatusEvent
+	if err := database.GormDB.Where("wamid = ?", wamID).Order("at").Find(&events).Error; err != nil || len(events) == 0 {
+		return err
+	}
+	var known int64
+	if err := database.GormDB.Model(&models.CampaignRecipient{}).Where("wamid = ?", wamID).Count(&known).Error; err != nil || known == 0 {
+		return err
+	}
+	for _, event := range events {
+		if err := applyStatus(wamID, event.Status, event.At, event.ErrorCode, event.ErrorTitle); err != nil {
+			return err
+		}
+	}
+	return database.GormDB.Delete(&events).Error
+}
+
+func applyStatus(wamID, status string, at time.Time, errorCode int, errorTitle string) error {
+	recipients := func() *gorm.DB {
+		return database.GormDB.Model(&models.CampaignRecipient{}).Where("wamid = ?", wamID)
+	}
+	switch status {
+	case "delivered":
+		return recipients().Where("delivered_at IS NULL").Update("delivered_at", at).Error
+	case "read":
+		// A read receipt can arrive without a delivered one
+		if err := recipients().Where("delivered_at IS NULL").Update("delivered_at", at).Error; err != nil {
+			return err
+		}
+		return recipients().Where("read_at IS NULL").Update("read_at", at).Error
+	case "failed":
+		// A late failure does not undo a delivery
+		return recipients().Where("delivered_at IS NULL AND read_at IS NULL").Updates(map[string]interface{}{
+			"status":     RecipientFailed,
+			"error_code": errorCode,
+			"error":      errorTitle,
+			"failed_at":  at,
+		}).Error
+	}
+	return nil
+}
+
+// attributed finds the campaign recipient an inbound message from waID
+// belongs to: the message it replies to or, failing that, the latest
+// campaign message sent to the contact within window before at
+func attributed(waID, repliedTo string, at time.Time, window time.Duration) (*models.CampaignRecipient, error) {
+	var recipient models.CampaignRecipient
+	if repliedTo != "" {
+		result := database.GormDB.Where("wamid = ? AND contact_wa_id = ?", repliedTo, waID).Limit(1).Find(&recipient)
+		if result.Error != nil || result.RowsAffected > 0 {
+			return &recipient, result.Error
+		}
+	}
+	result := database.GormDB.Where("contact_wa_id = ? AND sent_at BETWEEN ? AND ?", waID, at.Add(-window), at).
+		Order("sent_at DESC").Limit(1).Find(&recipient)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return &recipient, nil
+}
+
+// TrackReply attributes an inbound message to a campaign. clicked marks a
+// quick reply button click, which also counts as a reply.
+func TrackReply(waID, repliedTo string, clicked bool, at time.Time, window time.Duration) error {
+	recipient, err := attributed(waID, repliedTo, at, window)
+	if err != nil || recipient == nil {
+		return err
+	}
+	if recipient.RepliedAt == nil {
+		if err := database.GormDB.Model(recipient).Update("replied_at", at).Error; err != nil {
+			return err
+		}
+	}
+	if clicked && recipient.ClickedAt == nil {
+		return database.GormDB.Model(recipient).Update("clicked_at", at).Error
+	}
+	return nil
+}
+
+// TrackOptOut attributes an opt-out to the campaign the contact last received
+// within window
+func TrackOptOut(waID string, at time.Time, window time.Duration) error {
+	recipient, err := attributed(waID, "", at, window)
+	if err != nil || recipient == nil || recipient.OptedOutAt != nil {
+		return err
+	}
+	return database.GormDB.Model(recipient).Update("opted_out_at", at).Error
+}
+
+// Funnel is how far a campaign's recipients got
+type Funnel struct {
+	CampaignID   uint           `json:"campaign_id"`
+	Name         string         `json:"name"`
+	TemplateName string         `json:"template_name"`
+	Status       string         `json:"status"`
+	StartedAt    *time.Time     `json:"started_at"`
+	Recipients   int64          `json:"recipients"`
+	Sent         int64          `json:"sent"` // Accepted by Meta
+	Delivered    int64          `json:"delivered"`
+	Read         int64          `json:"read"`
+	Failed       int64          `json:"failed"`
+	Suppressed   int64          `json:"suppressed"`
+	Replied      int64          `json:"replied"`
+	Clicked      int64          `json:"clicked"`
+	OptedOut     int64          `json:"opted_out"`
+	Rates        FunnelRates    `json:"rates"`
+	Failures     []FailureCount `json:"failures_by_code"`
+}
+
+// FunnelRates are shares between 0 and 1. Delivery and failure are of the
+// attempted sends, the others of the delivered messages.
+type FunnelRates struct {
+	Delivery float64 `json:"delivery"`
+	Failure  float64 `json:"failure"`
+	Read     float64 `json:"read"`
+	Reply    float64 `json:"reply"`
+	Click    float64 `json:"click"`
+	OptOut   float64 `json:"opt_out"`
+}
+
+// FailureCount is the number of failed recipients with one error code.
+// Code 0 are failures without a Meta error code, e.g. network errors.
+type FailureCount struct {
+	Code  int    `json:"code"`
+	Error string `json:"error"` // One of the error messages, as an example
+	Count int64  `json:"count"`
+}
+
+// GetFunnel aggregates a campaign's recipients into its funnel
+func GetFunnel(c *models.Campaign) (*Funnel, error) {
+	f, err := countFunnel(func() *gorm.DB {
+		return database.GormDB.Model(&models.CampaignRecipient{}).Where("campaign_id = ?", c.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	f.CampaignID, f.Name, f.TemplateName, f.Status, f.StartedAt = c.ID, c.Name, c.TemplateName, c.Status, c.StartedAt
+	return f, nil
+}
+
+// countFunnel aggregates the recipients selected by recipients
+func countFunnel(recipients func() *gorm.DB) (*Funnel, error) {
+	var row struct {
+		Recipients int64
+		Attempted  int64
+		Sent       int64
+		Delivered  int64
+		ReadCount  int64
+		Failed     int64
+		Suppressed int64
+		Replied    int64
+		Clicked    int64
+		OptedOut   int64
+	}
+	err := recipients().Select(`COUNT(*) AS recipients,
+		SUM(CASE WHEN sent_at IS NOT NULL OR status = ? THEN 1 ELSE 0 END) AS attempted,
+		COUNT(sent_at) AS sent,
+		COUNT(delivered_at) AS delivered,
+		COUNT(read_at) AS read_count,
+		SUM(CASE WHEN status = ? THEN 1 ELSE 0 END) AS failed,
+		SUM(CASE WHEN status = ? THEN 1 ELSE 0 END) AS suppressed,
+		COUNT(replied_at) AS replied,
+		COUNT(clicked_at) AS clicked,
+		COUNT(opted_out_at) AS opted_out`, RecipientFailed, RecipientFailed, RecipientSuppressed).
+		Scan(&row).Error
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Funnel{
+		Recipients: row.Recipients,
+		Sent:       row.Sent,
+		Delivered:  row.Delivered,
+		Read:       row.ReadCount,
+		Failed:     row.Failed,
+		Suppressed: row.Suppressed,
+		Replied:    row.Replied,
+		Clicked:    row.Clicked,
+		OptedOut:   row.OptedOut,
+		Rates: FunnelRates{
+			Delivery: ratio(row.Delivered, row.Attempted),
+			Failure:  ratio(row.Failed, row.Attempted),
+			Read:     ratio(row.ReadCount, row.Delivered),
+			Reply:    ratio(row.Replied, row.Delivered),
+			Click:    ratio(row.Clicked, row.Delivered),
+			OptOut:   ratio(row.OptedOut, row.Delivered),
+		},
+		Failures: []FailureCount{},
+	}
+
+	err = recipients().Select("error_code AS code, MAX(error) AS error, COUNT(*) AS count").
+		Where("status = ?", RecipientFailed).
+		Group("error_code").Order("count DESC").Scan(&f.Failures).Error
+	return f, err
+}
+
+// Compare returns the funnels of the campaigns in the order given
+func Compare(ids []uint) ([]Funnel, error) {
+	var campaigns []models.Campaign
+	if err := database.GormDB.Where("id IN ?", ids).Find(&campaigns).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]*models.Campaign, len(campaigns))
+	for i := range campaigns {
+		byID[campaigns[i].ID] = &campaigns[i]
+	}
+
+	funnels := make([]Funnel, 0, len(ids))
+	for _, id := range ids {
+		c, ok := byID[id]
+		if !ok {
+			continue
+		}
+		f, err := GetFunnel(c)
+		if err != nil {
+			return nil, err
+		}
+		funnels = append(funnels, *f)
+	}
+	return funnels, nil
+}
+
+// Point is one bucket of a campaign's time series. Each event is counted in
+// the bucket it happened in.
+type Point struct {
+	Bucket    string `json:"bucket"` // UTC, 2006-01-02 or 2006-01-02T15:00
+	Sent      int64  `json:"sent"`
+	Delivered int64  `json:"delivered"`
+	Read      int64  `json:"read"`
+	Failed    int64  `json:"failed"`
+	Replied   int64  `json:"replied"`
+	Clicked   int64  `json:"clicked"`
+	OptedOut  int64  `json:"opted_out"`
+}
+
+// TimeSeries counts a campaign's events per hour or day
+func TimeSeries(campaignID uint, interval string) ([]Point, error) {
+	if interval != "hour" && interval != "day" {
+		return nil, ErrUnknownInterval
+	}
+
+	buckets := map[string]*Point{}
+	metrics := []struct {
+		column string
+		add    func(p *Point, n int64)
+	}{
+		{"sent_at", func(p *Point, n int64) { p.Sent += n }},
+		{"delivered_at", func(p *Point, n int64) { p.Delivered += n }},
+		{"read_at", func(p *Point, n int64) { p.Read += n }},
+		{"failed_at", func(p *Point, n int64) { p.Failed += n }},
+		{"replied_at", func(p *Point, n int64) { p.Replied += n }},
+		{"clicked_at", func(p *Point, n int64) { p.Clicked += n }},
+		{"opted_out_at", func(p *Point, n int64) { p.OptedOut += n }},
+	}
+	for _, metric := range metrics {
+		var rows []struct {
+			Bucket string
+			Count  int64
+		}
+		bucket := bucketExpression(metric.column, interval)
+		err := database.GormDB.Model(&models.CampaignRecipient{}).
+			Select(bucket+" AS bucket, COUNT(*) AS count").
+			Where("campaign_id = ? AND "+metric.column+" IS NOT NULL", campaignID).
+			Group(bucket).Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			p, ok := buckets[row.Bucket]
+			if !ok {
+				p = &Point{Bucket: row.Bucket}
+				buckets[row.Bucket] = p
+			}
+			metric.add(p, row.Count)
+		}
+	}
+
+	points := make([]Point, 0, len(buckets))
+	for _, p := range buckets {
+		points = append(points, *p)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Bucket < points[j].Bucket })
+	return points, nil
+}
+
+func bucketExpression(column, interval string) string {
+	if database.IsPostgres() {
+		if interval == "hour" {
+			return "to_char(" + column + ` AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:00')`
+		}
+		return "to_char(" + column + " AT TIME ZONE 'UTC', 'YYYY-MM-DD')"
+	}
+	if interval == "hour" {
+		return "strftime('%Y-%m-%dT%H:00', " + column + ")"
+	}
+	return "strftime('%Y-%m-%d', " + column + ")"
+}
+
+func ratio(part, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole)
+}
@@ -0,0 +1,346 @@
+package campaign
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"whatsapp-gateway/internal/consent"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/phone"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidAudience = errors.New("invalid audience")
+	ErrEmptyAudience   = fmt.Errorf("%w: it needs contacts, a filter or all: true", ErrInvalidAudience)
+)
+
+// Audience selects the recipients of a campaign. Explicit contacts (typed or
+// from a CSV upload) or, with All, every contact form the base set; the
+// filters then narrow it down.
+type Audience struct {
+	All              bool        `json:"all,omitempty"`
+	Contacts         []string    `json:"contacts,omitempty"`
+	Tags             []string    `json:"tags,omitempty"`               // Must have all of these
+	AnyTags          []string    `json:"any_tags,omitempty"`           // Must have at least one of these
+	ExcludeTags      []string    `json:"exclude_tags,omitempty"`       // Must have none of these
+	Conditions       []Condition `json:"conditions,omitempty"`         // All must match
+	ActiveWithinDays int         `json:"active_within_days,omitempty"` // Wrote to us in the last N days
+	InactiveForDays  int         `json:"inactive_for_days,omitempty"`  // Hasn't written for N days, or never
+}
+
+// Condition compares a contact attribute (name, wa_id, country, created_at,
+// last_inbound_at) with a value. Operators: equals, not_equals, contains,
+// starts_with, is_empty, not_empty and, for dates, before and after.
+type Condition struct {
+	Field    string `json:"field"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// Resolved is an audience turned into recipients
+type Resolved struct {
+	WaIDs      []string // Distinct recipients that may be messaged
+	Suppressed []string // Matched but opted out
+	Duplicates int      // Explicit contacts listed more than once
+	Invalid    int      // Explicit contacts that are not phone numbers
+}
+
+func (a Audience) hasFilters() bool {
+	return len(a.Tags) > 0 || len(a.AnyTags) > 0 || len(a.ExcludeTags) > 0 || len(a.Conditions) > 0 ||
+		a.ActiveWithinDays > 0 || a.InactiveForDays > 0
+}
+
+// Validate checks the audience before it is stored
+func (a Audience) Validate() error {
+	if !a.All && len(a.Contacts) == 0 && !a.hasFilters() {
+		return ErrEmptyAudience
+	}
+	for _, cond := range a.Conditions {
+		if _, ok := conditionFields[cond.Field]; !ok {
+			return fmt.Errorf("%w: unknown condition field %q", ErrInvalidAudience, cond.Field)
+		}
+		switch cond.Operator {
+		case "equals", "not_equals", "contains", "starts_with", "is_empty", "not_empty":
+		case "before", "after":
+			if _, err := parseDate(cond.Value); err != nil {
+				return fmt.Errorf("%w: condition on %s needs a date, use RFC3339 or YYYY-MM-DD", ErrInvalidAudience, cond.Field)
+			}
+		default:
+			return fmt.Errorf("%w: unknown condition operator %q", ErrInvalidAudience, cond.Operator)
+		}
+	}
+	return nil
+}
+
+// Resolve finds the contacts of the audience now. Contacts suppressed for
+// all messages or for templateCategory are reported separately.
+func Resolve(a Audience, templateCategory string) (*Resolved, error) {
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	res := &Resolved{}
+	var matched []string
+	collect := func(contacts []models.Contact) {
+		for _, contact := range contacts {
+			if a.matches(contact) {
+				matched = append(matched, contact.WaID)
+			}
+		}
+	}
+
+	if len(a.Contacts) > 0 {
+		waIDs := normalizeList(a.Contacts, res)
+		for start := 0; start < len(waIDs); start += 500 {
+			chunk := waIDs[start:min(start+500, len(waIDs))]
+			var found []models.Contact
+			if err := database.GormDB.Where("wa_id IN ?", chunk).Find(&found).Error; err != nil {
+				return nil, err
+			}
+			byID := make(map[string]models.Contact, len(found))
+			for _, contact := range found {
+				byID[contact.WaID] = contact
+			}
+			// Unknown numbers are still messaged, they just have no attributes
+			contacts := make([]models.Contact, 0, len(chunk))
+			for _, waID := range chunk {
+				contact, ok := byID[waID]
+				if !ok {
+					contact = models.Contact{WaID: waID}
+				}
+				contacts = append(contacts, contact)
+			}
+			collect(contacts)
+		}
+	} else {
+		var batch []models.Contact
+		err := a.prefilter(database.GormDB.Model(&models.Contact{})).
+			FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+				collect(batch)
+				return nil
+			}).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	suppressed, err := suppressedSet(templateCategory)
+	if err != nil {
+		return nil, err
+	}
+	for _, waID := range matched {
+		if suppressed[waID] {
+			res.Suppressed = append(res.Suppressed, waID)
+		} else {
+			res.WaIDs = append(res.WaIDs, waID)
+		}
+	}
+	return res, nil
+}
+
+// prefilter narrows the contacts query in SQL; matches has the final say
+func (a Audience) prefilter(query *gorm.DB) *gorm.DB {
+	for _, tag := range a.Tags {
+		query = query.Where("LOWER(tags) LIKE ?", "%"+strings.ToLower(tag)+"%")
+	}
+	if a.ActiveWithinDays > 0 {
+		query = query.Where("last_inbound_at >= ?", time.Now().AddDate(0, 0, -a.ActiveWithinDays))
+	}
+	if a.InactiveForDays > 0 {
+		query = query.Where("last_inbound_at IS NULL OR last_inbound_at < ?", time.Now().AddDate(0, 0, -a.InactiveForDays))
+	}
+	return query
+}
+
+func (a Audience) matches(contact models.Contact) bool {
+	tags := map[string]bool{}
+	for _, tag := range contact.TagList() {
+		tags[strings.ToLower(tag)] = true
+	}
+	for _, tag := range a.Tags {
+		if !tags[strings.ToLower(tag)] {
+			return false
+		}
+	}
+	if len(a.AnyTags) > 0 {
+		found := false
+		for _, tag := range a.AnyTags {
+			found = found || tags[strings.ToLower(tag)]
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, tag := range a.ExcludeTags {
+		if tags[strings.ToLower(tag)] {
+			return false
+		}
+	}
+
+	now := time.Now()
+	if a.ActiveWithinDays > 0 && (contact.LastInboundAt == nil || contact.LastInboundAt.Before(now.AddDate(0, 0, -a.ActiveWithinDays))) {
+		return false
+	}
+	if a.InactiveForDays > 0 && contact.LastInboundAt != nil && !contact.LastInboundAt.Before(now.AddDate(0, 0, -a.InactiveForDays)) {
+		return false
+	}
+
+	for _, cond := range a.Conditions {
+		if !cond.matches(contact) {
+			return false
+		}
+	}
+	return true
+}
+
+// conditionFields returns the text and, for dates, time value of a field
+var conditionFields = map[string]func(models.Contact) (string, *time.Time){
+	"name":    func(c models.Contact) (string, *time.Time) { return c.Name, nil },
+	"wa_id":   func(c models.Contact) (string, *time.Time) { return c.WaID, nil },
+	"country": func(c models.Contact) (string, *time.Time) { return phone.Country(c.WaID), nil },
+	"created_at": func(c models.Contact) (string, *time.Time) {
+		if c.CreatedAt.IsZero() {
+			return "", nil
+		}
+		return c.CreatedAt.Format(time.RFC3339), &c.CreatedAt
+	},
+	"last_inbound_at": func(c models.Contact) (string, *time.Time) {
+		if c.LastInboundAt == nil {
+			return "", nil
+		}
+		return c.LastInboundAt.Format(time.RFC3339), c.LastInboundAt
+	},
+}
+
+func (cond Condition) matches(contact models.Contact) bool {
+	text, at := conditionFields[cond.Field](contact)
+	value := strings.ToLower(cond.Value)
+	lower := strings.ToLower(text)
+	switch cond.Operator {
+	case "equals":
+		return lower == value
+	case "not_equals":
+		return lower != value
+	case "contains":
+		return strings.Contains(lower, value)
+	case "starts_with":
+		return strings.HasPrefix(lower, value)
+	case "is_empty":
+		return text == ""
+	case "not_empty":
+		return text != ""
+	case "before", "after":
+		limit, err := parseDate(cond.Value)
+		if err != nil || at == nil {
+			return false
+		}
+		if cond.Operator == "before" {
+			return at.Before(limit)
+		}
+		return at.After(limit)
+	}
+	return false
+}
+
+func parseDate(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", raw)
+}
+
+// normalizeList turns typed or uploaded numbers into distinct WhatsApp IDs
+func normalizeList(raw []string, res *Resolved) []string {
+	seen := make(map[string]bool, len(raw))
+	var waIDs []string
+	for _, entry := range raw {
+		waID := digits(entry)
+		if len(waID) < 7 {
+			if strings.TrimSpace(entry) != "" {
+				res.Invalid++
+			}
+			continue
+		}
+		if seen[waID] {
+			res.Duplicates++
+			continue
+		}
+		seen[waID] = true
+		waIDs = append(waIDs, waID)
+	}
+	return waIDs
+}
+
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// suppressedSet returns the contacts suppressed for all messages or the category
+func suppressedSet(category string) (map[string]bool, error) {
+	categories := []string{consent.CategoryAll}
+	if category != "" {
+		categories = append(categories, category)
+	}
+	var waIDs []string
+	err := database.GormDB.Model(&models.Suppression{}).Where("category IN ?", categories).Pluck("contact_wa_id", &waIDs).Error
+	set := make(map[string]bool, len(waIDs))
+	for _, waID := range waIDs {
+		set[waID] = true
+	}
+	return set, err
+}
+
+// csvPhoneHeaders are the column names recognised as the phone number column
+var csvPhoneHeaders = []string{"wa_id", "whatsapp", "whatsapp id", "phone", "phone number", "mobile", "number", "msisdn"}
+
+// ParseContactsCSV reads phone numbers from a CSV upload. The phone column is
+// found by its header; without a recognised header the first column is used.
+func ParseContactsCSV(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	column := 0
+	var numbers []string
+	for line := 0; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 0 {
+			if i := phoneColumn(record); i >= 0 {
+				column = i
+				continue
+			}
+		}
+		if column < len(record) {
+			numbers = append(numbers, record[column])
+		}
+	}
+	return numbers, nil
+}
+
+func phoneColumn(header []string) int {
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		for _, known := range csvPhoneHeaders {
+			if name == known {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,297 @@
+package campaign
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+	"whatsapp-gateway/internal/consent"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/localtime"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/whatsapp"
+
+	"gorm.io/gorm"
+)
+
+// Campaign statuses
+const (
+	StatusRunning   = "running"
+	StatusPaused    = "paused"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+	StatusFailed    = "failed"
+)
+
+// Recipient statuses
+const (
+	RecipientPending    = "pending"
+	RecipientHeld       = "held" // Waits for the A/B test winner
+	RecipientSending    = "sending"
+	RecipientSent       = "sent"
+	RecipientFailed     = "failed"
+	RecipientSuppressed = "suppressed"
+	RecipientCancelled  = "cancelled"
+)
+
+var (
+	ErrTemplateNotSynced = errors.New("template not found locally, sync templates before sending with parameters")
+	ErrNotRunning        = errors.New("campaign is not running")
+	ErrNotPaused         = errors.New("campaign is not paused")
+	ErrFinished          = errors.New("campaign has already finished")
+	ErrInvalidRate       = errors.New("messages_per_second cannot be negative")
+	ErrInvalidABTest     = errors.New("invalid A/B test")
+)
+
+// Winner metrics of an A/B test
+const (
+	MetricRead  = "read"
+	MetricReply = "reply"
+)
+
+// Request describes a campaign to create
+type Request struct {
+	Name         string                   `json:"name"` // Defaults to the template name
+	TemplateName string                   `json:"template_name"`
+	Language     string                   `json:"language"`
+	Parameters   *whatsapp.TemplateParams `json:"parameters"`
+	Audience     Audience                 `json:"audience"`
+	// MessagesPerSecond slows this campaign below the gateway's limit; 0 uses the limit
+	MessagesPerSecond int `json:"messages_per_second"`
+	// ABTest sends its variants to a share of the audience each and the
+	// best one to the rest. The variants replace TemplateName and Parameters.
+	ABTest *ABTest `json:"ab_test"`
+	// LocalTime (HH:MM) sends to each recipient at the next such time in
+	// their timezone instead of right away
+	LocalTime        string `json:"local_time"`
+	IgnoreQuietHours bool   `json:"ignore_quiet_hours"` // For urgent campaigns
+}
+
+// Validate checks the request and applies the A/B test defaults
+func (req *Request) Validate() error {
+	if err := req.Audience.Validate(); err != nil {
+		return err
+	}
+	if req.MessagesPerSecond < 0 {
+		return ErrInvalidRate
+	}
+	if req.LocalTime != "" {
+		if _, err := localtime.ParseClock(req.LocalTime); err != nil {
+			return err
+		}
+	}
+	if req.ABTest != nil {
+		return req.ABTest.normalize()
+	}
+	return nil
+}
+
+// ABTest compares templates on a sample of the audience before sending the
+// winner to everyone else
+type ABTest struct {
+	Variants           []Variant `json:"variants"`
+	TestPercent        int       `json:"test_percent"`         // Share of the audience per variant, default 10
+	Metric             string    `json:"metric"`               // read (default) or reply
+	DecideAfterMinutes int       `json:"decide_after_minutes"` // Default 240
+}
+
+// Variant is one template of an A/B test
+type Variant struct {
+	TemplateName string                   `json:"template_name"`
+	Language     string                   `json:"language"`
+	Parameters   *whatsapp.TemplateParams `json:"parameters"`
+}
+
+// normalize applies the defaults and checks the test
+func (t *ABTest) normalize() error {
+	if len(t.Variants) != 2 {
+		return fmt.Errorf("%w: it needs exactly two variants", ErrInvalidABTest)
+	}
+	for _, v := range t.Variants {
+		if v.TemplateName == "" {
+			return fmt.Errorf("%w: every variant needs a template_name", ErrInvalidABTest)
+		}
+	}
+	if t.TestPercent == 0 {
+		t.TestPercent = 10
+	}
+	if t.TestPercent < 1 || t.TestPercent*len(t.Variants) >= 100 {
+		return fmt.Errorf("%w: test_percent must leave part of the audience for the winner", ErrInvalidABTest)
+	}
+	if t.Metric == "" {
+		t.Metric = MetricRead
+	}
+	if t.Metric != MetricRead && t.Metric != MetricReply {
+		return fmt.Errorf("%w: metric must be read or reply", ErrInvalidABTest)
+	}
+	if t.DecideAfterMinutes == 0 {
+		t.DecideAfterMinutes = 240
+	}
+	if t.DecideAfterMinutes < 0 {
+		return fmt.Errorf("%w: decide_after_minutes cannot be negative", ErrInvalidABTest)
+	}
+	return nil
+}
+
+// Create stores a running campaign. The Runner resolves its audience into
+// recipients when it starts sending.
+func Create(req Request) (*models.Campaign, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	audience, err := json.Marshal(req.Audience)
+	if err != nil {
+		return nil, err
+	}
+
+	c := models.Campaign{
+		Name:              req.Name,
+		TemplateName:      req.TemplateName,
+		Language:          req.Language,
+		Audience:          string(audience),
+		Status:            StatusRunning,
+		MessagesPerSecond: req.MessagesPerSecond,
+		LocalTime:         req.LocalTime,
+		IgnoreQuietHours:  req.IgnoreQuietHours,
+	}
+	if test := req.ABTest; test != nil {
+		c.TestPercent, c.WinnerMetric, c.DecideAfter = test.TestPercent, test.Metric, test.DecideAfterMinutes
+		for i, v := range test.Variants {
+			params, err := encodeParams(v.TemplateName, v.Language, v.Parameters)
+			if err != nil {
+				return nil, err
+			}
+			c.Variants = append(c.Variants, models.CampaignVariant{
+				Label:        string(rune('A' + i)),
+				TemplateName: v.TemplateName,
+				Language:     v.Language,
+				Parameters:   params,
+			})
+		}
+		// The campaign shows the first variant until a winner is picked
+		c.TemplateName, c.Language, c.Parameters = c.Variants[0].TemplateName, c.Variants[0].Language, c.Variants[0].Parameters
+	} else {
+		if c.Parameters, err = encodeParams(req.TemplateName, req.Language, req.Parameters); err != nil {
+			return nil, err
+		}
+	}
+	if c.Name == "" {
+		c.Name = c.TemplateName
+	}
+	if err := database.GormDB.Create(&c).Error; err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// encodeParams stores template parameters as JSON. Parameters are bound
+// against the locally synced template definition, so it must exist.
+func encodeParams(templateName, language string, params *whatsapp.TemplateParams) (string, error) {
+	if params == nil || params.IsEmpty() {
+		return "{}", nil
+	}
+	var count int64
+	database.GormDB.Model(&models.Template{}).Where("name = ? AND language = ?", templateName, language).Count(&count)
+	if count == 0 {
+		return "", ErrTemplateNotSynced
+	}
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// resolveRecipients turns the campaign's audience into recipient rows.
+// Suppressed contacts get a row too, so the results show who was skipped.
+func resolveRecipients(c *models.Campaign) error {
+	var audience Audience
+	if err := json.Unmarshal([]byte(c.Audience), &audience); err != nil {
+		return err
+	}
+	res, err := Resolve(audience, consent.TemplateCategory(c.TemplateName))
+	if err != nil {
+		return err
+	}
+
+	recipients := make([]models.CampaignRecipient, 0, len(res.WaIDs)+len(res.Suppressed))
+	if c.TestPercent > 0 {
+		recipients = append(recipients, splitForTest(c, res.WaIDs)...)
+	} else {
+		for _, waID := range res.WaIDs {
+			recipients = append(recipients, models.CampaignRecipient{CampaignID: c.ID, ContactWaID: waID, Status: RecipientPending})
+		}
+	}
+	for _, waID := range res.Suppressed {
+		recipients = append(recipients, models.CampaignRecipient{CampaignID: c.ID, ContactWaID: waID, Status: RecipientSuppressed, Error: "opted out"})
+	}
+
+	now := time.Now()
+	return database.GormDB.Transaction(func(tx *gorm.DB) error {
+		if len(recipients) > 0 {
+			if err := tx.CreateInBatches(recipients, 500).Error; err != nil {
+				return err
+			}
+		}
+		c.Total, c.ResolvedAt = len(recipients), &now
+		return tx.Model(c).Updates(map[string]interface{}{"total": c.Total, "resolved_at": now}).Error
+	})
+}
+
+// splitForTest assigns a random TestPercent of the recipients to each
+// variant. The rest are held until the winner is known.
+func splitForTest(c *models.Campaign, waIDs []string) []models.CampaignRecipient {
+	var variants []models.CampaignVariant
+	database.GormDB.Where("campaign_id = ?", c.ID).Order("id").Find(&variants)
+
+	shuffled := append([]string(nil), waIDs...)
+	rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	perVariant := max(len(shuffled)*c.TestPercent/100, 1)
+
+	recipients := make([]models.CampaignRecipient, 0, len(shuffled))
+	for i, waID := range shuffled {
+		recipient := models.CampaignRecipient{CampaignID: c.ID, ContactWaID: waID, Status: RecipientHeld}
+		if v := i / perVariant; v < len(variants) {
+			recipient.Status, recipient.VariantID = RecipientPending, &variants[v].ID
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients
+}
+
+// StatusCounts returns the number of recipients per recipient status
+func StatusCounts(campaignID uint) (map[string]int, error) {
+	var rows []struct {
+		Status string
+		Count  int
+	}
+	err := database.GormDB.Model(&models.CampaignRecipient{}).
+		Select("status, COUNT(*) AS count").
+		Where("campaign_id = ?", campaignID).
+		Group("status").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// refreshCounts copies the recipient counts onto the campaign
+func refreshCounts(c *models.Campaign) error {
+	counts, err := StatusCounts(c.ID)
+	if err != nil {
+		return err
+	}
+	c.Sent, c.Failed, c.Suppressed = counts[RecipientSent], counts[RecipientFailed], counts[RecipientSuppressed]
+	c.Cancelled = counts[RecipientCancelled]
+	return database.GormDB.Model(c).Updates(map[string]interface{}{
+		"sent":       c.Sent,
+		"failed":     c.Failed,
+		"suppressed": c.Suppressed,
+		"cancelled":  c.Cancelled,
+	}).Error
+}
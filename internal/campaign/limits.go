@@ -0,0 +1,159 @@
+package campaign
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+)
+
+// healthEvery is how often a running campaign re-checks the phone number's
+// quality rating and messaging tier with Meta
+const healthEvery = time.Minute
+
+// throughputLimits caps messages per second by the phone number's throughput level
+var throughputLimits = map[string]int{"STANDARD": 80, "HIGH": 1000}
+
+// tierLimits is how many unique customers a phone number may message with
+// templates in a rolling 24 hours. TIER_UNLIMITED has no limit.
+var tierLimits = map[string]int{
+	"TIER_50":   50,
+	"TIER_250":  250,
+	"TIER_1K":   1000,
+	"TIER_2K":   2000,
+	"TIER_10K":  10000,
+	"TIER_100K": 100000,
+}
+
+// throttle spaces out the sends of one phone number. Campaigns sending from
+// the same number share it, so together they stay under the limit.
+type throttle struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until the next send slot at perSecond messages per second.
+// perSecond <= 0 does not throttle.
+func (t *throttle) wait(ctx context.Context, perSecond int) error {
+	if perSecond <= 0 {
+		return ctx.Err()
+	}
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	at := t.next
+	t.next = at.Add(time.Second / time.Duration(perSecond))
+	t.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (r *Runner) throttle(phoneNumberID string) *throttle {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.throttles[phoneNumberID]
+	if !ok {
+		t = &throttle{}
+		r.throttles[phoneNumberID] = t
+	}
+	return t
+}
+
+// health is what Meta last reported about the sending phone number
+type health struct {
+	QualityRating string
+	Tier          string
+	Throughput    string
+}
+
+// checkHealth fetches the phone number from Meta and updates the cached copy.
+// When Meta can't be reached the cached copy is used.
+func (r *Runner) checkHealth(ctx context.Context) health {
+	id := r.Config.PhoneNumberID
+	number, err := r.Client.GetPhoneNumber(ctx, id)
+	if err != nil {
+		log.Printf("[Campaign] Could not check phone number %s: %v", id, err)
+		var cached models.PhoneNumber
+		database.GormDB.Where("id = ?", id).Limit(1).Find(&cached)
+		return health{QualityRating: cached.QualityRating, Tier: cached.MessagingLimitTier, Throughput: cached.ThroughputLevel}
+	}
+
+	database.GormDB.Model(&models.PhoneNumber{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"quality_rating":       number.QualityRating,
+		"messaging_limit_tier": number.MessagingLimitTier,
+		"throughput_level":     number.Throughput.Level,
+		"synced_at":            time.Now(),
+	})
+	return health{QualityRating: number.QualityRating, Tier: number.MessagingLimitTier, Throughput: number.Throughput.Level}
+}
+
+// qualityProblem returns why sending should pause, if the quality rating is
+// one of CAMPAIGN_PAUSE_ON_QUALITY
+func (r *Runner) qualityProblem(h health) string {
+	if h.QualityRating == "" {
+		return ""
+	}
+	for _, rating := range strings.Split(r.Config.CampaignPauseOnQuality, ",") {
+		if strings.EqualFold(strings.TrimSpace(rating), h.QualityRating) {
+			return fmt.Sprintf("phone number quality rating is %s", h.QualityRating)
+		}
+	}
+	return ""
+}
+
+// failureProblem returns why sending should pause, if too many sends failed
+func (r *Runner) failureProblem(c *models.Campaign) string {
+	attempted := c.Sent + c.Failed
+	if r.Config.CampaignMaxFailureRate <= 0 || attempted < r.Config.CampaignFailureMinSample || attempted == 0 {
+		return ""
+	}
+	rate := float64(c.Failed) / float64(attempted)
+	if rate <= r.Config.CampaignMaxFailureRate {
+		return ""
+	}
+	return fmt.Sprintf("failure rate %.0f%% is above %.0f%%", rate*100, r.Config.CampaignMaxFailureRate*100)
+}
+
+// perSecond is the campaign's send rate: the gateway's limit, lowered by the
+// campaign's own setting and the phone number's throughput level
+func (r *Runner) perSecond(c *models.Campaign, h health) int {
+	limit := r.Config.CampaignMessagesPerSecond
+	if c.MessagesPerSecond > 0 && (limit <= 0 || c.MessagesPerSecond < limit) {
+		limit = c.MessagesPerSecond
+	}
+	if ceiling, ok := throughputLimits[h.Throughput]; ok && (limit <= 0 || ceiling < limit) {
+		limit = ceiling
+	}
+	return limit
+}
+
+// tierRemaining is how many more contacts may be messaged under the phone
+// number's messaging tier, or -1 without a known limit. Recipients of all
+// campaigns in the last 24 hours count.
+func tierRemaining(h health) (int, error) {
+	limit, ok := tierLimits[h.Tier]
+	if !ok {
+		return -1, nil
+	}
+	var used int64
+	err := database.GormDB.Model(&models.CampaignRecipient{}).
+		Where("status = ? AND sent_at >= ?", RecipientSent, time.Now().Add(-24*time.Hour)).
+		Distinct("contact_wa_id").Count(&used).Error
+	if err != nil {
+		return 0, err
+	}
+	return max(limit-int(used), 0), nil
+}
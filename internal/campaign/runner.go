@@ -0,0 +1,509 @@
+package campaign
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"whatsapp-gateway/internal/config"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/localtime"
+	"whatsapp-gateway/internal/media"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/whatsapp"
+	"whatsapp-gateway/internal/ws"
+)
+
+const (
+	batchSize     = 100
+	progressEvery = 25
+)
+
+// errInterrupted marks recipients that were being sent to when the server
+// stopped. Whether Meta accepted the message is unknown, so they are not
+// retried to avoid sending it twice.
+const errInterrupted = "interrupted while sending, not retried to avoid a duplicate"
+
+// Runner sends campaigns in the background, one goroutine per campaign.
+// Progress is kept per recipient, so a restart resumes where it stopped.
+type Runner struct {
+	Client whatsapp.Messenger
+	Config *config.Config
+	Media  *media.Library
+	Hub    *ws.Hub
+
+	mu        sync.Mutex
+	active    map[uint]context.CancelFunc
+	throttles map[string]*throttle // Per phone number ID
+}
+
+func NewRunner(client whatsapp.Messenger, cfg *config.Config, library *media.Library, hub *ws.Hub) *Runner {
+	return &Runner{
+		Client:    client,
+		Config:    cfg,
+		Media:     library,
+		Hub:       hub,
+		active:    make(map[uint]context.CancelFunc),
+		throttles: make(map[string]*throttle),
+	}
+}
+
+// Recover restarts the campaigns that were running when the server stopped.
+// Paused campaigns stay paused.
+func (r *Runner) Recover() {
+	var ids []uint
+	if err := database.GormDB.Model(&models.Campaign{}).Where("status = ?", StatusRunning).Pluck("id", &ids).Error; err != nil {
+		log.Printf("[Campaign] Could not load running campaigns: %v", err)
+		return
+	}
+	for _, id := range ids {
+		log.Printf("[Campaign] Resuming campaign %d", id)
+		r.Start(id)
+	}
+}
+
+// Start sends a campaign in the background unless it is already being sent
+func (r *Runner) Start(id uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.active[id]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.active[id] = cancel
+	go func() {
+		if err := r.run(ctx, id); err != nil {
+			log.Printf("[Campaign] Campaign %d failed: %v", id, err)
+		}
+		r.mu.Lock()
+		delete(r.active, id)
+		r.mu.Unlock()
+		cancel()
+
+		// Paused and resumed before this run noticed: start again
+		var status string
+		if ctx.Err() != nil && database.GormDB.Model(&models.Campaign{}).Where("id = ?", id).Pluck("status", &status).Error == nil && status == StatusRunning {
+			r.Start(id)
+		}
+	}()
+}
+
+// Pause stops sending after the message in flight. Pending recipients are
+// kept, so Resume continues where it stopped.
+func (r *Runner) Pause(id uint, reason string) (*models.Campaign, error) {
+	result := database.GormDB.Model(&models.Campaign{}).Where("id = ? AND status = ?", id, StatusRunning).
+		Updates(map[string]interface{}{"status": StatusPaused, "pause_reason": reason, "paused_at": time.Now()})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotRunning
+	}
+	r.stop(id)
+	log.Printf("[Campaign] Campaign %d paused: %s", id, reason)
+	return r.changed(id, "campaign_paused")
+}
+
+// Resume continues sending a paused campaign
+func (r *Runner) Resume(id uint) (*models.Campaign, error) {
+	result := database.GormDB.Model(&models.Campaign{}).Where("id = ? AND status = ?", id, StatusPaused).
+		Updates(map[string]interface{}{"status": StatusRunning, "pause_reason": "", "paused_at": nil})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotPaused
+	}
+	r.Start(id)
+	return r.changed(id, "campaign_resumed")
+}
+
+// Cancel stops a running or paused campaign for good. Recipients not sent
+// to yet are marked cancelled.
+func (r *Runner) Cancel(id uint) (*models.Campaign, error) {
+	result := database.GormDB.Model(&models.Campaign{}).Where("id = ? AND status IN ?", id, []string{StatusRunning, StatusPaused}).
+		Updates(map[string]interface{}{"status": StatusCancelled, "completed_at": time.Now()})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrFinished
+	}
+	r.stop(id)
+	if err := database.GormDB.Model(&models.CampaignRecipient{}).
+		Where("campaign_id = ? AND status IN ?", id, []string{RecipientPending, RecipientHeld}).
+		Update("status", RecipientCancelled).Error; err != nil {
+		return nil, err
+	}
+	log.Printf("[Campaign] Campaign %d cancelled", id)
+	return r.changed(id, "campaign_cancelled")
+}
+
+// stop ends the campaign's run without changing its status
+func (r *Runner) stop(id uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cancel, ok := r.active[id]; ok {
+		cancel()
+	}
+}
+
+// changed reloads a campaign after a status change and announces it
+func (r *Runner) changed(id uint, event string) (*models.Campaign, error) {
+	var c models.Campaign
+	if err := database.GormDB.First(&c, id).Error; err != nil {
+		return nil, err
+	}
+	if err := refreshCounts(&c); err != nil {
+		return nil, err
+	}
+	r.notify(event, &c)
+	return &c, nil
+}
+
+// run sends to the campaign's pending recipients until none are left or ctx
+// is cancelled by Pause or Cancel
+func (r *Runner) run(ctx context.Context, id uint) error {
+	var c models.Campaign
+	if err := database.GormDB.First(&c, id).Error; err != nil {
+		return err
+	}
+	if c.Status != StatusRunning {
+		return nil
+	}
+
+	database.GormDB.Model(&models.CampaignRecipient{}).
+		Where("campaign_id = ? AND status = ?", c.ID, RecipientSending).
+		Updates(map[string]interface{}{"status": RecipientFailed, "error": errInterrupted, "failed_at": time.Now()})
+
+	if c.StartedAt == nil {
+		now := time.Now()
+		c.StartedAt = &now
+		database.GormDB.Model(&c).Update("started_at", now)
+		r.notify("campaign_started", &c)
+	}
+
+	if c.ResolvedAt == nil {
+		if err := resolveRecipients(&c); err != nil {
+			return r.fail(&c, err)
+		}
+		log.Printf("[Campaign] Campaign %d audience resolved to %d recipients", c.ID, c.Total)
+	}
+
+	templates, err := r.prepareAll(&c)
+	if err != nil {
+		return r.fail(&c, err)
+	}
+
+	h := r.checkHealth(ctx)
+	checked := time.Now()
+	if reason := r.qualityProblem(h); reason != "" {
+		return r.autoPause(&c, reason)
+	}
+	remaining, err := tierRemaining(h)
+	if err != nil {
+		return r.autoPause(&c, "could not check the messaging limit: "+err.Error())
+	}
+	perSecond := r.perSecond(&c, h)
+	limiter := r.throttle(r.Config.PhoneNumberID)
+	quiet := localtime.QuietHours(r.Config)
+
+	processed := 0
+	for {
+		var batch []models.CampaignRecipient
+		if err := database.GormDB.Where("campaign_id = ? AND status = ? AND (send_after IS NULL OR send_after <= ?)", c.ID, RecipientPending, time.Now().UTC()).
+			Order("id").Limit(batchSize).Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			// Recipients deferred to their local time: wait for the first
+			waiting, err := r.waitDeferred(ctx, &c)
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			if waiting {
+				continue
+			}
+			if c.TestPercent == 0 || c.WinnerVariantID != nil {
+				break
+			}
+			// The test sends are done: wait, then release the held recipients
+			if err := r.pickWinner(ctx, &c); err != nil {
+				return r.fail(&c, err)
+			}
+			if c.WinnerVariantID == nil {
+				return nil // Paused or cancelled while waiting
+			}
+			continue
+		}
+		var locations map[string]*time.Location
+		if deferrable(&c, quiet) {
+			waIDs := make([]string, len(batch))
+			for i, recipient := range batch {
+				waIDs[i] = recipient.ContactWaID
+			}
+			locations = localtime.ForContacts(waIDs, r.Config)
+		}
+		for _, recipient := range batch {
+			if time.Since(checked) >= healthEvery {
+				h, checked = r.checkHealth(ctx), time.Now()
+				if reason := r.qualityProblem(h); reason != "" {
+					return r.autoPause(&c, reason)
+				}
+				if remaining, err = tierRemaining(h); err != nil {
+					return r.autoPause(&c, "could not check the messaging limit: "+err.Error())
+				}
+				perSecond = r.perSecond(&c, h)
+			}
+			if until := deferral(&c, quiet, locations[recipient.ContactWaID], time.Now()); until != nil {
+				if err := database.GormDB.Model(&recipient).Where("status = ?", RecipientPending).Update("send_after", until).Error; err != nil {
+					return r.autoPause(&c, "could not defer a recipient: "+err.Error())
+				}
+				continue
+			}
+			if remaining == 0 {
+				return r.autoPause(&c, fmt.Sprintf("messaging limit %s reached for the last 24 hours", h.Tier))
+			}
+			if limiter.wait(ctx, perSecond) != nil || ctx.Err() != nil {
+				return nil
+			}
+
+			// Sends are not tied to ctx, so pausing never aborts a request
+			// half way. Counted against the tier even if the contact was
+			// already messaged today, which errs on the safe side.
+			if r.send(context.Background(), &c, templates[variantKey(recipient.VariantID)], recipient) && remaining > 0 {
+				remaining--
+			}
+			processed++
+			if processed%progressEvery == 0 {
+				refreshCounts(&c)
+				r.notify("campaign_progress", &c)
+				if reason := r.failureProblem(&c); reason != "" {
+					return r.autoPause(&c, reason)
+				}
+			}
+		}
+	}
+
+	if err := refreshCounts(&c); err != nil {
+		return err
+	}
+	now := time.Now()
+	result := database.GormDB.Model(&c).Where("status = ?", StatusRunning).
+		Updates(map[string]interface{}{"status": StatusCompleted, "completed_at": now})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error // Paused or cancelled meanwhile
+	}
+	c.Status, c.CompletedAt = StatusCompleted, &now
+	r.notify("campaign_completed", &c)
+	log.Printf("[Campaign] Campaign %d completed: %d sent, %d failed, %d suppressed", c.ID, c.Sent, c.Failed, c.Suppressed)
+	return nil
+}
+
+// deferrable reports whether recipients may have to wait: the campaign sends
+// at a local time or quiet hours apply
+func deferrable(c *models.Campaign, quiet localtime.Policy) bool {
+	_, err := localtime.ParseClock(c.LocalTime)
+	atClock := c.LocalTime != "" && err == nil && c.StartedAt != nil
+	return atClock || (quiet.Enabled() && !c.IgnoreQuietHours)
+}
+
+// deferral returns when a recipient in loc may get the campaign if that is
+// later than now: the campaign's local time in their timezone, or the end of
+// their quiet hours
+func deferral(c *models.Campaign, quiet localtime.Policy, loc *time.Location, now time.Time) *time.Time {
+	if loc == nil || !deferrable(c, quiet) {
+		return nil
+	}
+	if c.IgnoreQuietHours {
+		quiet = localtime.Policy{}
+	}
+
+	at := now.UTC()
+	if clock, err := localtime.ParseClock(c.LocalTime); c.LocalTime != "" && err == nil && c.StartedAt != nil {
+		if slot := localtime.Next(*c.StartedAt, clock, loc); slot.After(at) {
+			at = slot
+		}
+	}
+	at = quiet.Defer(at, loc)
+	if !at.After(now) {
+		return nil
+	}
+	return &at
+}
+
+// waitDeferred waits until the first deferred recipient is due. It reports
+// whether there was one; it returns early when ctx is cancelled.
+func (r *Runner) waitDeferred(ctx context.Context, c *models.Campaign) (bool, error) {
+	var next models.CampaignRecipient
+	err := database.GormDB.Where("campaign_id = ? AND status = ? AND send_after IS NOT NULL", c.ID, RecipientPending).
+		Order("send_after").Limit(1).Find(&next).Error
+	if err != nil || next.ID == 0 {
+		return false, err
+	}
+	refreshCounts(c)
+	r.notify("campaign_progress", c)
+	log.Printf("[Campaign] Campaign %d waits until %s for recipients' local time", c.ID, next.SendAfter.Format(time.RFC3339))
+
+	timer := time.NewTimer(time.Until(*next.SendAfter))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+	return true, nil
+}
+
+// autoPause pauses the campaign from its own run when sending looks unsafe
+func (r *Runner) autoPause(c *models.Campaign, reason string) error {
+	refreshCounts(c)
+	if _, err := r.Pause(c.ID, reason); err != nil && err != ErrNotRunning {
+		return err
+	}
+	return nil
+}
+
+// prepared is a template of a campaign ready for per-recipient binding
+type prepared struct {
+	name       string
+	language   string
+	params     whatsapp.TemplateParams
+	definition []whatsapp.TemplateComponent // nil when the template is not synced
+}
+
+// prepareAll prepares the campaign's template, keyed 0, or the template of
+// each A/B test variant, keyed by variant ID
+func (r *Runner) prepareAll(c *models.Campaign) (map[uint]*prepared, error) {
+	var variants []models.CampaignVariant
+	if err := database.GormDB.Where("campaign_id = ?", c.ID).Find(&variants).Error; err != nil {
+		return nil, err
+	}
+	templates := make(map[uint]*prepared, len(variants)+1)
+	if len(variants) == 0 {
+		p, err := r.prepare(c.TemplateName, c.Language, c.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		templates[0] = p
+	}
+	for _, v := range variants {
+		p, err := r.prepare(v.TemplateName, v.Language, v.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		templates[v.ID] = p
+	}
+	return templates, nil
+}
+
+func variantKey(variantID *uint) uint {
+	if variantID == nil {
+		return 0
+	}
+	return *variantID
+}
+
+// prepare loads a template's parameters and definition and resolves library
+// header media to a Meta media ID once for all recipients
+func (r *Runner) prepare(name, language, parameters string) (*prepared, error) {
+	p := &prepared{name: name, language: language}
+	if parameters != "" {
+		if err := json.Unmarshal([]byte(parameters), &p.params); err != nil {
+			return nil, err
+		}
+	}
+
+	var template models.Template
+	if database.GormDB.Where("name = ? AND language = ?", name, language).First(&template).Error != nil {
+		if !p.params.IsEmpty() {
+			return nil, ErrTemplateNotSynced
+		}
+		return p, nil
+	}
+	p.params = p.params.WithDefaultHeaderMedia(template)
+
+	if r.Media != nil {
+		if err := r.Media.ResolveTemplateParams(context.Background(), &p.params); err != nil {
+			return nil, err
+		}
+	}
+	definition, err := whatsapp.ParseTemplateComponents(template.Components)
+	if err != nil {
+		return nil, err
+	}
+	p.definition = definition
+	return p, nil
+}
+
+// send delivers the campaign template to one recipient and stores the outcome.
+// It reports whether the message was sent.
+func (r *Runner) send(ctx context.Context, c *models.Campaign, tmpl *prepared, recipient models.CampaignRecipient) bool {
+	// Claim the recipient, unless Cancel got to it first
+	claim := database.GormDB.Model(&recipient).Where("status = ?", RecipientPending).Update("status", RecipientSending)
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return false
+	}
+
+	var components []whatsapp.ComponentObj
+	if tmpl.definition != nil {
+		var contact models.Contact
+		if err := database.GormDB.Where("wa_id = ?", recipient.ContactWaID).First(&contact).Error; err != nil {
+			contact = models.Contact{WaID: recipient.ContactWaID}
+		}
+		var issues []whatsapp.TemplateIssue
+		components, issues = whatsapp.BindTemplate(tmpl.definition, tmpl.params.WithContact(contact))
+		if err := whatsapp.IssuesError(issues); err != nil {
+			log.Printf("[Campaign] Not sending campaign %d to %s: %v", c.ID, recipient.ContactWaID, err)
+			database.GormDB.Model(&recipient).Updates(map[string]interface{}{"status": RecipientFailed, "error": err.Error(), "failed_at": time.Now()})
+			return false
+		}
+	}
+
+	resp, err := r.Client.SendTemplateWithComponents(ctx, recipient.ContactWaID, tmpl.name, tmpl.language, components)
+	updates := map[string]interface{}{}
+	switch {
+	case err == nil:
+		now := time.Now()
+		updates["status"], updates["wamid"], updates["sent_at"] = RecipientSent, resp.MessageID(), now
+	case errors.Is(err, whatsapp.ErrSuppressed):
+		updates["status"], updates["error"] = RecipientSuppressed, err.Error()
+	default:
+		log.Printf("[Campaign] Failed to send campaign %d to %s: %v", c.ID, recipient.ContactWaID, err)
+		updates["status"], updates["error"], updates["failed_at"] = RecipientFailed, err.Error(), time.Now()
+		var apiErr *whatsapp.APIError
+		if errors.As(err, &apiErr) {
+			updates["error_code"] = apiErr.Code
+		}
+	}
+	database.GormDB.Model(&recipient).Updates(updates)
+	if err == nil {
+		if err := applyEarlyStatuses(resp.MessageID()); err != nil {
+			log.Printf("[Campaign] Could not apply early statuses of %s: %v", resp.MessageID(), err)
+		}
+	}
+	return err == nil
+}
+
+func (r *Runner) fail(c *models.Campaign, cause error) error {
+	database.GormDB.Model(&models.CampaignRecipient{}).
+		Where("campaign_id = ? AND status IN ?", c.ID, []string{RecipientPending, RecipientHeld}).
+		Updates(map[string]interface{}{"status": RecipientFailed, "error": cause.Error(), "failed_at": time.Now()})
+	refreshCounts(c)
+	now := time.Now()
+	c.Status, c.CompletedAt = StatusFailed, &now
+	database.GormDB.Model(c).Updates(map[string]interface{}{"status": c.Status, "completed_at": now})
+	r.notify("campaign_failed", c)
+	return cause
+}
+
+func (r *Runner) notify(event string, c *models.Campaign) {
+	if r.Hub != nil {
+		r.Hub.BroadcastEvent(event, c)
+	}
+}
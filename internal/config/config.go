@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -12,7 +13,9 @@ type Config struct {
 	VerifyToken               string
 	WhatsAppToken             string
 	PhoneNumberID             string
+	BusinessPhoneNumber       string
 	WhatsAppBusinessAccountID string
+	DBDriver                  string
 	DBPath                    string
 	DBHost                    string
 	DBPort                    string
@@ -20,6 +23,31 @@ type Config struct {
 	DBPassword                string
 	DBName                    string
 	DBSSLMode                 string
+	MediaStoragePath          string
+	AppID                     string
+	AppSecret                 string
+	FlowsPrivateKeyPath       string
+	SearchLanguage            string
+	EnforceServiceWindow      bool
+	ServiceWindowTemplate     string
+	ServiceWindowTemplateLang string
+	OptOutKeywords            string
+	OptInKeywords             string
+	OptOutReply               string
+	OptInReply                string
+	CampaignMessagesPerSecond int
+	CampaignMaxFailureRate    float64
+	CampaignFailureMinSample  int
+	CampaignPauseOnQuality    string
+	CampaignAttributionHours  int
+
+	SchedulerIntervalSeconds    int
+	SchedulerMissedGraceMinutes int
+
+	QuietHoursStart string
+	QuietHoursEnd   string
+	DefaultTimezone string
+	DefaultCountry  string
 }
 
 func LoadConfig() *Config {
@@ -33,7 +61,9 @@ func LoadConfig() *Config {
 		VerifyToken:               getEnv("VERIFY_TOKEN", ""),
 		WhatsAppToken:             getEnv("WHATSAPP_TOKEN", ""),
 		PhoneNumberID:             getEnv("PHONE_NUMBER_ID", ""),
+		BusinessPhoneNumber:       getEnv("BUSINESS_PHONE_NUMBER", ""),
 		WhatsAppBusinessAccountID: getEnv("WABA_ID", ""),
+		DBDriver:                  getEnv("DB_DRIVER", "postgres"),
 		DBPath:                    getEnv("DB_PATH", "./whatsapp.db"),
 		DBHost:                    getEnv("DB_HOST", "localhost"),
 		DBPort:                    getEnv("DB_PORT", "5432"),
@@ -41,6 +71,31 @@ func LoadConfig() *Config {
 		DBPassword:                getEnv("DB_PASSWORD", "postgres"),
 		DBName:                    getEnv("DB_NAME", "whatsapp_gateway"),
 		DBSSLMode:                 getEnv("DB_SSLMODE", "disable"),
+		MediaStoragePath:          getEnv("MEDIA_STORAGE_PATH", "./storage/media"),
+		AppID:                     getEnv("APP_ID", ""),
+		AppSecret:                 getEnv("APP_SECRET", ""),
+		FlowsPrivateKeyPath:       getEnv("FLOWS_PRIVATE_KEY_PATH", "./keys/flows_private.pem"),
+		SearchLanguage:            getEnv("SEARCH_LANGUAGE", "simple"),
+		EnforceServiceWindow:      getEnv("ENFORCE_SERVICE_WINDOW", "true") != "false",
+		ServiceWindowTemplate:     getEnv("SERVICE_WINDOW_TEMPLATE", ""),
+		ServiceWindowTemplateLang: getEnv("SERVICE_WINDOW_TEMPLATE_LANGUAGE", "en_US"),
+		OptOutKeywords:            getEnv("OPT_OUT_KEYWORDS", "STOP,STOPALL,UNSUBSCRIBE,OPTOUT,OPT-OUT"),
+		OptInKeywords:             getEnv("OPT_IN_KEYWORDS", "START,UNSTOP,SUBSCRIBE"),
+		OptOutReply:               getEnv("OPT_OUT_REPLY", "You have been unsubscribed and will not receive further messages from us. Reply START to subscribe again."),
+		OptInReply:                getEnv("OPT_IN_REPLY", "You are subscribed again. Reply STOP at any time to unsubscribe."),
+		CampaignMessagesPerSecond: getEnvInt("CAMPAIGN_MESSAGES_PER_SECOND", 20),
+		CampaignMaxFailureRate:    getEnvFloat("CAMPAIGN_MAX_FAILURE_RATE", 0.25),
+		CampaignFailureMinSample:  getEnvInt("CAMPAIGN_FAILURE_MIN_SAMPLE", 20),
+		CampaignPauseOnQuality:    getEnv("CAMPAIGN_PAUSE_ON_QUALITY", "RED"),
+		CampaignAttributionHours:  getEnvInt("CAMPAIGN_ATTRIBUTION_HOURS", 72),
+
+		SchedulerIntervalSeconds:    getEnvInt("SCHEDULER_INTERVAL_SECONDS", 30),
+		SchedulerMissedGraceMinutes: getEnvInt("SCHEDULER_MISSED_GRACE_MINUTES", 15),
+
+		QuietHoursStart: getEnv("QUIET_HOURS_START", ""),
+		QuietHoursEnd:   getEnv("QUIET_HOURS_END", ""),
+		DefaultTimezone: getEnv("DEFAULT_TIMEZONE", "UTC"),
+		DefaultCountry:  getEnv("DEFAULT_COUNTRY", ""),
 	}
 }
 
@@ -50,3 +105,19 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(getEnv(key, ""), 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
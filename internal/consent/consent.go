@@ -0,0 +1,131 @@
+package consent
+
+import (
+	"strings"
+	"time"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	OptedIn  = "opted_in"
+	OptedOut = "opted_out"
+)
+
+// CategoryAll covers every message; other categories are template categories
+const CategoryAll = "all"
+
+const (
+	SourceKeyword = "keyword"
+	SourceAPI     = "api"
+)
+
+const channelWhatsApp = "whatsapp"
+
+// Change describes an opt-in or opt-out
+type Change struct {
+	WaID     string
+	Category string // Defaults to CategoryAll
+	Source   string
+	Note     string
+}
+
+// OptOut records the opt-out in the consent history and suppresses the
+// contact for the category
+func OptOut(ch Change) error {
+	ch.Category = normalizeCategory(ch.Category)
+	return database.GormDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(history(ch, OptedOut)).Error; err != nil {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Suppression{
+			ContactWaID: ch.WaID,
+			Category:    ch.Category,
+			Source:      ch.Source,
+			Reason:      ch.Note,
+		}).Error
+	})
+}
+
+// OptIn records the opt-in and lifts the suppression for the category. Opting
+// in to all messages lifts every suppression of the contact.
+func OptIn(ch Change) error {
+	ch.Category = normalizeCategory(ch.Category)
+	return database.GormDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(history(ch, OptedIn)).Error; err != nil {
+			return err
+		}
+		query := tx.Where("contact_wa_id = ?", ch.WaID)
+		if ch.Category != CategoryAll {
+			query = query.Where("category = ?", ch.Category)
+		}
+		return query.Delete(&models.Suppression{}).Error
+	})
+}
+
+// Suppressed returns the suppression that blocks a message of category to
+// waID, or nil. Category is the template category, "" for other messages.
+func Suppressed(waID, category string) (*models.Suppression, error) {
+	categories := []string{CategoryAll}
+	if category != "" {
+		categories = append(categories, strings.ToLower(category))
+	}
+	var suppression models.Suppression
+	err := database.GormDB.Where("contact_wa_id = ? AND category IN ?", waID, categories).Limit(1).Find(&suppression).Error
+	if err != nil || suppression.ID == 0 {
+		return nil, err
+	}
+	return &suppression, nil
+}
+
+// TemplateCategory returns the category of a synced template, lowercased
+func TemplateCategory(name string) string {
+	var template models.Template
+	database.GormDB.Select("category").Where("name = ?", name).Limit(1).Find(&template)
+	return strings.ToLower(template.Category)
+}
+
+// History returns the consent changes of a contact, oldest first
+func History(waID string) ([]models.Consent, error) {
+	var changes []models.Consent
+	err := database.GormDB.Where("contact_wa_id = ?", waID).Order("created_at ASC, id ASC").Find(&changes).Error
+	return changes, err
+}
+
+// MatchKeyword reports whether text is one of the comma separated keywords.
+// The whole message must match, ignoring case and surrounding punctuation.
+func MatchKeyword(text, keywords string) bool {
+	text = strings.ToUpper(strings.Trim(strings.TrimSpace(text), ".!?"))
+	if text == "" {
+		return false
+	}
+	for _, keyword := range strings.Split(keywords, ",") {
+		if strings.ToUpper(strings.TrimSpace(keyword)) == text {
+			return true
+		}
+	}
+	return false
+}
+
+func history(ch Change, status string) *models.Consent {
+	return &models.Consent{
+		ContactWaID: ch.WaID,
+		Channel:     channelWhatsApp,
+		Category:    ch.Category,
+		Status:      status,
+		Source:      ch.Source,
+		Note:        ch.Note,
+		CreatedAt:   time.Now(),
+	}
+}
+
+func normalizeCategory(category string) string {
+	category = strings.ToLower(strings.TrimSpace(category))
+	if category == "" {
+		return CategoryAll
+	}
+	return category
+}
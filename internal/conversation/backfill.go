@@ -0,0 +1,109 @@
+package conversation
+
+import (
+	"strings"
+	"time"
+	"whatsapp-gateway/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// legacyMessage is a messages row as stored before conversations existed
+type legacyMessage struct {
+	ID        uint
+	WaID      string
+	Sender    string
+	Content   string
+	CreatedAt time.Time
+}
+
+// HasLegacyColumns reports whether messages still has the wa_id/sender columns
+func HasLegacyColumns(db *gorm.DB) bool {
+	return db.Migrator().HasColumn(&models.Message{}, "sender")
+}
+
+// Backfill assigns direction, wamid, contact and conversation to messages
+// stored with the old wa_id/sender columns, batchSize rows at a time. Rows
+// already backfilled are skipped, so it can be run again after a failure.
+// It returns the number of messages updated.
+func Backfill(db *gorm.DB, phoneNumberID string, batchSize int) (int, error) {
+	if !HasLegacyColumns(db) {
+		return 0, nil
+	}
+
+	updated := 0
+	var lastID uint
+	for {
+		var rows []legacyMessage
+		err := db.Table("messages").
+			Select("id, wa_id, sender, content, created_at").
+			Where("id > ? AND (direction IS NULL OR direction = '')", lastID).
+			Order("id").Limit(batchSize).Scan(&rows).Error
+		if err != nil {
+			return updated, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for _, row := range rows {
+				direction, wamID := Inbound, row.WaID
+				// Outbound rows were stored as wa_id "outgoing-<phone>"
+				if strings.HasPrefix(row.WaID, "outgoing-") {
+					direction, wamID = Outbound, ""
+				}
+				contact := row.Sender
+				if contact == "" {
+					contact = strings.TrimPrefix(row.WaID, "outgoing-")
+				}
+
+				conv, err := ensure(tx, contact, phoneNumberID)
+				if err != nil {
+					return err
+				}
+				if err := tx.Model(&models.Message{}).Where("id = ?", row.ID).Updates(map[string]interface{}{
+					"conversation_id": conv.ID,
+					"contact_wa_id":   contact,
+					"direction":       direction,
+					"wamid":           wamID,
+				}).Error; err != nil {
+					return err
+				}
+
+				tx.Model(&models.Conversation{}).
+					Where("id = ? AND (last_message_at IS NULL OR last_message_at <= ?)", conv.ID, row.CreatedAt).
+					Updates(map[string]interface{}{
+						"last_message_at":      row.CreatedAt,
+						"last_message_preview": Preview(row.Content),
+						"last_direction":       direction,
+					})
+				if direction == Inbound {
+					touchInbound(tx, conv.ID, contact, row.CreatedAt)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return updated, err
+		}
+
+		updated += len(rows)
+		lastID = rows[len(rows)-1].ID
+	}
+	return updated, nil
+}
+
+// DropLegacyColumns removes wa_id and sender from messages once every row
+// has been backfilled
+func DropLegacyColumns(db *gorm.DB) error {
+	for _, column := range []string{"wa_id", "sender"} {
+		if !db.Migrator().HasColumn(&models.Message{}, column) {
+			continue
+		}
+		if err := db.Migrator().DropColumn(&models.Message{}, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package conversation
+
+import (
+	"time"
+	"unicode/utf8"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	Inbound  = "inbound"
+	Outbound = "outbound"
+)
+
+const previewLength = 120
+
+// Ensure returns the conversation between waID and our phone number,
+// creating the contact and conversation when they don't exist yet
+func Ensure(waID, phoneNumberID string) (*models.Conversation, error) {
+	return ensure(database.GormDB, waID, phoneNumberID)
+}
+
+func ensure(db *gorm.DB, waID, phoneNumberID string) (*models.Conversation, error) {
+	contact := models.Contact{WaID: waID, Name: waID, Tags: "[]"}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&contact).Error; err != nil {
+		return nil, err
+	}
+
+	conv := models.Conversation{ContactWaID: waID, PhoneNumberID: phoneNumberID}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&conv).Error; err != nil {
+		return nil, err
+	}
+	if conv.ID == 0 {
+		if err := db.Where("contact_wa_id = ? AND phone_number_id = ?", waID, phoneNumberID).First(&conv).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &conv, nil
+}
+
+// Message describes a message to record in a conversation
+type Message struct {
+	PhoneNumberID string
+	ContactWaID   string
+	Direction     string
+	WamID         string
+	Content       string
+	Type          string
+	Status        string
+	CreatedAt     time.Time // Defaults to now
+}
+
+// Record stores a message in its conversation and updates the
+// conversation's last message and unread count
+func Record(in Message) (*models.Message, error) {
+	return record(database.GormDB, in)
+}
+
+func record(db *gorm.DB, in Message) (*models.Message, error) {
+	conv, err := ensure(db, in.ContactWaID, in.PhoneNumberID)
+	if err != nil {
+		return nil, err
+	}
+	if in.CreatedAt.IsZero() {
+		in.CreatedAt = time.Now()
+	}
+
+	msg := models.Message{
+		ConversationID: &conv.ID,
+		ContactWaID:    in.ContactWaID,
+		Direction:      in.Direction,
+		WamID:          in.WamID,
+		Content:        in.Content,
+		Type:           in.Type,
+		Status:         in.Status,
+		CreatedAt:      in.CreatedAt,
+	}
+	if err := db.Create(&msg).Error; err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{
+		"last_message_at":      in.CreatedAt,
+		"last_message_preview": Preview(in.Content),
+		"last_direction":       in.Direction,
+	}
+	if in.Direction == Inbound {
+		updates["unread_count"] = gorm.Expr("unread_count + 1")
+	}
+	// Backfilled messages may be older than what the conversation already shows
+	db.Model(&models.Conversation{}).
+		Where("id = ? AND (last_message_at IS NULL OR last_message_at <= ?)", conv.ID, in.CreatedAt).
+		Updates(updates)
+
+	if in.Direction == Inbound {
+		touchInbound(db, conv.ID, in.ContactWaID, in.CreatedAt)
+	}
+
+	return &msg, nil
+}
+
+// touchInbound records a customer message at t, which (re)opens the
+// service window of the conversation and contact
+func touchInbound(db *gorm.DB, conversationID uint, waID string, t time.Time) {
+	db.Model(&models.Conversation{}).
+		Where("id = ? AND (last_inbound_at IS NULL OR last_inbound_at < ?)", conversationID, t).
+		Update("last_inbound_at", t)
+	db.Model(&models.Contact{}).
+		Where("wa_id = ? AND (last_inbound_at IS NULL OR last_inbound_at < ?)", waID, t).
+		Update("last_inbound_at", t)
+}
+
+// Window returns the service window between a contact and one of our
+// numbers. Contacts that never wrote to that number have a closed window.
+func Window(waID, phoneNumberID string) (models.WindowStatus, error) {
+	var conv models.Conversation
+	err := database.GormDB.Where("contact_wa_id = ? AND phone_number_id = ?", waID, phoneNumberID).Limit(1).Find(&conv).Error
+	if err != nil {
+		return models.WindowStatus{}, err
+	}
+	return models.NewWindowStatus(conv.LastInboundAt, time.Now()), nil
+}
+
+// keepsStatus lists, per delivery status, the statuses it must not replace.
+// Webhooks can arrive out of order: a message must not go back from read to
+// delivered, and a late failure does not undo a delivery.
+var keepsStatus = map[string][]string{
+	"sent":      {"delivered", "read", "failed"},
+	"delivered": {"read"},
+	"failed":    {"delivered", "read"},
+}
+
+// UpdateStatus applies a delivery status (sent, delivered, read, failed) from
+// the webhook to the outbound message with that wamid
+func UpdateStatus(wamID, status string) error {
+	if wamID == "" {
+		return nil
+	}
+	query := database.GormDB.Model(&models.Message{}).Where("wamid = ? AND direction = ?", wamID, Outbound)
+	if keep := keepsStatus[status]; len(keep) > 0 {
+		query = query.Where("status NOT IN ?", keep)
+	}
+	return query.Update("status", status).Error
+}
+
+// MarkRead clears the unread count of a conversation
+func MarkRead(conversationID uint) error {
+	return database.GormDB.Model(&models.Conversation{}).
+		Where("id = ?", conversationID).
+		Update("unread_count", 0).Error
+}
+
+// Preview shortens content for conversation lists
+func Preview(content string) string {
+	if utf8.RuneCountInString(content) <= previewLength {
+		return content
+	}
+	runes := []rune(content)
+	return string(runes[:previewLength-1]) + "…"
+}
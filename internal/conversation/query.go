@@ -0,0 +1,102 @@
+package conversation
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Filter narrows a message listing. Zero values are ignored.
+type Filter struct {
+	ContactWaID    string
+	ConversationID uint
+	Direction      string
+	Type           string
+	Status         string
+	Since          *time.Time
+	Until          *time.Time
+}
+
+// Cursor points at the last message of a page. Messages are listed newest
+// first by (created_at, id), so the next page starts strictly before it.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
+
+// Encode returns the opaque form handed to API clients
+func (c Cursor) Encode() string {
+	raw := fmt.Sprintf("%d:%d", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a cursor produced by Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &Cursor{CreatedAt: time.Unix(0, nanos), ID: uint(id)}, nil
+}
+
+// ListMessages returns up to limit messages matching f, newest first,
+// starting after cursor. The returned cursor is nil on the last page.
+func ListMessages(f Filter, cursor *Cursor, limit int) ([]models.Message, *Cursor, error) {
+	query := database.GormDB.Model(&models.Message{})
+	if f.ContactWaID != "" {
+		query = query.Where("contact_wa_id = ?", f.ContactWaID)
+	}
+	if f.ConversationID != 0 {
+		query = query.Where("conversation_id = ?", f.ConversationID)
+	}
+	if f.Direction != "" {
+		query = query.Where("direction = ?", f.Direction)
+	}
+	if f.Type != "" {
+		query = query.Where("type = ?", f.Type)
+	}
+	if f.Status != "" {
+		query = query.Where("status = ?", f.Status)
+	}
+	if f.Since != nil {
+		query = query.Where("created_at >= ?", *f.Since)
+	}
+	if f.Until != nil {
+		query = query.Where("created_at < ?", *f.Until)
+	}
+	if cursor != nil {
+		query = query.Where("(created_at < ? OR (created_at = ? AND id < ?))", cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+	}
+
+	// One extra row tells whether there is a next page
+	var messages []models.Message
+	if err := query.Order("created_at DESC, id DESC").Limit(limit + 1).Find(&messages).Error; err != nil {
+		return nil, nil, err
+	}
+	if len(messages) <= limit {
+		return messages, nil, nil
+	}
+
+	messages = messages[:limit]
+	last := messages[len(messages)-1]
+	return messages, &Cursor{CreatedAt: last.CreatedAt, ID: last.ID}, nil
+}
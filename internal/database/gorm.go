@@ -8,6 +8,7 @@ import (
 	"whatsapp-gateway/internal/models"
 
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
@@ -15,19 +16,27 @@ import (
 var GormDB *gorm.DB
 
 func InitGorm(cfg *config.Config) {
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
-		cfg.DBHost, cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBPort, cfg.DBSSLMode)
+	var dialector gorm.Dialector
+	switch cfg.DBDriver {
+	case "sqlite":
+		// Local development only; search falls back to LIKE queries
+		dialector = sqlite.Open(cfg.DBPath + "?_foreign_keys=on")
+	default:
+		dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
+			cfg.DBHost, cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBPort, cfg.DBSSLMode)
+		dialector = postgres.Open(dsn)
+	}
 
 	var err error
-	GormDB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{
+	GormDB, err = gorm.Open(dialector, &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
 	})
 
 	if err != nil {
-		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+		log.Fatalf("Failed to connect to %s: %v", dialector.Name(), err)
 	}
 
-	log.Println("Connected to PostgreSQL successfully")
+	log.Printf("Connected to %s successfully", dialector.Name())
 
 	// Auto Migration
 	err = GormDB.AutoMigrate(
@@ -44,14 +53,60 @@ func InitGorm(cfg *config.Config) {
 		&models.FlowNode{},
 		&models.FlowEdge{},
 		&models.SystemSetting{},
+		&models.FlowSend{},
+		&models.FlowSubmission{},
+		&models.BusinessProfile{},
+		&models.BusinessProfileChange{},
+		&models.PhoneNumber{},
+		&models.ChatLink{},
+		&models.ChatLinkScan{},
+		&models.Conversation{},
+		&models.PricingEvent{},
+		&models.RateCard{},
+		&models.Consent{},
+		&models.Suppression{},
+		&models.Campaign{},
+		&models.CampaignVariant{},
+		&models.CampaignRecipient{},
+		&models.CampaignStatusEvent{},
+		&models.Sequence{},
+		&models.SequenceStep{},
+		&models.SequenceEnrollment{},
+		&models.SequenceDelivery{},
+		&models.ContactImport{},
+		&models.ContactImportRow{},
 	)
 	if err != nil {
 		log.Fatalf("Failed to run auto-migration: %v", err)
 	}
 
+	// Messages stored before conversations existed keep their wa_id/sender
+	// columns until cmd/migrate_conversations has backfilled them. New rows
+	// no longer set those columns, so they can't stay NOT NULL.
+	if GormDB.Migrator().HasColumn(&models.Message{}, "sender") {
+		log.Println("messages still has legacy wa_id/sender columns, run: go run ./cmd/migrate_conversations")
+		if IsPostgres() {
+			for _, column := range []string{"wa_id", "sender"} {
+				if err := GormDB.Exec("ALTER TABLE messages ALTER COLUMN " + column + " DROP NOT NULL").Error; err != nil {
+					log.Printf("Failed to relax messages.%s: %v", column, err)
+				}
+			}
+		}
+	}
+
+	if IsPostgres() {
+		ensureSearchIndexes(cfg.SearchLanguage)
+	}
+
 	log.Println("Database migration completed")
 }
 
+// IsPostgres reports whether GormDB talks to PostgreSQL rather than the
+// SQLite development database
+func IsPostgres() bool {
+	return GormDB.Dialector.Name() == "postgres"
+}
+
 func SyncConfig(cfg *config.Config) {
 	settings := []struct {
 		Key   string
@@ -61,6 +116,14 @@ func SyncConfig(cfg *config.Config) {
 		{"WHATSAPP_TOKEN", &cfg.WhatsAppToken},
 		{"PHONE_NUMBER_ID", &cfg.PhoneNumberID},
 		{"WABA_ID", &cfg.WhatsAppBusinessAccountID},
+		{"OPT_OUT_KEYWORDS", &cfg.OptOutKeywords},
+		{"OPT_IN_KEYWORDS", &cfg.OptInKeywords},
+		{"OPT_OUT_REPLY", &cfg.OptOutReply},
+		{"OPT_IN_REPLY", &cfg.OptInReply},
+		{"QUIET_HOURS_START", &cfg.QuietHoursStart},
+		{"QUIET_HOURS_END", &cfg.QuietHoursEnd},
+		{"DEFAULT_TIMEZONE", &cfg.DefaultTimezone},
+		{"DEFAULT_COUNTRY", &cfg.DefaultCountry},
 	}
 
 	for _, s := range settings {
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+// SearchLanguage is the text search configuration the messages index was
+// built with. Queries must use the same one to match.
+var SearchLanguage = "simple"
+
+// TrigramAvailable reports whether pg_trgm could be enabled for phone
+// number matching
+var TrigramAvailable bool
+
+var languagePattern = regexp.MustCompile(`^[a-z_]+$`)
+
+// ensureSearchIndexes adds the generated tsvector columns and their GIN
+// indexes. The messages column is rebuilt when SEARCH_LANGUAGE changes.
+func ensureSearchIndexes(language string) {
+	language = strings.ToLower(strings.TrimSpace(language))
+	var known int64
+	if languagePattern.MatchString(language) {
+		GormDB.Raw("SELECT COUNT(*) FROM pg_ts_config WHERE cfgname = ?", language).Scan(&known)
+	}
+	if known == 0 {
+		log.Printf("Unknown SEARCH_LANGUAGE %q, using simple", language)
+		language = "simple"
+	}
+	SearchLanguage = language
+
+	var expression string
+	GormDB.Raw(`SELECT pg_get_expr(d.adbin, d.adrelid) FROM pg_attrdef d
+		JOIN pg_attribute a ON a.attrelid = d.adrelid AND a.attnum = d.adnum
+		WHERE d.adrelid = 'messages'::regclass AND a.attname = 'search_vector'`).Scan(&expression)
+	if expression != "" && !strings.Contains(expression, "'"+language+"'") {
+		log.Printf("Search language changed to %s, rebuilding messages.search_vector", language)
+		GormDB.Exec("ALTER TABLE messages DROP COLUMN search_vector")
+	}
+
+	statements := []string{
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector
+			GENERATED ALWAYS AS (to_tsvector('` + language + `', coalesce(content, ''))) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_search ON messages USING GIN (search_vector)`,
+		// Names and tags are matched word for word whatever the language
+		`ALTER TABLE contacts ADD COLUMN IF NOT EXISTS search_vector tsvector
+			GENERATED ALWAYS AS (
+				setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+				setweight(to_tsvector('simple', coalesce(tags, '')), 'B')
+			) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_contacts_search ON contacts USING GIN (search_vector)`,
+	}
+	for _, stmt := range statements {
+		if err := GormDB.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to set up search index: %v", err)
+			return
+		}
+	}
+
+	// Partial phone numbers are matched with trigrams
+	if err := GormDB.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		log.Printf("pg_trgm unavailable, phone number search will not be indexed: %v", err)
+		return
+	}
+	if err := GormDB.Exec("CREATE INDEX IF NOT EXISTS idx_contacts_wa_id_trgm ON contacts USING GIN (wa_id gin_trgm_ops)").Error; err != nil {
+		log.Printf("Failed to create phone number trigram index: %v", err)
+		return
+	}
+	TrigramAvailable = true
+}
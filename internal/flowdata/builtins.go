@@ -0,0 +1,54 @@
+package flowdata
+
+import (
+	"strings"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+)
+
+// RegisterBuiltins adds the flows served straight from our database.
+// tagsChanged is told when a flow changes the tags of a contact.
+func RegisterBuiltins(r *Registry, tagsChanged func(waID string, before, after []string)) {
+	r.Register("contact_profile", func(req *Request, token FlowToken) (*Response, error) {
+		return contactProfile(req, token, tagsChanged)
+	})
+}
+
+// contactProfile lets a contact review and update the name and tags we hold
+// for them. Screens: PROFILE (name, tags) -> SUCCESS.
+func contactProfile(req *Request, token FlowToken, tagsChanged func(waID string, before, after []string)) (*Response, error) {
+	var contact models.Contact
+	if err := database.GormDB.Where("wa_id = ?", token.WaID).First(&contact).Error; err != nil {
+		return nil, ErrInvalidFlowToken
+	}
+
+	switch req.Action {
+	case ActionInit, ActionBack:
+		return Screen("PROFILE", map[string]interface{}{
+			"name":  contact.Name,
+			"phone": contact.WaID,
+			"tags":  contact.Tags,
+		}), nil
+	case ActionDataExchange:
+		name, _ := req.Data["name"].(string)
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, &UserError{Message: "Please enter your name"}
+		}
+		updateData := map[string]interface{}{"name": name}
+		tags, hasTags := req.Data["tags"].(string)
+		if hasTags {
+			updateData["tags"] = tags
+		}
+		before := contact.TagList()
+		if err := database.GormDB.Model(&contact).Updates(updateData).Error; err != nil {
+			return nil, err
+		}
+		if hasTags && tagsChanged != nil {
+			tagsChanged(contact.WaID, before, models.Contact{Tags: tags}.TagList())
+		}
+		return Complete(req.FlowToken, map[string]interface{}{"name": name}), nil
+	}
+
+	return nil, &UserError{Message: "Unsupported action"}
+}
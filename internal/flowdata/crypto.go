@@ -0,0 +1,152 @@
+package flowdata
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrDecryption means the request could not be decrypted with our private
+// key. Meta answers this with a 421 by re-fetching the public key.
+var ErrDecryption = errors.New("failed to decrypt flow request")
+
+// EncryptedRequest is the body Meta posts to the data endpoint
+type EncryptedRequest struct {
+	EncryptedFlowData string `json:"encrypted_flow_data"`
+	EncryptedAESKey   string `json:"encrypted_aes_key"`
+	InitialVector     string `json:"initial_vector"`
+}
+
+// Session holds the per-request AES key and IV needed to encrypt the response
+type Session struct {
+	key []byte
+	iv  []byte
+}
+
+// DecryptRequest unwraps the AES key with RSA-OAEP (SHA-256) and decrypts
+// the AES-GCM payload into req
+func DecryptRequest(body EncryptedRequest, privateKey *rsa.PrivateKey) (*Request, *Session, error) {
+	encryptedKey, err := base64.StdEncoding.DecodeString(body.EncryptedAESKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid aes key encoding", ErrDecryption)
+	}
+	iv, err := base64.StdEncoding.DecodeString(body.InitialVector)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid iv encoding", ErrDecryption)
+	}
+	payload, err := base64.StdEncoding.DecodeString(body.EncryptedFlowData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid payload encoding", ErrDecryption)
+	}
+
+	key, err := rsa.DecryptOAEP(sha256.New(), nil, privateKey, encryptedKey, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrDecryption, err)
+	}
+
+	gcm, err := newGCM(key, len(iv))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrDecryption, err)
+	}
+	plaintext, err := gcm.Open(nil, iv, payload, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrDecryption, err)
+	}
+
+	var req Request
+	if err := json.Unmarshal(plaintext, &req); err != nil {
+		return nil, nil, fmt.Errorf("invalid flow request payload: %w", err)
+	}
+
+	return &req, &Session{key: key, iv: iv}, nil
+}
+
+// EncryptResponse encrypts v with the request's AES key and the bit-flipped
+// IV, returning the base64 body Meta expects
+func (s *Session) EncryptResponse(v interface{}) (string, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	flipped := make([]byte, len(s.iv))
+	for i, b := range s.iv {
+		flipped[i] = ^b
+	}
+
+	gcm, err := newGCM(s.key, len(flipped))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nil, flipped, plaintext, nil)), nil
+}
+
+// EncryptRequest builds an encrypted request the way the WhatsApp client
+// does. It is used by the key check command to exercise a key pair end to end.
+func EncryptRequest(req *Request, publicKey *rsa.PublicKey) (EncryptedRequest, *Session, error) {
+	key := make([]byte, 16)
+	iv := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return EncryptedRequest{}, nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return EncryptedRequest{}, nil, err
+	}
+
+	plaintext, err := json.Marshal(req)
+	if err != nil {
+		return EncryptedRequest{}, nil, err
+	}
+	gcm, err := newGCM(key, len(iv))
+	if err != nil {
+		return EncryptedRequest{}, nil, err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, key, nil)
+	if err != nil {
+		return EncryptedRequest{}, nil, err
+	}
+
+	return EncryptedRequest{
+		EncryptedFlowData: base64.StdEncoding.EncodeToString(gcm.Seal(nil, iv, plaintext, nil)),
+		EncryptedAESKey:   base64.StdEncoding.EncodeToString(encryptedKey),
+		InitialVector:     base64.StdEncoding.EncodeToString(iv),
+	}, &Session{key: key, iv: iv}, nil
+}
+
+// DecryptResponse reverses EncryptResponse
+func (s *Session) DecryptResponse(body string, v interface{}) error {
+	ciphertext, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return err
+	}
+
+	flipped := make([]byte, len(s.iv))
+	for i, b := range s.iv {
+		flipped[i] = ^b
+	}
+
+	gcm, err := newGCM(s.key, len(flipped))
+	if err != nil {
+		return err
+	}
+	plaintext, err := gcm.Open(nil, flipped, ciphertext, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plaintext, v)
+}
+
+// Meta uses a 16 byte IV rather than the standard 12 byte GCM nonce
+func newGCM(key []byte, nonceSize int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, nonceSize)
+}
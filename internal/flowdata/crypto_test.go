@@ -0,0 +1,183 @@
+package flowdata
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+)
+
+var (
+	testKeyOnce sync.Once
+	testKey     *rsa.PrivateKey
+)
+
+func privateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	testKeyOnce.Do(func() {
+		privatePEM, _, err := GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("GenerateKeyPair: %v", err)
+		}
+		if testKey, err = ParsePrivateKey(privatePEM); err != nil {
+			t.Fatalf("ParsePrivateKey: %v", err)
+		}
+	})
+	if testKey == nil {
+		t.Fatal("no test key")
+	}
+	return testKey
+}
+
+// metaEncrypt encrypts payload like the WhatsApp client: a random AES-128
+// key wrapped with RSA-OAEP (SHA-256), AES-GCM with a 16 byte IV
+func metaEncrypt(t *testing.T, payload interface{}, publicKey *rsa.PublicKey) (EncryptedRequest, []byte, []byte) {
+	t.Helper()
+	key := make([]byte, 16)
+	iv := make([]byte, 16)
+	rand.Read(key)
+	rand.Read(iv)
+
+	plaintext, _ := json.Marshal(payload)
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCMWithNonceSize(block, len(iv))
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, key, nil)
+	if err != nil {
+		t.Fatalf("EncryptOAEP: %v", err)
+	}
+	return EncryptedRequest{
+		EncryptedFlowData: base64.StdEncoding.EncodeToString(gcm.Seal(nil, iv, plaintext, nil)),
+		EncryptedAESKey:   base64.StdEncoding.EncodeToString(encryptedKey),
+		InitialVector:     base64.StdEncoding.EncodeToString(iv),
+	}, key, iv
+}
+
+// metaDecrypt opens a response with the given IV like the WhatsApp client
+func metaDecrypt(body string, key, iv []byte) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCMWithNonceSize(block, len(iv))
+	return gcm.Open(nil, iv, ciphertext, nil)
+}
+
+func TestDecryptRequestAndEncryptResponse(t *testing.T) {
+	key := privateKey(t)
+	body, aesKey, iv := metaEncrypt(t, Request{
+		Version:   "3.0",
+		Action:    ActionDataExchange,
+		Screen:    "WELCOME",
+		Data:      map[string]interface{}{"name": "Ana"},
+		FlowToken: "survey:447700900123:abcd",
+	}, &key.PublicKey)
+
+	req, session, err := DecryptRequest(body, key)
+	if err != nil {
+		t.Fatalf("DecryptRequest: %v", err)
+	}
+	if req.Action != ActionDataExchange || req.Screen != "WELCOME" || req.Data["name"] != "Ana" || req.FlowToken != "survey:447700900123:abcd" {
+		t.Fatalf("unexpected request %+v", req)
+	}
+
+	encoded, err := session.EncryptResponse(Screen("DONE", map[string]interface{}{"ok": true}))
+	if err != nil {
+		t.Fatalf("EncryptResponse: %v", err)
+	}
+
+	flipped := make([]byte, len(iv))
+	for i, b := range iv {
+		flipped[i] = ^b
+	}
+	plaintext, err := metaDecrypt(encoded, aesKey, flipped)
+	if err != nil {
+		t.Fatalf("response does not open with the flipped IV: %v", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(plaintext, &resp); err != nil {
+		t.Fatalf("response is not JSON: %v", err)
+	}
+	if resp.Screen != "DONE" || resp.Data["ok"] != true {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if _, err := metaDecrypt(encoded, aesKey, iv); err == nil {
+		t.Fatal("response opens with the request IV, want the flipped IV only")
+	}
+}
+
+func TestDecryptRequestWithOtherKey(t *testing.T) {
+	other, err := rsa.GenerateKey(rand.Reader, KeySize)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	body, _, _ := metaEncrypt(t, Request{Action: ActionPing}, &other.PublicKey)
+
+	if _, _, err := DecryptRequest(body, privateKey(t)); !errors.Is(err, ErrDecryption) {
+		t.Fatalf("got %v, want ErrDecryption", err)
+	}
+}
+
+func TestDecryptRequestTamperedPayload(t *testing.T) {
+	key := privateKey(t)
+	body, _, _ := metaEncrypt(t, Request{Action: ActionPing}, &key.PublicKey)
+	payload, _ := base64.StdEncoding.DecodeString(body.EncryptedFlowData)
+	payload[0] ^= 0xff
+	body.EncryptedFlowData = base64.StdEncoding.EncodeToString(payload)
+
+	if _, _, err := DecryptRequest(body, key); !errors.Is(err, ErrDecryption) {
+		t.Fatalf("got %v, want ErrDecryption", err)
+	}
+}
+
+func TestEncryptRequestMatchesDecrypt(t *testing.T) {
+	key := privateKey(t)
+	body, clientSession, err := EncryptRequest(&Request{Version: "3.0", Action: ActionPing}, &key.PublicKey)
+	if err != nil {
+		t.Fatalf("EncryptRequest: %v", err)
+	}
+	req, session, err := DecryptRequest(body, key)
+	if err != nil {
+		t.Fatalf("DecryptRequest: %v", err)
+	}
+	resp, _ := NewRegistry().Dispatch(req)
+	encoded, err := session.EncryptResponse(resp)
+	if err != nil {
+		t.Fatalf("EncryptResponse: %v", err)
+	}
+
+	var got Response
+	if err := clientSession.DecryptResponse(encoded, &got); err != nil {
+		t.Fatalf("DecryptResponse: %v", err)
+	}
+	if got.Data["status"] != "active" {
+		t.Fatalf("unexpected ping response %+v", got)
+	}
+}
+
+func TestPublicKeyPEMMatchesGeneratedKey(t *testing.T) {
+	privatePEM, publicPEM, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	key, err := ParsePrivateKey(privatePEM)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey: %v", err)
+	}
+	derived, err := PublicKeyPEM(key)
+	if err != nil {
+		t.Fatalf("PublicKeyPEM: %v", err)
+	}
+	if string(derived) != string(publicPEM) {
+		t.Fatal("derived public key differs from the generated one")
+	}
+	if _, err := ParsePrivateKey(publicPEM); err == nil {
+		t.Fatal("ParsePrivateKey accepted a public key")
+	}
+}
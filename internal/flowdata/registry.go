@@ -0,0 +1,172 @@
+package flowdata
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Flow request actions
+const (
+	ActionPing         = "ping"
+	ActionInit         = "INIT"
+	ActionDataExchange = "data_exchange"
+	ActionBack         = "BACK"
+)
+
+// ErrInvalidFlowToken tells the client the flow can't continue. Meta shows
+// the user an error and closes the flow (HTTP 427).
+var ErrInvalidFlowToken = errors.New("flow token is no longer valid")
+
+// Request is a decrypted data_exchange request
+type Request struct {
+	Version   string                 `json:"version"`
+	Action    string                 `json:"action"`
+	Screen    string                 `json:"screen,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	FlowToken string                 `json:"flow_token,omitempty"`
+}
+
+// Response is what gets encrypted and sent back to the client
+type Response struct {
+	Version string                 `json:"version,omitempty"`
+	Screen  string                 `json:"screen,omitempty"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+// Screen navigates the client to screen with data
+func Screen(screen string, data map[string]interface{}) *Response {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	return &Response{Screen: screen, Data: data}
+}
+
+// Complete closes the flow. The params are delivered back to us in the
+// nfm_reply webhook message.
+func Complete(flowToken string, params map[string]interface{}) *Response {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	params["flow_token"] = flowToken
+	return &Response{
+		Screen: "SUCCESS",
+		Data: map[string]interface{}{
+			"extension_message_response": map[string]interface{}{"params": params},
+		},
+	}
+}
+
+// UserError is shown to the user on the current screen as an error message
+type UserError struct {
+	Message string
+}
+
+func (e *UserError) Error() string {
+	return e.Message
+}
+
+// Handler builds the response for a request belonging to one flow
+type Handler func(req *Request, token FlowToken) (*Response, error)
+
+// FlowToken is the parsed form of the flow_token we hand out when sending
+// a flow, "<flow>:<wa_id>:<nonce>"
+type FlowToken struct {
+	Flow  string
+	WaID  string
+	Nonce string
+}
+
+// NewFlowToken creates a token identifying the handler and contact for a flow
+func NewFlowToken(flow, waID string) string {
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	return fmt.Sprintf("%s:%s:%s", flow, waID, hex.EncodeToString(nonce))
+}
+
+func ParseFlowToken(token string) FlowToken {
+	parts := strings.SplitN(token, ":", 3)
+	t := FlowToken{Flow: parts[0]}
+	if len(parts) > 1 {
+		t.WaID = parts[1]
+	}
+	if len(parts) > 2 {
+		t.Nonce = parts[2]
+	}
+	return t
+}
+
+// Registry maps flow names to the handlers that serve their screens
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+
+	// ResolveToken turns a flow_token into the flow it belongs to. The
+	// default only accepts tokens recorded by Send.
+	ResolveToken func(token string) (FlowToken, error)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers:     make(map[string]Handler),
+		ResolveToken: ResolveStoredToken,
+	}
+}
+
+func (r *Registry) Register(flow string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[flow] = h
+}
+
+// Flows lists the registered flow names
+func (r *Registry) Flows() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.handlers))
+	for name := range r.handlers {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Dispatch answers health checks and error notifications itself and routes
+// everything else to the flow's handler
+func (r *Registry) Dispatch(req *Request) (*Response, error) {
+	if req.Action == ActionPing {
+		return &Response{Data: map[string]interface{}{"status": "active"}}, nil
+	}
+
+	// The client reports its own errors with an "error" key in data
+	if _, ok := req.Data["error"]; ok {
+		log.Printf("[FlowData] Client error on screen %s: %v (%v)", req.Screen, req.Data["error"], req.Data["error_message"])
+		return &Response{Data: map[string]interface{}{"acknowledged": true}}, nil
+	}
+
+	token, err := r.ResolveToken(req.FlowToken)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	h, ok := r.handlers[token.Flow]
+	r.mu.RUnlock()
+	if !ok {
+		log.Printf("[FlowData] No handler registered for flow '%s'", token.Flow)
+		return nil, ErrInvalidFlowToken
+	}
+
+	resp, err := h(req, token)
+	if err != nil {
+		var userErr *UserError
+		if errors.As(err, &userErr) {
+			return Screen(req.Screen, map[string]interface{}{"error_message": userErr.Message}), nil
+		}
+		return nil, err
+	}
+	return resp, nil
+}
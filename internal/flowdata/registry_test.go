@@ -0,0 +1,69 @@
+package flowdata
+
+import (
+	"errors"
+	"testing"
+)
+
+func testRegistry() *Registry {
+	r := NewRegistry()
+	r.ResolveToken = func(token string) (FlowToken, error) {
+		if token == "" || token == "expired" {
+			return FlowToken{}, ErrInvalidFlowToken
+		}
+		return ParseFlowToken(token), nil
+	}
+	r.Register("survey", func(req *Request, token FlowToken) (*Response, error) {
+		if req.Data["name"] == "" {
+			return nil, &UserError{Message: "Name is required"}
+		}
+		return Screen("THANKS", map[string]interface{}{"wa_id": token.WaID}), nil
+	})
+	return r
+}
+
+func TestDispatchPing(t *testing.T) {
+	resp, err := testRegistry().Dispatch(&Request{Action: ActionPing})
+	if err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	if resp.Data["status"] != "active" {
+		t.Fatalf("unexpected ping response %+v", resp)
+	}
+}
+
+func TestDispatchRoutesByToken(t *testing.T) {
+	r := testRegistry()
+
+	resp, err := r.Dispatch(&Request{Action: ActionDataExchange, Screen: "FORM", Data: map[string]interface{}{"name": "Ana"}, FlowToken: "survey:447700900123:ab"})
+	if err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	if resp.Screen != "THANKS" || resp.Data["wa_id"] != "447700900123" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	resp, err = r.Dispatch(&Request{Action: ActionDataExchange, Screen: "FORM", Data: map[string]interface{}{"name": ""}, FlowToken: "survey:447700900123:ab"})
+	if err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	if resp.Screen != "FORM" || resp.Data["error_message"] != "Name is required" {
+		t.Fatalf("user error not shown on the screen: %+v", resp)
+	}
+}
+
+func TestDispatchInvalidToken(t *testing.T) {
+	r := testRegistry()
+	for _, token := range []string{"expired", "unknown:447700900123:ab"} {
+		if _, err := r.Dispatch(&Request{Action: ActionDataExchange, FlowToken: token}); !errors.Is(err, ErrInvalidFlowToken) {
+			t.Errorf("token %q: got %v, want ErrInvalidFlowToken", token, err)
+		}
+	}
+}
+
+func TestParseFlowToken(t *testing.T) {
+	token := ParseFlowToken(NewFlowToken("survey", "447700900123"))
+	if token.Flow != "survey" || token.WaID != "447700900123" || len(token.Nonce) != 16 {
+		t.Fatalf("unexpected token %+v", token)
+	}
+}
@@ -0,0 +1,95 @@
+package flowdata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/whatsapp"
+)
+
+// DefaultHandler is used in tokens for flows that don't use the data endpoint
+const DefaultHandler = "flow"
+
+// Send sends a flow to waID with a fresh flow token and records the send so
+// the reply can be matched. handler names the registry entry serving the
+// flow's data endpoint; sessionID is the chatbot session waiting for the reply.
+func Send(ctx context.Context, client whatsapp.MessageSender, waID string, msg whatsapp.FlowMessage, handler string, sessionID *uint) (*models.FlowSend, error) {
+	if handler == "" {
+		handler = DefaultHandler
+	}
+	msg.Token = NewFlowToken(handler, waID)
+
+	send := models.FlowSend{
+		FlowToken: msg.Token,
+		WaID:      waID,
+		FlowID:    msg.FlowID,
+		Handler:   handler,
+		SessionID: sessionID,
+		Status:    "sent",
+	}
+	if err := database.GormDB.Create(&send).Error; err != nil {
+		return nil, err
+	}
+
+	if _, err := client.SendFlowMessage(ctx, waID, msg); err != nil {
+		database.GormDB.Model(&send).Update("status", "failed")
+		return nil, err
+	}
+	return &send, nil
+}
+
+// ResolveStoredToken looks a flow token up in the recorded sends. Tokens we
+// never issued or whose flow was already submitted are rejected.
+func ResolveStoredToken(token string) (FlowToken, error) {
+	var send models.FlowSend
+	if err := database.GormDB.Where("flow_token = ?", token).First(&send).Error; err != nil {
+		return FlowToken{}, ErrInvalidFlowToken
+	}
+	if send.Status != "sent" {
+		return FlowToken{}, ErrInvalidFlowToken
+	}
+
+	parsed := ParseFlowToken(token)
+	return FlowToken{Flow: send.Handler, WaID: send.WaID, Nonce: parsed.Nonce}, nil
+}
+
+// RecordReply stores an nfm_reply as a form submission, linking it to the
+// send it answers. The send is nil for replies to flows we have no record of.
+func RecordReply(waID, messageID, responsePayload string) (*models.FlowSubmission, *models.FlowSend, map[string]interface{}, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(responsePayload), &fields); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid flow response payload: %w", err)
+	}
+
+	submission := models.FlowSubmission{
+		WaID:      waID,
+		MessageID: messageID,
+		Response:  responsePayload,
+	}
+
+	var send *models.FlowSend
+	if token, ok := fields["flow_token"].(string); ok && token != "" {
+		submission.FlowToken = token
+		delete(fields, "flow_token")
+
+		var found models.FlowSend
+		if err := database.GormDB.Where("flow_token = ?", token).First(&found).Error; err == nil {
+			send = &found
+			submission.FlowSendID = &found.ID
+			submission.FlowID = found.FlowID
+
+			now := time.Now()
+			database.GormDB.Model(&found).Updates(map[string]interface{}{"status": "completed", "completed_at": now})
+			found.Status = "completed"
+			found.CompletedAt = &now
+		}
+	}
+
+	if err := database.GormDB.Create(&submission).Error; err != nil {
+		return nil, nil, nil, err
+	}
+	return &submission, send, fields, nil
+}
@@ -0,0 +1,178 @@
+package flowjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// node is a parsed JSON value that remembers where it started in the source
+type node struct {
+	kind   string // object, array, string, number, bool, null
+	offset int64
+
+	keys   []string // object keys in source order
+	fields map[string]*node
+	items  []*node
+	str    string
+	num    float64
+	bool   bool
+}
+
+func (n *node) get(key string) *node {
+	if n == nil || n.kind != "object" {
+		return nil
+	}
+	return n.fields[key]
+}
+
+func (n *node) stringValue() (string, bool) {
+	if n == nil || n.kind != "string" {
+		return "", false
+	}
+	return n.str, true
+}
+
+// parser walks the token stream of encoding/json, recording the byte
+// offset at which every value starts
+type parser struct {
+	src []byte
+	dec *json.Decoder
+}
+
+func parse(src []byte) (*node, error) {
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber()
+	p := &parser{src: src, dec: dec}
+
+	root, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, &SyntaxError{Offset: dec.InputOffset(), Message: "unexpected data after top-level value"}
+	}
+	return root, nil
+}
+
+// SyntaxError is a JSON syntax error with its position in the source
+type SyntaxError struct {
+	Offset  int64
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Message
+}
+
+// start finds the first byte of the next value after the decoder's position
+func (p *parser) start() int64 {
+	i := p.dec.InputOffset()
+	for i < int64(len(p.src)) {
+		switch p.src[i] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func (p *parser) value() (*node, error) {
+	offset := p.start()
+	tok, err := p.dec.Token()
+	if err != nil {
+		return nil, p.syntaxError(err, offset)
+	}
+	return p.valueFrom(tok, offset)
+}
+
+func (p *parser) valueFrom(tok json.Token, offset int64) (*node, error) {
+	n := &node{offset: offset}
+
+	switch v := tok.(type) {
+	case json.Delim:
+		switch v {
+		case '{':
+			n.kind = "object"
+			n.fields = make(map[string]*node)
+			for p.dec.More() {
+				keyOffset := p.start()
+				keyTok, err := p.dec.Token()
+				if err != nil {
+					return nil, p.syntaxError(err, keyOffset)
+				}
+				key, ok := keyTok.(string)
+				if !ok {
+					return nil, &SyntaxError{Offset: keyOffset, Message: "object key must be a string"}
+				}
+				child, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				if _, dup := n.fields[key]; !dup {
+					n.keys = append(n.keys, key)
+				}
+				n.fields[key] = child
+			}
+		case '[':
+			n.kind = "array"
+			for p.dec.More() {
+				child, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				n.items = append(n.items, child)
+			}
+		default:
+			return nil, &SyntaxError{Offset: offset, Message: fmt.Sprintf("unexpected '%s'", v)}
+		}
+
+		// Consume the closing delimiter
+		if _, err := p.dec.Token(); err != nil {
+			return nil, p.syntaxError(err, p.start())
+		}
+	case string:
+		n.kind = "string"
+		n.str = v
+	case json.Number:
+		n.kind = "number"
+		n.num, _ = v.Float64()
+	case bool:
+		n.kind = "bool"
+		n.bool = v
+	case nil:
+		n.kind = "null"
+	}
+
+	return n, nil
+}
+
+func (p *parser) syntaxError(err error, offset int64) error {
+	if se, ok := err.(*json.SyntaxError); ok {
+		return &SyntaxError{Offset: se.Offset, Message: se.Error()}
+	}
+	if err == io.EOF {
+		return &SyntaxError{Offset: int64(len(p.src)), Message: "unexpected end of JSON input"}
+	}
+	return &SyntaxError{Offset: offset, Message: err.Error()}
+}
+
+// position converts a byte offset to a 1-based line and column
+func position(src []byte, offset int64) (int, int) {
+	if offset > int64(len(src)) {
+		offset = int64(len(src))
+	}
+	line, col := 1, 1
+	for _, b := range src[:offset] {
+		if b == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
@@ -0,0 +1,497 @@
+package flowjson
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SupportedVersions are the Flow JSON versions accepted by the Cloud API
+var SupportedVersions = []string{
+	"2.1", "3.0", "3.1", "4.0", "5.0", "5.1", "6.0", "6.1", "6.2", "6.3", "7.0", "7.1",
+}
+
+// Error is a problem found in a Flow JSON document, addressed by JSON path
+// and source position
+type Error struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+func (e Error) String() string {
+	return fmt.Sprintf("%d:%d %s: %s", e.Line, e.Column, e.Path, e.Message)
+}
+
+// componentSpec lists the properties a component must have
+type componentSpec struct {
+	required  []string
+	inputName bool // the component contributes a form field named by "name"
+}
+
+var components = map[string]componentSpec{
+	"TextHeading":       {required: []string{"text"}},
+	"TextSubheading":    {required: []string{"text"}},
+	"TextBody":          {required: []string{"text"}},
+	"TextCaption":       {required: []string{"text"}},
+	"RichText":          {required: []string{"text"}},
+	"Form":              {required: []string{"name", "children"}},
+	"TextInput":         {required: []string{"name", "label"}, inputName: true},
+	"TextArea":          {required: []string{"name", "label"}, inputName: true},
+	"CheckboxGroup":     {required: []string{"name", "data-source"}, inputName: true},
+	"RadioButtonsGroup": {required: []string{"name", "data-source"}, inputName: true},
+	"Dropdown":          {required: []string{"name", "label", "data-source"}, inputName: true},
+	"ChipsSelector":     {required: []string{"name", "label", "data-source"}, inputName: true},
+	"DatePicker":        {required: []string{"name", "label"}, inputName: true},
+	"CalendarPicker":    {required: []string{"name", "label"}, inputName: true},
+	"OptIn":             {required: []string{"name", "label"}, inputName: true},
+	"PhotoPicker":       {required: []string{"name", "label"}, inputName: true},
+	"DocumentPicker":    {required: []string{"name", "label"}, inputName: true},
+	"EmbeddedLink":      {required: []string{"text", "on-click-action"}},
+	"Footer":            {required: []string{"label", "on-click-action"}},
+	"Image":             {required: []string{"src"}},
+	"NavigationList":    {required: []string{"name", "list-items"}},
+	"If":                {required: []string{"condition", "then"}},
+	"Switch":            {required: []string{"value", "cases"}},
+}
+
+var actionNames = map[string]bool{
+	"navigate":      true,
+	"complete":      true,
+	"data_exchange": true,
+	"update_data":   true,
+	"open_url":      true,
+}
+
+var (
+	screenIDPattern = regexp.MustCompile(`^[A-Z][A-Z_]*$`)
+	bindingPattern  = regexp.MustCompile(`\$\{(data|form)\.([A-Za-z0-9_]+)\}|\$\{screen\.([A-Za-z0-9_]+)\.(data|form)\.([A-Za-z0-9_]+)\}`)
+)
+
+type screenInfo struct {
+	node     *node
+	path     string
+	id       string
+	terminal bool
+	data     map[string]bool
+	fields   map[string]bool
+}
+
+type validator struct {
+	src     []byte
+	errors  []Error
+	screens map[string]*screenInfo
+	order   []*screenInfo
+	dataAPI bool
+	routing map[string][]string
+}
+
+// Validate checks a Flow JSON document and returns every problem found. An
+// empty result means the document is valid.
+func Validate(src []byte) []Error {
+	root, err := parse(src)
+	if err != nil {
+		var offset int64
+		if se, ok := err.(*SyntaxError); ok {
+			offset = se.Offset
+		}
+		line, col := position(src, offset)
+		return []Error{{Path: "$", Line: line, Column: col, Message: "invalid JSON: " + err.Error()}}
+	}
+
+	v := &validator{src: src, screens: make(map[string]*screenInfo)}
+	v.validate(root)
+
+	sort.SliceStable(v.errors, func(i, j int) bool {
+		if v.errors[i].Line != v.errors[j].Line {
+			return v.errors[i].Line < v.errors[j].Line
+		}
+		return v.errors[i].Column < v.errors[j].Column
+	})
+	return v.errors
+}
+
+func (v *validator) errorf(n *node, path, format string, args ...interface{}) {
+	var offset int64
+	if n != nil {
+		offset = n.offset
+	}
+	line, col := position(v.src, offset)
+	v.errors = append(v.errors, Error{Path: path, Line: line, Column: col, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validate(root *node) {
+	if root.kind != "object" {
+		v.errorf(root, "$", "Flow JSON must be an object")
+		return
+	}
+
+	version, ok := root.get("version").stringValue()
+	switch {
+	case root.get("version") == nil:
+		v.errorf(root, "$", "missing required property 'version'")
+	case !ok:
+		v.errorf(root.get("version"), "$.version", "version must be a string")
+	case !contains(SupportedVersions, version):
+		v.errorf(root.get("version"), "$.version", "unsupported version '%s' (supported: %s)", version, strings.Join(SupportedVersions, ", "))
+	}
+
+	if dataAPI := root.get("data_api_version"); dataAPI != nil {
+		v.dataAPI = true
+		if s, ok := dataAPI.stringValue(); !ok || s != "3.0" {
+			v.errorf(dataAPI, "$.data_api_version", "data_api_version must be \"3.0\"")
+		}
+	}
+
+	screens := root.get("screens")
+	if screens == nil {
+		v.errorf(root, "$", "missing required property 'screens'")
+		return
+	}
+	if screens.kind != "array" || len(screens.items) == 0 {
+		v.errorf(screens, "$.screens", "screens must be a non-empty array")
+		return
+	}
+
+	// First pass: collect screen ids so references can be checked in any order
+	for i, s := range screens.items {
+		v.collectScreen(s, fmt.Sprintf("$.screens[%d]", i))
+	}
+
+	v.validateRouting(root)
+
+	hasTerminal := false
+	for _, s := range v.order {
+		if s.terminal {
+			hasTerminal = true
+		}
+		v.validateScreen(s)
+	}
+	if !hasTerminal {
+		v.errorf(screens, "$.screens", "at least one screen must be terminal")
+	}
+}
+
+func (v *validator) collectScreen(n *node, path string) {
+	if n.kind != "object" {
+		v.errorf(n, path, "screen must be an object")
+		return
+	}
+
+	id, ok := n.get("id").stringValue()
+	switch {
+	case n.get("id") == nil:
+		v.errorf(n, path, "missing required property 'id'")
+		return
+	case !ok || !screenIDPattern.MatchString(id):
+		v.errorf(n.get("id"), path+".id", "screen id must contain only uppercase letters and underscores")
+		return
+	case id == "SUCCESS":
+		v.errorf(n.get("id"), path+".id", "'SUCCESS' is a reserved screen id")
+		return
+	}
+	if _, dup := v.screens[id]; dup {
+		v.errorf(n.get("id"), path+".id", "duplicate screen id '%s'", id)
+		return
+	}
+
+	s := &screenInfo{node: n, path: path, id: id, data: map[string]bool{}, fields: map[string]bool{}}
+	if t := n.get("terminal"); t != nil {
+		if t.kind != "bool" {
+			v.errorf(t, path+".terminal", "terminal must be a boolean")
+		}
+		s.terminal = t.bool
+	}
+	if success := n.get("success"); success != nil && !s.terminal {
+		v.errorf(success, path+".success", "'success' is only allowed on terminal screens")
+	}
+
+	if data := n.get("data"); data != nil {
+		if data.kind != "object" {
+			v.errorf(data, path+".data", "screen data must be an object")
+		} else {
+			for _, key := range data.keys {
+				s.data[key] = true
+				field := data.fields[key]
+				fieldPath := path + ".data." + key
+				if field.kind != "object" {
+					v.errorf(field, fieldPath, "data field must be an object with 'type' and '__example__'")
+					continue
+				}
+				if field.get("type") == nil {
+					v.errorf(field, fieldPath, "missing required property 'type'")
+				}
+				if field.get("__example__") == nil {
+					v.errorf(field, fieldPath, "missing required property '__example__'")
+				}
+			}
+		}
+	}
+
+	v.screens[id] = s
+	v.order = append(v.order, s)
+}
+
+func (v *validator) validateRouting(root *node) {
+	routing := root.get("routing_model")
+	if routing == nil {
+		if v.dataAPI {
+			v.errorf(root, "$", "routing_model is required when data_api_version is set")
+		}
+		return
+	}
+	if routing.kind != "object" {
+		v.errorf(routing, "$.routing_model", "routing_model must be an object")
+		return
+	}
+
+	v.routing = make(map[string][]string)
+	targeted := map[string]bool{}
+	for _, from := range routing.keys {
+		path := "$.routing_model." + from
+		if _, ok := v.screens[from]; !ok {
+			v.errorf(routing.fields[from], path, "routing_model references unknown screen '%s'", from)
+		}
+		targets := routing.fields[from]
+		if targets.kind != "array" {
+			v.errorf(targets, path, "routes must be an array of screen ids")
+			continue
+		}
+		for i, t := range targets.items {
+			to, ok := t.stringValue()
+			if !ok {
+				v.errorf(t, fmt.Sprintf("%s[%d]", path, i), "route target must be a screen id")
+				continue
+			}
+			if _, exists := v.screens[to]; !exists {
+				v.errorf(t, fmt.Sprintf("%s[%d]", path, i), "route to unknown screen '%s'", to)
+			}
+			if to == from {
+				v.errorf(t, fmt.Sprintf("%s[%d]", path, i), "screen '%s' cannot route to itself", from)
+			}
+			targeted[to] = true
+			v.routing[from] = append(v.routing[from], to)
+		}
+	}
+
+	entry := false
+	for _, s := range v.order {
+		if !targeted[s.id] {
+			entry = true
+			break
+		}
+	}
+	if !entry {
+		v.errorf(routing, "$.routing_model", "routing_model has no entry screen (every screen is a route target)")
+	}
+}
+
+func (v *validator) validateScreen(s *screenInfo) {
+	layout := s.node.get("layout")
+	if layout == nil {
+		v.errorf(s.node, s.path, "missing required property 'layout'")
+		return
+	}
+	if t, _ := layout.get("type").stringValue(); t != "SingleColumnLayout" {
+		v.errorf(layout, s.path+".layout.type", "layout type must be 'SingleColumnLayout'")
+	}
+	children := layout.get("children")
+	if children == nil || children.kind != "array" {
+		v.errorf(layout, s.path+".layout", "layout must have a 'children' array")
+		return
+	}
+
+	// Collect form field names first so ${form.x} can be resolved anywhere on the screen
+	v.collectFields(s, children)
+
+	footers := 0
+	v.walkComponents(s, children, s.path+".layout.children", &footers)
+
+	if footers > 1 {
+		v.errorf(children, s.path+".layout.children", "a screen can have at most one Footer")
+	}
+	if s.terminal && footers == 0 {
+		v.errorf(s.node, s.path, "terminal screen '%s' must have a Footer", s.id)
+	}
+}
+
+func (v *validator) collectFields(s *screenInfo, list *node) {
+	for _, c := range list.items {
+		t, _ := c.get("type").stringValue()
+		if spec, ok := components[t]; ok && spec.inputName {
+			if name, ok := c.get("name").stringValue(); ok {
+				s.fields[name] = true
+			}
+		}
+		for _, key := range []string{"children", "then", "else"} {
+			if nested := c.get(key); nested != nil && nested.kind == "array" {
+				v.collectFields(s, nested)
+			}
+		}
+		if cases := c.get("cases"); cases != nil && cases.kind == "object" {
+			for _, key := range cases.keys {
+				if cases.fields[key].kind == "array" {
+					v.collectFields(s, cases.fields[key])
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) walkComponents(s *screenInfo, list *node, path string, footers *int) {
+	names := map[string]bool{}
+	for i, c := range list.items {
+		cPath := fmt.Sprintf("%s[%d]", path, i)
+		if c.kind != "object" {
+			v.errorf(c, cPath, "component must be an object")
+			continue
+		}
+
+		t, ok := c.get("type").stringValue()
+		if !ok {
+			v.errorf(c, cPath, "missing required property 'type'")
+			continue
+		}
+		spec, known := components[t]
+		if !known {
+			v.errorf(c.get("type"), cPath+".type", "unknown component type '%s'", t)
+			continue
+		}
+		for _, prop := range spec.required {
+			if c.get(prop) == nil {
+				v.errorf(c, cPath, "%s is missing required property '%s'", t, prop)
+			}
+		}
+
+		if name, ok := c.get("name").stringValue(); ok && spec.inputName {
+			if names[name] {
+				v.errorf(c.get("name"), cPath+".name", "duplicate component name '%s'", name)
+			}
+			names[name] = true
+		}
+
+		if t == "Footer" {
+			*footers++
+		}
+
+		for _, key := range c.keys {
+			prop := c.fields[key]
+			propPath := cPath + "." + key
+			switch {
+			case strings.HasPrefix(key, "on-") && strings.HasSuffix(key, "-action"):
+				v.validateAction(s, prop, propPath)
+			case key == "children" || key == "then" || key == "else":
+				if prop.kind == "array" {
+					v.walkComponents(s, prop, propPath, footers)
+				}
+			case key == "cases":
+				if prop.kind == "object" {
+					for _, caseKey := range prop.keys {
+						if prop.fields[caseKey].kind == "array" {
+							v.walkComponents(s, prop.fields[caseKey], propPath+"."+caseKey, footers)
+						}
+					}
+				}
+			default:
+				v.validateBindings(s, prop, propPath)
+			}
+		}
+	}
+}
+
+func (v *validator) validateAction(s *screenInfo, action *node, path string) {
+	if action.kind != "object" {
+		v.errorf(action, path, "action must be an object")
+		return
+	}
+	name, ok := action.get("name").stringValue()
+	if !ok {
+		v.errorf(action, path, "action is missing required property 'name'")
+		return
+	}
+	if !actionNames[name] {
+		v.errorf(action.get("name"), path+".name", "unknown action '%s'", name)
+		return
+	}
+
+	switch name {
+	case "navigate":
+		next := action.get("next")
+		target, _ := next.get("name").stringValue()
+		if next == nil || target == "" {
+			v.errorf(action, path, "navigate action requires next.name")
+			break
+		}
+		if t, _ := next.get("type").stringValue(); t != "" && t != "screen" {
+			v.errorf(next.get("type"), path+".next.type", "next.type must be 'screen'")
+		}
+		if _, exists := v.screens[target]; !exists {
+			v.errorf(next.get("name"), path+".next.name", "navigate to unknown screen '%s'", target)
+		} else if v.routing != nil && !contains(v.routing[s.id], target) {
+			v.errorf(next.get("name"), path+".next.name", "routing_model does not allow '%s' -> '%s'", s.id, target)
+		}
+	case "complete":
+		if !s.terminal {
+			v.errorf(action.get("name"), path+".name", "complete action is only allowed on terminal screens")
+		}
+	case "data_exchange":
+		if !v.dataAPI {
+			v.errorf(action.get("name"), path+".name", "data_exchange action requires data_api_version")
+		}
+	}
+
+	if payload := action.get("payload"); payload != nil {
+		v.validateBindings(s, payload, path+".payload")
+	}
+}
+
+// validateBindings checks every ${...} expression in a property value
+func (v *validator) validateBindings(s *screenInfo, n *node, path string) {
+	switch n.kind {
+	case "string":
+		for _, m := range bindingPattern.FindAllStringSubmatch(n.str, -1) {
+			if m[1] != "" {
+				v.checkBinding(s, m[1], m[2], n, path)
+				continue
+			}
+			target, ok := v.screens[m[3]]
+			if !ok {
+				v.errorf(n, path, "binding references unknown screen '%s'", m[3])
+				continue
+			}
+			v.checkBinding(target, m[4], m[5], n, path)
+		}
+	case "object":
+		for _, key := range n.keys {
+			v.validateBindings(s, n.fields[key], path+"."+key)
+		}
+	case "array":
+		for i, item := range n.items {
+			v.validateBindings(s, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+func (v *validator) checkBinding(s *screenInfo, scope, field string, n *node, path string) {
+	switch scope {
+	case "data":
+		if !s.data[field] {
+			v.errorf(n, path, "${data.%s} is not declared in screen '%s' data", field, s.id)
+		}
+	case "form":
+		if !s.fields[field] {
+			v.errorf(n, path, "${form.%s} does not match any input on screen '%s'", field, s.id)
+		}
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,440 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"whatsapp-gateway/internal/config"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/phone"
+	"whatsapp-gateway/internal/sequence"
+	"whatsapp-gateway/internal/ws"
+
+	"gorm.io/gorm"
+)
+
+// Import statuses
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// Modes decide what happens to contacts that already exist
+const (
+	ModeCreate = "create" // Only add new contacts
+	ModeUpdate = "update" // Only change existing contacts
+	ModeUpsert = "upsert" // Add new contacts, overwrite existing ones with the file's values
+	ModeMerge  = "merge"  // Add new contacts, fill in what existing ones lack and add tags
+)
+
+// Row statuses, only rows that were not imported are stored
+const (
+	RowSkipped = "skipped"
+	RowInvalid = "invalid"
+)
+
+// Fields that columns can be mapped to
+const (
+	FieldWaID     = "wa_id"
+	FieldName     = "name"
+	FieldTags     = "tags"
+	FieldTimezone = "timezone"
+)
+
+const (
+	chunkSize = 500
+	maxRows   = 200000
+)
+
+var (
+	ErrInvalidImport = errors.New("invalid import")
+	ErrNoPhoneColumn = errors.New("no phone number column, map wa_id to one of the columns")
+)
+
+// errInterrupted marks imports that were running when the gateway stopped
+const errInterrupted = "interrupted by a restart, rows not processed yet were not imported"
+
+// headers are the column names each field is recognised by
+var headers = map[string][]string{
+	FieldWaID:     {"wa_id", "whatsapp", "whatsapp id", "phone", "phone number", "telephone", "tel", "mobile", "cell", "number", "msisdn"},
+	FieldName:     {"name", "full name", "contact name"},
+	FieldTags:     {"tags", "tag", "labels", "groups"},
+	FieldTimezone: {"timezone", "time zone", "tz"},
+}
+
+// Options describe how to import a file
+type Options struct {
+	Mode           string            `json:"mode"`            // Default upsert
+	DefaultCountry string            `json:"default_country"` // ISO code for national numbers, default DEFAULT_COUNTRY
+	Mapping        map[string]string `json:"mapping"`         // Field to column header, e.g. {"wa_id": "Mobile"}
+	Tags           []string          `json:"tags"`            // Added to every imported contact
+	DryRun         bool              `json:"dry_run"`         // Validate and count without saving
+}
+
+// Importer imports contact files in the background, one goroutine per file
+type Importer struct {
+	Config *config.Config
+	Hub    *ws.Hub
+}
+
+func NewImporter(cfg *config.Config, hub *ws.Hub) *Importer {
+	return &Importer{Config: cfg, Hub: hub}
+}
+
+// Recover fails the imports that were running when the gateway stopped. The
+// file is not kept, so they cannot resume.
+func (im *Importer) Recover() {
+	err := database.GormDB.Model(&models.ContactImport{}).Where("status = ?", StatusRunning).
+		Updates(map[string]interface{}{"status": StatusFailed, "error": errInterrupted, "completed_at": time.Now()}).Error
+	if err != nil {
+		log.Printf("[Import] Could not fail interrupted imports: %v", err)
+	}
+}
+
+// Start checks a file and imports it in the background
+func (im *Importer) Start(fileName string, data []byte, opts Options) (*models.ContactImport, error) {
+	if opts.Mode == "" {
+		opts.Mode = ModeUpsert
+	}
+	switch opts.Mode {
+	case ModeCreate, ModeUpdate, ModeUpsert, ModeMerge:
+	default:
+		return nil, fmt.Errorf("%w: mode must be create, update, upsert or merge", ErrInvalidImport)
+	}
+	if opts.DefaultCountry == "" {
+		opts.DefaultCountry = im.Config.DefaultCountry
+	}
+	opts.DefaultCountry = strings.ToUpper(strings.TrimSpace(opts.DefaultCountry))
+	if opts.DefaultCountry != "" && phone.CallingCode(opts.DefaultCountry) == "" {
+		return nil, fmt.Errorf("%w: unknown default_country %s", ErrInvalidImport, opts.DefaultCountry)
+	}
+
+	format, err := DetectFormat(fileName, data)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := Read(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("%w: the file needs a header row and at least one contact", ErrInvalidImport)
+	}
+	if len(rows)-1 > maxRows {
+		return nil, fmt.Errorf("%w: at most %d rows per file", ErrInvalidImport, maxRows)
+	}
+	columns, err := mapColumns(rows[0], opts.Mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping, _ := json.Marshal(opts.Mapping)
+	tags, _ := json.Marshal(cleanTags(opts.Tags))
+	job := models.ContactImport{
+		FileName:       fileName,
+		Format:         format,
+		Mode:           opts.Mode,
+		DefaultCountry: opts.DefaultCountry,
+		Mapping:        string(mapping),
+		Tags:           string(tags),
+		DryRun:         opts.DryRun,
+		Status:         StatusRunning,
+		Total:          len(rows) - 1,
+	}
+	if err := database.GormDB.Create(&job).Error; err != nil {
+		return nil, err
+	}
+	log.Printf("[Import] Importing %d rows of %s (%s)", job.Total, fileName, job.Mode)
+
+	go im.run(job, rows[1:], columns, cleanTags(opts.Tags))
+	return &job, nil
+}
+
+// mapColumns finds the column of each field, by the mapping or else by the
+// usual header names
+func mapColumns(header []string, mapping map[string]string) (map[string]int, error) {
+	names := make([]string, len(header))
+	for i, h := range header {
+		names[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+	}
+	find := func(wanted string) int {
+		for i, name := range names {
+			if name == strings.ToLower(strings.TrimSpace(wanted)) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	columns := map[string]int{}
+	for field, column := range mapping {
+		if _, ok := headers[field]; !ok {
+			return nil, fmt.Errorf("%w: cannot map %s, fields are wa_id, name, tags and timezone", ErrInvalidImport, field)
+		}
+		i := find(column)
+		if i < 0 {
+			return nil, fmt.Errorf("%w: no column %q for %s", ErrInvalidImport, column, field)
+		}
+		columns[field] = i
+	}
+	for field, known := range headers {
+		if _, ok := columns[field]; ok {
+			continue
+		}
+		for _, name := range known {
+			if i := find(name); i >= 0 {
+				columns[field] = i
+				break
+			}
+		}
+	}
+	if _, ok := columns[FieldWaID]; !ok {
+		return nil, ErrNoPhoneColumn
+	}
+	return columns, nil
+}
+
+// row is a valid row ready to be saved
+type row struct {
+	line     int
+	phone    string
+	waID     string
+	name     string
+	timezone string
+	tags     []string
+	hasTags  bool
+}
+
+// run imports the rows chunk by chunk, storing progress after each
+func (im *Importer) run(job models.ContactImport, rows [][]string, columns map[string]int, tags []string) {
+	seen := map[string]int{}
+	for start := 0; start < len(rows); start += chunkSize {
+		end := min(start+chunkSize, len(rows))
+		var valid []row
+		var problems []models.ContactImportRow
+		for i, record := range rows[start:end] {
+			line := start + i + 2 // 1 based, after the header
+			r, problem := im.parse(job, record, columns, line)
+			if problem == nil {
+				if first, ok := seen[r.waID]; ok {
+					problem = &models.ContactImportRow{Phone: r.phone, WaID: r.waID, Status: RowSkipped, Reason: fmt.Sprintf("duplicate of line %d", first)}
+				} else {
+					seen[r.waID] = line
+				}
+			}
+			if problem != nil {
+				problem.ImportID, problem.Line = job.ID, line
+				problems = append(problems, *problem)
+				continue
+			}
+			r.tags = cleanTags(append(r.tags, tags...))
+			valid = append(valid, r)
+		}
+
+		if err := im.save(&job, valid, &problems); err != nil {
+			im.fail(&job, err)
+			return
+		}
+		if len(problems) > 0 {
+			if err := database.GormDB.CreateInBatches(problems, 500).Error; err != nil {
+				im.fail(&job, err)
+				return
+			}
+		}
+		for _, p := range problems {
+			if p.Status == RowInvalid {
+				job.Invalid++
+			} else {
+				job.Skipped++
+			}
+		}
+		job.Processed = end
+		database.GormDB.Model(&job).Updates(map[string]interface{}{
+			"processed": job.Processed, "created": job.Created, "updated": job.Updated, "unchanged": job.Unchanged,
+			"skipped": job.Skipped, "invalid": job.Invalid,
+		})
+		im.notify("contact_import_progress", &job)
+	}
+
+	now := time.Now()
+	job.Status, job.CompletedAt = StatusCompleted, &now
+	database.GormDB.Model(&job).Updates(map[string]interface{}{"status": job.Status, "completed_at": now})
+	im.notify("contact_import_completed", &job)
+	log.Printf("[Import] Import %d completed: %d created, %d updated, %d unchanged, %d skipped, %d invalid",
+		job.ID, job.Created, job.Updated, job.Unchanged, job.Skipped, job.Invalid)
+}
+
+// parse validates one record; problem is set for invalid rows
+func (im *Importer) parse(job models.ContactImport, record []string, columns map[string]int, line int) (row, *models.ContactImportRow) {
+	cell := func(field string) (string, bool) {
+		i, ok := columns[field]
+		if !ok || i >= len(record) {
+			return "", false
+		}
+		return strings.TrimSpace(record[i]), true
+	}
+	invalid := func(raw, reason string) (row, *models.ContactImportRow) {
+		return row{}, &models.ContactImportRow{Phone: raw, Status: RowInvalid, Reason: reason}
+	}
+
+	if strings.TrimSpace(strings.Join(record, "")) == "" {
+		return row{}, &models.ContactImportRow{Status: RowSkipped, Reason: "empty row"}
+	}
+	raw, _ := cell(FieldWaID)
+	if raw == "" {
+		return invalid(raw, "missing phone number")
+	}
+	waID, err := phone.Normalize(raw, job.DefaultCountry)
+	if err != nil {
+		if job.DefaultCountry == "" && !strings.HasPrefix(raw, "+") {
+			return invalid(raw, err.Error()+", add the country code or set default_country")
+		}
+		return invalid(raw, err.Error())
+	}
+
+	r := row{line: line, phone: raw, waID: waID}
+	r.name, _ = cell(FieldName)
+	if utf8.RuneCountInString(r.name) > 255 {
+		return invalid(raw, "name is longer than 255 characters")
+	}
+	if r.timezone, _ = cell(FieldTimezone); r.timezone != "" {
+		if _, err := time.LoadLocation(r.timezone); err != nil {
+			return invalid(raw, "unknown timezone "+r.timezone)
+		}
+	}
+	if value, ok := cell(FieldTags); ok {
+		r.tags = cleanTags(strings.FieldsFunc(value, func(c rune) bool { return c == ',' || c == ';' || c == '|' }))
+		r.hasTags = value != ""
+	}
+	return r, nil
+}
+
+// save creates or updates the contacts of one chunk according to the mode.
+// Rows skipped by the mode are added to problems.
+func (im *Importer) save(job *models.ContactImport, rows []row, problems *[]models.ContactImportRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	waIDs := make([]string, len(rows))
+	for i, r := range rows {
+		waIDs[i] = r.waID
+	}
+	var existing []models.Contact
+	if err := database.GormDB.Where("wa_id IN ?", waIDs).Find(&existing).Error; err != nil {
+		return err
+	}
+	byID := make(map[string]models.Contact, len(existing))
+	for _, c := range existing {
+		byID[c.WaID] = c
+	}
+
+	added := map[string][]string{}
+	err := database.GormDB.Transaction(func(tx *gorm.DB) error {
+		for _, r := range rows {
+			contact, exists := byID[r.waID]
+			skip := ""
+			switch {
+			case exists && job.Mode == ModeCreate:
+				skip = "contact already exists"
+			case !exists && job.Mode == ModeUpdate:
+				skip = "contact does not exist"
+			}
+			if skip != "" {
+				*problems = append(*problems, models.ContactImportRow{ImportID: job.ID, Line: r.line, Phone: r.phone, WaID: r.waID, Status: RowSkipped, Reason: skip})
+				continue
+			}
+
+			if !exists {
+				tags, _ := json.Marshal(orEmpty(r.tags))
+				job.Created++
+				added[r.waID] = r.tags
+				if !job.DryRun {
+					c := models.Contact{WaID: r.waID, Name: r.name, Timezone: r.timezone, Tags: string(tags)}
+					if err := tx.Create(&c).Error; err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			before := contact.TagList()
+			updates := map[string]interface{}{}
+			if r.name != "" && r.name != contact.Name && (job.Mode != ModeMerge || contact.Name == "") {
+				updates["name"] = r.name
+			}
+			if r.timezone != "" && r.timezone != contact.Timezone && (job.Mode != ModeMerge || contact.Timezone == "") {
+				updates["timezone"] = r.timezone
+			}
+			after := before
+			if job.Mode != ModeMerge && r.hasTags {
+				after = nil // The file's tags replace the contact's
+			}
+			after = append(append([]string(nil), after...), sequence.AddedTags(after, r.tags)...)
+			if len(sequence.AddedTags(before, after)) > 0 || len(sequence.AddedTags(after, before)) > 0 {
+				tags, _ := json.Marshal(orEmpty(after))
+				updates["tags"] = string(tags)
+			}
+			if len(updates) == 0 {
+				job.Unchanged++
+				continue
+			}
+			job.Updated++
+			added[r.waID] = sequence.AddedTags(before, after)
+			if !job.DryRun {
+				if err := tx.Model(&models.Contact{}).Where("wa_id = ?", r.waID).Updates(updates).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil || job.DryRun {
+		return err
+	}
+
+	for waID, tags := range added {
+		if err := sequence.TagsAdded(waID, tags); err != nil {
+			log.Printf("[Import] Could not apply sequence tags for %s: %v", waID, err)
+		}
+	}
+	return nil
+}
+
+func (im *Importer) fail(job *models.ContactImport, err error) {
+	log.Printf("[Import] Import %d failed: %v", job.ID, err)
+	now := time.Now()
+	job.Status, job.Error, job.CompletedAt = StatusFailed, err.Error(), &now
+	database.GormDB.Model(job).Updates(map[string]interface{}{"status": job.Status, "error": job.Error, "completed_at": now})
+	im.notify("contact_import_completed", job)
+}
+
+func (im *Importer) notify(event string, job *models.ContactImport) {
+	if im.Hub != nil {
+		im.Hub.BroadcastEvent(event, job)
+	}
+}
+
+// cleanTags trims tags and drops empty and repeated ones
+func cleanTags(tags []string) []string {
+	var clean []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && len(sequence.AddedTags(clean, []string{tag})) > 0 {
+			clean = append(clean, tag)
+		}
+	}
+	return clean
+}
+
+func orEmpty(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
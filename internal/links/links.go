@@ -0,0 +1,187 @@
+package links
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"whatsapp-gateway/internal/config"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/whatsapp"
+
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+var nonDigits = regexp.MustCompile(`\D`)
+
+// URL builds the wa.me link that opens a chat with phone and prefills text
+func URL(phone, text string) string {
+	link := "https://wa.me/" + nonDigits.ReplaceAllString(phone, "")
+	if text != "" {
+		// wa.me shows "+" literally, so spaces must be %20
+		link += "?text=" + strings.ReplaceAll(url.QueryEscape(text), "+", "%20")
+	}
+	return link
+}
+
+// BusinessPhone returns our phone number, from config, the phone number
+// cache or Meta in that order
+func BusinessPhone(ctx context.Context, client whatsapp.AccountManager, cfg *config.Config) (string, error) {
+	if cfg.BusinessPhoneNumber != "" {
+		return cfg.BusinessPhoneNumber, nil
+	}
+
+	var cached models.PhoneNumber
+	if err := database.GormDB.First(&cached, "id = ?", cfg.PhoneNumberID).Error; err == nil && cached.DisplayPhoneNumber != "" {
+		return cached.DisplayPhoneNumber, nil
+	}
+
+	number, err := client.GetPhoneNumber(ctx, cfg.PhoneNumberID)
+	if err != nil {
+		return "", fmt.Errorf("could not determine business phone number (set BUSINESS_PHONE_NUMBER): %w", err)
+	}
+	return number.DisplayPhoneNumber, nil
+}
+
+// Match finds the link an incoming message came from: the message is the
+// link's prefilled text, or equals or starts with its keyword as a whole
+// word. When several match, the longest keyword wins.
+func Match(text string) (*models.ChatLink, error) {
+	text = normalizeText(text)
+	if text == "" {
+		return nil, nil
+	}
+
+	var all []models.ChatLink
+	if err := database.GormDB.Find(&all).Error; err != nil {
+		return nil, err
+	}
+
+	var best *models.ChatLink
+	for i := range all {
+		keyword := normalizeText(all[i].Keyword)
+		if keyword == "" {
+			continue
+		}
+		if text != normalizeText(all[i].PrefilledText) && !startsWithWord(text, keyword) {
+			continue
+		}
+		if best == nil || len(keyword) > len(best.Keyword) {
+			best = &all[i]
+		}
+	}
+	return best, nil
+}
+
+// normalizeText lowercases text and collapses its whitespace
+func normalizeText(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// startsWithWord reports whether text is word or starts with it followed by
+// something other than a letter or digit
+func startsWithWord(text, word string) bool {
+	if !strings.HasPrefix(text, word) {
+		return false
+	}
+	next, _ := utf8.DecodeRuneInString(text[len(word):])
+	return next == utf8.RuneError || !(unicode.IsLetter(next) || unicode.IsDigit(next))
+}
+
+// RecordScan stores that a contact arrived through link
+func RecordScan(link *models.ChatLink, waID, messageID string, newContact bool) (*models.ChatLinkScan, error) {
+	scan := models.ChatLinkScan{
+		LinkID:     link.ID,
+		WaID:       waID,
+		MessageID:  messageID,
+		NewContact: newContact,
+	}
+	if err := database.GormDB.Create(&scan).Error; err != nil {
+		return nil, err
+	}
+	return &scan, nil
+}
+
+// QRPNG renders content as a PNG QR code of size x size pixels
+func QRPNG(content string, size int) ([]byte, error) {
+	return qrcode.Encode(content, qrcode.Medium, size)
+}
+
+// QRSVG renders content as an SVG QR code, one square per dark module
+func QRSVG(content string, size int) ([]byte, error) {
+	qr, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+	bitmap := qr.Bitmap()
+	modules := len(bitmap)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, modules, modules)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#ffffff"/>`, modules, modules)
+	buf.WriteString(`<path fill="#000000" d="`)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes(), nil
+}
+
+// Stats summarises how a link performs
+type Stats struct {
+	LinkID         uint    `json:"link_id"`
+	Scans          int64   `json:"scans"`
+	UniqueContacts int64   `json:"unique_contacts"`
+	NewContacts    int64   `json:"new_contacts"`
+	Triggered      int64   `json:"triggered"`
+	Conversions    int64   `json:"conversions"`
+	ConversionRate float64 `json:"conversion_rate"`
+}
+
+// StatsFor counts scans and conversions for a link. For flow links a scan
+// converts when the contact completes the flow afterwards; for rule links
+// when the rule ran.
+func StatsFor(link *models.ChatLink) (Stats, error) {
+	stats := Stats{LinkID: link.ID}
+	scans := func() *gorm.DB { return database.GormDB.Model(&models.ChatLinkScan{}).Where("link_id = ?", link.ID) }
+
+	if err := scans().Count(&stats.Scans).Error; err != nil {
+		return stats, err
+	}
+	if err := scans().Distinct("wa_id").Count(&stats.UniqueContacts).Error; err != nil {
+		return stats, err
+	}
+	if err := scans().Where("new_contact = ?", true).Count(&stats.NewContacts).Error; err != nil {
+		return stats, err
+	}
+	if err := scans().Where("triggered = ?", true).Count(&stats.Triggered).Error; err != nil {
+		return stats, err
+	}
+
+	if link.FlowID != "" {
+		err := scans().Where(`EXISTS (
+			SELECT 1 FROM conversation_sessions cs
+			WHERE cs.wa_id = chat_link_scans.wa_id AND cs.flow_id = ? AND cs.status = 'completed' AND cs.started_at >= chat_link_scans.created_at
+		)`, link.FlowID).Count(&stats.Conversions).Error
+		if err != nil {
+			return stats, err
+		}
+	} else {
+		stats.Conversions = stats.Triggered
+	}
+
+	if stats.Scans > 0 {
+		stats.ConversionRate = float64(stats.Conversions) / float64(stats.Scans)
+	}
+	return stats, nil
+}
@@ -0,0 +1,140 @@
+package localtime
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"whatsapp-gateway/internal/config"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/phone"
+)
+
+var (
+	ErrInvalidClock    = errors.New("time of day must be HH:MM")
+	ErrInvalidTimezone = errors.New("unknown timezone")
+)
+
+// Policy is the global quiet hours: local times of day when contacts get no
+// messages. Sends that would fall inside are deferred to its end.
+type Policy struct {
+	Start, End int // Minutes after midnight; Start == End means no quiet hours
+}
+
+// QuietHours reads the policy from QUIET_HOURS_START and QUIET_HOURS_END
+func QuietHours(cfg *config.Config) Policy {
+	start, err1 := ParseClock(cfg.QuietHoursStart)
+	end, err2 := ParseClock(cfg.QuietHoursEnd)
+	if err1 != nil || err2 != nil {
+		return Policy{}
+	}
+	return Policy{Start: start, End: end}
+}
+
+// Enabled reports whether there are quiet hours
+func (p Policy) Enabled() bool {
+	return p.Start != p.End
+}
+
+// Defer returns t, or the end of the quiet hours in loc if t falls inside them
+func (p Policy) Defer(t time.Time, loc *time.Location) time.Time {
+	if !p.Enabled() {
+		return t
+	}
+	local := t.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	quiet := minute >= p.Start && minute < p.End
+	if p.Start > p.End { // Over midnight, e.g. 21:00 to 08:00
+		quiet = minute >= p.Start || minute < p.End
+	}
+	if !quiet {
+		return t
+	}
+	return Next(t, p.End, loc)
+}
+
+// Next returns the first time at or after t when the clock in loc shows
+// minute (minutes after midnight)
+func Next(t time.Time, minute int, loc *time.Location) time.Time {
+	local := t.In(loc)
+	for day := 0; day <= 2; day++ {
+		at := time.Date(local.Year(), local.Month(), local.Day()+day, minute/60, minute%60, 0, 0, loc)
+		if !at.Before(t) {
+			return at.UTC()
+		}
+	}
+	return t
+}
+
+// ParseClock reads a time of day like 21:30 as minutes after midnight
+func ParseClock(value string) (int, error) {
+	clock, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidClock, value)
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// locations caches loaded locations by name, nil for unknown names.
+// time.LoadLocation reads the zone file on every call.
+var locations sync.Map
+
+func load(name string) *time.Location {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = nil
+	}
+	locations.Store(name, loc)
+	return loc
+}
+
+// Timezone returns a contact's timezone name: the one set on the contact,
+// else the one of its phone number's country, else DEFAULT_TIMEZONE
+func Timezone(contact models.Contact, cfg *config.Config) string {
+	return Location(contact, cfg).String()
+}
+
+// Location returns the location of a contact, see Timezone
+func Location(contact models.Contact, cfg *config.Config) *time.Location {
+	for _, name := range []string{contact.Timezone, phone.Timezone(contact.WaID), cfg.DefaultTimezone} {
+		if name == "" {
+			continue
+		}
+		if loc := load(name); loc != nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// ForContact returns the location of the contact with waID
+func ForContact(waID string, cfg *config.Config) *time.Location {
+	contact := models.Contact{WaID: waID}
+	database.GormDB.Where("wa_id = ?", waID).Limit(1).Find(&contact)
+	return Location(contact, cfg)
+}
+
+// ForContacts returns the locations of the contacts with waIDs, keyed by
+// wa_id, loading the contacts with one query
+func ForContacts(waIDs []string, cfg *config.Config) map[string]*time.Location {
+	var contacts []models.Contact
+	database.GormDB.Select("wa_id", "timezone").Where("wa_id IN ?", waIDs).Find(&contacts)
+	byID := make(map[string]models.Contact, len(contacts))
+	for _, c := range contacts {
+		byID[c.WaID] = c
+	}
+	out := make(map[string]*time.Location, len(waIDs))
+	for _, waID := range waIDs {
+		contact, ok := byID[waID]
+		if !ok {
+			contact = models.Contact{WaID: waID}
+		}
+		out[waID] = Location(contact, cfg)
+	}
+	return out
+}
@@ -0,0 +1,263 @@
+package media
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"whatsapp-gateway/internal/config"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/whatsapp"
+
+	"gorm.io/gorm"
+)
+
+// MetaMediaTTL is how long we trust a Meta media ID. Meta keeps uploads for
+// 30 days; refreshing a day early avoids racing the expiry.
+const MetaMediaTTL = 29 * 24 * time.Hour
+
+var ErrNotFound = errors.New("media not found in library")
+
+// Library keeps uploaded files in local storage, deduplicated by SHA-256,
+// and hands out fresh Meta media IDs for them.
+type Library struct {
+	Client      whatsapp.MediaManager
+	StoragePath string
+}
+
+func NewLibrary(client whatsapp.MediaManager, cfg *config.Config) *Library {
+	return &Library{Client: client, StoragePath: cfg.MediaStoragePath}
+}
+
+// Store saves a file in the library and uploads it to Meta. If the same
+// content was stored before, the existing entry is returned instead.
+func (l *Library) Store(ctx context.Context, data []byte, filename, mimeType string, opts StoreOptions) (*models.Media, bool, error) {
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	var existing models.Media
+	err := database.GormDB.Where("sha256 = ?", checksum).First(&existing).Error
+	if err == nil {
+		// Recover the local copy if it went missing
+		if _, statErr := os.Stat(existing.StoragePath); existing.StoragePath == "" || statErr != nil {
+			path, writeErr := l.writeFile(checksum, filename, mimeType, data)
+			if writeErr != nil {
+				return nil, false, writeErr
+			}
+			existing.StoragePath = path
+			database.GormDB.Model(&existing).Update("storage_path", path)
+		}
+		if l.IsExpired(&existing) {
+			if err := l.Refresh(ctx, &existing); err != nil {
+				return nil, false, err
+			}
+		}
+		return &existing, true, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, false, err
+	}
+
+	path, err := l.writeFile(checksum, filename, mimeType, data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp, err := l.Client.UploadMedia(ctx, data, mimeType, filename)
+	if err != nil {
+		os.Remove(path)
+		return nil, false, err
+	}
+
+	tags := opts.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	tagsJSON, _ := json.Marshal(tags)
+
+	now := time.Now()
+	m := models.Media{
+		MediaID:        resp.ID,
+		Filename:       filename,
+		MimeType:       mimeType,
+		FileSize:       int64(len(data)),
+		SHA256:         checksum,
+		StoragePath:    path,
+		Folder:         opts.Folder,
+		Tags:           string(tagsJSON),
+		MetaUploadedAt: &now,
+	}
+	if strings.HasPrefix(mimeType, "image/") {
+		if thumb, err := l.writeThumbnail(checksum, data); err == nil {
+			m.ThumbnailPath = thumb
+		} else {
+			log.Printf("[Media] Failed to generate thumbnail for %s: %v", filename, err)
+		}
+	}
+	if err := database.GormDB.Create(&m).Error; err != nil {
+		return nil, false, err
+	}
+
+	return &m, false, nil
+}
+
+// StoreOptions carries the organisation metadata for a new library entry
+type StoreOptions struct {
+	Folder string
+	Tags   []string
+}
+
+func (l *Library) writeFile(checksum, filename, mimeType string, data []byte) (string, error) {
+	ext := filepath.Ext(filename)
+	if ext == "" {
+		if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+
+	dir := filepath.Join(l.StoragePath, checksum[:2])
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create media storage: %w", err)
+	}
+
+	path := filepath.Join(dir, checksum+ext)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write media file: %w", err)
+	}
+	return path, nil
+}
+
+// Find looks up a library entry by Meta media ID or library ID
+func (l *Library) Find(ref string) (*models.Media, error) {
+	var m models.Media
+	if err := database.GormDB.Where("media_id = ?", ref).First(&m).Error; err == nil {
+		return &m, nil
+	}
+	if id, err := strconv.ParseUint(ref, 10, 64); err == nil {
+		if err := database.GormDB.First(&m, id).Error; err == nil {
+			return &m, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// IsExpired reports whether the entry's Meta media ID should be refreshed
+func (l *Library) IsExpired(m *models.Media) bool {
+	uploadedAt := m.UploadedAt
+	if m.MetaUploadedAt != nil {
+		uploadedAt = *m.MetaUploadedAt
+	}
+	return time.Since(uploadedAt) > MetaMediaTTL
+}
+
+// Refresh re-uploads the local copy to Meta and stores the new media ID
+func (l *Library) Refresh(ctx context.Context, m *models.Media) error {
+	if m.StoragePath == "" {
+		return fmt.Errorf("media %d has no local copy to re-upload", m.ID)
+	}
+	data, err := os.ReadFile(m.StoragePath)
+	if err != nil {
+		return fmt.Errorf("failed to read local copy of media %d: %w", m.ID, err)
+	}
+
+	resp, err := l.Client.UploadMedia(ctx, data, m.MimeType, m.Filename)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := database.GormDB.Model(m).Updates(map[string]interface{}{
+		"media_id":         resp.ID,
+		"meta_uploaded_at": now,
+	}).Error; err != nil {
+		return err
+	}
+	m.MediaID = resp.ID
+	m.MetaUploadedAt = &now
+
+	log.Printf("[Media] Refreshed media %d (%s), new Meta ID %s", m.ID, m.Filename, resp.ID)
+	return nil
+}
+
+// Resolve returns a usable Meta media ID for a library reference. Unknown
+// references are assumed to be raw Meta IDs and returned unchanged.
+func (l *Library) Resolve(ctx context.Context, ref string) (string, error) {
+	if ref == "" {
+		return "", nil
+	}
+	m, err := l.Find(ref)
+	if err != nil {
+		return ref, nil
+	}
+	if l.IsExpired(m) && m.StoragePath != "" {
+		if err := l.Refresh(ctx, m); err != nil {
+			return "", err
+		}
+	}
+	return m.MediaID, nil
+}
+
+// SendWithRetry resolves ref and calls send with the Meta media ID. If the
+// send fails with a media error, the file is re-uploaded and sent once more.
+func (l *Library) SendWithRetry(ctx context.Context, ref string, send func(mediaID string) error) error {
+	mediaID, err := l.Resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	err = send(mediaID)
+	if err == nil || !whatsapp.IsMediaError(err) {
+		return err
+	}
+
+	m, findErr := l.Find(ref)
+	if findErr != nil || m.StoragePath == "" {
+		return err
+	}
+	log.Printf("[Media] Send failed with media error for %s, re-uploading: %v", ref, err)
+	if refreshErr := l.Refresh(ctx, m); refreshErr != nil {
+		return fmt.Errorf("%v (re-upload failed: %v)", err, refreshErr)
+	}
+	return send(m.MediaID)
+}
+
+// ResolveTemplateParams replaces a library reference in a template's header media
+func (l *Library) ResolveTemplateParams(ctx context.Context, params *whatsapp.TemplateParams) error {
+	if params == nil || params.HeaderMedia == nil || params.HeaderMedia.ID == "" {
+		return nil
+	}
+	mediaID, err := l.Resolve(ctx, params.HeaderMedia.ID)
+	if err != nil {
+		return err
+	}
+	params.HeaderMedia.ID = mediaID
+	return nil
+}
+
+// Delete removes the entry from Meta, local storage and the database
+func (l *Library) Delete(ctx context.Context, m *models.Media) error {
+	if !l.IsExpired(m) {
+		if err := l.Client.DeleteMedia(ctx, m.MediaID); err != nil {
+			log.Printf("[Media] Failed to delete Meta media %s: %v", m.MediaID, err)
+		}
+	}
+	for _, path := range []string{m.StoragePath, m.ThumbnailPath} {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return database.GormDB.Delete(m).Error
+}
@@ -0,0 +1,65 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+)
+
+const ThumbnailSize = 256
+
+// Thumbnail returns the path of the entry's thumbnail, generating it from
+// the local copy if it doesn't exist yet. Only images have thumbnails.
+func (l *Library) Thumbnail(m *models.Media) (string, error) {
+	if m.ThumbnailPath != "" {
+		if _, err := os.Stat(m.ThumbnailPath); err == nil {
+			return m.ThumbnailPath, nil
+		}
+	}
+	if !strings.HasPrefix(m.MimeType, "image/") {
+		return "", fmt.Errorf("thumbnails are only available for images")
+	}
+	if m.StoragePath == "" {
+		return "", fmt.Errorf("media %d has no local copy", m.ID)
+	}
+
+	data, err := os.ReadFile(m.StoragePath)
+	if err != nil {
+		return "", err
+	}
+	path, err := l.writeThumbnail(m.SHA256, data)
+	if err != nil {
+		return "", err
+	}
+
+	database.GormDB.Model(m).Update("thumbnail_path", path)
+	m.ThumbnailPath = path
+	return path, nil
+}
+
+func (l *Library) writeThumbnail(checksum string, data []byte) (string, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("could not decode image: %w", err)
+	}
+
+	encoded, err := EncodeJPEG(Downscale(img, ThumbnailSize), 80)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(l.StoragePath, "thumbnails")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, checksum+".jpg")
+	if err := os.WriteFile(path, encoded, 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
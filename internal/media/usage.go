@@ -0,0 +1,54 @@
+package media
+
+import (
+	"fmt"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+)
+
+// Usage is a flow or template that references a library entry
+type Usage struct {
+	Type string `json:"type"` // flow, template
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Usage lists the flows and templates referencing m, either by library ID
+// or by its current Meta media ID
+func (l *Library) Usage(m *models.Media) ([]Usage, error) {
+	refs := []string{fmt.Sprintf("%d", m.ID), m.MediaID}
+	usage := []Usage{}
+
+	// Flow steps store the reference inside the node data JSON
+	var flows []struct {
+		ID   string
+		Name string
+	}
+	query := database.GormDB.Table("flow_nodes").
+		Select("DISTINCT flows.id, flows.name").
+		Joins("JOIN flows ON flows.id = flow_nodes.flow_id")
+	for i, ref := range refs {
+		pattern := fmt.Sprintf(`%%"mediaId":"%s"%%`, ref)
+		if i == 0 {
+			query = query.Where("flow_nodes.data LIKE ?", pattern)
+		} else {
+			query = query.Or("flow_nodes.data LIKE ?", pattern)
+		}
+	}
+	if err := query.Scan(&flows).Error; err != nil {
+		return nil, err
+	}
+	for _, f := range flows {
+		usage = append(usage, Usage{Type: "flow", ID: f.ID, Name: f.Name})
+	}
+
+	var templates []models.Template
+	if err := database.GormDB.Where("header_media_ref IN ?", refs).Find(&templates).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range templates {
+		usage = append(usage, Usage{Type: "template", ID: t.ID, Name: t.Name})
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,338 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	// Decoders for formats we accept as input and convert to JPEG
+	_ "image/gif"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+
+	"github.com/gabriel-vasile/mimetype"
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	MB = 1 << 20
+	KB = 1 << 10
+
+	// Images larger than this (on either side) are downscaled before upload
+	MaxImageDimension = 4096
+)
+
+// Media categories as understood by the Cloud API
+const (
+	CategoryImage    = "image"
+	CategoryVideo    = "video"
+	CategoryAudio    = "audio"
+	CategoryDocument = "document"
+	CategorySticker  = "sticker"
+)
+
+// Limit describes what WhatsApp accepts for a media category
+type Limit struct {
+	MaxSize   int64
+	MimeTypes []string
+}
+
+var Limits = map[string]Limit{
+	CategoryImage:   {MaxSize: 5 * MB, MimeTypes: []string{"image/jpeg", "image/png"}},
+	CategoryVideo:   {MaxSize: 16 * MB, MimeTypes: []string{"video/mp4", "video/3gpp"}},
+	CategoryAudio:   {MaxSize: 16 * MB, MimeTypes: []string{"audio/aac", "audio/amr", "audio/mpeg", "audio/mp4", "audio/ogg"}},
+	CategorySticker: {MaxSize: 500 * KB, MimeTypes: []string{"image/webp"}},
+	CategoryDocument: {MaxSize: 100 * MB, MimeTypes: []string{
+		"text/plain",
+		"application/pdf",
+		"application/msword",
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"application/vnd.ms-excel",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"application/vnd.ms-powerpoint",
+		"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	}},
+}
+
+// Image formats we can decode in pure Go and re-encode as JPEG
+var convertibleImages = map[string]bool{
+	"image/gif":  true,
+	"image/bmp":  true,
+	"image/tiff": true,
+	"image/webp": true,
+	"image/png":  true,
+	"image/jpeg": true,
+}
+
+// Sniffed types that differ only in name from what WhatsApp expects
+var mimeAliases = map[string]string{
+	"audio/x-m4a":       "audio/mp4",
+	"audio/x-mpeg":      "audio/mpeg",
+	"audio/mp3":         "audio/mpeg",
+	"audio/x-aac":       "audio/aac",
+	"audio/amr-nb":      "audio/amr",
+	"video/3gp":         "video/3gpp",
+	"image/x-ms-bmp":    "image/bmp",
+	"image/jpg":         "image/jpeg",
+	"application/x-pdf": "application/pdf",
+}
+
+// ValidationError explains why a file cannot be sent through WhatsApp
+type ValidationError struct {
+	Code     string `json:"code"` // empty_file, unsupported_type, type_mismatch, file_too_large, conversion_failed
+	Message  string `json:"message"`
+	Category string `json:"category,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Limit    int64  `json:"limit,omitempty"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Prepared is a file that passed validation, possibly after conversion
+type Prepared struct {
+	Data        []byte
+	MimeType    string
+	Filename    string
+	Category    string
+	Conversions []string
+}
+
+// Prepare sniffs the real content type of a file, converts it where that can
+// be done safely in pure Go and checks it against WhatsApp's limits. The
+// category may be empty, in which case it is derived from the content.
+func Prepare(data []byte, filename, declaredMime, category string) (*Prepared, error) {
+	if len(data) == 0 {
+		return nil, &ValidationError{Code: "empty_file", Message: "file is empty"}
+	}
+
+	mimeType := sniff(data, filename, declaredMime)
+	if category == "" {
+		category = categoryFor(mimeType)
+	}
+
+	limit, ok := Limits[category]
+	if !ok {
+		return nil, &ValidationError{Code: "unsupported_type", Message: fmt.Sprintf("unknown media type '%s'", category), Category: category}
+	}
+
+	p := &Prepared{Data: data, MimeType: mimeType, Filename: filename, Category: category}
+
+	if category == CategoryImage && convertibleImages[mimeType] {
+		if err := p.fitImage(limit.MaxSize); err != nil {
+			return nil, err
+		}
+	}
+
+	if !contains(limit.MimeTypes, p.MimeType) {
+		code := "unsupported_type"
+		if categoryFor(p.MimeType) != category && categoryFor(p.MimeType) != "" {
+			code = "type_mismatch"
+		}
+		return nil, &ValidationError{
+			Code:     code,
+			Message:  fmt.Sprintf("%s is not a supported %s format (allowed: %s)", p.MimeType, category, strings.Join(limit.MimeTypes, ", ")),
+			Category: category,
+			MimeType: p.MimeType,
+		}
+	}
+
+	if category == CategorySticker && !isAnimatedWebP(p.Data) && int64(len(p.Data)) > 100*KB {
+		return nil, &ValidationError{Code: "file_too_large", Message: "static stickers must be 100 KB or smaller", Category: category, MimeType: p.MimeType, Size: int64(len(p.Data)), Limit: 100 * KB}
+	}
+
+	if int64(len(p.Data)) > limit.MaxSize {
+		return nil, &ValidationError{
+			Code:     "file_too_large",
+			Message:  fmt.Sprintf("%s files must be %s or smaller, got %s", category, formatSize(limit.MaxSize), formatSize(int64(len(p.Data)))),
+			Category: category,
+			MimeType: p.MimeType,
+			Size:     int64(len(p.Data)),
+			Limit:    limit.MaxSize,
+		}
+	}
+
+	return p, nil
+}
+
+// fitImage converts unsupported image formats to JPEG and shrinks images
+// that are too large in dimensions or bytes
+func (p *Prepared) fitImage(maxSize int64) error {
+	supported := p.MimeType == "image/jpeg" || p.MimeType == "image/png"
+	if supported && int64(len(p.Data)) <= maxSize {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(p.Data))
+		if err == nil && cfg.Width <= MaxImageDimension && cfg.Height <= MaxImageDimension {
+			return nil
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(p.Data))
+	if err != nil {
+		return &ValidationError{Code: "conversion_failed", Message: "could not decode image: " + err.Error(), Category: CategoryImage, MimeType: p.MimeType}
+	}
+
+	if b := img.Bounds(); b.Dx() > MaxImageDimension || b.Dy() > MaxImageDimension {
+		img = Downscale(img, MaxImageDimension)
+		p.Conversions = append(p.Conversions, fmt.Sprintf("downscaled from %dx%d", b.Dx(), b.Dy()))
+	}
+
+	// Re-encode as JPEG, lowering quality and then dimensions until it fits
+	quality := 90
+	for {
+		encoded, err := EncodeJPEG(img, quality)
+		if err != nil {
+			return &ValidationError{Code: "conversion_failed", Message: "could not encode image: " + err.Error(), Category: CategoryImage, MimeType: p.MimeType}
+		}
+		if int64(len(encoded)) <= maxSize {
+			if p.MimeType != "image/jpeg" {
+				p.Conversions = append(p.Conversions, fmt.Sprintf("converted %s to image/jpeg", p.MimeType))
+			}
+			if quality != 90 {
+				p.Conversions = append(p.Conversions, fmt.Sprintf("re-encoded at quality %d", quality))
+			}
+			p.Data = encoded
+			p.MimeType = "image/jpeg"
+			p.Filename = strings.TrimSuffix(p.Filename, filepath.Ext(p.Filename)) + ".jpg"
+			return nil
+		}
+
+		if quality > 60 {
+			quality -= 10
+			continue
+		}
+
+		b := img.Bounds()
+		if b.Dx() < 320 || b.Dy() < 320 {
+			return &ValidationError{Code: "file_too_large", Message: "image could not be reduced below the size limit", Category: CategoryImage, MimeType: p.MimeType, Size: int64(len(encoded)), Limit: maxSize}
+		}
+		longest := b.Dx()
+		if b.Dy() > longest {
+			longest = b.Dy()
+		}
+		img = Downscale(img, longest*3/4)
+		p.Conversions = append(p.Conversions, fmt.Sprintf("downscaled to %dx%d", img.Bounds().Dx(), img.Bounds().Dy()))
+	}
+}
+
+// Downscale resizes img so its longest side is at most maxDimension
+func Downscale(img image.Image, maxDimension int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxDimension && h <= maxDimension {
+		return img
+	}
+	if w >= h {
+		h = h * maxDimension / w
+		w = maxDimension
+	} else {
+		w = w * maxDimension / h
+		h = maxDimension
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// EncodeJPEG flattens transparency onto white and encodes img as JPEG
+func EncodeJPEG(img image.Image, quality int) ([]byte, error) {
+	b := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, b.Min, draw.Over)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sniff detects the content type from the bytes, falling back to the declared
+// type or file extension for containers that can't be told apart by content
+func sniff(data []byte, filename, declaredMime string) string {
+	detected := normalizeMime(mimetype.Detect(data).String())
+
+	switch detected {
+	case "application/octet-stream", "application/x-ole-storage", "application/zip", "text/plain":
+		for _, candidate := range []string{declaredMime, mime.TypeByExtension(filepath.Ext(filename))} {
+			candidate = normalizeMime(candidate)
+			if candidate != "" && candidate != detected && categoryFor(candidate) == CategoryDocument {
+				return candidate
+			}
+		}
+	}
+	return detected
+}
+
+func normalizeMime(mimeType string) string {
+	if mimeType == "" {
+		return ""
+	}
+	if parsed, _, err := mime.ParseMediaType(mimeType); err == nil {
+		mimeType = parsed
+	}
+	mimeType = strings.ToLower(mimeType)
+	if alias, ok := mimeAliases[mimeType]; ok {
+		return alias
+	}
+	return mimeType
+}
+
+// categoryFor maps a content type to the WhatsApp media category it belongs to
+func categoryFor(mimeType string) string {
+	for category, limit := range Limits {
+		if category == CategorySticker {
+			continue
+		}
+		if contains(limit.MimeTypes, mimeType) {
+			return category
+		}
+	}
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return CategoryImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return CategoryVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return CategoryAudio
+	}
+	return CategoryDocument
+}
+
+// isAnimatedWebP checks the VP8X header's animation flag
+func isAnimatedWebP(data []byte) bool {
+	return len(data) > 20 && string(data[12:16]) == "VP8X" && data[20]&0x02 != 0
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func formatSize(size int64) string {
+	if size >= MB {
+		return fmt.Sprintf("%.1f MB", float64(size)/MB)
+	}
+	return fmt.Sprintf("%d KB", size/KB)
+}
@@ -1,39 +1,141 @@
 package models
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // Message represents a WhatsApp message
 type Message struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	WaID      string    `gorm:"index;not null" json:"wa_id"`
-	Sender    string    `gorm:"not null" json:"sender"`
-	Content   string    `gorm:"type:text" json:"content"`
-	Type      string    `gorm:"type:varchar(50)" json:"type"`
-	Status    string    `gorm:"type:varchar(20)" json:"status"`
-	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+	ID             uint          `gorm:"primaryKey;index:idx_messages_created_id,priority:2" json:"id"`
+	ConversationID *uint         `gorm:"index;index:idx_messages_conversation_created,priority:1" json:"conversation_id"`
+	Conversation   *Conversation `gorm:"constraint:OnDelete:SET NULL;" json:"-"`
+	ContactWaID    string        `gorm:"index;index:idx_messages_contact_created,priority:1" json:"contact_wa_id"`
+	Contact        *Contact      `gorm:"foreignKey:ContactWaID;references:WaID;constraint:OnDelete:CASCADE;" json:"-"`
+	Direction      string        `gorm:"type:varchar(10);index" json:"direction"` // inbound or outbound
+	WamID          string        `gorm:"column:wamid;index" json:"wamid"`
+	Content        string        `gorm:"type:text" json:"content"`
+	Type           string        `gorm:"type:varchar(50)" json:"type"`
+	Status         string        `gorm:"type:varchar(20);index" json:"status"`
+	CreatedAt      time.Time     `gorm:"autoCreateTime;index:idx_messages_created_id,priority:1;index:idx_messages_conversation_created,priority:2;index:idx_messages_contact_created,priority:2" json:"created_at"`
+	UpdatedAt      time.Time     `gorm:"autoUpdateTime" json:"updated_at"`
+
+	// Deprecated: the pre-conversation fields, still returned for older
+	// dashboard clients. WaID was the wamid for inbound messages and
+	// "outgoing-<phone>" for outbound ones; Sender was always the contact.
+	WaID   string `gorm:"-" json:"wa_id"`
+	Sender string `gorm:"-" json:"sender"`
 }
 
 func (Message) TableName() string {
 	return "messages"
 }
 
+// FillLegacyFields sets WaID and Sender from the conversation columns
+func (m *Message) FillLegacyFields() {
+	m.Sender = m.ContactWaID
+	if m.Direction == "outbound" {
+		m.WaID = "outgoing-" + m.ContactWaID
+	} else {
+		m.WaID = m.WamID
+	}
+}
+
+func (m *Message) AfterFind(tx *gorm.DB) error {
+	m.FillLegacyFields()
+	return nil
+}
+
+func (m *Message) AfterCreate(tx *gorm.DB) error {
+	m.FillLegacyFields()
+	return nil
+}
+
+// Conversation is the thread between a contact and one of our business numbers
+type Conversation struct {
+	ID                 uint       `gorm:"primaryKey" json:"id"`
+	ContactWaID        string     `gorm:"not null;uniqueIndex:idx_conversation_contact_number" json:"contact_wa_id"`
+	Contact            *Contact   `gorm:"foreignKey:ContactWaID;references:WaID;constraint:OnDelete:CASCADE;" json:"contact,omitempty"`
+	PhoneNumberID      string     `gorm:"type:varchar(64);not null;uniqueIndex:idx_conversation_contact_number" json:"phone_number_id"`
+	LastMessageAt      *time.Time `gorm:"index" json:"last_message_at"`
+	LastMessagePreview string     `gorm:"type:varchar(255)" json:"last_message_preview"`
+	LastDirection      string     `gorm:"type:varchar(10)" json:"last_direction"`
+	UnreadCount        int        `gorm:"default:0" json:"unread_count"`
+	LastInboundAt      *time.Time `json:"last_inbound_at"`
+	CreatedAt          time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt          time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+
+	ServiceWindow WindowStatus `gorm:"-" json:"service_window"`
+}
+
+func (Conversation) TableName() string {
+	return "conversations"
+}
+
+func (c *Conversation) AfterFind(tx *gorm.DB) error {
+	c.ServiceWindow = NewWindowStatus(c.LastInboundAt, time.Now())
+	return nil
+}
+
+// ServiceWindow is how long after a customer's last message free-form
+// (non-template) messages may be sent to them
+const ServiceWindow = 24 * time.Hour
+
+// WindowStatus describes the customer service window of a contact or conversation
+type WindowStatus struct {
+	Open      bool       `json:"open"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// NewWindowStatus returns the window opened by a customer message at lastInboundAt
+func NewWindowStatus(lastInboundAt *time.Time, now time.Time) WindowStatus {
+	if lastInboundAt == nil {
+		return WindowStatus{}
+	}
+	expires := lastInboundAt.Add(ServiceWindow)
+	return WindowStatus{Open: now.Before(expires), ExpiresAt: &expires}
+}
+
 // Contact represents a WhatsApp contact
 type Contact struct {
-	WaID          string    `gorm:"primaryKey" json:"wa_id"` // WhatsApp ID (phone number)
-	Name          string    `gorm:"type:varchar(255)" json:"name"`
-	ProfilePicURL string    `gorm:"type:text" json:"profile_pic_url"`
-	Tags          string    `gorm:"type:text" json:"tags"` // Comma separated tags
-	CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt     time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+	WaID          string     `gorm:"primaryKey" json:"wa_id"` // WhatsApp ID (phone number)
+	Name          string     `gorm:"type:varchar(255)" json:"name"`
+	ProfilePicURL string     `gorm:"type:text" json:"profile_pic_url"`
+	Tags          string     `gorm:"type:text" json:"tags"`            // Comma separated tags
+	LastInboundAt *time.Time `gorm:"index" json:"last_inbound_at"`     // Last message received from the contact
+	Timezone      string     `gorm:"type:varchar(64)" json:"timezone"` // IANA name; empty infers it from the phone number
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+
+	ServiceWindow WindowStatus `gorm:"-" json:"service_window"`
 }
 
 func (Contact) TableName() string {
 	return "contacts"
 }
 
+// TagList returns the contact's tags, stored either as a JSON array or comma separated
+func (c Contact) TagList() []string {
+	var tags []string
+	if err := json.Unmarshal([]byte(c.Tags), &tags); err == nil {
+		return tags
+	}
+	for _, tag := range strings.Split(c.Tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func (c *Contact) AfterFind(tx *gorm.DB) error {
+	c.ServiceWindow = NewWindowStatus(c.LastInboundAt, time.Now())
+	return nil
+}
+
 // Template represents a WhatsApp message template
 type Template struct {
 	ID         string `gorm:"primaryKey" json:"id"`
@@ -42,6 +144,8 @@ type Template struct {
 	Category   string `gorm:"type:varchar(100)" json:"category"`
 	Status     string `gorm:"type:varchar(50)" json:"status"`
 	Components string `gorm:"type:text" json:"components"` // JSON components
+	// Default header media (library ID) used when a send doesn't supply one
+	HeaderMediaRef string `gorm:"type:varchar(255)" json:"header_media_ref"`
 }
 
 func (Template) TableName() string {
@@ -80,17 +184,32 @@ func (ChatbotFlow) TableName() string {
 	return "chatbot_flows"
 }
 
-// ScheduledMessage represents a message to be sent at a future time
+// ScheduledMessage represents a message, or a campaign, to be sent at a
+// future time and optionally repeated
 type ScheduledMessage struct {
 	ID             uint       `gorm:"primaryKey" json:"id"`
+	Kind           string     `gorm:"type:varchar(20);default:'message'" json:"kind"` // message or campaign
 	RecipientWaID  string     `gorm:"type:varchar(50)" json:"recipient_wa_id"`
 	MessageContent string     `gorm:"type:text" json:"message_content"`
 	TemplateID     string     `gorm:"type:varchar(255)" json:"template_id"`
-	ScheduledTime  time.Time  `gorm:"not null" json:"scheduled_time"`
-	Recurrence     string     `gorm:"type:varchar(50)" json:"recurrence"`
-	Status         string     `gorm:"type:varchar(20);default:'pending'" json:"status"`
+	Parameters     string     `gorm:"type:text" json:"parameters,omitempty"` // JSON template parameters
+	Campaign       string     `gorm:"type:text" json:"campaign,omitempty"`   // JSON campaign request, for kind campaign
+	ScheduledTime  time.Time  `gorm:"not null;index:idx_scheduled_due,priority:2" json:"scheduled_time"`
+	StartTime      time.Time  `json:"start_time"`                                        // First run, recurrences are counted from it
+	Timezone       string     `gorm:"type:varchar(64);default:'UTC'" json:"timezone"`    // Recurrences keep the wall clock time here
+	Recurrence     string     `gorm:"type:varchar(50)" json:"recurrence"`                // once, daily, weekly, monthly or a cron expression
+	MissedRuns     string     `gorm:"type:varchar(20);default:'run'" json:"missed_runs"` // run (once, late) or skip, after downtime
+	Status         string     `gorm:"type:varchar(20);default:'pending';index:idx_scheduled_due,priority:1" json:"status"`
 	SentAt         *time.Time `json:"sent_at"`
+	ClaimedAt      *time.Time `json:"-"`
+	RunCount       int        `json:"run_count"`
+	LastRunAt      *time.Time `json:"last_run_at"`
+	LastError      string     `gorm:"type:text" json:"last_error,omitempty"`
+	CampaignID     *uint      `json:"campaign_id,omitempty"` // Campaign created by the last run
 	CreatedAt      time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+
+	IgnoreQuietHours bool `json:"ignore_quiet_hours,omitempty"` // Send even in the recipient's quiet hours
 }
 
 func (ScheduledMessage) TableName() string {
@@ -129,14 +248,21 @@ func (AutomationLog) TableName() string {
 	return "automation_logs"
 }
 
-// Media represents an uploaded media bit
+// Media represents an uploaded media bit. The file itself is kept in local
+// storage so the Meta media ID (which expires) can be refreshed on demand.
 type Media struct {
-	ID         uint      `gorm:"primaryKey" json:"id"`
-	MediaID    string    `gorm:"type:varchar(255);not null;uniqueIndex" json:"media_id"`
-	Filename   string    `gorm:"type:varchar(255)" json:"filename"`
-	MimeType   string    `gorm:"type:varchar(100)" json:"mime_type"`
-	FileSize   int64     `json:"file_size"`
-	UploadedAt time.Time `gorm:"autoCreateTime" json:"uploaded_at"`
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	MediaID        string     `gorm:"type:varchar(255);not null;uniqueIndex" json:"media_id"` // Current Meta media ID
+	Filename       string     `gorm:"type:varchar(255)" json:"filename"`
+	MimeType       string     `gorm:"type:varchar(100)" json:"mime_type"`
+	FileSize       int64      `json:"file_size"`
+	SHA256         string     `gorm:"type:varchar(64);index" json:"sha256"`
+	StoragePath    string     `gorm:"type:text" json:"-"`
+	ThumbnailPath  string     `gorm:"type:text" json:"-"`
+	Folder         string     `gorm:"type:varchar(255);index;default:''" json:"folder"`
+	Tags           string     `gorm:"type:text;default:'[]'" json:"tags"` // JSON array
+	MetaUploadedAt *time.Time `json:"meta_uploaded_at"`
+	UploadedAt     time.Time  `gorm:"autoCreateTime" json:"uploaded_at"`
 }
 
 func (Media) TableName() string {
@@ -186,6 +312,417 @@ func (FlowEdge) TableName() string {
 	return "flow_edges"
 }
 
+// FlowSend records a WhatsApp Flow message sent to a contact. The flow token
+// ties data endpoint requests and the final nfm_reply back to this send.
+type FlowSend struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	FlowToken   string     `gorm:"type:varchar(255);uniqueIndex;not null" json:"flow_token"`
+	WaID        string     `gorm:"type:varchar(50);index" json:"wa_id"`
+	FlowID      string     `gorm:"type:varchar(255);index" json:"flow_id"`        // Meta flow ID
+	Handler     string     `gorm:"type:varchar(255)" json:"handler"`              // Data endpoint handler, if any
+	SessionID   *uint      `json:"session_id"`                                    // Chatbot session waiting for the reply
+	Status      string     `gorm:"type:varchar(20);default:'sent'" json:"status"` // sent, completed
+	SentAt      time.Time  `gorm:"autoCreateTime" json:"sent_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+func (FlowSend) TableName() string {
+	return "flow_sends"
+}
+
+// FlowSubmission is the form data a contact submitted in a WhatsApp Flow
+type FlowSubmission struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	FlowSendID *uint     `gorm:"index" json:"flow_send_id"`
+	FlowToken  string    `gorm:"type:varchar(255);index" json:"flow_token"`
+	FlowID     string    `gorm:"type:varchar(255);index" json:"flow_id"`
+	WaID       string    `gorm:"type:varchar(50);index" json:"wa_id"`
+	MessageID  string    `gorm:"type:varchar(255)" json:"message_id"` // wamid of the nfm_reply
+	Response   string    `gorm:"type:text" json:"response"`           // JSON form fields
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (FlowSubmission) TableName() string {
+	return "flow_submissions"
+}
+
+// BusinessProfile caches the WhatsApp business profile of a phone number
+type BusinessProfile struct {
+	PhoneNumberID     string    `gorm:"primaryKey;type:varchar(255)" json:"phone_number_id"`
+	About             string    `gorm:"type:varchar(255)" json:"about"`
+	Address           string    `gorm:"type:varchar(255)" json:"address"`
+	Description       string    `gorm:"type:text" json:"description"`
+	Email             string    `gorm:"type:varchar(255)" json:"email"`
+	Websites          []string  `gorm:"type:text;serializer:json" json:"websites"`
+	Vertical          string    `gorm:"type:varchar(50)" json:"vertical"`
+	ProfilePictureURL string    `gorm:"type:text" json:"profile_picture_url"`
+	SyncedAt          time.Time `json:"synced_at"`
+}
+
+func (BusinessProfile) TableName() string {
+	return "business_profiles"
+}
+
+// BusinessProfileChange records a change to one business profile field
+type BusinessProfileChange struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	PhoneNumberID string    `gorm:"type:varchar(255);index" json:"phone_number_id"`
+	Field         string    `gorm:"type:varchar(50)" json:"field"`
+	OldValue      string    `gorm:"type:text" json:"old_value"`
+	NewValue      string    `gorm:"type:text" json:"new_value"`
+	Source        string    `gorm:"type:varchar(20)" json:"source"` // gateway, meta (changed outside the gateway)
+	CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (BusinessProfileChange) TableName() string {
+	return "business_profile_changes"
+}
+
+// PhoneNumber caches the details of a phone number on the business account
+type PhoneNumber struct {
+	ID                     string    `gorm:"primaryKey;type:varchar(255)" json:"id"`
+	DisplayPhoneNumber     string    `gorm:"type:varchar(50)" json:"display_phone_number"`
+	VerifiedName           string    `gorm:"type:varchar(255)" json:"verified_name"`
+	QualityRating          string    `gorm:"type:varchar(20)" json:"quality_rating"`
+	CodeVerificationStatus string    `gorm:"type:varchar(50)" json:"code_verification_status"`
+	NameStatus             string    `gorm:"type:varchar(50)" json:"name_status"`
+	PlatformType           string    `gorm:"type:varchar(50)" json:"platform_type"`
+	MessagingLimitTier     string    `gorm:"type:varchar(50)" json:"messaging_limit_tier"`
+	ThroughputLevel        string    `gorm:"type:varchar(50)" json:"throughput_level"`
+	SyncedAt               time.Time `json:"synced_at"`
+}
+
+func (PhoneNumber) TableName() string {
+	return "phone_numbers"
+}
+
+// ChatLink is a named wa.me link (usually printed as a QR code) that opens a
+// chat with a prefilled keyword and routes the contact to a rule or flow
+type ChatLink struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Name          string    `gorm:"type:varchar(255);not null" json:"name"`
+	Keyword       string    `gorm:"type:varchar(100);uniqueIndex;not null" json:"keyword"`
+	PrefilledText string    `gorm:"type:text" json:"prefilled_text"`  // Must contain the keyword
+	RuleID        *uint     `json:"rule_id"`                          // Automation rule to run on scan
+	FlowID        string    `gorm:"type:varchar(255)" json:"flow_id"` // Or chatbot flow to start
+	CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (ChatLink) TableName() string {
+	return "chat_links"
+}
+
+// ChatLinkScan records a prefilled keyword arriving from a chat link
+type ChatLinkScan struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	LinkID     uint      `gorm:"index" json:"link_id"`
+	WaID       string    `gorm:"type:varchar(50);index" json:"wa_id"`
+	MessageID  string    `gorm:"type:varchar(255)" json:"message_id"`
+	NewContact bool      `json:"new_contact"`
+	Triggered  bool      `json:"triggered"` // The link's rule or flow ran
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (ChatLinkScan) TableName() string {
+	return "chat_link_scans"
+}
+
+// PricingEvent is the pricing Meta reported for an outbound message in its
+// status webhooks, with our estimated cost from the rate card
+type PricingEvent struct {
+	ID                    uint       `gorm:"primaryKey" json:"id"`
+	WamID                 string     `gorm:"column:wamid;type:varchar(255);uniqueIndex;not null" json:"wamid"`
+	MetaConversationID    string     `gorm:"type:varchar(255);index" json:"meta_conversation_id"`
+	Origin                string     `gorm:"type:varchar(50)" json:"origin"` // Who opened the conversation, e.g. marketing or service
+	ConversationExpiresAt *time.Time `json:"conversation_expires_at"`
+	Billable              bool       `json:"billable"`
+	PricingModel          string     `gorm:"type:varchar(20)" json:"pricing_model"` // CBP (per conversation) or PMP (per message)
+	Category              string     `gorm:"type:varchar(50);index" json:"category"`
+	Charged               bool       `json:"charged"` // This event carries the charge of its conversation
+	EstimatedCost         float64    `json:"estimated_cost"`
+	Currency              string     `gorm:"type:varchar(3)" json:"currency"`
+	Country               string     `gorm:"type:varchar(2);index" json:"country"`
+	PhoneNumberID         string     `gorm:"type:varchar(64);index" json:"phone_number_id"`
+	ContactWaID           string     `gorm:"type:varchar(50);index" json:"contact_wa_id"`
+	CampaignID            *uint      `gorm:"index" json:"campaign_id"` // Campaign that sent the message
+	CreatedAt             time.Time  `gorm:"autoCreateTime;index" json:"created_at"`
+}
+
+func (PricingEvent) TableName() string {
+	return "pricing_events"
+}
+
+// RateCard is what a billable conversation (or message) of a category costs
+// in a country. Country "*" applies to countries without their own rate.
+type RateCard struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Country   string    `gorm:"type:varchar(2);not null;uniqueIndex:idx_rate_country_category" json:"country"`
+	Category  string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_rate_country_category" json:"category"`
+	Rate      float64   `json:"rate"`
+	Currency  string    `gorm:"type:varchar(3);default:USD" json:"currency"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (RateCard) TableName() string {
+	return "rate_cards"
+}
+
+// Consent is one change of a contact's messaging consent, kept as audit history
+type Consent struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	ContactWaID string    `gorm:"type:varchar(50);index;not null" json:"contact_wa_id"`
+	Channel     string    `gorm:"type:varchar(20);default:whatsapp" json:"channel"`
+	Category    string    `gorm:"type:varchar(50)" json:"category"` // all or a template category such as marketing
+	Status      string    `gorm:"type:varchar(20)" json:"status"`   // opted_in or opted_out
+	Source      string    `gorm:"type:varchar(50)" json:"source"`   // keyword, api, import
+	Note        string    `gorm:"type:text" json:"note"`            // e.g. the keyword received
+	CreatedAt   time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+}
+
+func (Consent) TableName() string {
+	return "consents"
+}
+
+// Suppression blocks sends to a contact, either all messages or templates of
+// one category. It has no foreign key so it outlives a deleted contact.
+type Suppression struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	ContactWaID string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_suppression_contact_category" json:"contact_wa_id"`
+	Category    string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_suppression_contact_category" json:"category"`
+	Source      string    `gorm:"type:varchar(50)" json:"source"`
+	Reason      string    `gorm:"type:text" json:"reason"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (Suppression) TableName() string {
+	return "suppressions"
+}
+
+// Campaign is a template broadcast sent in the background to its recipients
+type Campaign struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	Name              string     `gorm:"type:varchar(255);not null" json:"name"`
+	TemplateName      string     `gorm:"type:varchar(255);not null" json:"template_name"`
+	Language          string     `gorm:"type:varchar(50)" json:"language"`
+	Parameters        string     `gorm:"type:text" json:"parameters"`          // JSON template parameters
+	Audience          string     `gorm:"type:text" json:"audience"`            // JSON audience, resolved when sending starts
+	Status            string     `gorm:"type:varchar(20);index" json:"status"` // running, paused, completed, cancelled, failed
+	MessagesPerSecond int        `json:"messages_per_second,omitempty"`        // Lower than the gateway's limit to send slower
+	PauseReason       string     `gorm:"type:text" json:"pause_reason,omitempty"`
+	PausedAt          *time.Time `json:"paused_at,omitempty"`
+	Total             int        `json:"total"`
+	Sent              int        `json:"sent"`
+	Failed            int        `json:"failed"`
+	Suppressed        int        `json:"suppressed"`
+	Cancelled         int        `json:"cancelled"`
+	TestPercent       int        `json:"test_percent,omitempty"`                          // A/B test: share of the audience per variant
+	WinnerMetric      string     `gorm:"type:varchar(20)" json:"winner_metric,omitempty"` // read or reply
+	DecideAfter       int        `json:"decide_after_minutes,omitempty"`                  // Minutes after the test sends to pick the winner
+	TestEndsAt        *time.Time `json:"test_ends_at,omitempty"`
+	WinnerVariantID   *uint      `json:"winner_variant_id,omitempty"`
+	LocalTime         string     `gorm:"type:varchar(5)" json:"local_time,omitempty"` // HH:MM in each recipient's timezone to send at
+	IgnoreQuietHours  bool       `json:"ignore_quiet_hours,omitempty"`
+	StartedAt         *time.Time `json:"started_at"`
+	ResolvedAt        *time.Time `json:"resolved_at"` // When the audience was turned into recipients
+	CompletedAt       *time.Time `json:"completed_at"`
+	CreatedAt         time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt         time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+
+	Variants []CampaignVariant `gorm:"constraint:OnDelete:CASCADE;" json:"variants,omitempty"` // A/B test variants
+}
+
+func (Campaign) TableName() string {
+	return "campaigns"
+}
+
+// CampaignVariant is one of the templates compared by an A/B tested campaign
+type CampaignVariant struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	CampaignID   uint      `gorm:"not null;index" json:"campaign_id"`
+	Label        string    `gorm:"type:varchar(10)" json:"label"` // A, B
+	TemplateName string    `gorm:"type:varchar(255);not null" json:"template_name"`
+	Language     string    `gorm:"type:varchar(50)" json:"language"`
+	Parameters   string    `gorm:"type:text" json:"parameters"` // JSON template parameters
+	Winner       bool      `json:"winner"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (CampaignVariant) TableName() string {
+	return "campaign_variants"
+}
+
+// CampaignRecipient tracks the send to one contact of a campaign and what
+// the contact did with it
+type CampaignRecipient struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	CampaignID  uint       `gorm:"not null;uniqueIndex:idx_campaign_recipient;index:idx_campaign_recipient_status,priority:1" json:"campaign_id"`
+	Campaign    *Campaign  `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	ContactWaID string     `gorm:"type:varchar(50);not null;uniqueIndex:idx_campaign_recipient;index:idx_campaign_recipient_contact,priority:1" json:"contact_wa_id"`
+	VariantID   *uint      `gorm:"index" json:"variant_id,omitempty"`                                             // A/B tested campaigns: the variant sent, nil until the winner is picked
+	Status      string     `gorm:"type:varchar(20);index:idx_campaign_recipient_status,priority:2" json:"status"` // pending, held, sending, sent, failed, suppressed, cancelled
+	WamID       string     `gorm:"column:wamid;type:varchar(255);index" json:"wamid"`
+	Error       string     `gorm:"type:text" json:"error,omitempty"`
+	ErrorCode   int        `json:"error_code,omitempty"` // Meta error code of a failed send
+	SentAt      *time.Time `gorm:"index:idx_campaign_recipient_contact,priority:2" json:"sent_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+	FailedAt    *time.Time `json:"failed_at,omitempty"`
+	RepliedAt   *time.Time `json:"replied_at,omitempty"`   // First reply within the attribution window
+	ClickedAt   *time.Time `json:"clicked_at,omitempty"`   // First quick reply button click
+	OptedOutAt  *time.Time `json:"opted_out_at,omitempty"` // Opted out within the attribution window
+	SendAfter   *time.Time `json:"send_after,omitempty"`   // Deferred to the recipient's local time or past quiet hours
+	UpdatedAt   time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (CampaignRecipient) TableName() string {
+	return "campaign_recipients"
+}
+
+// CampaignStatusEvent is a status webhook that arrived before the campaign
+// runner saved the message's wamid. It is applied once the wamid is saved.
+type CampaignStatusEvent struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	WamID      string    `gorm:"column:wamid;type:varchar(255);index" json:"wamid"`
+	Status     string    `gorm:"type:varchar(20)" json:"status"`
+	ErrorCode  int       `json:"error_code,omitempty"`
+	ErrorTitle string    `gorm:"type:text" json:"error_title,omitempty"`
+	At         time.Time `json:"at"`
+	CreatedAt  time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+}
+
+func (CampaignStatusEvent) TableName() string {
+	return "campaign_status_events"
+}
+
+// Sequence is a drip journey: timed steps sent to each enrolled contact
+type Sequence struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Name          string    `gorm:"type:varchar(255);not null" json:"name"`
+	Description   string    `gorm:"type:text" json:"description"`
+	Enabled       bool      `json:"enabled"`                                              // Disabled sequences take no new enrollments
+	TriggerTag    string    `gorm:"type:varchar(255);index" json:"trigger_tag,omitempty"` // Enroll contacts when they get this tag
+	ExitOnReply   bool      `json:"exit_on_reply"`
+	ExitOnOptOut  bool      `json:"exit_on_opt_out"`
+	ExitOnTags    string    `gorm:"type:text" json:"exit_on_tags"` // JSON array, exit when the contact gets one of them
+	AllowReenroll bool      `json:"allow_reenroll"`                // Enroll again after a finished enrollment
+	CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+
+	LocalTime        string `gorm:"type:varchar(5)" json:"local_time,omitempty"` // HH:MM in the contact's timezone to send delayed steps at
+	IgnoreQuietHours bool   `json:"ignore_quiet_hours,omitempty"`
+
+	Steps []SequenceStep `gorm:"constraint:OnDelete:CASCADE;" json:"steps"`
+}
+
+func (Sequence) TableName() string {
+	return "sequences"
+}
+
+// SequenceStep is one message of a sequence
+type SequenceStep struct {
+	ID            uint   `gorm:"primaryKey" json:"id"`
+	SequenceID    uint   `gorm:"not null;index" json:"sequence_id"`
+	Position      int    `json:"position"`
+	DelayMinutes  int    `json:"delay_minutes"`                // After enrollment
+	Type          string `gorm:"type:varchar(20)" json:"type"` // template, message, flow or chatbot
+	TemplateName  string `gorm:"type:varchar(255)" json:"template_name,omitempty"`
+	Language      string `gorm:"type:varchar(50)" json:"language,omitempty"`
+	Parameters    string `gorm:"type:text" json:"parameters,omitempty"` // JSON template parameters
+	Message       string `gorm:"type:text" json:"message,omitempty"`    // Free-form text, or the body of a flow message
+	FlowID        string `gorm:"type:varchar(255)" json:"flow_id,omitempty"`
+	FlowCTA       string `gorm:"type:varchar(255)" json:"flow_cta,omitempty"`
+	FlowScreen    string `gorm:"type:varchar(255)" json:"flow_screen,omitempty"`
+	FlowHandler   string `gorm:"type:varchar(255)" json:"flow_handler,omitempty"`
+	SkipIfReplied bool   `json:"skip_if_replied"` // Skip when the contact replied since enrolling
+}
+
+func (SequenceStep) TableName() string {
+	return "sequence_steps"
+}
+
+// SequenceEnrollment is one contact's way through a sequence
+type SequenceEnrollment struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	SequenceID  uint       `gorm:"not null;index:idx_enrollment_contact,priority:1" json:"sequence_id"`
+	Sequence    *Sequence  `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	ContactWaID string     `gorm:"type:varchar(50);not null;index:idx_enrollment_contact,priority:2" json:"contact_wa_id"`
+	Status      string     `gorm:"type:varchar(20);index:idx_enrollment_due,priority:1" json:"status"` // active, completed, exited, failed
+	Source      string     `gorm:"type:varchar(20)" json:"source"`                                     // api, automation or tag
+	NextStep    int        `json:"next_step"`                                                          // Position of the next step
+	NextRunAt   *time.Time `gorm:"index:idx_enrollment_due,priority:2" json:"next_run_at"`
+	ClaimedAt   *time.Time `json:"-"`
+	ExitReason  string     `gorm:"type:varchar(50)" json:"exit_reason,omitempty"` // replied, tag, opted_out, cancelled
+	LastError   string     `gorm:"type:text" json:"last_error,omitempty"`
+	RepliedAt   *time.Time `json:"replied_at,omitempty"` // First reply after enrolling
+	EnrolledAt  time.Time  `json:"enrolled_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	UpdatedAt   time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (SequenceEnrollment) TableName() string {
+	return "sequence_enrollments"
+}
+
+// SequenceDelivery records what happened to one step of an enrollment
+type SequenceDelivery struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	EnrollmentID uint      `gorm:"not null;index" json:"enrollment_id"`
+	SequenceID   uint      `gorm:"not null;index" json:"sequence_id"`
+	StepID       uint      `json:"step_id"`
+	Status       string    `gorm:"type:varchar(20)" json:"status"` // sent, skipped, failed
+	WamID        string    `gorm:"column:wamid;type:varchar(255)" json:"wamid,omitempty"`
+	Reason       string    `gorm:"type:text" json:"reason,omitempty"` // Why it was skipped or failed
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (SequenceDelivery) TableName() string {
+	return "sequence_deliveries"
+}
+
+// ContactImport is a CSV or XLSX upload of contacts, imported in the background
+type ContactImport struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	FileName       string     `gorm:"type:varchar(255)" json:"file_name"`
+	Format         string     `gorm:"type:varchar(10)" json:"format"`         // csv or xlsx
+	Mode           string     `gorm:"type:varchar(20)" json:"mode"`           // create, update, upsert or merge
+	DefaultCountry string     `gorm:"type:varchar(2)" json:"default_country"` // For numbers without a country code
+	Mapping        string     `gorm:"type:text" json:"mapping"`               // JSON field to column header
+	Tags           string     `gorm:"type:text" json:"tags"`                  // JSON array added to every imported contact
+	DryRun         bool       `json:"dry_run"`                                // Validate only
+	Status         string     `gorm:"type:varchar(20);index" json:"status"`   // running, completed, failed
+	Total          int        `json:"total"`
+	Processed      int        `json:"processed"`
+	Created        int        `json:"created"`
+	Updated        int        `json:"updated"`
+	Unchanged      int        `json:"unchanged"` // Existing contacts the file had nothing new for
+	Skipped        int        `json:"skipped"`
+	Invalid        int        `json:"invalid"`
+	Error          string     `gorm:"type:text" json:"error,omitempty"`
+	CompletedAt    *time.Time `json:"completed_at"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (ContactImport) TableName() string {
+	return "contact_imports"
+}
+
+// ContactImportRow is a row of an import that was skipped or invalid
+type ContactImportRow struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	ImportID uint   `gorm:"not null;index" json:"import_id"`
+	Line     int    `json:"line"` // Line in the file, the header is line 1
+	Phone    string `gorm:"type:varchar(100)" json:"phone"`
+	WaID     string `gorm:"type:varchar(50)" json:"wa_id,omitempty"`
+	Status   string `gorm:"type:varchar(20);index" json:"status"` // skipped or invalid
+	Reason   string `gorm:"type:text" json:"reason"`
+}
+
+func (ContactImportRow) TableName() string {
+	return "contact_import_rows"
+}
+
 // SystemSetting represents a key-value setting stored in the database
 type SystemSetting struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
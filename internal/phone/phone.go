@@ -0,0 +1,213 @@
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidNumber  = errors.New("invalid phone number")
+	ErrUnknownCountry = errors.New("unknown country")
+)
+
+// callingCodes maps international calling codes to ISO 3166 country codes.
+// Shared codes resolve to their largest country: "1" is US (Canada and the
+// Caribbean included) and "7" is Russia unless the number starts with 76/77.
+var callingCodes = map[string]string{
+	"1": "US", "7": "RU", "76": "KZ", "77": "KZ",
+	"20": "EG", "27": "ZA", "30": "GR", "31": "NL", "32": "BE", "33": "FR", "34": "ES",
+	"36": "HU", "39": "IT", "40": "RO", "41": "CH", "43": "AT", "44": "GB", "45": "DK",
+	"46": "SE", "47": "NO", "48": "PL", "49": "DE", "51": "PE", "52": "MX", "53": "CU",
+	"54": "AR", "55": "BR", "56": "CL", "57": "CO", "58": "VE", "60": "MY", "61": "AU",
+	"62": "ID", "63": "PH", "64": "NZ", "65": "SG", "66": "TH", "81": "JP", "82": "KR",
+	"84": "VN", "86": "CN", "90": "TR", "91": "IN", "92": "PK", "93": "AF", "94": "LK",
+	"95": "MM", "98": "IR",
+	"211": "SS", "212": "MA", "213": "DZ", "216": "TN", "218": "LY", "220": "GM", "221": "SN",
+	"223": "ML", "224": "GN", "225": "CI", "226": "BF", "227": "NE", "228": "TG", "229": "BJ",
+	"230": "MU", "231": "LR", "232": "SL", "233": "GH", "234": "NG", "235": "TD", "236": "CF",
+	"237": "CM", "238": "CV", "240": "GQ", "241": "GA", "242": "CG", "243": "CD", "244": "AO",
+	"249": "SD", "250": "RW", "251": "ET", "252": "SO", "253": "DJ", "254": "KE", "255": "TZ",
+	"256": "UG", "257": "BI", "258": "MZ", "260": "ZM", "261": "MG", "263": "ZW", "264": "NA",
+	"265": "MW", "266": "LS", "267": "BW", "268": "SZ",
+	"351": "PT", "352": "LU", "353": "IE", "354": "IS", "355": "AL", "356": "MT", "357": "CY",
+	"358": "FI", "359": "BG", "370": "LT", "371": "LV", "372": "EE", "373": "MD", "374": "AM",
+	"375": "BY", "376": "AD", "377": "MC", "380": "UA", "381": "RS", "382": "ME", "383": "XK",
+	"385": "HR", "386": "SI", "387": "BA", "389": "MK", "420": "CZ", "421": "SK", "423": "LI",
+	"501": "BZ", "502": "GT", "503": "SV", "504": "HN", "505": "NI", "506": "CR", "507": "PA",
+	"509": "HT", "591": "BO", "592": "GY", "593": "EC", "595": "PY", "597": "SR", "598": "UY",
+	"670": "TL", "673": "BN", "675": "PG", "679": "FJ",
+	"852": "HK", "853": "MO", "855": "KH", "856": "LA", "880": "BD", "886": "TW",
+	"960": "MV", "961": "LB", "962": "JO", "963": "SY", "964": "IQ", "965": "KW", "966": "SA",
+	"967": "YE", "968": "OM", "970": "PS", "971": "AE", "972": "IL", "973": "BH", "974": "QA",
+	"975": "BT", "976": "MN", "977": "NP", "992": "TJ", "993": "TM", "994": "AZ", "995": "GE",
+	"996": "KG", "998": "UZ",
+}
+
+// Country returns the ISO country code of a WhatsApp ID (an international
+// number without "+"), or "" if the calling code is unknown
+func Country(waID string) string {
+	for n := 3; n >= 1; n-- {
+		if len(waID) < n {
+			continue
+		}
+		if country, ok := callingCodes[waID[:n]]; ok {
+			return country
+		}
+	}
+	return ""
+}
+
+// timezones maps country codes to an IANA timezone. Countries spanning
+// several zones get the one most of their population lives in.
+var timezones = map[string]string{
+	"US": "America/New_York", "RU": "Europe/Moscow", "KZ": "Asia/Almaty",
+	"EG": "Africa/Cairo", "ZA": "Africa/Johannesburg", "GR": "Europe/Athens", "NL": "Europe/Amsterdam",
+	"BE": "Europe/Brussels", "FR": "Europe/Paris", "ES": "Europe/Madrid", "HU": "Europe/Budapest",
+	"IT": "Europe/Rome", "RO": "Europe/Bucharest", "CH": "Europe/Zurich", "AT": "Europe/Vienna",
+	"GB": "Europe/London", "DK": "Europe/Copenhagen", "SE": "Europe/Stockholm", "NO": "Europe/Oslo",
+	"PL": "Europe/Warsaw", "DE": "Europe/Berlin", "PE": "America/Lima", "MX": "America/Mexico_City",
+	"CU": "America/Havana", "AR": "America/Argentina/Buenos_Aires", "BR": "America/Sao_Paulo",
+	"CL": "America/Santiago", "CO": "America/Bogota", "VE": "America/Caracas", "MY": "Asia/Kuala_Lumpur",
+	"AU": "Australia/Sydney", "ID": "Asia/Jakarta", "PH": "Asia/Manila", "NZ": "Pacific/Auckland",
+	"SG": "Asia/Singapore", "TH": "Asia/Bangkok", "JP": "Asia/Tokyo", "KR": "Asia/Seoul",
+	"VN": "Asia/Ho_Chi_Minh", "CN": "Asia/Shanghai", "TR": "Europe/Istanbul", "IN": "Asia/Kolkata",
+	"PK": "Asia/Karachi", "AF": "Asia/Kabul", "LK": "Asia/Colombo", "MM": "Asia/Yangon", "IR": "Asia/Tehran",
+	"SS": "Africa/Juba", "MA": "Africa/Casablanca", "DZ": "Africa/Algiers", "TN": "Africa/Tunis",
+	"LY": "Africa/Tripoli", "GM": "Africa/Banjul", "SN": "Africa/Dakar", "ML": "Africa/Bamako",
+	"GN": "Africa/Conakry", "CI": "Africa/Abidjan", "BF": "Africa/Ouagadougou", "NE": "Africa/Niamey",
+	"TG": "Africa/Lome", "BJ": "Africa/Porto-Novo", "MU": "Indian/Mauritius", "LR": "Africa/Monrovia",
+	"SL": "Africa/Freetown", "GH": "Africa/Accra", "NG": "Africa/Lagos", "TD": "Africa/Ndjamena",
+	"CF": "Africa/Bangui", "CM": "Africa/Douala", "CV": "Atlantic/Cape_Verde", "GQ": "Africa/Malabo",
+	"GA": "Africa/Libreville", "CG": "Africa/Brazzaville", "CD": "Africa/Kinshasa", "AO": "Africa/Luanda",
+	"SD": "Africa/Khartoum", "RW": "Africa/Kigali", "ET": "Africa/Addis_Ababa", "SO": "Africa/Mogadishu",
+	"DJ": "Africa/Djibouti", "KE": "Africa/Nairobi", "TZ": "Africa/Dar_es_Salaam", "UG": "Africa/Kampala",
+	"BI": "Africa/Bujumbura", "MZ": "Africa/Maputo", "ZM": "Africa/Lusaka", "MG": "Indian/Antananarivo",
+	"ZW": "Africa/Harare", "NA": "Africa/Windhoek", "MW": "Africa/Blantyre", "LS": "Africa/Maseru",
+	"BW": "Africa/Gaborone", "SZ": "Africa/Mbabane",
+	"PT": "Europe/Lisbon", "LU": "Europe/Luxembourg", "IE": "Europe/Dublin", "IS": "Atlantic/Reykjavik",
+	"AL": "Europe/Tirane", "MT": "Europe/Malta", "CY": "Asia/Nicosia", "FI": "Europe/Helsinki",
+	"BG": "Europe/Sofia", "LT": "Europe/Vilnius", "LV": "Europe/Riga", "EE": "Europe/Tallinn",
+	"MD": "Europe/Chisinau", "AM": "Asia/Yerevan", "BY": "Europe/Minsk", "AD": "Europe/Andorra",
+	"MC": "Europe/Monaco", "UA": "Europe/Kyiv", "RS": "Europe/Belgrade", "ME": "Europe/Podgorica",
+	"XK": "Europe/Belgrade", "HR": "Europe/Zagreb", "SI": "Europe/Ljubljana", "BA": "Europe/Sarajevo",
+	"MK": "Europe/Skopje", "CZ": "Europe/Prague", "SK": "Europe/Bratislava", "LI": "Europe/Vaduz",
+	"BZ": "America/Belize", "GT": "America/Guatemala", "SV": "America/El_Salvador", "HN": "America/Tegucigalpa",
+	"NI": "America/Managua", "CR": "America/Costa_Rica", "PA": "America/Panama", "HT": "America/Port-au-Prince",
+	"BO": "America/La_Paz", "GY": "America/Guyana", "EC": "America/Guayaquil", "PY": "America/Asuncion",
+	"SR": "America/Paramaribo", "UY": "America/Montevideo",
+	"TL": "Asia/Dili", "BN": "Asia/Brunei", "PG": "Pacific/Port_Moresby", "FJ": "Pacific/Fiji",
+	"HK": "Asia/Hong_Kong", "MO": "Asia/Macau", "KH": "Asia/Phnom_Penh", "LA": "Asia/Vientiane",
+	"BD": "Asia/Dhaka", "TW": "Asia/Taipei",
+	"MV": "Indian/Maldives", "LB": "Asia/Beirut", "JO": "Asia/Amman", "SY": "Asia/Damascus",
+	"IQ": "Asia/Baghdad", "KW": "Asia/Kuwait", "SA": "Asia/Riyadh", "YE": "Asia/Aden", "OM": "Asia/Muscat",
+	"PS": "Asia/Gaza", "AE": "Asia/Dubai", "IL": "Asia/Jerusalem", "BH": "Asia/Bahrain", "QA": "Asia/Qatar",
+	"BT": "Asia/Thimphu", "MN": "Asia/Ulaanbaatar", "NP": "Asia/Kathmandu", "TJ": "Asia/Dushanbe",
+	"TM": "Asia/Ashgabat", "AZ": "Asia/Baku", "GE": "Asia/Tbilisi", "KG": "Asia/Bishkek", "UZ": "Asia/Tashkent",
+}
+
+// Timezone returns the IANA timezone of a WhatsApp ID's country, or "" if
+// the calling code is unknown
+func Timezone(waID string) string {
+	return timezones[Country(waID)]
+}
+
+// sharedCodes are countries that share a calling code with a larger one
+var sharedCodes = map[string]string{
+	"CA": "1", "PR": "1", "DO": "1", "JM": "1", "TT": "1", "BS": "1", "BB": "1", "KZ": "7",
+}
+
+// CallingCode returns the international calling code of an ISO country
+// code, or "" if it is unknown
+func CallingCode(country string) string {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	if code, ok := sharedCodes[country]; ok {
+		return code
+	}
+	for code, c := range callingCodes {
+		if c == country {
+			return code
+		}
+	}
+	return ""
+}
+
+// nationalLengths are the lengths of national (significant) numbers per
+// calling code, without the trunk prefix. They tell a national number from
+// one that already starts with the calling code.
+var nationalLengths = map[string][2]int{
+	"1": {10, 10}, "7": {10, 10}, "20": {9, 10}, "27": {9, 9}, "30": {10, 10}, "31": {9, 9},
+	"32": {8, 9}, "33": {9, 9}, "34": {9, 9}, "36": {8, 9}, "39": {6, 11}, "40": {9, 9},
+	"41": {9, 9}, "43": {7, 13}, "44": {9, 10}, "45": {8, 8}, "46": {7, 9}, "47": {8, 8},
+	"48": {9, 9}, "49": {6, 11}, "51": {8, 9}, "52": {10, 10}, "54": {10, 11}, "55": {10, 11},
+	"56": {9, 9}, "57": {10, 10}, "58": {10, 10}, "60": {9, 10}, "61": {9, 9}, "62": {9, 12},
+	"63": {8, 10}, "64": {8, 10}, "65": {8, 8}, "66": {8, 9}, "81": {9, 10}, "82": {8, 10},
+	"84": {9, 10}, "86": {10, 11}, "90": {10, 10}, "91": {10, 10}, "92": {9, 10}, "94": {9, 9},
+	"98": {10, 10}, "212": {9, 9}, "213": {9, 9}, "216": {8, 8}, "225": {10, 10}, "233": {9, 9},
+	"234": {8, 10}, "254": {9, 9}, "255": {9, 9}, "256": {9, 9}, "351": {9, 9}, "353": {7, 9},
+	"358": {6, 10}, "359": {8, 9}, "380": {9, 9}, "420": {9, 9}, "421": {9, 9}, "852": {8, 8},
+	"880": {10, 10}, "886": {8, 9}, "966": {9, 9}, "971": {8, 9}, "972": {8, 9},
+}
+
+// trunkPrefixes are the national dialling prefixes that are not part of the
+// international number, "0" unless listed. Italy and Côte d'Ivoire keep the
+// leading 0 in international numbers.
+var trunkPrefixes = map[string]string{
+	"IT": "", "CI": "", "RU": "8", "KZ": "8",
+}
+
+// validNational reports whether n digits is a national number length for
+// code. Codes without known lengths never match.
+func validNational(code string, n int) bool {
+	lengths, ok := nationalLengths[code]
+	return ok && n >= lengths[0] && n <= lengths[1]
+}
+
+// Normalize turns a phone number as people write it into a WhatsApp ID: the
+// E.164 number without "+". Numbers starting with + or 00 are international;
+// others are national numbers of defaultCountry, without their trunk prefix,
+// unless they only make sense as a number that starts with its calling code.
+func Normalize(raw, defaultCountry string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	international := strings.HasPrefix(raw, "+")
+	digits := strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9':
+			return r
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')' || r == '/' || r == '+' || r == '\u00a0':
+			return -1
+		}
+		return 'x'
+	}, raw)
+	if digits == "" || strings.Contains(digits, "x") || strings.Contains(strings.TrimPrefix(raw, "+"), "+") {
+		return "", ErrInvalidNumber
+	}
+	if !international && strings.HasPrefix(digits, "00") {
+		digits, international = digits[2:], true
+	}
+
+	if !international && defaultCountry != "" {
+		code := CallingCode(defaultCountry)
+		if code == "" {
+			return "", ErrUnknownCountry
+		}
+		trunk, ok := trunkPrefixes[strings.ToUpper(strings.TrimSpace(defaultCountry))]
+		if !ok {
+			trunk = "0"
+		}
+		national := digits
+		if trunk != "" {
+			national = strings.TrimPrefix(digits, trunk)
+		}
+		// E.g. 447700900123 for GB, but 55912345678 is a Brazilian national
+		// number with area code 55
+		withCode := strings.HasPrefix(digits, code) && validNational(code, len(digits)-len(code))
+		if !withCode || validNational(code, len(national)) {
+			digits = code + national
+		}
+	}
+
+	if strings.HasPrefix(digits, "0") || len(digits) < 8 || len(digits) > 15 {
+		return "", ErrInvalidNumber
+	}
+	return digits, nil
+}
@@ -0,0 +1,61 @@
+package phone
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		country string
+		want    string
+	}{
+		{"GB international", "+44 7700 900123", "GB", "447700900123"},
+		{"GB national", "07700900123", "GB", "447700900123"},
+		{"GB without plus", "447700900123", "GB", "447700900123"},
+		{"RU trunk prefix 8", "8 912 345 67 89", "RU", "79123456789"},
+		{"RU international", "79123456789", "RU", "79123456789"},
+		{"RU national without trunk", "9123456789", "RU", "79123456789"},
+		{"BR national starting with 55", "55912345678", "BR", "5555912345678"},
+		{"BR international", "5511987654321", "BR", "5511987654321"},
+		{"BR formatted", "(11) 98765-4321", "BR", "5511987654321"},
+		{"BR trunk prefix", "011 98765 4321", "BR", "5511987654321"},
+		{"00 prefix without country", "0049 170 1234567", "", "491701234567"},
+		{"IT landline keeps 0", "06 1234 5678", "IT", "390612345678"},
+		{"IT international landline", "+39 06 1234 5678", "IT", "390612345678"},
+		{"IT mobile", "3471234567", "IT", "393471234567"},
+		{"CI keeps 0", "0707123456", "CI", "2250707123456"},
+		{"US formatted", "(415) 555-0100", "US", "14155550100"},
+		{"US with country code", "1 415 555 0100", "US", "14155550100"},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.raw, tt.country)
+		if err != nil {
+			t.Errorf("%s: Normalize(%q, %q): %v", tt.name, tt.raw, tt.country, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: Normalize(%q, %q) = %q, want %q", tt.name, tt.raw, tt.country, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeInvalid(t *testing.T) {
+	tests := []struct {
+		raw     string
+		country string
+		want    error
+	}{
+		{"12345", "GB", ErrInvalidNumber},
+		{"", "GB", ErrInvalidNumber},
+		{"not a number", "GB", ErrInvalidNumber},
+		{"07700900123", "XX", ErrUnknownCountry},
+	}
+	for _, tt := range tests {
+		if _, err := Normalize(tt.raw, tt.country); !errors.Is(err, tt.want) {
+			t.Errorf("Normalize(%q, %q): got %v, want %v", tt.raw, tt.country, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/phone"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrUnknownDimension = errors.New("group_by must be one of category, day, campaign, phone_number, country")
+
+// Event is the pricing information of one status webhook
+type Event struct {
+	WamID                 string
+	RecipientWaID         string
+	PhoneNumberID         string
+	MetaConversationID    string
+	Origin                string
+	ConversationExpiresAt *time.Time
+	Billable              bool
+	PricingModel          string
+	Category              string
+}
+
+// Record stores the pricing of a message with its estimated cost. Meta sends
+// pricing with both the sent and delivered status, only the first is kept.
+// Under conversation-based pricing only the first billable message of a
+// conversation is charged.
+func Record(e Event) (*models.PricingEvent, error) {
+	if e.WamID == "" {
+		return nil, nil
+	}
+
+	var existing models.PricingEvent
+	if err := database.GormDB.Where("wamid = ?", e.WamID).Limit(1).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing.ID != 0 {
+		// The first event may have come before the campaign stored the wamid
+		if existing.CampaignID == nil {
+			if campaignID := campaignOf(e.WamID); campaignID != nil {
+				return nil, database.GormDB.Model(&existing).Update("campaign_id", *campaignID).Error
+			}
+		}
+		return nil, nil
+	}
+
+	event := models.PricingEvent{
+		WamID:                 e.WamID,
+		MetaConversationID:    e.MetaConversationID,
+		Origin:                e.Origin,
+		ConversationExpiresAt: e.ConversationExpiresAt,
+		Billable:              e.Billable,
+		PricingModel:          e.PricingModel,
+		Category:              e.Category,
+		PhoneNumberID:         e.PhoneNumberID,
+		ContactWaID:           e.RecipientWaID,
+	}
+
+	event.CampaignID = campaignOf(e.WamID)
+	var msg models.Message
+	if err := database.GormDB.Where("wamid = ?", e.WamID).Limit(1).Find(&msg).Error; err == nil && msg.ContactWaID != "" {
+		event.ContactWaID = msg.ContactWaID
+	}
+	event.Country = phone.Country(event.ContactWaID)
+
+	if e.Billable {
+		event.Charged = true
+		if e.PricingModel != "PMP" && e.MetaConversationID != "" {
+			var charged int64
+			database.GormDB.Model(&models.PricingEvent{}).
+				Where("meta_conversation_id = ? AND charged = ?", e.MetaConversationID, true).
+				Count(&charged)
+			event.Charged = charged == 0
+		}
+	}
+	// Uncharged events still take the currency so reports group them together
+	if rate := FindRate(event.Country, event.Category); rate != nil {
+		event.Currency = rate.Currency
+		if event.Charged {
+			event.EstimatedCost = rate.Rate
+		}
+	}
+
+	if err := database.GormDB.Clauses(clause.OnConflict{DoNothing: true}).Create(&event).Error; err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// campaignOf returns the campaign that sent the message with wamID. Campaigns
+// store the wamid on the recipient as soon as the send returns.
+func campaignOf(wamID string) *uint {
+	var recipient models.CampaignRecipient
+	if err := database.GormDB.Select("campaign_id").Where("wamid = ?", wamID).Limit(1).Find(&recipient).Error; err != nil || recipient.CampaignID == 0 {
+		return nil
+	}
+	return &recipient.CampaignID
+}
+
+// FindRate returns the rate for a category in a country, falling back to
+// the "*" rate of the category. It returns nil when neither is configured.
+func FindRate(country, category string) *models.RateCard {
+	var rates []models.RateCard
+	database.GormDB.Where("category = ? AND country IN ?", strings.ToLower(category), []string{strings.ToUpper(country), "*"}).Find(&rates)
+	var fallback *models.RateCard
+	for i := range rates {
+		if rates[i].Country != "*" {
+			return &rates[i]
+		}
+		fallback = &rates[i]
+	}
+	return fallback
+}
+
+// Row is one group of a cost report
+type Row struct {
+	Key              string  `gorm:"column:group_key" json:"key"`
+	Name             string  `gorm:"-" json:"name,omitempty"` // Campaign name, when grouped by campaign
+	Currency         string  `json:"currency"`
+	Conversations    int64   `json:"conversations"`
+	Messages         int64   `json:"messages"`
+	BillableMessages int64   `json:"billable_messages"`
+	EstimatedCost    float64 `json:"estimated_cost"`
+}
+
+// dimensions maps the group_by values to the column they group on
+var dimensions = map[string]string{
+	"category":     "category",
+	"campaign":     "COALESCE(CAST(campaign_id AS VARCHAR(20)), '')",
+	"phone_number": "phone_number_id",
+	"country":      "country",
+}
+
+// Report sums conversations and estimated cost per dimension value (and
+// currency) for events between since and until. Days are UTC.
+func Report(dimension string, since, until *time.Time) ([]Row, error) {
+	column, ok := dimensions[dimension]
+	if dimension == "day" {
+		column, ok = dayExpression(), true
+	}
+	if !ok {
+		return nil, ErrUnknownDimension
+	}
+
+	query := database.GormDB.Model(&models.PricingEvent{}).Select(fmt.Sprintf(`%s AS group_key, currency,
+		COUNT(DISTINCT NULLIF(meta_conversation_id, '')) AS conversations,
+		COUNT(*) AS messages,
+		SUM(CASE WHEN billable THEN 1 ELSE 0 END) AS billable_messages,
+		COALESCE(SUM(estimated_cost), 0) AS estimated_cost`, column))
+	query = between(query, since, until)
+
+	var rows []Row
+	if err := query.Group(column + ", currency").Order("group_key").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	if dimension == "campaign" {
+		nameCampaigns(rows)
+	}
+	return rows, nil
+}
+
+// nameCampaigns sets the campaign name of rows keyed by campaign ID
+func nameCampaigns(rows []Row) {
+	var campaigns []models.Campaign
+	database.GormDB.Select("id", "name").Find(&campaigns)
+	names := make(map[string]string, len(campaigns))
+	for _, c := range campaigns {
+		names[strconv.FormatUint(uint64(c.ID), 10)] = c.Name
+	}
+	for i := range rows {
+		rows[i].Name = names[rows[i].Key]
+	}
+}
+
+func dayExpression() string {
+	if database.IsPostgres() {
+		return "to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD')"
+	}
+	return "strftime('%Y-%m-%d', created_at)"
+}
+
+func between(query *gorm.DB, since, until *time.Time) *gorm.DB {
+	if since != nil {
+		query = query.Where("created_at >= ?", *since)
+	}
+	if until != nil {
+		query = query.Where("created_at < ?", *until)
+	}
+	return query
+}
@@ -0,0 +1,181 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRecurrence = errors.New("recurrence must be once, daily, weekly, monthly or a cron expression")
+
+// Recurrence computes the runs of a repeated schedule
+type Recurrence interface {
+	// Next returns the first run strictly after after
+	Next(after time.Time) time.Time
+}
+
+// ParseRecurrence parses once (or empty), daily, weekly, monthly or a five
+// field cron expression. start is the first run; fixed periods keep its wall
+// clock time in loc. It returns nil for one-off schedules.
+func ParseRecurrence(spec string, start time.Time, loc *time.Location) (Recurrence, error) {
+	switch strings.ToLower(strings.TrimSpace(spec)) {
+	case "", "once":
+		return nil, nil
+	case "daily":
+		return period{start: start.In(loc), days: 1}, nil
+	case "weekly":
+		return period{start: start.In(loc), days: 7}, nil
+	case "monthly":
+		return period{start: start.In(loc), months: 1}, nil
+	}
+	return parseCron(spec, loc)
+}
+
+// period repeats start every days or months. Months that are too short for
+// start's day of month run on their last day.
+type period struct {
+	start  time.Time
+	days   int
+	months int
+}
+
+func (p period) Next(after time.Time) time.Time {
+	if after.Before(p.start) {
+		return p.start
+	}
+	// Jump close to after, then step to the first run past it
+	n := 0
+	if p.days > 0 {
+		n = int(after.Sub(p.start).Hours()/24) / p.days
+	} else {
+		n = (after.Year()-p.start.Year())*12 + int(after.Month()) - int(p.start.Month())
+	}
+	for n = max(n-1, 0); ; n++ {
+		if run := p.nth(n); run.After(after) {
+			return run
+		}
+	}
+}
+
+func (p period) nth(n int) time.Time {
+	if p.days > 0 {
+		return p.start.AddDate(0, 0, n*p.days)
+	}
+	s := p.start
+	first := time.Date(s.Year(), s.Month()+time.Month(n*p.months), 1, s.Hour(), s.Minute(), s.Second(), 0, s.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(s.Day(), lastDay)-1)
+}
+
+// cron is a standard five field expression: minute hour day-of-month month
+// day-of-week. Fields take *, lists, ranges and steps (*/15, 1-5, 0,30).
+// When both day fields are restricted either may match, as in cron.
+type cron struct {
+	minute, hour, dom, month, dow map[int]bool
+	domAny, dowAny                bool
+	loc                           *time.Location
+}
+
+func parseCron(spec string, loc *time.Location) (*cron, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, ErrInvalidRecurrence
+	}
+	c := &cron{loc: loc, domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var err error
+	bounds := []struct {
+		set      *map[int]bool
+		min, max int
+	}{
+		{&c.minute, 0, 59}, {&c.hour, 0, 23}, {&c.dom, 1, 31}, {&c.month, 1, 12}, {&c.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		if *b.set, err = parseCronField(fields[i], b.min, b.max); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+		}
+	}
+	if c.dow[7] {
+		c.dow[0] = true // 7 is Sunday too
+	}
+	return c, nil
+}
+
+func parseCronField(field string, lo, hi int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("bad step in %q", part)
+			}
+			step, part = n, part[:i]
+		}
+		from, to := lo, hi
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("bad value %q", part)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("bad range %q", part)
+				}
+			} else if step > 1 {
+				to = hi // 5/15 means from 5 in steps of 15
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, lo, hi)
+		}
+		for v := from; v <= to; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// Next walks the wall clock in loc, so a time that happens twice when clocks
+// go back runs once, and one skipped when they go forward runs shifted by the
+// change.
+func (c *cron) Next(after time.Time) time.Time {
+	local := after.In(c.loc)
+	t := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), 0, 0, time.UTC).Add(time.Minute)
+	// Five years covers every valid expression, e.g. 29 February
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !c.month[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case !c.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+		case !c.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			if run := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, c.loc); run.After(after) {
+				return run
+			}
+			t = t.Add(time.Minute)
+		}
+	}
+	return time.Time{}
+}
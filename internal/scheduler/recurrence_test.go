@@ -0,0 +1,195 @@
+package scheduler
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("LoadLocation(%q): %v", name, err)
+	}
+	return loc
+}
+
+// at parses a wall clock time in loc
+func at(t *testing.T, loc *time.Location, value string) time.Time {
+	t.Helper()
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+	if err != nil {
+		t.Fatalf("ParseInLocation(%q): %v", value, err)
+	}
+	return parsed
+}
+
+func keys(set map[int]bool) []int {
+	out := make([]int, 0, len(set))
+	for k, ok := range set {
+		if ok {
+			out = append(out, k)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+func TestParseCronFields(t *testing.T) {
+	tests := []struct {
+		spec   string
+		minute []int
+		hour   []int
+		dow    []int
+	}{
+		{"*/15 9 * * *", []int{0, 15, 30, 45}, []int{9}, nil},
+		{"0,30 9-11 * * 1-5", []int{0, 30}, []int{9, 10, 11}, []int{1, 2, 3, 4, 5}},
+		{"5/20 1-10/3 * * 1,3,5", []int{5, 25, 45}, []int{1, 4, 7, 10}, []int{1, 3, 5}},
+		{"0 0 * * 7", []int{0}, []int{0}, []int{0, 7}},
+		{"0 0 * * 5-7", []int{0}, []int{0}, []int{0, 5, 6, 7}},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.spec, time.UTC)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := keys(c.minute); !reflect.DeepEqual(got, tt.minute) {
+			t.Errorf("parseCron(%q) minutes = %v, want %v", tt.spec, got, tt.minute)
+		}
+		if got := keys(c.hour); !reflect.DeepEqual(got, tt.hour) {
+			t.Errorf("parseCron(%q) hours = %v, want %v", tt.spec, got, tt.hour)
+		}
+		if tt.dow != nil {
+			if got := keys(c.dow); !reflect.DeepEqual(got, tt.dow) {
+				t.Errorf("parseCron(%q) weekdays = %v, want %v", tt.spec, got, tt.dow)
+			}
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-x * * * *",
+	} {
+		if _, err := parseCron(spec, time.UTC); !errors.Is(err, ErrInvalidRecurrence) {
+			t.Errorf("parseCron(%q): got %v, want ErrInvalidRecurrence", spec, err)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	london := mustLoad(t, "Europe/London")
+	tests := []struct {
+		name  string
+		spec  string
+		loc   *time.Location
+		after string
+		want  string // UTC
+	}{
+		{"step", "*/15 * * * *", time.UTC, "2026-10-16 10:07", "2026-10-16T10:15:00Z"},
+		{"exact match is not returned", "0 9 * * *", time.UTC, "2026-10-16 09:00", "2026-10-17T09:00:00Z"},
+		{"weekday range skips the weekend", "0 9 * * 1-5", time.UTC, "2026-10-16 10:00", "2026-10-19T09:00:00Z"},
+		{"list of hours", "0 8,20 * * *", time.UTC, "2026-10-16 08:00", "2026-10-16T20:00:00Z"},
+		{"7 is Sunday", "0 0 * * 7", time.UTC, "2026-10-16 10:00", "2026-10-18T00:00:00Z"},
+		{"day of month or weekday, weekday first", "0 0 13 * 5", time.UTC, "2026-10-14 00:00", "2026-10-16T00:00:00Z"},
+		{"day of month or weekday, day first", "0 0 13 * 5", time.UTC, "2026-11-07 00:00", "2026-11-13T00:00:00Z"},
+		{"day of month only", "0 0 13 * *", time.UTC, "2026-10-14 00:00", "2026-11-13T00:00:00Z"},
+		{"31st skips short months", "0 0 31 * *", time.UTC, "2026-04-01 00:00", "2026-05-31T00:00:00Z"},
+		{"29 February", "0 0 29 2 *", time.UTC, "2026-03-01 00:00", "2028-02-29T00:00:00Z"},
+		{"local wall clock", "0 9 * * *", london, "2026-07-01 10:00", "2026-07-02T08:00:00Z"},
+		{"after clocks go forward", "0 9 * * *", london, "2026-03-28 09:00", "2026-03-29T08:00:00Z"},
+		{"inside the skipped hour", "30 1 * * *", london, "2026-03-29 00:00", "2026-03-29T01:30:00Z"},
+		{"after clocks go back", "0 9 * * *", london, "2026-10-24 09:00", "2026-10-25T09:00:00Z"},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.spec, tt.loc)
+		if err != nil {
+			t.Fatalf("%s: parseCron(%q): %v", tt.name, tt.spec, err)
+		}
+		want, _ := time.Parse(time.RFC3339, tt.want)
+		if got := c.Next(at(t, tt.loc, tt.after)); !got.Equal(want) {
+			t.Errorf("%s: Next = %s, want %s", tt.name, got.UTC().Format(time.RFC3339), tt.want)
+		}
+	}
+}
+
+func TestCronNextRunsOnceWhenClocksGoBack(t *testing.T) {
+	london := mustLoad(t, "Europe/London")
+	c, err := parseCron("30 1 * * *", london)
+	if err != nil {
+		t.Fatalf("parseCron: %v", err)
+	}
+	var runs []time.Time
+	end := at(t, london, "2026-10-26 00:00")
+	for run := c.Next(at(t, london, "2026-10-24 12:00")); run.Before(end); run = c.Next(run) {
+		runs = append(runs, run)
+	}
+	if len(runs) != 1 || runs[0].In(london).Format("15:04") != "01:30" {
+		t.Fatalf("got runs %v on 25 October, want one at 01:30", runs)
+	}
+}
+
+func TestPeriodNext(t *testing.T) {
+	london := mustLoad(t, "Europe/London")
+	tests := []struct {
+		name   string
+		start  string
+		days   int
+		months int
+		after  string
+		want   string // Wall clock in London
+	}{
+		{"before start", "2026-03-27 09:00", 1, 0, "2026-03-01 00:00", "2026-03-27 09:00"},
+		{"daily", "2026-03-27 09:00", 1, 0, "2026-03-27 09:00", "2026-03-28 09:00"},
+		{"daily keeps the hour when clocks go forward", "2026-03-27 09:00", 1, 0, "2026-03-28 09:30", "2026-03-29 09:00"},
+		{"daily keeps the hour when clocks go back", "2026-10-20 09:00", 1, 0, "2026-10-25 08:30", "2026-10-25 09:00"},
+		{"weekly", "2026-10-16 18:00", 7, 0, "2026-10-20 00:00", "2026-10-23 18:00"},
+		{"monthly clamps to the last day", "2026-01-31 09:00", 0, 1, "2026-02-01 00:00", "2026-02-28 09:00"},
+		{"monthly returns to the 31st", "2026-01-31 09:00", 0, 1, "2026-02-28 09:00", "2026-03-31 09:00"},
+		{"monthly on 29 February", "2027-01-31 09:00", 0, 1, "2028-02-01 00:00", "2028-02-29 09:00"},
+		{"monthly across years", "2026-11-15 09:00", 0, 1, "2026-12-20 00:00", "2027-01-15 09:00"},
+	}
+	for _, tt := range tests {
+		p := period{start: at(t, london, tt.start), days: tt.days, months: tt.months}
+		want := at(t, london, tt.want)
+		if got := p.Next(at(t, london, tt.after)); !got.Equal(want) {
+			t.Errorf("%s: Next = %s, want %s", tt.name, got.In(london).Format("2006-01-02 15:04 MST"), tt.want)
+		}
+	}
+}
+
+func TestNextIsStrictlyAfter(t *testing.T) {
+	start := at(t, time.UTC, "2026-01-01 00:00")
+	for _, zone := range []string{"UTC", "Europe/London", "America/New_York", "Australia/Lord_Howe"} {
+		loc := mustLoad(t, zone)
+		for _, spec := range []string{"daily", "weekly", "monthly", "*/15 * * * *", "30 1,2 * * *", "0 0 31 * *", "0 9 13 * 5"} {
+			r, err := ParseRecurrence(spec, start, loc)
+			if err != nil {
+				t.Fatalf("ParseRecurrence(%q): %v", spec, err)
+			}
+			prev := start
+			for i := 0; i < 400; i++ {
+				next := r.Next(prev)
+				if !next.After(prev) {
+					t.Fatalf("%s in %s: Next(%s) = %s, not after it", spec, zone, prev, next)
+				}
+				prev = next
+			}
+		}
+	}
+}
@@ -0,0 +1,509 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"whatsapp-gateway/internal/campaign"
+	"whatsapp-gateway/internal/config"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/localtime"
+	"whatsapp-gateway/internal/media"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/whatsapp"
+	"whatsapp-gateway/internal/ws"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds of scheduled messages
+const (
+	KindMessage  = "message"
+	KindCampaign = "campaign"
+)
+
+// Scheduled message statuses. Recurring messages go back to pending after
+// each run until they are deleted.
+const (
+	StatusPending = "pending"
+	StatusSending = "sending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+	StatusMissed  = "missed" // Skipped after downtime, see MissedSkip
+)
+
+// What to do with runs that were missed while the gateway was down
+const (
+	MissedRun  = "run"  // Run once, late, then continue with the next run
+	MissedSkip = "skip" // Continue with the next run
+)
+
+const (
+	// claimBatch is how many due messages are claimed per query
+	claimBatch = 50
+	// staleAfter is when a claimed message is considered abandoned by an
+	// instance that stopped while sending it
+	staleAfter  = 10 * time.Minute
+	sendTimeout = time.Minute
+)
+
+// errInterrupted marks runs that were being sent when the gateway stopped.
+// Whether Meta accepted the message is unknown, so they are not retried.
+const errInterrupted = "interrupted while sending, not retried to avoid a duplicate"
+
+var (
+	ErrInvalidTimezone  = errors.New("unknown timezone")
+	ErrInvalidMissedRun = errors.New("missed_runs must be run or skip")
+	ErrInPast           = errors.New("scheduled_time is in the past")
+	ErrNoFutureRun      = errors.New("recurrence has no future run")
+)
+
+// Scheduler sends scheduled messages and starts scheduled campaigns when they
+// are due. Due rows are claimed before sending, so several gateway instances
+// can share a database without sending anything twice.
+type Scheduler struct {
+	Client    whatsapp.Messenger
+	Config    *config.Config
+	Media     *media.Library
+	Campaigns *campaign.Runner
+	Hub       *ws.Hub
+
+	jobs []Job
+}
+
+// Job is other timed work that runs on every tick, like sequence steps
+type Job interface {
+	Tick(now time.Time)
+}
+
+// Add runs job on every tick after the scheduled messages
+func (s *Scheduler) Add(job Job) {
+	s.jobs = append(s.jobs, job)
+}
+
+func NewScheduler(client whatsapp.Messenger, cfg *config.Config, library *media.Library, campaigns *campaign.Runner, hub *ws.Hub) *Scheduler {
+	return &Scheduler{Client: client, Config: cfg, Media: library, Campaigns: campaigns, Hub: hub}
+}
+
+// Start checks for due messages every SCHEDULER_INTERVAL_SECONDS in the background
+func (s *Scheduler) Start() {
+	interval := time.Duration(s.Config.SchedulerIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.Tick(time.Now())
+			<-ticker.C
+		}
+	}()
+	log.Printf("[Scheduler] Checking for scheduled messages every %s", interval)
+}
+
+// Tick runs every message that is due at now, then the jobs
+func (s *Scheduler) Tick(now time.Time) {
+	now = now.UTC()
+	s.releaseStale(now)
+	s.runDue(now)
+	for _, job := range s.jobs {
+		job.Tick(now)
+	}
+}
+
+func (s *Scheduler) runDue(now time.Time) {
+	for {
+		claimed, err := claimDue(now)
+		if err != nil {
+			log.Printf("[Scheduler] Could not claim due messages: %v", err)
+			return
+		}
+		if len(claimed) == 0 {
+			return
+		}
+		for i := range claimed {
+			s.run(&claimed[i], now)
+		}
+	}
+}
+
+// claimDue marks a batch of due messages as sending. On postgres the rows are
+// locked and rows locked by another instance are skipped; the conditional
+// update makes sure only one instance wins a row either way.
+func claimDue(now time.Time) ([]models.ScheduledMessage, error) {
+	var claimed []models.ScheduledMessage
+	claim := claimStamp(now)
+	err := database.GormDB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("status = ? AND scheduled_time <= ?", StatusPending, now).Order("scheduled_time").Limit(claimBatch)
+		if database.IsPostgres() {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+		var due []models.ScheduledMessage
+		if err := query.Find(&due).Error; err != nil {
+			return err
+		}
+		for _, msg := range due {
+			result := tx.Model(&msg).Where("status = ?", StatusPending).
+				Updates(map[string]interface{}{"status": StatusSending, "claimed_at": claim})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				msg.Status, msg.ClaimedAt = StatusSending, &claim
+				claimed = append(claimed, msg)
+			}
+		}
+		return nil
+	})
+	return claimed, err
+}
+
+// reclaim renews the claim on msg right before it is sent. Messages late in a
+// batch were claimed when the batch was; if another instance released one as
+// stale meanwhile, it is not sent.
+func reclaim(msg *models.ScheduledMessage) bool {
+	if msg.ClaimedAt == nil {
+		return false
+	}
+	claimed, claim := *msg.ClaimedAt, claimStamp(time.Now())
+	result := database.GormDB.Model(msg).Where("status = ? AND claimed_at = ?", StatusSending, claimed).
+		Update("claimed_at", claim)
+	if result.Error != nil {
+		log.Printf("[Scheduler] Could not renew the claim on scheduled message %d: %v", msg.ID, result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		log.Printf("[Scheduler] Scheduled message %d was released by another instance, not sending it", msg.ID)
+		return false
+	}
+	msg.ClaimedAt = &claim
+	return true
+}
+
+// claimStamp is the claimed_at value for a claim made at t. Postgres keeps
+// microseconds, so the stamp is truncated to compare equal when read back.
+func claimStamp(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+// releaseStale handles messages whose sending instance stopped mid-run. One-off
+// messages fail, recurring ones continue with their next run.
+func (s *Scheduler) releaseStale(now time.Time) {
+	var stale []models.ScheduledMessage
+	if err := database.GormDB.Where("status = ? AND claimed_at < ?", StatusSending, now.Add(-staleAfter)).Find(&stale).Error; err != nil {
+		log.Printf("[Scheduler] Could not load interrupted messages: %v", err)
+		return
+	}
+	for i := range stale {
+		msg := &stale[i]
+		log.Printf("[Scheduler] Scheduled message %d was interrupted while sending", msg.ID)
+		s.finish(msg, now, errors.New(errInterrupted), false)
+	}
+}
+
+// run sends one claimed message and schedules its next run
+func (s *Scheduler) run(msg *models.ScheduledMessage, now time.Time) {
+	late := now.Sub(msg.ScheduledTime) > s.grace()
+	if late && msg.MissedRuns == MissedSkip {
+		log.Printf("[Scheduler] Skipping scheduled message %d, it was due at %s", msg.ID, msg.ScheduledTime.Format(time.RFC3339))
+		s.finish(msg, now, nil, false)
+		return
+	}
+	if until := s.quietUntil(msg, now); until != nil {
+		s.postpone(msg, *until)
+		return
+	}
+	if late {
+		log.Printf("[Scheduler] Scheduled message %d is late, it was due at %s", msg.ID, msg.ScheduledTime.Format(time.RFC3339))
+	}
+
+	if !reclaim(msg) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	err := s.deliver(ctx, msg)
+	cancel()
+	if err != nil {
+		log.Printf("[Scheduler] Scheduled message %d failed: %v", msg.ID, err)
+	}
+	s.finish(msg, now, err, true)
+}
+
+// quietUntil returns the end of the recipient's quiet hours if a message is
+// due inside them
+func (s *Scheduler) quietUntil(msg *models.ScheduledMessage, now time.Time) *time.Time {
+	quiet := localtime.QuietHours(s.Config)
+	if msg.Kind != KindMessage || msg.IgnoreQuietHours || !quiet.Enabled() {
+		return nil
+	}
+	until := quiet.Defer(now, localtime.ForContact(msg.RecipientWaID, s.Config))
+	if !until.After(now) {
+		return nil
+	}
+	return &until
+}
+
+// postpone puts a claimed message back to pending at until, without counting
+// a run. Recurrences stay on their schedule.
+func (s *Scheduler) postpone(msg *models.ScheduledMessage, until time.Time) {
+	log.Printf("[Scheduler] Scheduled message %d is due in the recipient's quiet hours, deferred to %s", msg.ID, until.Format(time.RFC3339))
+	result := database.GormDB.Model(msg).Where("status = ?", StatusSending).
+		Updates(map[string]interface{}{"status": StatusPending, "scheduled_time": until, "claimed_at": nil})
+	if result.Error != nil {
+		log.Printf("[Scheduler] Could not update scheduled message %d: %v", msg.ID, result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		database.GormDB.First(msg, msg.ID)
+		s.notify(msg)
+	}
+}
+
+// finish stores the outcome of a run. ran is false for skipped and
+// interrupted runs. Recurring messages go back to pending at their next run
+// after now, so a gateway that was down runs missed occurrences at most once.
+func (s *Scheduler) finish(msg *models.ScheduledMessage, now time.Time, runErr error, ran bool) {
+	updates := map[string]interface{}{"claimed_at": nil}
+	lastError := ""
+	if runErr != nil {
+		lastError = runErr.Error()
+	}
+	if ran || runErr != nil {
+		updates["last_error"] = lastError
+	}
+	if ran {
+		msg.RunCount++
+		updates["run_count"], updates["last_run_at"] = msg.RunCount, now
+	}
+
+	next, err := NextRun(msg, now)
+	switch {
+	case err != nil:
+		updates["status"], updates["last_error"] = StatusFailed, err.Error()
+	case !next.IsZero():
+		updates["status"], updates["scheduled_time"] = StatusPending, next
+	case !ran && runErr == nil:
+		updates["status"] = StatusMissed
+	case runErr != nil:
+		updates["status"] = StatusFailed
+	default:
+		updates["status"], updates["sent_at"] = StatusSent, now
+	}
+
+	result := database.GormDB.Model(msg).Where("status = ?", StatusSending).Updates(updates)
+	if result.Error != nil {
+		log.Printf("[Scheduler] Could not update scheduled message %d: %v", msg.ID, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		return // Deleted meanwhile, or another instance already released it
+	}
+	database.GormDB.First(msg, msg.ID)
+	s.notify(msg)
+}
+
+// deliver sends the message, or creates and starts the campaign
+func (s *Scheduler) deliver(ctx context.Context, msg *models.ScheduledMessage) error {
+	if msg.Kind == KindCampaign {
+		return s.startCampaign(msg)
+	}
+	if msg.TemplateID != "" {
+		return s.sendTemplate(ctx, msg)
+	}
+	_, err := s.Client.SendMessage(ctx, msg.RecipientWaID, msg.MessageContent)
+	return err
+}
+
+func (s *Scheduler) startCampaign(msg *models.ScheduledMessage) error {
+	var req campaign.Request
+	if err := json.Unmarshal([]byte(msg.Campaign), &req); err != nil {
+		return fmt.Errorf("invalid campaign: %w", err)
+	}
+	if req.Name == "" {
+		req.Name = req.TemplateName
+	}
+	if msg.Recurrence != "" {
+		// Tell the runs of a recurring campaign apart
+		req.Name = fmt.Sprintf("%s (%s)", req.Name, time.Now().In(location(msg.Timezone)).Format("2006-01-02 15:04"))
+	}
+	c, err := campaign.Create(req)
+	if err != nil {
+		return err
+	}
+	msg.CampaignID = &c.ID
+	database.GormDB.Model(msg).Update("campaign_id", c.ID)
+	if s.Campaigns != nil {
+		s.Campaigns.Start(c.ID)
+	}
+	log.Printf("[Scheduler] Scheduled message %d started campaign %d", msg.ID, c.ID)
+	return nil
+}
+
+// sendTemplate sends the template with ID TemplateID
+func (s *Scheduler) sendTemplate(ctx context.Context, msg *models.ScheduledMessage) error {
+	var template models.Template
+	if err := database.GormDB.Where("id = ?", msg.TemplateID).First(&template).Error; err != nil {
+		return fmt.Errorf("template %s not found, sync templates first", msg.TemplateID)
+	}
+	_, err := SendTemplate(ctx, s.Client, s.Media, template, msg.Parameters, msg.RecipientWaID)
+	return err
+}
+
+// SendTemplate sends a synced template to waID, binding its JSON parameters
+// to the contact like a campaign does
+func SendTemplate(ctx context.Context, client whatsapp.Messenger, library *media.Library, template models.Template, parameters, waID string) (*whatsapp.MessageResponse, error) {
+	var params whatsapp.TemplateParams
+	if parameters != "" {
+		if err := json.Unmarshal([]byte(parameters), &params); err != nil {
+			return nil, fmt.Errorf("invalid parameters: %w", err)
+		}
+	}
+	params = params.WithDefaultHeaderMedia(template)
+
+	if library != nil {
+		if err := library.ResolveTemplateParams(ctx, &params); err != nil {
+			return nil, err
+		}
+	}
+	definition, err := whatsapp.ParseTemplateComponents(template.Components)
+	if err != nil {
+		return nil, err
+	}
+	var contact models.Contact
+	if err := database.GormDB.Where("wa_id = ?", waID).First(&contact).Error; err != nil {
+		contact = models.Contact{WaID: waID}
+	}
+	components, issues := whatsapp.BindTemplate(definition, params.WithContact(contact))
+	if err := whatsapp.IssuesError(issues); err != nil {
+		return nil, err
+	}
+	return client.SendTemplateWithComponents(ctx, waID, template.Name, template.Language, components)
+}
+
+func (s *Scheduler) grace() time.Duration {
+	return time.Duration(s.Config.SchedulerMissedGraceMinutes) * time.Minute
+}
+
+func (s *Scheduler) notify(msg *models.ScheduledMessage) {
+	if s.Hub != nil {
+		s.Hub.BroadcastEvent("scheduled_message_"+msg.Status, msg)
+	}
+}
+
+// Plan validates the timing of msg and sets its first run. first is when the
+// schedule starts; a recurring schedule that started in the past continues
+// with its next run after now.
+func Plan(msg *models.ScheduledMessage, first, now time.Time) error {
+	if msg.Timezone == "" {
+		msg.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(msg.Timezone); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidTimezone, msg.Timezone)
+	}
+	msg.MissedRuns = strings.ToLower(msg.MissedRuns)
+	if msg.MissedRuns == "" {
+		msg.MissedRuns = MissedRun
+	}
+	if msg.MissedRuns != MissedRun && msg.MissedRuns != MissedSkip {
+		return ErrInvalidMissedRun
+	}
+	msg.Recurrence = strings.TrimSpace(msg.Recurrence)
+	if strings.EqualFold(msg.Recurrence, "once") {
+		msg.Recurrence = ""
+	}
+
+	msg.StartTime = first.UTC()
+	rec, err := ParseRecurrence(msg.Recurrence, msg.StartTime, location(msg.Timezone))
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		if first.Before(now.Add(-time.Minute)) {
+			return ErrInPast
+		}
+		msg.ScheduledTime = msg.StartTime
+		return nil
+	}
+	from := msg.StartTime
+	if from.Before(now) {
+		from = now
+	}
+	next := rec.Next(from.Add(-time.Second))
+	if next.IsZero() {
+		return ErrNoFutureRun
+	}
+	msg.ScheduledTime = next.UTC()
+	return nil
+}
+
+// NextRun returns the run of a recurring message after now, or zero for a
+// one-off message
+func NextRun(msg *models.ScheduledMessage, now time.Time) (time.Time, error) {
+	rec, err := recurrence(msg)
+	if err != nil || rec == nil {
+		return time.Time{}, err
+	}
+	next := rec.Next(now)
+	if next.IsZero() {
+		return next, ErrNoFutureRun
+	}
+	return next.UTC(), nil
+}
+
+// Upcoming lists the next n runs of a pending message
+func Upcoming(msg *models.ScheduledMessage, n int) []time.Time {
+	if msg.Status != StatusPending {
+		return []time.Time{}
+	}
+	runs := []time.Time{msg.ScheduledTime}
+	rec, err := recurrence(msg)
+	if err != nil || rec == nil {
+		return runs
+	}
+	for len(runs) < n {
+		next := rec.Next(runs[len(runs)-1])
+		if next.IsZero() {
+			break
+		}
+		runs = append(runs, next.UTC())
+	}
+	return runs
+}
+
+func recurrence(msg *models.ScheduledMessage) (Recurrence, error) {
+	start := msg.StartTime
+	if start.IsZero() {
+		start = msg.ScheduledTime // Scheduled before start times were stored
+	}
+	return ParseRecurrence(msg.Recurrence, start, location(msg.Timezone))
+}
+
+// ParseTime reads an RFC 3339 time, or a wall clock time like
+// 2006-01-02T15:04 in loc
+func ParseTime(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("scheduled_time %q must be RFC 3339 or 2006-01-02T15:04", value)
+}
+
+// location loads a time zone, falling back to UTC
+func location(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
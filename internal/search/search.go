@@ -0,0 +1,277 @@
+package search
+
+import (
+	"errors"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var ErrEmptyQuery = errors.New("query is required")
+
+// Snippets mark matches with these control characters until they are
+// HTML-escaped, so message content can never inject markup
+const (
+	markStart = "\x01"
+	markStop  = "\x02"
+)
+
+var (
+	nonDigits    = regexp.MustCompile(`\D`)
+	phonePattern = regexp.MustCompile(`^[\d\s+().-]+$`)
+)
+
+// phoneDigits returns the digits of a query that looks like (part of) a
+// phone number, e.g. "+49 151 123"
+func phoneDigits(query string) string {
+	if !phonePattern.MatchString(query) {
+		return ""
+	}
+	digits := nonDigits.ReplaceAllString(query, "")
+	if len(digits) < 3 {
+		return ""
+	}
+	return digits
+}
+
+// Options controls a search
+type Options struct {
+	Query       string
+	Scope       string // all (default), messages or contacts
+	ContactWaID string // Only messages with this contact
+	Since       *time.Time
+	Until       *time.Time
+	Limit       int
+}
+
+// MessageHit is a matching message with a highlighted snippet
+type MessageHit struct {
+	models.Message
+	Snippet string  `json:"snippet"`
+	Rank    float64 `json:"rank"`
+}
+
+// ContactHit is a matching contact with a highlighted snippet
+type ContactHit struct {
+	models.Contact
+	Snippet string  `json:"snippet"`
+	Rank    float64 `json:"rank"`
+}
+
+// Result holds the hits for each scope
+type Result struct {
+	Query    string       `json:"query"`
+	Messages []MessageHit `json:"messages"`
+	Contacts []ContactHit `json:"contacts"`
+}
+
+// Search finds messages and contacts matching opts.Query. PostgreSQL uses
+// the full-text indexes; SQLite falls back to LIKE matching.
+func Search(opts Options) (*Result, error) {
+	opts.Query = strings.TrimSpace(opts.Query)
+	if opts.Query == "" {
+		return nil, ErrEmptyQuery
+	}
+	if opts.Limit <= 0 || opts.Limit > 100 {
+		opts.Limit = 20
+	}
+
+	result := &Result{Query: opts.Query, Messages: []MessageHit{}, Contacts: []ContactHit{}}
+	var err error
+	if opts.Scope != "contacts" {
+		if database.IsPostgres() {
+			result.Messages, err = searchMessagesPostgres(opts)
+		} else {
+			result.Messages, err = searchMessagesLike(opts)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := range result.Messages {
+			result.Messages[i].FillLegacyFields()
+			result.Messages[i].Snippet = renderSnippet(result.Messages[i].Snippet)
+		}
+	}
+	if opts.Scope != "messages" && opts.ContactWaID == "" {
+		if database.IsPostgres() {
+			result.Contacts, err = searchContactsPostgres(opts)
+		} else {
+			result.Contacts, err = searchContactsLike(opts)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := range result.Contacts {
+			result.Contacts[i].ServiceWindow = models.NewWindowStatus(result.Contacts[i].LastInboundAt, time.Now())
+			result.Contacts[i].Snippet = renderSnippet(result.Contacts[i].Snippet)
+		}
+	}
+	return result, nil
+}
+
+// headlineOptions configures ts_headline for short, chat-sized snippets
+const headlineOptions = "StartSel=" + markStart + ", StopSel=" + markStop + ", MaxWords=18, MinWords=6, MaxFragments=2, FragmentDelimiter=\" … \""
+
+func searchMessagesPostgres(opts Options) ([]MessageHit, error) {
+	lang := database.SearchLanguage
+	query := database.GormDB.Table("messages").
+		Select("messages.*, ts_rank(messages.search_vector, q) AS rank, ts_headline(?::regconfig, messages.content, q, ?) AS snippet", lang, headlineOptions).
+		Joins("CROSS JOIN websearch_to_tsquery(?::regconfig, ?) AS q", lang, opts.Query).
+		Where("messages.search_vector @@ q")
+	query = messageFilters(query, opts)
+
+	var hits []MessageHit
+	err := query.Order("rank DESC, messages.created_at DESC").Limit(opts.Limit).Scan(&hits).Error
+	return hits, err
+}
+
+func searchContactsPostgres(opts Options) ([]ContactHit, error) {
+	digits := phoneDigits(opts.Query)
+	snippet := "ts_headline('simple', coalesce(contacts.name, '') || ' ' || coalesce(contacts.tags, ''), q, ?) AS snippet"
+
+	query := database.GormDB.Table("contacts")
+	if database.TrigramAvailable && digits != "" {
+		query = query.Select("contacts.*, GREATEST(ts_rank(contacts.search_vector, q), similarity(contacts.wa_id, ?)) AS rank, "+snippet, digits, headlineOptions)
+	} else {
+		query = query.Select("contacts.*, ts_rank(contacts.search_vector, q) AS rank, "+snippet, headlineOptions)
+	}
+	query = query.
+		Joins("CROSS JOIN websearch_to_tsquery('simple', ?) AS q", opts.Query)
+	if digits != "" {
+		query = query.Where("contacts.search_vector @@ q OR contacts.wa_id LIKE ?", "%"+digits+"%")
+	} else {
+		query = query.Where("contacts.search_vector @@ q")
+	}
+
+	var hits []ContactHit
+	err := query.Order("rank DESC, contacts.updated_at DESC").Limit(opts.Limit).Scan(&hits).Error
+	return hits, err
+}
+
+func searchMessagesLike(opts Options) ([]MessageHit, error) {
+	terms := strings.Fields(strings.ToLower(opts.Query))
+	query := database.GormDB.Model(&models.Message{})
+	for _, term := range terms {
+		query = query.Where("LOWER(messages.content) LIKE ?", "%"+term+"%")
+	}
+	query = messageFilters(query, opts)
+
+	var messages []models.Message
+	if err := query.Order("messages.created_at DESC").Limit(opts.Limit).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	hits := make([]MessageHit, 0, len(messages))
+	for _, m := range messages {
+		hits = append(hits, MessageHit{Message: m, Snippet: highlight(m.Content, terms), Rank: 1})
+	}
+	return hits, nil
+}
+
+func searchContactsLike(opts Options) ([]ContactHit, error) {
+	terms := strings.Fields(strings.ToLower(opts.Query))
+	query := database.GormDB.Model(&models.Contact{})
+	if digits := phoneDigits(opts.Query); digits != "" {
+		query = query.Where("wa_id LIKE ?", "%"+digits+"%")
+		terms = []string{digits}
+	} else {
+		for _, term := range terms {
+			pattern := "%" + term + "%"
+			query = query.Where("LOWER(name) LIKE ? OR LOWER(tags) LIKE ?", pattern, pattern)
+		}
+	}
+
+	var contacts []models.Contact
+	if err := query.Order("updated_at DESC").Limit(opts.Limit).Find(&contacts).Error; err != nil {
+		return nil, err
+	}
+	hits := make([]ContactHit, 0, len(contacts))
+	for _, contact := range contacts {
+		text := contact.Name + " " + contact.Tags
+		if contact.Name != contact.WaID {
+			text += " " + contact.WaID
+		}
+		hits = append(hits, ContactHit{Contact: contact, Snippet: highlight(text, terms), Rank: 1})
+	}
+	return hits, nil
+}
+
+func messageFilters(query *gorm.DB, opts Options) *gorm.DB {
+	if opts.ContactWaID != "" {
+		query = query.Where("messages.contact_wa_id = ?", opts.ContactWaID)
+	}
+	if opts.Since != nil {
+		query = query.Where("messages.created_at >= ?", *opts.Since)
+	}
+	if opts.Until != nil {
+		query = query.Where("messages.created_at < ?", *opts.Until)
+	}
+	return query
+}
+
+// highlight marks terms in text and trims it around the first match
+func highlight(text string, terms []string) string {
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		// Lowercasing changed byte offsets, skip the marks
+		lower = text
+	}
+	first := -1
+	for _, term := range terms {
+		if i := strings.Index(lower, term); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+
+	start, end := 0, len(text)
+	if first > 60 {
+		start = first - 60
+	}
+	if end-start > 200 {
+		end = start + 200
+	}
+	// Don't cut through a multi-byte character
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("… ")
+	}
+	fragment, lowerFragment := text[start:end], lower[start:end]
+	for i := 0; i < len(fragment); {
+		matched := ""
+		for _, term := range terms {
+			if term != "" && strings.HasPrefix(lowerFragment[i:], term) && len(term) > len(matched) {
+				matched = term
+			}
+		}
+		if matched == "" {
+			b.WriteByte(fragment[i])
+			i++
+			continue
+		}
+		b.WriteString(markStart + fragment[i:i+len(matched)] + markStop)
+		i += len(matched)
+	}
+	if end < len(text) {
+		b.WriteString(" …")
+	}
+	return b.String()
+}
+
+// renderSnippet escapes a snippet and turns the match markers into <mark> tags
+func renderSnippet(raw string) string {
+	escaped := html.EscapeString(raw)
+	escaped = strings.ReplaceAll(escaped, markStart, "<mark>")
+	return strings.ReplaceAll(escaped, markStop, "</mark>")
+}
@@ -0,0 +1,117 @@
+package sequence
+
+import (
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Report is how the enrollments of a sequence are doing
+type Report struct {
+	SequenceID  uint             `json:"sequence_id"`
+	Name        string           `json:"name"`
+	Enrolled    int64            `json:"enrolled"`
+	Active      int64            `json:"active"`
+	Completed   int64            `json:"completed"`
+	Exited      int64            `json:"exited"`
+	Replied     int64            `json:"replied"` // Enrollments the contact replied to
+	ExitReasons map[string]int64 `json:"exit_reasons"`
+	Steps       []StepReport     `json:"steps"`
+}
+
+// StepReport counts the outcomes of one step
+type StepReport struct {
+	StepID   uint   `json:"step_id"`
+	Position int    `json:"position"`
+	Type     string `json:"type"`
+	Waiting  int64  `json:"waiting"` // Active enrollments whose next step this is
+	Sent     int64  `json:"sent"`
+	Skipped  int64  `json:"skipped"`
+	Failed   int64  `json:"failed"`
+}
+
+// GetReport counts a sequence's enrollments and step deliveries
+func GetReport(seq *models.Sequence) (*Report, error) {
+	report := &Report{SequenceID: seq.ID, Name: seq.Name, ExitReasons: map[string]int64{}, Steps: []StepReport{}}
+	enrollments := func() *gorm.DB {
+		return database.GormDB.Model(&models.SequenceEnrollment{}).Where("sequence_id = ?", seq.ID)
+	}
+
+	var byStatus []struct {
+		Status string
+		Count  int64
+	}
+	if err := enrollments().Select("status, COUNT(*) AS count").Group("status").Scan(&byStatus).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range byStatus {
+		report.Enrolled += row.Count
+		switch row.Status {
+		case StatusActive:
+			report.Active = row.Count
+		case StatusCompleted:
+			report.Completed = row.Count
+		case StatusExited:
+			report.Exited = row.Count
+		}
+	}
+
+	var byReason []struct {
+		ExitReason string
+		Count      int64
+	}
+	err := enrollments().Select("exit_reason, COUNT(*) AS count").Where("status = ?", StatusExited).Group("exit_reason").Scan(&byReason).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range byReason {
+		report.ExitReasons[row.ExitReason] = row.Count
+	}
+	if err := enrollments().Where("replied_at IS NOT NULL").Count(&report.Replied).Error; err != nil {
+		return nil, err
+	}
+
+	var deliveries []struct {
+		StepID uint
+		Status string
+		Count  int64
+	}
+	err = database.GormDB.Model(&models.SequenceDelivery{}).Select("step_id, status, COUNT(*) AS count").
+		Where("sequence_id = ?", seq.ID).Group("step_id, status").Scan(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	var waiting []struct {
+		NextStep int
+		Count    int64
+	}
+	err = enrollments().Select("next_step, COUNT(*) AS count").Where("status = ?", StatusActive).Group("next_step").Scan(&waiting).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, step := range seq.Steps {
+		sr := StepReport{StepID: step.ID, Position: step.Position, Type: step.Type}
+		for _, row := range deliveries {
+			if row.StepID != step.ID {
+				continue
+			}
+			switch row.Status {
+			case DeliverySent:
+				sr.Sent = row.Count
+			case DeliverySkipped:
+				sr.Skipped = row.Count
+			case DeliveryFailed:
+				sr.Failed = row.Count
+			}
+		}
+		for _, row := range waiting {
+			if row.NextStep == step.Position {
+				sr.Waiting = row.Count
+			}
+		}
+		report.Steps = append(report.Steps, sr)
+	}
+	return report, nil
+}
@@ -0,0 +1,376 @@
+package sequence
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+	"whatsapp-gateway/internal/config"
+	"whatsapp-gateway/internal/consent"
+	"whatsapp-gateway/internal/conversation"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/flowdata"
+	"whatsapp-gateway/internal/localtime"
+	"whatsapp-gateway/internal/media"
+	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/scheduler"
+	"whatsapp-gateway/internal/whatsapp"
+	"whatsapp-gateway/internal/ws"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	claimBatch  = 50
+	staleAfter  = 10 * time.Minute
+	sendTimeout = time.Minute
+)
+
+// errInterrupted marks steps that were being sent when the gateway stopped.
+// Whether Meta accepted the message is unknown, so they are not retried.
+const errInterrupted = "interrupted while sending, not retried to avoid a duplicate"
+
+// FlowStarter starts chatbot flows, see automation.Engine
+type FlowStarter interface {
+	StartFlow(ctx context.Context, waID string, flowID string) error
+}
+
+// Runner sends the due steps of active enrollments. It runs as a scheduler
+// job; each enrollment is claimed before its step is sent, so several gateway
+// instances never send a step twice.
+type Runner struct {
+	Client whatsapp.Messenger
+	Config *config.Config
+	Media  *media.Library
+	Flows  FlowStarter
+	Hub    *ws.Hub
+}
+
+func NewRunner(client whatsapp.Messenger, cfg *config.Config, library *media.Library, flows FlowStarter, hub *ws.Hub) *Runner {
+	return &Runner{Client: client, Config: cfg, Media: library, Flows: flows, Hub: hub}
+}
+
+// Tick sends every step that is due at now
+func (r *Runner) Tick(now time.Time) {
+	r.releaseStale(now)
+	for {
+		claimed, err := claimDue(now)
+		if err != nil {
+			log.Printf("[Sequence] Could not claim due enrollments: %v", err)
+			return
+		}
+		if len(claimed) == 0 {
+			return
+		}
+		for i := range claimed {
+			r.step(&claimed[i], now)
+		}
+	}
+}
+
+// claimDue marks a batch of due enrollments as being worked on, like the
+// scheduler claims its messages
+func claimDue(now time.Time) ([]models.SequenceEnrollment, error) {
+	var claimed []models.SequenceEnrollment
+	claim := now.UTC().Truncate(time.Microsecond)
+	err := database.GormDB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("status = ? AND next_run_at <= ? AND claimed_at IS NULL", StatusActive, now).Order("next_run_at").Limit(claimBatch)
+		if database.IsPostgres() {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+		var due []models.SequenceEnrollment
+		if err := query.Find(&due).Error; err != nil {
+			return err
+		}
+		for _, e := range due {
+			result := tx.Model(&e).Where("claimed_at IS NULL").Update("claimed_at", claim)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				e.ClaimedAt = &claim
+				claimed = append(claimed, e)
+			}
+		}
+		return nil
+	})
+	return claimed, err
+}
+
+// reclaim renews the claim on e right before its step is sent, like the
+// scheduler does. Enrollments late in a batch may have been released as stale
+// by another instance meanwhile; those are not sent.
+func reclaim(e *models.SequenceEnrollment) bool {
+	if e.ClaimedAt == nil {
+		return false
+	}
+	claimed, claim := *e.ClaimedAt, time.Now().UTC().Truncate(time.Microsecond)
+	result := database.GormDB.Model(e).Where("status = ? AND claimed_at = ?", StatusActive, claimed).
+		Update("claimed_at", claim)
+	if result.Error != nil {
+		log.Printf("[Sequence] Could not renew the claim on enrollment %d: %v", e.ID, result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		log.Printf("[Sequence] Enrollment %d was released by another instance, not sending its step", e.ID)
+		return false
+	}
+	e.ClaimedAt = &claim
+	return true
+}
+
+// releaseStale moves enrollments whose instance stopped mid-step on to their
+// next step
+func (r *Runner) releaseStale(now time.Time) {
+	var stale []models.SequenceEnrollment
+	err := database.GormDB.Where("status = ? AND claimed_at < ?", StatusActive, now.Add(-staleAfter)).Find(&stale).Error
+	if err != nil {
+		log.Printf("[Sequence] Could not load interrupted enrollments: %v", err)
+		return
+	}
+	for i := range stale {
+		e := &stale[i]
+		seq, err := load(e.SequenceID)
+		if err != nil {
+			continue
+		}
+		log.Printf("[Sequence] Enrollment %d was interrupted while sending", e.ID)
+		stepID := uint(0)
+		if e.NextStep < len(seq.Steps) {
+			stepID = seq.Steps[e.NextStep].ID
+		}
+		record(e, stepID, DeliveryFailed, "", errInterrupted)
+		r.advance(e, seq, now, errInterrupted)
+	}
+}
+
+// step sends the enrollment's next step, unless an exit condition is met,
+// and moves it on
+func (r *Runner) step(e *models.SequenceEnrollment, now time.Time) {
+	seq, err := load(e.SequenceID)
+	if err != nil {
+		log.Printf("[Sequence] Could not load sequence %d: %v", e.SequenceID, err)
+		database.GormDB.Model(e).Update("claimed_at", nil)
+		return
+	}
+	if e.NextStep >= len(seq.Steps) {
+		r.advance(e, seq, now, "") // Steps were removed meanwhile
+		return
+	}
+	step := seq.Steps[e.NextStep]
+
+	var contact models.Contact
+	if database.GormDB.Where("wa_id = ?", e.ContactWaID).Limit(1).Find(&contact).Error != nil || contact.WaID == "" {
+		contact = models.Contact{WaID: e.ContactWaID}
+	}
+	replied := e.RepliedAt != nil || (contact.LastInboundAt != nil && contact.LastInboundAt.After(e.EnrolledAt))
+	if reason := exitReason(seq, contact, replied); reason != "" {
+		r.exit(e, reason)
+		return
+	}
+
+	if until := r.deferral(seq, step, e, contact, now); until != nil {
+		database.GormDB.Model(e).Where("status = ?", StatusActive).Updates(map[string]interface{}{"next_run_at": *until, "claimed_at": nil})
+		return
+	}
+
+	if step.SkipIfReplied && replied {
+		record(e, step.ID, DeliverySkipped, "", "contact replied")
+		r.advance(e, seq, now, "")
+		return
+	}
+
+	if !reclaim(e) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	wamID, skipped, err := r.send(ctx, step, e.ContactWaID)
+	cancel()
+	switch {
+	case errors.Is(err, whatsapp.ErrSuppressed) && seq.ExitOnOptOut:
+		record(e, step.ID, DeliverySkipped, "", err.Error())
+		r.exit(e, ExitOptedOut)
+		return
+	case err != nil:
+		log.Printf("[Sequence] Step %d of sequence %d failed for %s: %v", step.Position+1, seq.ID, e.ContactWaID, err)
+		record(e, step.ID, DeliveryFailed, "", err.Error())
+		r.advance(e, seq, now, err.Error())
+		return
+	case skipped != "":
+		record(e, step.ID, DeliverySkipped, "", skipped)
+	default:
+		record(e, step.ID, DeliverySent, wamID, "")
+	}
+	r.advance(e, seq, now, "")
+}
+
+// send delivers one step. Free-form steps are skipped outside the service
+// window; skipped says why.
+func (r *Runner) send(ctx context.Context, step models.SequenceStep, waID string) (wamID, skipped string, err error) {
+	if step.Type != StepTemplate {
+		window, err := conversation.Window(waID, r.Config.PhoneNumberID)
+		if err != nil {
+			return "", "", err
+		}
+		if !window.Open {
+			return "", "outside the service window", nil
+		}
+	}
+
+	var resp *whatsapp.MessageResponse
+	switch step.Type {
+	case StepTemplate:
+		var template models.Template
+		database.GormDB.Where("name = ? AND language = ?", step.TemplateName, step.Language).Limit(1).Find(&template)
+		if template.Name == "" {
+			if step.Parameters != "" {
+				return "", "", errors.New("template " + step.TemplateName + " is not synced")
+			}
+			template = models.Template{Name: step.TemplateName, Language: step.Language}
+		}
+		resp, err = scheduler.SendTemplate(ctx, r.Client, r.Media, template, step.Parameters, waID)
+	case StepMessage:
+		resp, err = r.Client.SendMessage(ctx, waID, step.Message)
+	case StepFlow:
+		cta := step.FlowCTA
+		if cta == "" {
+			cta = "Open"
+		}
+		_, err = flowdata.Send(ctx, r.Client, waID, whatsapp.FlowMessage{
+			FlowID: step.FlowID,
+			CTA:    cta,
+			Body:   step.Message,
+			Screen: step.FlowScreen,
+		}, step.FlowHandler, nil)
+	case StepChatbot:
+		if r.Flows == nil {
+			return "", "", errors.New("chatbot flows are not available")
+		}
+		err = r.Flows.StartFlow(ctx, waID, step.FlowID)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if resp != nil {
+		wamID = resp.MessageID()
+	}
+	return wamID, "", nil
+}
+
+// deferral returns when a due step may be sent if that is later than now.
+// Delayed steps wait for the sequence's local time in the contact's timezone,
+// e.g. day 2 at 10:00, and no step is sent in quiet hours.
+func (r *Runner) deferral(seq *models.Sequence, step models.SequenceStep, e *models.SequenceEnrollment, contact models.Contact, now time.Time) *time.Time {
+	quiet := localtime.QuietHours(r.Config)
+	if seq.IgnoreQuietHours {
+		quiet = localtime.Policy{}
+	}
+	clock, err := localtime.ParseClock(seq.LocalTime)
+	atClock := seq.LocalTime != "" && err == nil && step.DelayMinutes > 0
+	if !atClock && !quiet.Enabled() {
+		return nil
+	}
+
+	loc := localtime.Location(contact, r.Config)
+	at := now
+	if atClock {
+		due := e.EnrolledAt.Add(time.Duration(step.DelayMinutes) * time.Minute)
+		if slot := localtime.Next(due, clock, loc); slot.After(at) {
+			at = slot
+		}
+	}
+	at = quiet.Defer(at, loc)
+	if !at.After(now) {
+		return nil
+	}
+	return &at
+}
+
+// exitReason returns why the contact leaves the sequence before the next step
+func exitReason(seq *models.Sequence, contact models.Contact, replied bool) string {
+	if seq.ExitOnReply && replied {
+		return ExitReplied
+	}
+	if hasAny(parseTags(seq.ExitOnTags), contact.TagList()) {
+		return ExitTag
+	}
+	if seq.ExitOnOptOut {
+		if suppression, err := consent.Suppressed(contact.WaID, ""); err == nil && suppression != nil {
+			return ExitOptedOut
+		}
+	}
+	return ""
+}
+
+// advance moves the enrollment to its next step, or completes it after the
+// last one. Steps are due their delay after enrollment; steps that are
+// overdue, e.g. after downtime, are sent one after the other.
+func (r *Runner) advance(e *models.SequenceEnrollment, seq *models.Sequence, now time.Time, lastError string) {
+	e.NextStep++
+	updates := map[string]interface{}{"next_step": e.NextStep, "claimed_at": nil, "last_error": lastError}
+	if e.NextStep < len(seq.Steps) {
+		next := e.EnrolledAt.Add(time.Duration(seq.Steps[e.NextStep].DelayMinutes) * time.Minute)
+		if next.Before(now) {
+			next = now
+		}
+		updates["next_run_at"] = next
+	} else {
+		e.Status = StatusCompleted
+		updates["status"], updates["next_run_at"], updates["finished_at"] = StatusCompleted, nil, now
+	}
+	// An exit while the step was sent wins
+	result := database.GormDB.Model(e).Where("status = ?", StatusActive).Updates(updates)
+	if result.Error != nil {
+		log.Printf("[Sequence] Could not update enrollment %d: %v", e.ID, result.Error)
+		return
+	}
+	if e.Status == StatusCompleted && result.RowsAffected > 0 {
+		log.Printf("[Sequence] %s completed sequence %d", e.ContactWaID, seq.ID)
+	}
+	r.notify(e.ID)
+}
+
+func (r *Runner) exit(e *models.SequenceEnrollment, reason string) {
+	if err := Exit(e.ID, reason); err != nil && err != ErrNotActive {
+		log.Printf("[Sequence] Could not exit enrollment %d: %v", e.ID, err)
+		return
+	}
+	database.GormDB.Model(e).Update("claimed_at", nil)
+	log.Printf("[Sequence] %s exited sequence %d: %s", e.ContactWaID, e.SequenceID, reason)
+	r.notify(e.ID)
+}
+
+func (r *Runner) notify(enrollmentID uint) {
+	if r.Hub == nil {
+		return
+	}
+	var e models.SequenceEnrollment
+	if database.GormDB.First(&e, enrollmentID).Error == nil {
+		r.Hub.BroadcastEvent("sequence_enrollment_updated", e)
+	}
+}
+
+// record stores the outcome of one step
+func record(e *models.SequenceEnrollment, stepID uint, status, wamID, reason string) {
+	delivery := models.SequenceDelivery{
+		EnrollmentID: e.ID,
+		SequenceID:   e.SequenceID,
+		StepID:       stepID,
+		Status:       status,
+		WamID:        wamID,
+		Reason:       reason,
+	}
+	if err := database.GormDB.Create(&delivery).Error; err != nil {
+		log.Printf("[Sequence] Could not record step of enrollment %d: %v", e.ID, err)
+	}
+}
+
+func load(id uint) (*models.Sequence, error) {
+	var seq models.Sequence
+	if err := database.GormDB.Preload("Steps", orderSteps).First(&seq, id).Error; err != nil {
+		return nil, err
+	}
+	return &seq, nil
+}
@@ -0,0 +1,323 @@
+package sequence
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/localtime"
+	"whatsapp-gateway/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Enrollment statuses
+const (
+	StatusActive    = "active"
+	StatusCompleted = "completed"
+	StatusExited    = "exited"
+)
+
+// How a contact was enrolled
+const (
+	SourceAPI        = "api"
+	SourceAutomation = "automation"
+	SourceTag        = "tag"
+)
+
+// Why an enrollment exited before its last step
+const (
+	ExitReplied   = "replied"
+	ExitTag       = "tag"
+	ExitOptedOut  = "opted_out"
+	ExitCancelled = "cancelled"
+)
+
+// Step types
+const (
+	StepTemplate = "template"
+	StepMessage  = "message" // Free-form text, only inside the service window
+	StepFlow     = "flow"    // WhatsApp Flow, only inside the service window
+	StepChatbot  = "chatbot" // Starts a chatbot flow, only inside the service window
+)
+
+// Delivery statuses
+const (
+	DeliverySent    = "sent"
+	DeliverySkipped = "skipped"
+	DeliveryFailed  = "failed"
+)
+
+var (
+	ErrInvalidSequence = errors.New("invalid sequence")
+	ErrDisabled        = errors.New("sequence is disabled")
+	ErrAlreadyEnrolled = errors.New("contact is already enrolled in this sequence")
+	ErrNotActive       = errors.New("enrollment is not active")
+)
+
+// Validate checks a sequence and numbers its steps in the order given
+func Validate(seq *models.Sequence) error {
+	if strings.TrimSpace(seq.Name) == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidSequence)
+	}
+	if len(seq.Steps) == 0 {
+		return fmt.Errorf("%w: it needs at least one step", ErrInvalidSequence)
+	}
+	if seq.ExitOnTags != "" && !json.Valid([]byte(seq.ExitOnTags)) {
+		return fmt.Errorf("%w: exit_on_tags must be a JSON array", ErrInvalidSequence)
+	}
+	if seq.LocalTime != "" {
+		if _, err := localtime.ParseClock(seq.LocalTime); err != nil {
+			return fmt.Errorf("%w: local_time must be HH:MM", ErrInvalidSequence)
+		}
+	}
+	for i := range seq.Steps {
+		step := &seq.Steps[i]
+		step.Position = i
+		if step.DelayMinutes < 0 {
+			return fmt.Errorf("%w: step %d has a negative delay", ErrInvalidSequence, i+1)
+		}
+		if i > 0 && step.DelayMinutes < seq.Steps[i-1].DelayMinutes {
+			return fmt.Errorf("%w: step %d is due before step %d, delays count from enrollment", ErrInvalidSequence, i+1, i)
+		}
+		var missing string
+		switch step.Type {
+		case StepTemplate:
+			if step.TemplateName == "" {
+				missing = "template_name"
+			} else if step.Parameters != "" {
+				var count int64
+				database.GormDB.Model(&models.Template{}).Where("name = ?", step.TemplateName).Count(&count)
+				if count == 0 {
+					return fmt.Errorf("%w: step %d template %s is not synced, sync templates before using parameters", ErrInvalidSequence, i+1, step.TemplateName)
+				}
+			}
+		case StepMessage:
+			if step.Message == "" {
+				missing = "message"
+			}
+		case StepFlow, StepChatbot:
+			if step.FlowID == "" {
+				missing = "flow_id"
+			}
+		default:
+			return fmt.Errorf("%w: step %d type must be template, message, flow or chatbot", ErrInvalidSequence, i+1)
+		}
+		if missing != "" {
+			return fmt.Errorf("%w: step %d needs %s", ErrInvalidSequence, i+1, missing)
+		}
+	}
+	return nil
+}
+
+// Save stores a validated sequence. The steps of an existing sequence are
+// updated by position, so their reports carry over.
+func Save(seq *models.Sequence) error {
+	return database.GormDB.Transaction(func(tx *gorm.DB) error {
+		steps := seq.Steps
+		if err := tx.Omit("Steps").Save(seq).Error; err != nil {
+			return err
+		}
+		var existing []models.SequenceStep
+		if err := tx.Where("sequence_id = ?", seq.ID).Order("position").Find(&existing).Error; err != nil {
+			return err
+		}
+		for i := range steps {
+			steps[i].ID, steps[i].SequenceID = 0, seq.ID
+			if i < len(existing) {
+				steps[i].ID = existing[i].ID
+			}
+			if err := tx.Save(&steps[i]).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("sequence_id = ? AND position >= ?", seq.ID, len(steps)).Delete(&models.SequenceStep{}).Error
+	})
+}
+
+// Delete removes a sequence with its enrollments and their history
+func Delete(id uint) (bool, error) {
+	var deleted int64
+	err := database.GormDB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.SequenceDelivery{}, &models.SequenceEnrollment{}, &models.SequenceStep{}} {
+			if err := tx.Where("sequence_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		result := tx.Delete(&models.Sequence{}, id)
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	return deleted > 0, err
+}
+
+// Enroll starts a contact on a sequence. The first step is sent by the
+// Runner once its delay has passed.
+func Enroll(sequenceID uint, waID, source string) (*models.SequenceEnrollment, error) {
+	var seq models.Sequence
+	if err := database.GormDB.Preload("Steps", orderSteps).First(&seq, sequenceID).Error; err != nil {
+		return nil, err
+	}
+	if !seq.Enabled {
+		return nil, ErrDisabled
+	}
+	if len(seq.Steps) == 0 {
+		return nil, fmt.Errorf("%w: it has no steps", ErrInvalidSequence)
+	}
+
+	var enrollment models.SequenceEnrollment
+	err := database.GormDB.Transaction(func(tx *gorm.DB) error {
+		previous := tx.Model(&models.SequenceEnrollment{}).Where("sequence_id = ? AND contact_wa_id = ?", seq.ID, waID)
+		if seq.AllowReenroll {
+			previous = previous.Where("status = ?", StatusActive)
+		}
+		var count int64
+		if err := previous.Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAlreadyEnrolled
+		}
+
+		now := time.Now().UTC()
+		next := now.Add(time.Duration(seq.Steps[0].DelayMinutes) * time.Minute)
+		enrollment = models.SequenceEnrollment{
+			SequenceID:  seq.ID,
+			ContactWaID: waID,
+			Status:      StatusActive,
+			Source:      source,
+			NextRunAt:   &next,
+			EnrolledAt:  now,
+		}
+		return tx.Create(&enrollment).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[Sequence] Enrolled %s in sequence %d (%s)", waID, seq.ID, source)
+	return &enrollment, nil
+}
+
+// Exit ends an active enrollment early
+func Exit(enrollmentID uint, reason string) error {
+	result := finishActive(database.GormDB.Where("id = ?", enrollmentID), StatusExited, reason)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotActive
+	}
+	return nil
+}
+
+// ExitContact ends a contact's active enrollment in a sequence, if any
+func ExitContact(sequenceID uint, waID, reason string) error {
+	return finishActive(database.GormDB.Where("sequence_id = ? AND contact_wa_id = ?", sequenceID, waID), StatusExited, reason).Error
+}
+
+// Replied records an inbound message from waID and exits the enrollments of
+// sequences that end on a reply
+func Replied(waID string, at time.Time) error {
+	err := database.GormDB.Model(&models.SequenceEnrollment{}).
+		Where("contact_wa_id = ? AND status = ? AND replied_at IS NULL AND enrolled_at <= ?", waID, StatusActive, at.UTC()).
+		Update("replied_at", at.UTC()).Error
+	if err != nil {
+		return err
+	}
+	exiting := database.GormDB.Where("contact_wa_id = ? AND sequence_id IN (?)", waID,
+		database.GormDB.Model(&models.Sequence{}).Select("id").Where("exit_on_reply = ?", true))
+	return finishActive(exiting, StatusExited, ExitReplied).Error
+}
+
+// OptedOut exits the enrollments of sequences that end on an opt-out
+func OptedOut(waID string) error {
+	exiting := database.GormDB.Where("contact_wa_id = ? AND sequence_id IN (?)", waID,
+		database.GormDB.Model(&models.Sequence{}).Select("id").Where("exit_on_opt_out = ?", true))
+	return finishActive(exiting, StatusExited, ExitOptedOut).Error
+}
+
+// TagsAdded exits the sequences that end on one of the tags a contact just
+// got and enrolls the contact in the sequences they trigger
+func TagsAdded(waID string, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	var sequences []models.Sequence
+	err := database.GormDB.Where("trigger_tag <> '' OR (exit_on_tags <> '' AND exit_on_tags <> '[]')").Find(&sequences).Error
+	if err != nil {
+		return err
+	}
+	for _, seq := range sequences {
+		if hasAny(parseTags(seq.ExitOnTags), tags) {
+			if err := ExitContact(seq.ID, waID, ExitTag); err != nil {
+				return err
+			}
+			continue // Its exit tag wins over its trigger tag
+		}
+		if seq.Enabled && seq.TriggerTag != "" && hasAny([]string{seq.TriggerTag}, tags) {
+			_, err := Enroll(seq.ID, waID, SourceTag)
+			if err != nil && err != ErrAlreadyEnrolled {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TagsChanged runs TagsAdded for the tags in after that are not in before,
+// logging failures. It suits callers that update tags without an error path.
+func TagsChanged(waID string, before, after []string) {
+	if err := TagsAdded(waID, AddedTags(before, after)); err != nil {
+		log.Printf("[Sequence] Could not apply tags added to %s: %v", waID, err)
+	}
+}
+
+// AddedTags returns the tags in after that are not in before
+func AddedTags(before, after []string) []string {
+	var added []string
+	for _, tag := range after {
+		if !hasAny(before, []string{tag}) {
+			added = append(added, tag)
+		}
+	}
+	return added
+}
+
+// finishActive ends the active enrollments selected by query
+func finishActive(query *gorm.DB, status, reason string) *gorm.DB {
+	updates := map[string]interface{}{
+		"status":      status,
+		"next_run_at": nil,
+		"finished_at": time.Now().UTC(),
+	}
+	if reason != "" {
+		updates["exit_reason"] = reason
+	}
+	return query.Model(&models.SequenceEnrollment{}).Where("status = ?", StatusActive).Updates(updates)
+}
+
+func orderSteps(db *gorm.DB) *gorm.DB {
+	return db.Order("position")
+}
+
+// parseTags reads a JSON array of tags
+func parseTags(raw string) []string {
+	var tags []string
+	json.Unmarshal([]byte(raw), &tags)
+	return tags
+}
+
+// hasAny reports whether one of tags is in set, ignoring case
+func hasAny(set, tags []string) bool {
+	for _, a := range set {
+		for _, b := range tags {
+			if strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -1,12 +1,23 @@
 package webhook
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 	"whatsapp-gateway/internal/automation"
+	"whatsapp-gateway/internal/campaign"
 	"whatsapp-gateway/internal/config"
+	"whatsapp-gateway/internal/consent"
+	"whatsapp-gateway/internal/conversation"
 	"whatsapp-gateway/internal/database"
+	"whatsapp-gateway/internal/links"
 	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/pricing"
+	"whatsapp-gateway/internal/sequence"
+	"whatsapp-gateway/internal/whatsapp"
 	"whatsapp-gateway/internal/ws"
 	pkgModels "whatsapp-gateway/pkg/models"
 
@@ -16,13 +27,15 @@ import (
 
 type Handler struct {
 	Config           *config.Config
+	Client           whatsapp.MessageSender
 	AutomationEngine *automation.Engine
 	Hub              *ws.Hub
 }
 
-func NewHandler(cfg *config.Config, automationEngine *automation.Engine, hub *ws.Hub) *Handler {
+func NewHandler(cfg *config.Config, client whatsapp.MessageSender, automationEngine *automation.Engine, hub *ws.Hub) *Handler {
 	return &Handler{
 		Config:           cfg,
+		Client:           client,
 		AutomationEngine: automationEngine,
 		Hub:              hub,
 	}
@@ -56,6 +69,48 @@ func (h *Handler) HandleMessage(c *gin.Context) {
 	// basic processing
 	if len(payload.Entry) > 0 && len(payload.Entry[0].Changes) > 0 {
 		value := payload.Entry[0].Changes[0].Value
+		phoneNumberID := value.Metadata.PhoneNumberID
+		if phoneNumberID == "" {
+			phoneNumberID = h.Config.PhoneNumberID
+		}
+
+		// Delivery receipts for messages we sent
+		for _, status := range value.Statuses {
+			if err := conversation.UpdateStatus(status.ID, status.Status); err != nil {
+				log.Printf("Error updating status of %s: %v", status.ID, err)
+				continue
+			}
+			var errorCode int
+			var errorTitle string
+			if len(status.Errors) > 0 {
+				errorCode, errorTitle = status.Errors[0].Code, status.Errors[0].Title
+			}
+			if err := campaign.TrackStatus(status.ID, status.RecipientId, status.Status, eventTime(status.Timestamp), errorCode, errorTitle); err != nil {
+				log.Printf("Error tracking campaign status of %s: %v", status.ID, err)
+			}
+			if status.Pricing != nil {
+				event := pricing.Event{
+					WamID:         status.ID,
+					RecipientWaID: status.RecipientId,
+					PhoneNumberID: phoneNumberID,
+					Billable:      status.Pricing.Billable,
+					PricingModel:  status.Pricing.PricingModel,
+					Category:      status.Pricing.Category,
+				}
+				if status.Conversation != nil {
+					event.MetaConversationID = status.Conversation.ID
+					event.Origin = status.Conversation.Origin.Type
+					event.ConversationExpiresAt = parseUnixTime(status.Conversation.ExpirationTimestamp)
+				}
+				if _, err := pricing.Record(event); err != nil {
+					log.Printf("Error recording pricing of %s: %v", status.ID, err)
+				}
+			}
+			if h.Hub != nil {
+				h.Hub.BroadcastEvent("message_status", status)
+			}
+		}
+
 		if len(value.Messages) > 0 {
 			message := value.Messages[0]
 
@@ -96,6 +151,11 @@ func (h *Handler) HandleMessage(c *gin.Context) {
 					}
 				}
 				log.Printf("Received document from %s", message.From)
+			case "button":
+				if message.Button != nil {
+					content = message.Button.Text
+				}
+				log.Printf("Received template button click from %s: %s", message.From, content)
 			case "interactive":
 				if message.Interactive != nil {
 					if message.Interactive.Type == "button_reply" && message.Interactive.ButtonReply != nil {
@@ -109,7 +169,7 @@ func (h *Handler) HandleMessage(c *gin.Context) {
 					} else if message.Interactive.Type == "nfm_reply" && message.Interactive.NfmReply != nil {
 						// This is a Flow response
 						reply := message.Interactive.NfmReply
-						content = "[flow_response]:" + reply.ResponsePayload
+						content = automation.FlowResponsePrefix + reply.ResponsePayload
 						log.Printf("Received Flow response from %s: %s", message.From, reply.ResponsePayload)
 					} else {
 						content = "[interactive]:" + message.Interactive.Type
@@ -121,27 +181,12 @@ func (h *Handler) HandleMessage(c *gin.Context) {
 				log.Printf("Received %s from %s", message.Type, message.From)
 			}
 
-			// Store message in DB
-			msgModel := models.Message{
-				WaID:    message.ID,
-				Sender:  message.From,
-				Content: content,
-				Type:    msgType,
-				Status:  "received",
-			}
-			if err := database.GormDB.Create(&msgModel).Error; err != nil {
-				log.Printf("Error inserting into db: %v", err)
-			} else {
-				// Broadcast via WebSocket
-				if h.Hub != nil {
-					h.Hub.NotifyMessage(msgModel)
-				}
-			}
-
 			// Auto-save Contact
 			var contact models.Contact
+			newContact := false
 			err := database.GormDB.Where("wa_id = ?", message.From).First(&contact).Error
 			if err == gorm.ErrRecordNotFound {
+				newContact = true
 				contact = models.Contact{
 					WaID: message.From,
 					Name: message.From, // Default to phone number
@@ -155,20 +200,71 @@ func (h *Handler) HandleMessage(c *gin.Context) {
 				}
 			}
 
+			// Store message in its conversation
+			msgModel, err := conversation.Record(conversation.Message{
+				PhoneNumberID: phoneNumberID,
+				ContactWaID:   message.From,
+				Direction:     conversation.Inbound,
+				WamID:         message.ID,
+				Content:       content,
+				Type:          msgType,
+				Status:        "received",
+			})
+			if err != nil {
+				log.Printf("Error inserting into db: %v", err)
+			} else {
+				// Broadcast via WebSocket
+				if h.Hub != nil {
+					h.Hub.NotifyMessage(*msgModel)
+				}
+			}
+
+			// Opt-out / opt-in keywords are answered here and never reach automation
+			if message.Type == "text" && h.handleConsentKeyword(message.From, message.Text.Body, eventTime(message.Timestamp)) {
+				c.Status(http.StatusOK)
+				return
+			}
+
+			repliedTo := ""
+			if message.Context != nil {
+				repliedTo = message.Context.ID
+			}
+			clicked := message.Type == "button" || (message.Interactive != nil && message.Interactive.Type == "button_reply")
+			if err := campaign.TrackReply(message.From, repliedTo, clicked, eventTime(message.Timestamp), h.attributionWindow()); err != nil {
+				log.Printf("Error attributing reply from %s: %v", message.From, err)
+			}
+			if err := sequence.Replied(message.From, eventTime(message.Timestamp)); err != nil {
+				log.Printf("Error recording sequence reply from %s: %v", message.From, err)
+			}
+
 			// Process through automation engine (text and interactive messages)
 			if h.AutomationEngine != nil {
 				// Determine the message content to process
 				var messageContent string
-				if message.Type == "text" {
+				if message.Type == "interactive" && message.Interactive != nil && message.Interactive.NfmReply != nil {
+					// Flow submissions are matched to their send by flow_token
+					go h.AutomationEngine.ProcessFlowReply(context.Background(), message.From, message.ID, message.Interactive.NfmReply.ResponsePayload)
+				} else if message.Type == "text" {
 					messageContent = message.Text.Body
 				} else if message.Type == "interactive" && content != "" {
 					// For interactive messages, use the extracted content (button title, list selection, etc.)
 					messageContent = content
 				}
 
-				// Process if we have content
-				if messageContent != "" {
-					go h.AutomationEngine.ProcessIncomingMessage(message.From, messageContent)
+				// Process if we have content. Texts prefilled by chat links (QR codes)
+				// route to the link's target, which falls back to the regular rules.
+				var scan *models.ChatLinkScan
+				link := h.matchLink(message.Type, messageContent)
+				if link != nil {
+					if scan, err = links.RecordScan(link, message.From, message.ID, newContact); err != nil {
+						log.Printf("Error recording chat link scan: %v", err)
+					}
+				}
+				if scan != nil {
+					log.Printf("Chat link '%s' scanned by %s", link.Name, message.From)
+					go h.AutomationEngine.ProcessLinkScan(context.Background(), *link, *scan, messageContent)
+				} else if messageContent != "" {
+					go h.AutomationEngine.ProcessIncomingMessage(context.Background(), message.From, messageContent)
 				}
 			}
 		}
@@ -176,3 +272,81 @@ func (h *Handler) HandleMessage(c *gin.Context) {
 
 	c.Status(http.StatusOK)
 }
+
+// matchLink returns the chat link a text message came from, if any
+func (h *Handler) matchLink(messageType, text string) *models.ChatLink {
+	if messageType != "text" || text == "" {
+		return nil
+	}
+	link, err := links.Match(text)
+	if err != nil {
+		log.Printf("Error matching chat links: %v", err)
+		return nil
+	}
+	return link
+}
+
+// handleConsentKeyword opts the contact out or in when text is one of the
+// configured keywords and confirms it. It reports whether text was a keyword.
+func (h *Handler) handleConsentKeyword(waID, text string, at time.Time) bool {
+	change := consent.Change{WaID: waID, Source: consent.SourceKeyword, Note: strings.TrimSpace(text)}
+	var reply string
+	switch {
+	case consent.MatchKeyword(text, h.Config.OptOutKeywords):
+		if err := consent.OptOut(change); err != nil {
+			log.Printf("Error opting out %s: %v", waID, err)
+			return true
+		}
+		log.Printf("Contact %s opted out with '%s'", waID, change.Note)
+		if err := campaign.TrackOptOut(waID, at, h.attributionWindow()); err != nil {
+			log.Printf("Error attributing opt-out of %s: %v", waID, err)
+		}
+		if err := sequence.OptedOut(waID); err != nil {
+			log.Printf("Error exiting sequences of %s: %v", waID, err)
+		}
+		reply = h.Config.OptOutReply
+	case consent.MatchKeyword(text, h.Config.OptInKeywords):
+		if err := consent.OptIn(change); err != nil {
+			log.Printf("Error opting in %s: %v", waID, err)
+			return true
+		}
+		log.Printf("Contact %s opted in with '%s'", waID, change.Note)
+		reply = h.Config.OptInReply
+	default:
+		return false
+	}
+
+	if reply != "" && h.Client != nil {
+		go func() {
+			ctx := whatsapp.SkipSuppression(context.Background())
+			if _, err := h.Client.SendMessage(ctx, waID, reply); err != nil {
+				log.Printf("Error confirming consent change to %s: %v", waID, err)
+			}
+		}()
+	}
+	return true
+}
+
+// attributionWindow is how long after a campaign message replies and
+// opt-outs are credited to the campaign
+func (h *Handler) attributionWindow() time.Duration {
+	return time.Duration(h.Config.CampaignAttributionHours) * time.Hour
+}
+
+// eventTime is when a webhook event happened, or now without a timestamp
+func eventTime(timestamp string) time.Time {
+	if t := parseUnixTime(timestamp); t != nil {
+		return *t
+	}
+	return time.Now()
+}
+
+// parseUnixTime parses the unix timestamps used in webhook payloads
+func parseUnixTime(s string) *time.Time {
+	seconds, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil
+	}
+	t := time.Unix(seconds, 0)
+	return &t
+}
@@ -2,19 +2,24 @@ package whatsapp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
 	"strings"
+	"time"
 	"whatsapp-gateway/internal/config"
-	"whatsapp-gateway/internal/database"
-	"whatsapp-gateway/internal/models"
+	"whatsapp-gateway/internal/consent"
+	"whatsapp-gateway/internal/conversation"
 	"whatsapp-gateway/internal/ws"
 )
 
+// Client is the Messenger backed by the Meta Graph API
 type Client struct {
 	Config *config.Config
 	Hub    *ws.Hub
@@ -79,13 +84,16 @@ type ComponentObj struct {
 }
 
 type ParameterObj struct {
-	Type     string       `json:"type"`
-	Text     string       `json:"text,omitempty"`
-	Currency *CurrencyObj `json:"currency,omitempty"`
-	DateTime *DateTimeObj `json:"date_time,omitempty"`
-	Image    *MediaObj    `json:"image,omitempty"`
-	Video    *MediaObj    `json:"video,omitempty"`
-	Document *MediaObj    `json:"document,omitempty"`
+	Type          string       `json:"type"`
+	ParameterName string       `json:"parameter_name,omitempty"` // For named templates
+	Text          string       `json:"text,omitempty"`
+	Payload       string       `json:"payload,omitempty"`     // For quick reply buttons
+	CouponCode    string       `json:"coupon_code,omitempty"` // For copy code buttons
+	Currency      *CurrencyObj `json:"currency,omitempty"`
+	DateTime      *DateTimeObj `json:"date_time,omitempty"`
+	Image         *MediaObj    `json:"image,omitempty"`
+	Video         *MediaObj    `json:"video,omitempty"`
+	Document      *MediaObj    `json:"document,omitempty"`
 }
 
 type CurrencyObj struct {
@@ -141,6 +149,7 @@ type FlowParams struct {
 	FlowCTA            string             `json:"flow_cta"`
 	FlowAction         string             `json:"flow_action,omitempty"` // navigate or data_exchange
 	FlowActionPayload  *FlowActionPayload `json:"flow_action_payload,omitempty"`
+	Mode               string             `json:"mode,omitempty"` // draft or published (default)
 }
 
 type FlowActionPayload struct {
@@ -174,9 +183,111 @@ type RowObj struct {
 	Description string `json:"description,omitempty"`
 }
 
+// --- Errors ---
+
+// APIError is returned when the Graph API responds with an error status
+type APIError struct {
+	Status  string
+	Code    int
+	Subcode int
+	Message string
+	Body    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error: %s - %s", e.Status, e.Body)
+}
+
+func newAPIError(status string, body []byte) *APIError {
+	apiErr := &APIError{Status: status, Body: string(body)}
+	var parsed struct {
+		Error struct {
+			Message      string `json:"message"`
+			Code         int    `json:"code"`
+			ErrorSubcode int    `json:"error_subcode"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &parsed); err == nil {
+		apiErr.Code = parsed.Error.Code
+		apiErr.Subcode = parsed.Error.ErrorSubcode
+		apiErr.Message = parsed.Error.Message
+	}
+	return apiErr
+}
+
+// Is lets errors.Is match Meta's re-engagement error against ErrOutsideServiceWindow
+func (e *APIError) Is(target error) bool {
+	return target == ErrOutsideServiceWindow && e.Code == 131047
+}
+
+// ErrOutsideServiceWindow is returned for free-form messages to contacts who
+// haven't written to us in the last 24 hours; only templates can reach them
+var ErrOutsideServiceWindow = errors.New("outside the 24-hour customer service window, only template messages can be sent")
+
+// ServiceWindowError is returned when a send is refused before calling Meta
+// because the contact's service window is closed
+type ServiceWindowError struct {
+	To        string
+	ExpiredAt *time.Time // nil if the contact never wrote to us
+}
+
+func (e *ServiceWindowError) Error() string {
+	if e.ExpiredAt == nil {
+		return fmt.Sprintf("%s has not messaged us yet: %v", e.To, ErrOutsideServiceWindow)
+	}
+	return fmt.Sprintf("service window with %s closed at %s: %v", e.To, e.ExpiredAt.Format(time.RFC3339), ErrOutsideServiceWindow)
+}
+
+func (e *ServiceWindowError) Unwrap() error {
+	return ErrOutsideServiceWindow
+}
+
+// ErrSuppressed is returned for sends to contacts who opted out
+var ErrSuppressed = errors.New("contact has opted out of these messages")
+
+// SuppressedError is returned when a send is refused because the contact is
+// on the suppression list
+type SuppressedError struct {
+	To       string
+	Category string // all, or the template category the contact opted out of
+}
+
+func (e *SuppressedError) Error() string {
+	return fmt.Sprintf("%s is suppressed (%s): %v", e.To, e.Category, ErrSuppressed)
+}
+
+func (e *SuppressedError) Unwrap() error {
+	return ErrSuppressed
+}
+
+// IsMediaError reports whether err was caused by an invalid or expired media ID
+func IsMediaError(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Code {
+	case 131052, 131053: // Media download / upload error
+		return true
+	case 100: // Invalid parameter, raised for unknown media IDs
+		return strings.Contains(strings.ToLower(apiErr.Message), "media")
+	}
+	return false
+}
+
 // --- Helper Functions ---
 
-func (c *Client) sendRequest(method, url string, body interface{}, headers map[string]string) ([]byte, error) {
+// digitsOnly turns a recipient like "+49 151 234" into the wa_id form "49151234"
+func digitsOnly(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+func (c *Client) sendRequest(ctx context.Context, method, url string, body interface{}, headers map[string]string) ([]byte, error) {
 	var bodyReader io.Reader
 	if body != nil {
 		jsonData, err := json.Marshal(body)
@@ -186,7 +297,7 @@ func (c *Client) sendRequest(method, url string, body interface{}, headers map[s
 		bodyReader = bytes.NewBuffer(jsonData)
 	}
 
-	req, err := http.NewRequest(method, url, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
 	if err != nil {
 		return nil, err
 	}
@@ -213,7 +324,7 @@ func (c *Client) sendRequest(method, url string, body interface{}, headers map[s
 	}
 
 	if resp.StatusCode >= 400 {
-		return respBody, fmt.Errorf("API error: %s - %s", resp.Status, string(respBody))
+		return respBody, newAPIError(resp.Status, respBody)
 	}
 
 	return respBody, nil
@@ -221,9 +332,47 @@ func (c *Client) sendRequest(method, url string, body interface{}, headers map[s
 
 // --- Messaging Methods ---
 
-func (c *Client) SendRawMessage(msg GenericMessage) error {
+func (c *Client) SendRawMessage(ctx context.Context, msg GenericMessage) (*MessageResponse, error) {
+	if !suppressionSkipped(ctx) {
+		category := ""
+		if msg.Template != nil {
+			category = consent.TemplateCategory(msg.Template.Name)
+		}
+		suppression, err := consent.Suppressed(digitsOnly(msg.To), category)
+		if err != nil {
+			log.Printf("[WhatsApp] Could not check suppression list for %s: %v", msg.To, err)
+		} else if suppression != nil {
+			return nil, &SuppressedError{To: msg.To, Category: suppression.Category}
+		}
+	}
+
+	if msg.Type != "template" && c.Config.EnforceServiceWindow {
+		window, err := conversation.Window(digitsOnly(msg.To), c.Config.PhoneNumberID)
+		if err != nil {
+			log.Printf("[WhatsApp] Could not check service window for %s: %v", msg.To, err)
+		} else if !window.Open {
+			if c.Config.ServiceWindowTemplate == "" {
+				return nil, &ServiceWindowError{To: msg.To, ExpiredAt: window.ExpiresAt}
+			}
+			log.Printf("[WhatsApp] Service window with %s is closed, sending template %s instead", msg.To, c.Config.ServiceWindowTemplate)
+			resp, err := c.SendTemplateMessage(ctx, msg.To, c.Config.ServiceWindowTemplate, c.Config.ServiceWindowTemplateLang)
+			if resp != nil {
+				resp.FallbackTemplate = c.Config.ServiceWindowTemplate
+			}
+			return resp, err
+		}
+	}
+
 	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s/messages", c.Config.PhoneNumberID)
-	_, err := c.sendRequest("POST", url, msg, nil)
+	respBody, err := c.sendRequest(ctx, "POST", url, msg, nil)
+
+	var result *MessageResponse
+	if err == nil {
+		result = &MessageResponse{}
+		if jsonErr := json.Unmarshal(respBody, result); jsonErr != nil {
+			log.Printf("[WhatsApp] Could not parse send response: %v", jsonErr)
+		}
+	}
 
 	// Access the body to log it
 	content := ""
@@ -288,29 +437,39 @@ func (c *Client) SendRawMessage(msg GenericMessage) error {
 	}
 
 	// Log to DB (Fire and forget or simple log)
-	// Store the recipient phone number in 'sender' field so we can group conversations properly
-	go func() {
-		msgModel := models.Message{
-			WaID:    "outgoing-" + msg.To,
-			Sender:  msg.To,
-			Content: content,
-			Type:    msg.Type,
-			Status:  "sent",
+	contactWaID, wamID, status := msg.To, "", "failed"
+	if err == nil {
+		status = "sent"
+		wamID = result.MessageID()
+		// Meta normalises the number we sent to, use that for the contact
+		if len(result.Contacts) > 0 && result.Contacts[0].WaID != "" {
+			contactWaID = result.Contacts[0].WaID
 		}
-		if err := database.GormDB.Create(&msgModel).Error; err != nil {
+	}
+	go func() {
+		msgModel, err := conversation.Record(conversation.Message{
+			PhoneNumberID: c.Config.PhoneNumberID,
+			ContactWaID:   contactWaID,
+			Direction:     conversation.Outbound,
+			WamID:         wamID,
+			Content:       content,
+			Type:          msg.Type,
+			Status:        status,
+		})
+		if err != nil {
 			fmt.Printf("Error logging outgoing message: %v\n", err)
 		} else {
 			// Broadcast via WebSocket
 			if c.Hub != nil {
-				c.Hub.NotifyMessage(msgModel)
+				c.Hub.NotifyMessage(*msgModel)
 			}
 		}
 	}()
 
-	return err
+	return result, err
 }
 
-func (c *Client) SendMessage(to, body string) error {
+func (c *Client) SendMessage(ctx context.Context, to, body string) (*MessageResponse, error) {
 	msg := GenericMessage{
 		MessagingProduct: "whatsapp",
 		To:               to,
@@ -319,13 +478,13 @@ func (c *Client) SendMessage(to, body string) error {
 			Body: body,
 		},
 	}
-	return c.SendRawMessage(msg)
+	return c.SendRawMessage(ctx, msg)
 }
 
 // SendInteractiveButtons sends an interactive message with reply buttons (max 3)
-func (c *Client) SendInteractiveButtons(to, bodyText string, buttons []ButtonObj) error {
+func (c *Client) SendInteractiveButtons(ctx context.Context, to, bodyText string, buttons []ButtonObj) (*MessageResponse, error) {
 	if len(buttons) > 3 {
-		return fmt.Errorf("WhatsApp allows maximum 3 buttons, got %d", len(buttons))
+		return nil, fmt.Errorf("WhatsApp allows maximum 3 buttons, got %d", len(buttons))
 	}
 
 	msg := GenericMessage{
@@ -342,16 +501,16 @@ func (c *Client) SendInteractiveButtons(to, bodyText string, buttons []ButtonObj
 			},
 		},
 	}
-	return c.SendRawMessage(msg)
+	return c.SendRawMessage(ctx, msg)
 }
 
 // SendInteractiveList sends an interactive list message (max 10 options)
-func (c *Client) SendInteractiveList(to, bodyText, buttonText string, options []RowObj) error {
+func (c *Client) SendInteractiveList(ctx context.Context, to, bodyText, buttonText string, options []RowObj) (*MessageResponse, error) {
 	if len(options) > 10 {
-		return fmt.Errorf("WhatsApp allows maximum 10 list options, got %d", len(options))
+		return nil, fmt.Errorf("WhatsApp allows maximum 10 list options, got %d", len(options))
 	}
 	if len(options) == 0 {
-		return fmt.Errorf("list must have at least 1 option")
+		return nil, fmt.Errorf("list must have at least 1 option")
 	}
 
 	msg := GenericMessage{
@@ -373,10 +532,69 @@ func (c *Client) SendInteractiveList(to, bodyText, buttonText string, options []
 			},
 		},
 	}
-	return c.SendRawMessage(msg)
+	return c.SendRawMessage(ctx, msg)
+}
+
+// FlowMessage describes a WhatsApp Flow to send as an interactive message
+type FlowMessage struct {
+	FlowID string
+	Token  string
+	CTA    string
+	Body   string
+	Header string
+	Footer string
+	Screen string      // First screen to open; empty lets the data endpoint decide (INIT)
+	Data   interface{} // Initial data for Screen
+	Draft  bool        // Send the unpublished draft version, for testing
+}
+
+// SendFlowMessage sends an interactive flow message
+func (c *Client) SendFlowMessage(ctx context.Context, to string, flow FlowMessage) (*MessageResponse, error) {
+	params := &FlowParams{
+		FlowMessageVersion: "3",
+		FlowToken:          flow.Token,
+		FlowID:             flow.FlowID,
+		FlowCTA:            flow.CTA,
+	}
+	if flow.Screen != "" {
+		params.FlowAction = "navigate"
+		params.FlowActionPayload = &FlowActionPayload{Screen: flow.Screen, Data: flow.Data}
+	} else {
+		params.FlowAction = "data_exchange"
+	}
+	if flow.Draft {
+		params.Mode = "draft"
+	}
+
+	interactive := &InteractiveObj{
+		Type: "flow",
+		Body: BodyObj{Text: flow.Body},
+		Action: ActionObj{
+			Name:       "flow",
+			Parameters: params,
+		},
+	}
+	if flow.Header != "" {
+		interactive.Header = &HeaderObj{Type: "text", Text: flow.Header}
+	}
+	if flow.Footer != "" {
+		interactive.Footer = &FooterObj{Text: flow.Footer}
+	}
+
+	return c.SendRawMessage(ctx, GenericMessage{
+		MessagingProduct: "whatsapp",
+		To:               to,
+		Type:             "interactive",
+		Interactive:      interactive,
+	})
 }
 
-func (c *Client) SendTemplateMessage(to, templateName, languageCode string) error {
+func (c *Client) SendTemplateMessage(ctx context.Context, to, templateName, languageCode string) (*MessageResponse, error) {
+	return c.SendTemplateWithComponents(ctx, to, templateName, languageCode, nil)
+}
+
+// SendTemplateWithComponents sends a template with bound parameters (see BindTemplate)
+func (c *Client) SendTemplateWithComponents(ctx context.Context, to, templateName, languageCode string, components []ComponentObj) (*MessageResponse, error) {
 	msg := GenericMessage{
 		MessagingProduct: "whatsapp",
 		To:               to,
@@ -386,12 +604,13 @@ func (c *Client) SendTemplateMessage(to, templateName, languageCode string) erro
 			Language: LanguageObj{
 				Code: languageCode,
 			},
+			Components: components,
 		},
 	}
-	return c.SendRawMessage(msg)
+	return c.SendRawMessage(ctx, msg)
 }
 
-func (c *Client) SendImageMessage(to, imageUrl, caption string) error {
+func (c *Client) SendImageMessage(ctx context.Context, to, imageUrl, caption string) (*MessageResponse, error) {
 	msg := GenericMessage{
 		MessagingProduct: "whatsapp",
 		To:               to,
@@ -401,7 +620,7 @@ func (c *Client) SendImageMessage(to, imageUrl, caption string) error {
 			Caption: caption,
 		},
 	}
-	return c.SendRawMessage(msg)
+	return c.SendRawMessage(ctx, msg)
 }
 
 // --- Media Methods ---
@@ -410,7 +629,7 @@ type MediaResponse struct {
 	ID string `json:"id"`
 }
 
-func (c *Client) UploadMedia(fileData []byte, mimeType, filename string) (*MediaResponse, error) {
+func (c *Client) UploadMedia(ctx context.Context, fileData []byte, mimeType, filename string) (*MediaResponse, error) {
 	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s/media", c.Config.PhoneNumberID)
 
 	body := &bytes.Buffer{}
@@ -431,7 +650,7 @@ func (c *Client) UploadMedia(fileData []byte, mimeType, filename string) (*Media
 	writer.WriteField("type", mimeType)
 	writer.Close()
 
-	req, err := http.NewRequest("POST", url, body)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
 	if err != nil {
 		return nil, err
 	}
@@ -463,10 +682,10 @@ func (c *Client) UploadMedia(fileData []byte, mimeType, filename string) (*Media
 	return &mediaResp, nil
 }
 
-func (c *Client) RetrieveMediaURL(mediaID string) (string, error) {
+func (c *Client) RetrieveMediaURL(ctx context.Context, mediaID string) (string, error) {
 	// First get the media object URL
 	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s", mediaID)
-	resp, err := c.sendRequest("GET", url, nil, nil)
+	resp, err := c.sendRequest(ctx, "GET", url, nil, nil)
 	if err != nil {
 		return "", err
 	}
@@ -483,19 +702,32 @@ func (c *Client) RetrieveMediaURL(mediaID string) (string, error) {
 	return obj.URL, nil
 }
 
-func (c *Client) DeleteMedia(mediaID string) error {
+// DownloadMedia fetches a media URL returned by RetrieveMediaURL. The caller
+// closes the response body.
+func (c *Client) DownloadMedia(ctx context.Context, mediaURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", mediaURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Config.WhatsAppToken)
+
+	client := &http.Client{}
+	return client.Do(req)
+}
+
+func (c *Client) DeleteMedia(ctx context.Context, mediaID string) error {
 	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s", mediaID)
-	_, err := c.sendRequest("DELETE", url, nil, nil)
+	_, err := c.sendRequest(ctx, "DELETE", url, nil, nil)
 	return err
 }
 
 // --- Template Management Methods ---
 
-func (c *Client) GetTemplates() (interface{}, error) {
+func (c *Client) GetTemplates(ctx context.Context) (interface{}, error) {
 	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s/message_templates", c.Config.WhatsAppBusinessAccountID)
 	// We return raw interface{} or map[string]interface{} to just pass it through
 	// or we could define complex template structs.
-	resp, err := c.sendRequest("GET", url, nil, nil)
+	resp, err := c.sendRequest(ctx, "GET", url, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -505,9 +737,9 @@ func (c *Client) GetTemplates() (interface{}, error) {
 	return result, err
 }
 
-func (c *Client) CreateTemplate(templateData interface{}) (interface{}, error) {
+func (c *Client) CreateTemplate(ctx context.Context, templateData interface{}) (interface{}, error) {
 	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s/message_templates", c.Config.WhatsAppBusinessAccountID)
-	resp, err := c.sendRequest("POST", url, templateData, nil)
+	resp, err := c.sendRequest(ctx, "POST", url, templateData, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -517,19 +749,19 @@ func (c *Client) CreateTemplate(templateData interface{}) (interface{}, error) {
 	return result, err
 }
 
-func (c *Client) DeleteTemplate(templateName string) error {
+func (c *Client) DeleteTemplate(ctx context.Context, templateName string) error {
 	// Deleting by name usually requires filtering or a specific ID, but the Management API often uses parameters.
 	// Actually, DELETE https://graph.facebook.com/v19.0/{waba_id}/message_templates?name={name}
 	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s/message_templates?name=%s", c.Config.WhatsAppBusinessAccountID, templateName)
-	_, err := c.sendRequest("DELETE", url, nil, nil)
+	_, err := c.sendRequest(ctx, "DELETE", url, nil, nil)
 	return err
 }
 
 // --- Flow Management Methods ---
 
-func (c *Client) GetFlows() (interface{}, error) {
+func (c *Client) GetFlows(ctx context.Context) (interface{}, error) {
 	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s/flows", c.Config.WhatsAppBusinessAccountID)
-	resp, err := c.sendRequest("GET", url, nil, nil)
+	resp, err := c.sendRequest(ctx, "GET", url, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -538,9 +770,9 @@ func (c *Client) GetFlows() (interface{}, error) {
 	return result, err
 }
 
-func (c *Client) GetFlow(flowID string) (interface{}, error) {
+func (c *Client) GetFlow(ctx context.Context, flowID string) (interface{}, error) {
 	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s?fields=id,name,categories,preview,status,validation_errors,json_version,data_api_version,data_channel_uri,health_status", flowID)
-	resp, err := c.sendRequest("GET", url, nil, nil)
+	resp, err := c.sendRequest(ctx, "GET", url, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -549,7 +781,7 @@ func (c *Client) GetFlow(flowID string) (interface{}, error) {
 	return result, err
 }
 
-func (c *Client) CreateFlow(name string, categories []string, cloneFlowID string) (interface{}, error) {
+func (c *Client) CreateFlow(ctx context.Context, name string, categories []string, cloneFlowID string) (interface{}, error) {
 	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s/flows", c.Config.WhatsAppBusinessAccountID)
 
 	req := map[string]interface{}{
@@ -560,7 +792,7 @@ func (c *Client) CreateFlow(name string, categories []string, cloneFlowID string
 		req["clone_flow_id"] = cloneFlowID
 	}
 
-	resp, err := c.sendRequest("POST", url, req, nil)
+	resp, err := c.sendRequest(ctx, "POST", url, req, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -569,7 +801,7 @@ func (c *Client) CreateFlow(name string, categories []string, cloneFlowID string
 	return result, err
 }
 
-func (c *Client) UpdateFlowMetadata(flowID, name string, categories []string) (interface{}, error) {
+func (c *Client) UpdateFlowMetadata(ctx context.Context, flowID, name string, categories []string) (interface{}, error) {
 	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s", flowID)
 	req := map[string]interface{}{}
 	if name != "" {
@@ -579,7 +811,7 @@ func (c *Client) UpdateFlowMetadata(flowID, name string, categories []string) (i
 		req["categories"] = categories
 	}
 
-	resp, err := c.sendRequest("POST", url, req, nil)
+	resp, err := c.sendRequest(ctx, "POST", url, req, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -588,9 +820,9 @@ func (c *Client) UpdateFlowMetadata(flowID, name string, categories []string) (i
 	return result, err
 }
 
-func (c *Client) PublishFlow(flowID string) (interface{}, error) {
+func (c *Client) PublishFlow(ctx context.Context, flowID string) (interface{}, error) {
 	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s/publish", flowID)
-	resp, err := c.sendRequest("POST", url, nil, nil)
+	resp, err := c.sendRequest(ctx, "POST", url, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -599,9 +831,29 @@ func (c *Client) PublishFlow(flowID string) (interface{}, error) {
 	return result, err
 }
 
-func (c *Client) DeleteFlow(flowID string) (interface{}, error) {
+func (c *Client) DeleteFlow(ctx context.Context, flowID string) (interface{}, error) {
 	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s", flowID)
-	resp, err := c.sendRequest("DELETE", url, nil, nil)
+	resp, err := c.sendRequest(ctx, "DELETE", url, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	err = json.Unmarshal(resp, &result)
+	return result, err
+}
+
+// SetBusinessPublicKey uploads the public key used to encrypt Flows data
+// channel requests for our phone number
+func (c *Client) SetBusinessPublicKey(ctx context.Context, publicKeyPEM string) error {
+	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s/whatsapp_business_encryption", c.Config.PhoneNumberID)
+	_, err := c.sendRequest(ctx, "POST", url, map[string]string{"business_public_key": publicKeyPEM}, nil)
+	return err
+}
+
+// GetBusinessPublicKey returns the registered public key and its signature status
+func (c *Client) GetBusinessPublicKey(ctx context.Context) (interface{}, error) {
+	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s/whatsapp_business_encryption", c.Config.PhoneNumberID)
+	resp, err := c.sendRequest(ctx, "GET", url, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -610,7 +862,7 @@ func (c *Client) DeleteFlow(flowID string) (interface{}, error) {
 	return result, err
 }
 
-func (c *Client) UploadFlowJSON(flowID string, fileData []byte) (interface{}, error) {
+func (c *Client) UploadFlowJSON(ctx context.Context, flowID string, fileData []byte) (interface{}, error) {
 	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s/assets", flowID)
 
 	body := &bytes.Buffer{}
@@ -634,7 +886,7 @@ func (c *Client) UploadFlowJSON(flowID string, fileData []byte) (interface{}, er
 
 	writer.Close()
 
-	req, err := http.NewRequest("POST", url, body)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
 	if err != nil {
 		return nil, err
 	}
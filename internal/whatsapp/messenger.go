@@ -0,0 +1,106 @@
+package whatsapp
+
+import (
+	"context"
+	"net/http"
+)
+
+// MessageResponse is returned by the Cloud API for a sent message
+type MessageResponse struct {
+	Contacts []struct {
+		Input string `json:"input"`
+		WaID  string `json:"wa_id"`
+	} `json:"contacts"`
+	Messages []struct {
+		ID            string `json:"id"`
+		MessageStatus string `json:"message_status,omitempty"`
+	} `json:"messages"`
+
+	// FallbackTemplate is set when a free-form message was replaced by the
+	// configured template because the service window was closed
+	FallbackTemplate string `json:"-"`
+}
+
+// MessageID returns the wamid of the sent message, if any
+func (r *MessageResponse) MessageID() string {
+	if r == nil || len(r.Messages) == 0 {
+		return ""
+	}
+	return r.Messages[0].ID
+}
+
+type skipSuppressionKey struct{}
+
+// SkipSuppression lets sends with ctx reach suppressed contacts. It is only
+// meant for the confirmation of an opt-out.
+func SkipSuppression(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipSuppressionKey{}, true)
+}
+
+func suppressionSkipped(ctx context.Context) bool {
+	skip, _ := ctx.Value(skipSuppressionKey{}).(bool)
+	return skip
+}
+
+// MessageSender sends messages to WhatsApp users
+type MessageSender interface {
+	SendRawMessage(ctx context.Context, msg GenericMessage) (*MessageResponse, error)
+	SendMessage(ctx context.Context, to, body string) (*MessageResponse, error)
+	SendInteractiveButtons(ctx context.Context, to, bodyText string, buttons []ButtonObj) (*MessageResponse, error)
+	SendInteractiveList(ctx context.Context, to, bodyText, buttonText string, options []RowObj) (*MessageResponse, error)
+	SendFlowMessage(ctx context.Context, to string, flow FlowMessage) (*MessageResponse, error)
+	SendTemplateMessage(ctx context.Context, to, templateName, languageCode string) (*MessageResponse, error)
+	SendTemplateWithComponents(ctx context.Context, to, templateName, languageCode string, components []ComponentObj) (*MessageResponse, error)
+	SendImageMessage(ctx context.Context, to, imageUrl, caption string) (*MessageResponse, error)
+}
+
+// MediaManager uploads, fetches and deletes media objects
+type MediaManager interface {
+	UploadMedia(ctx context.Context, fileData []byte, mimeType, filename string) (*MediaResponse, error)
+	RetrieveMediaURL(ctx context.Context, mediaID string) (string, error)
+	DownloadMedia(ctx context.Context, mediaURL string) (*http.Response, error)
+	DeleteMedia(ctx context.Context, mediaID string) error
+}
+
+// TemplateManager manages message templates on the business account
+type TemplateManager interface {
+	GetTemplates(ctx context.Context) (interface{}, error)
+	CreateTemplate(ctx context.Context, templateData interface{}) (interface{}, error)
+	DeleteTemplate(ctx context.Context, templateName string) error
+}
+
+// FlowManager manages WhatsApp Flows and their encryption key
+type FlowManager interface {
+	GetFlows(ctx context.Context) (interface{}, error)
+	GetFlow(ctx context.Context, flowID string) (interface{}, error)
+	CreateFlow(ctx context.Context, name string, categories []string, cloneFlowID string) (interface{}, error)
+	UpdateFlowMetadata(ctx context.Context, flowID, name string, categories []string) (interface{}, error)
+	PublishFlow(ctx context.Context, flowID string) (interface{}, error)
+	DeleteFlow(ctx context.Context, flowID string) (interface{}, error)
+	UploadFlowJSON(ctx context.Context, flowID string, fileData []byte) (interface{}, error)
+	SetBusinessPublicKey(ctx context.Context, publicKeyPEM string) error
+	GetBusinessPublicKey(ctx context.Context) (interface{}, error)
+}
+
+// AccountManager manages the business profile and phone numbers
+type AccountManager interface {
+	GetBusinessProfile(ctx context.Context) (*BusinessProfile, error)
+	UpdateBusinessProfile(ctx context.Context, update BusinessProfileUpdate) error
+	UploadProfilePicture(ctx context.Context, data []byte, mimeType string) (string, error)
+	GetPhoneNumbers(ctx context.Context) ([]PhoneNumber, error)
+	GetPhoneNumber(ctx context.Context, phoneNumberID string) (*PhoneNumber, error)
+	SetTwoStepPin(ctx context.Context, phoneNumberID, pin string) error
+}
+
+// Messenger is everything the gateway needs from WhatsApp. Client talks to
+// the Graph API; other implementations (sandbox, recording, fakes) can be
+// passed anywhere a Messenger is accepted.
+type Messenger interface {
+	MessageSender
+	MediaManager
+	TemplateManager
+	FlowManager
+	AccountManager
+}
+
+var _ Messenger = (*Client)(nil)
@@ -0,0 +1,178 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// BusinessProfile is the public profile shown for our phone number
+type BusinessProfile struct {
+	About             string   `json:"about,omitempty"`
+	Address           string   `json:"address,omitempty"`
+	Description       string   `json:"description,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	Websites          []string `json:"websites,omitempty"`
+	Vertical          string   `json:"vertical,omitempty"`
+	ProfilePictureURL string   `json:"profile_picture_url,omitempty"`
+}
+
+// BusinessProfileUpdate holds the fields to change. Nil fields are left as is.
+type BusinessProfileUpdate struct {
+	About                *string   `json:"about,omitempty"`
+	Address              *string   `json:"address,omitempty"`
+	Description          *string   `json:"description,omitempty"`
+	Email                *string   `json:"email,omitempty"`
+	Websites             *[]string `json:"websites,omitempty"`
+	Vertical             *string   `json:"vertical,omitempty"`
+	ProfilePictureHandle string    `json:"profile_picture_handle,omitempty"`
+}
+
+// Verticals accepted by the business profile API
+var BusinessVerticals = []string{
+	"UNDEFINED", "OTHER", "AUTO", "BEAUTY", "APPAREL", "EDU", "ENTERTAIN", "EVENT_PLAN", "FINANCE",
+	"GROCERY", "GOVT", "HOTEL", "HEALTH", "NONPROFIT", "PROF_SERVICES", "RETAIL", "TRAVEL", "RESTAURANT", "NOT_A_BIZ",
+}
+
+// PhoneNumber describes a phone number registered on the business account
+type PhoneNumber struct {
+	ID                     string `json:"id"`
+	DisplayPhoneNumber     string `json:"display_phone_number"`
+	VerifiedName           string `json:"verified_name"`
+	QualityRating          string `json:"quality_rating"`
+	CodeVerificationStatus string `json:"code_verification_status"`
+	NameStatus             string `json:"name_status"`
+	PlatformType           string `json:"platform_type"`
+	MessagingLimitTier     string `json:"messaging_limit_tier"`
+	Throughput             struct {
+		Level string `json:"level"`
+	} `json:"throughput"`
+}
+
+const businessProfileFields = "about,address,description,email,profile_picture_url,websites,vertical"
+const phoneNumberFields = "id,display_phone_number,verified_name,quality_rating,code_verification_status,name_status,platform_type,messaging_limit_tier,throughput"
+
+func (c *Client) GetBusinessProfile(ctx context.Context) (*BusinessProfile, error) {
+	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s/whatsapp_business_profile?fields=%s", c.Config.PhoneNumberID, businessProfileFields)
+	resp, err := c.sendRequest(ctx, "GET", url, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Data []BusinessProfile `json:"data"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Data) == 0 {
+		return &BusinessProfile{}, nil
+	}
+	return &result.Data[0], nil
+}
+
+func (c *Client) UpdateBusinessProfile(ctx context.Context, update BusinessProfileUpdate) error {
+	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s/whatsapp_business_profile", c.Config.PhoneNumberID)
+
+	body := map[string]interface{}{"messaging_product": "whatsapp"}
+	fields, _ := json.Marshal(update)
+	json.Unmarshal(fields, &body)
+
+	_, err := c.sendRequest(ctx, "POST", url, body, nil)
+	return err
+}
+
+// UploadProfilePicture uploads an image through the resumable upload API
+// and returns the handle to set as profile_picture_handle
+func (c *Client) UploadProfilePicture(ctx context.Context, data []byte, mimeType string) (string, error) {
+	if c.Config.AppID == "" {
+		return "", fmt.Errorf("APP_ID is required for profile picture uploads")
+	}
+
+	// 1. Open an upload session
+	sessionURL := fmt.Sprintf("https://graph.facebook.com/v19.0/%s/uploads?file_length=%d&file_type=%s",
+		c.Config.AppID, len(data), url.QueryEscape(mimeType))
+	resp, err := c.sendRequest(ctx, "POST", sessionURL, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	var session struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(resp, &session); err != nil {
+		return "", err
+	}
+
+	// 2. Send the file in one chunk. This endpoint wants an OAuth header
+	// rather than a bearer token.
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://graph.facebook.com/v19.0/"+session.ID, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "OAuth "+c.Config.WhatsAppToken)
+	req.Header.Set("file_offset", "0")
+
+	client := &http.Client{}
+	uploadResp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer uploadResp.Body.Close()
+
+	respBody, err := io.ReadAll(uploadResp.Body)
+	if err != nil {
+		return "", err
+	}
+	if uploadResp.StatusCode >= 400 {
+		return "", newAPIError(uploadResp.Status, respBody)
+	}
+
+	var result struct {
+		H string `json:"h"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", err
+	}
+	return result.H, nil
+}
+
+func (c *Client) GetPhoneNumbers(ctx context.Context) ([]PhoneNumber, error) {
+	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s/phone_numbers?fields=%s", c.Config.WhatsAppBusinessAccountID, phoneNumberFields)
+	resp, err := c.sendRequest(ctx, "GET", url, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Data []PhoneNumber `json:"data"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+func (c *Client) GetPhoneNumber(ctx context.Context, phoneNumberID string) (*PhoneNumber, error) {
+	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s?fields=%s", phoneNumberID, phoneNumberFields)
+	resp, err := c.sendRequest(ctx, "GET", url, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var number PhoneNumber
+	if err := json.Unmarshal(resp, &number); err != nil {
+		return nil, err
+	}
+	return &number, nil
+}
+
+// SetTwoStepPin sets the six digit two-step verification PIN for a number
+func (c *Client) SetTwoStepPin(ctx context.Context, phoneNumberID, pin string) error {
+	url := fmt.Sprintf("https://graph.facebook.com/v19.0/%s", phoneNumberID)
+	_, err := c.sendRequest(ctx, "POST", url, map[string]string{"pin": pin}, nil)
+	return err
+}
@@ -0,0 +1,597 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"whatsapp-gateway/internal/models"
+)
+
+// WhatsApp limits for rendered template components
+const (
+	MaxTemplateHeaderLength = 60
+	MaxTemplateBodyLength   = 1024
+	MaxTemplateFooterLength = 60
+	MaxTemplateButtonParam  = 2000
+)
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+// --- Stored Template Definition (as returned by Meta) ---
+
+type TemplateComponent struct {
+	Type    string           `json:"type"`             // HEADER, BODY, FOOTER, BUTTONS
+	Format  string           `json:"format,omitempty"` // TEXT, IMAGE, VIDEO, DOCUMENT, LOCATION
+	Text    string           `json:"text,omitempty"`
+	Buttons []TemplateButton `json:"buttons,omitempty"`
+}
+
+type TemplateButton struct {
+	Type        string `json:"type"` // QUICK_REPLY, URL, PHONE_NUMBER, COPY_CODE, FLOW
+	Text        string `json:"text"`
+	URL         string `json:"url,omitempty"`
+	PhoneNumber string `json:"phone_number,omitempty"`
+}
+
+// ParseTemplateComponents decodes the components JSON stored on models.Template
+func ParseTemplateComponents(componentsJSON string) ([]TemplateComponent, error) {
+	var components []TemplateComponent
+	if componentsJSON == "" {
+		return components, nil
+	}
+	if err := json.Unmarshal([]byte(componentsJSON), &components); err != nil {
+		return nil, fmt.Errorf("invalid template components: %w", err)
+	}
+	return components, nil
+}
+
+// --- Caller Supplied Parameters ---
+
+// TemplateParams holds the values used to fill a template's placeholders.
+// Header and Body are positional ({{1}}, {{2}}...), Named fills named
+// placeholders ({{first_name}}) in either component.
+type TemplateParams struct {
+	Header      []string              `json:"header,omitempty"`
+	HeaderMedia *TemplateMedia        `json:"header_media,omitempty"`
+	Body        []string              `json:"body,omitempty"`
+	Named       map[string]string     `json:"named,omitempty"`
+	Buttons     []TemplateButtonParam `json:"buttons,omitempty"`
+
+	contact map[string]string // Fills named placeholders without a value, see WithContact
+}
+
+type TemplateMedia struct {
+	ID       string `json:"id,omitempty"`
+	Link     string `json:"link,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+type TemplateButtonParam struct {
+	Index int    `json:"index"`
+	Value string `json:"value"` // URL suffix, quick reply payload or coupon code
+}
+
+// IsEmpty reports whether no parameter values were supplied
+func (p TemplateParams) IsEmpty() bool {
+	return len(p.Header) == 0 && p.HeaderMedia == nil && len(p.Body) == 0 && len(p.Named) == 0 && len(p.Buttons) == 0
+}
+
+// WithDefaultHeaderMedia fills in the template's default header media when none was supplied
+func (p TemplateParams) WithDefaultHeaderMedia(template models.Template) TemplateParams {
+	if p.HeaderMedia == nil && template.HeaderMediaRef != "" {
+		p.HeaderMedia = &TemplateMedia{ID: template.HeaderMediaRef}
+	}
+	return p
+}
+
+// WithContact returns a copy of the params with contact variables
+// ({{contact.name}}, {{contact.phone}}) substituted in every value. Named
+// placeholders {{name}}, {{first_name}}, {{phone}} and {{wa_id}} without a
+// value are filled from the contact.
+func (p TemplateParams) WithContact(contact models.Contact) TemplateParams {
+	replace := func(v string) string {
+		v = strings.ReplaceAll(v, "{{contact.name}}", contact.Name)
+		v = strings.ReplaceAll(v, "{{contact.phone}}", contact.WaID)
+		return v
+	}
+
+	out := TemplateParams{HeaderMedia: p.HeaderMedia}
+	for _, v := range p.Header {
+		out.Header = append(out.Header, replace(v))
+	}
+	for _, v := range p.Body {
+		out.Body = append(out.Body, replace(v))
+	}
+	if p.Named != nil {
+		out.Named = make(map[string]string, len(p.Named))
+		for k, v := range p.Named {
+			out.Named[k] = replace(v)
+		}
+	}
+	for _, b := range p.Buttons {
+		out.Buttons = append(out.Buttons, TemplateButtonParam{Index: b.Index, Value: replace(b.Value)})
+	}
+
+	firstName, _, _ := strings.Cut(strings.TrimSpace(contact.Name), " ")
+	out.contact = map[string]string{
+		"name":       contact.Name,
+		"first_name": firstName,
+		"phone":      contact.WaID,
+		"wa_id":      contact.WaID,
+	}
+	return out
+}
+
+// named returns the named values with the contact's filling the gaps
+func (p TemplateParams) named() map[string]string {
+	if len(p.contact) == 0 {
+		return p.Named
+	}
+	named := make(map[string]string, len(p.Named)+len(p.contact))
+	for k, v := range p.contact {
+		named[k] = v
+	}
+	for k, v := range p.Named {
+		if v != "" || named[k] == "" {
+			named[k] = v
+		}
+	}
+	return named
+}
+
+// TemplateIssue describes a problem found while binding parameters
+type TemplateIssue struct {
+	Component string `json:"component"`
+	Index     int    `json:"index,omitempty"`
+	Code      string `json:"code"` // missing_parameter, unused_parameter, invalid_parameter, too_long
+	Message   string `json:"message"`
+}
+
+// BlockingIssues returns the issues Meta rejects or that would deliver a
+// broken message; unused parameters are left out as they are never sent
+func BlockingIssues(issues []TemplateIssue) []TemplateIssue {
+	var blocking []TemplateIssue
+	for _, issue := range issues {
+		if issue.Code != "unused_parameter" {
+			blocking = append(blocking, issue)
+		}
+	}
+	return blocking
+}
+
+// IssuesError describes blocking issues as an error, nil when there are none
+func IssuesError(issues []TemplateIssue) error {
+	blocking := BlockingIssues(issues)
+	if len(blocking) == 0 {
+		return nil
+	}
+	messages := make([]string, len(blocking))
+	for i, issue := range blocking {
+		messages[i] = issue.Message
+	}
+	return fmt.Errorf("template parameters: %s", strings.Join(messages, "; "))
+}
+
+// --- Binding ---
+
+// BindTemplate turns a template definition and caller parameters into the
+// components sent to the Cloud API. It is used both by the send path and by
+// the preview renderer so that both agree on how values are placed.
+func BindTemplate(definition []TemplateComponent, params TemplateParams) ([]ComponentObj, []TemplateIssue) {
+	var components []ComponentObj
+	var issues []TemplateIssue
+	usedNamed := map[string]bool{}
+	named := params.named()
+
+	for _, def := range definition {
+		switch strings.ToUpper(def.Type) {
+		case "HEADER":
+			format := strings.ToUpper(def.Format)
+			if format == "" || format == "TEXT" {
+				paramObjs, compIssues := bindText("header", def.Text, params.Header, named, usedNamed)
+				issues = append(issues, compIssues...)
+				if len([]rune(fillPlaceholders(def.Text, paramObjs))) > MaxTemplateHeaderLength {
+					issues = append(issues, TemplateIssue{Component: "header", Code: "too_long", Message: fmt.Sprintf("rendered header exceeds %d characters", MaxTemplateHeaderLength)})
+				}
+				if len(paramObjs) > 0 {
+					components = append(components, ComponentObj{Type: "header", Parameters: paramObjs})
+				}
+				continue
+			}
+
+			if format == "LOCATION" {
+				continue
+			}
+			if params.HeaderMedia == nil || (params.HeaderMedia.ID == "" && params.HeaderMedia.Link == "") {
+				issues = append(issues, TemplateIssue{
+					Component: "header",
+					Code:      "missing_parameter",
+					Message:   fmt.Sprintf("header requires a %s media parameter", strings.ToLower(format)),
+				})
+				continue
+			}
+			media := &MediaObj{ID: params.HeaderMedia.ID, Link: params.HeaderMedia.Link}
+			param := ParameterObj{Type: strings.ToLower(format)}
+			switch format {
+			case "IMAGE":
+				param.Image = media
+			case "VIDEO":
+				param.Video = media
+			case "DOCUMENT":
+				media.Filename = params.HeaderMedia.Filename
+				param.Document = media
+			}
+			components = append(components, ComponentObj{Type: "header", Parameters: []ParameterObj{param}})
+
+		case "BODY":
+			paramObjs, compIssues := bindText("body", def.Text, params.Body, named, usedNamed)
+			issues = append(issues, compIssues...)
+			for i, p := range paramObjs {
+				if strings.ContainsAny(p.Text, "\n\t") || strings.Contains(p.Text, "     ") {
+					issues = append(issues, TemplateIssue{
+						Component: "body",
+						Index:     i + 1,
+						Code:      "invalid_parameter",
+						Message:   "body parameters cannot contain new lines, tabs or more than 4 consecutive spaces",
+					})
+				}
+			}
+			if len([]rune(fillPlaceholders(def.Text, paramObjs))) > MaxTemplateBodyLength {
+				issues = append(issues, TemplateIssue{Component: "body", Code: "too_long", Message: fmt.Sprintf("rendered body exceeds %d characters", MaxTemplateBodyLength)})
+			}
+			if len(paramObjs) > 0 {
+				components = append(components, ComponentObj{Type: "body", Parameters: paramObjs})
+			}
+
+		case "FOOTER":
+			if len([]rune(def.Text)) > MaxTemplateFooterLength {
+				issues = append(issues, TemplateIssue{Component: "footer", Code: "too_long", Message: fmt.Sprintf("footer exceeds %d characters", MaxTemplateFooterLength)})
+			}
+
+		case "BUTTONS":
+			supplied := map[int]string{}
+			for _, b := range params.Buttons {
+				supplied[b.Index] = b.Value
+			}
+			for i, btn := range def.Buttons {
+				value, ok := supplied[i]
+				delete(supplied, i)
+
+				switch strings.ToUpper(btn.Type) {
+				case "URL":
+					if len(placeholderPattern.FindAllString(btn.URL, -1)) == 0 {
+						continue
+					}
+					if !ok || value == "" {
+						issues = append(issues, TemplateIssue{Component: "button", Index: i, Code: "missing_parameter", Message: fmt.Sprintf("button %d (%s) requires a URL suffix", i, btn.Text)})
+						continue
+					}
+					if len(value) > MaxTemplateButtonParam {
+						issues = append(issues, TemplateIssue{Component: "button", Index: i, Code: "too_long", Message: fmt.Sprintf("button %d URL parameter exceeds %d characters", i, MaxTemplateButtonParam)})
+					}
+					components = append(components, ComponentObj{
+						Type:       "button",
+						SubType:    "url",
+						Index:      strconv.Itoa(i),
+						Parameters: []ParameterObj{{Type: "text", Text: value}},
+					})
+				case "QUICK_REPLY":
+					if !ok {
+						continue
+					}
+					components = append(components, ComponentObj{
+						Type:       "button",
+						SubType:    "quick_reply",
+						Index:      strconv.Itoa(i),
+						Parameters: []ParameterObj{{Type: "payload", Payload: value}},
+					})
+				case "COPY_CODE":
+					if !ok || value == "" {
+						issues = append(issues, TemplateIssue{Component: "button", Index: i, Code: "missing_parameter", Message: fmt.Sprintf("button %d requires a coupon code", i)})
+						continue
+					}
+					components = append(components, ComponentObj{
+						Type:       "button",
+						SubType:    "copy_code",
+						Index:      strconv.Itoa(i),
+						Parameters: []ParameterObj{{Type: "coupon_code", CouponCode: value}},
+					})
+				default:
+					if ok {
+						issues = append(issues, TemplateIssue{Component: "button", Index: i, Code: "unused_parameter", Message: fmt.Sprintf("button %d (%s) does not accept parameters", i, btn.Type)})
+					}
+				}
+			}
+
+			leftover := make([]int, 0, len(supplied))
+			for idx := range supplied {
+				leftover = append(leftover, idx)
+			}
+			sort.Ints(leftover)
+			for _, idx := range leftover {
+				issues = append(issues, TemplateIssue{Component: "button", Index: idx, Code: "unused_parameter", Message: fmt.Sprintf("template has no button at index %d", idx)})
+			}
+		}
+	}
+
+	names := make([]string, 0, len(params.Named))
+	for name := range params.Named {
+		if !usedNamed[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		issues = append(issues, TemplateIssue{Component: "template", Code: "unused_parameter", Message: fmt.Sprintf("named parameter '%s' is not used by the template", name)})
+	}
+
+	return components, issues
+}
+
+// bindText builds the text parameters for one component. Positional
+// placeholders are filled from values, named ones from the named map.
+func bindText(component, text string, values []string, named map[string]string, usedNamed map[string]bool) ([]ParameterObj, []TemplateIssue) {
+	var params []ParameterObj
+	var issues []TemplateIssue
+
+	indexes, names := placeholderKeys(text)
+
+	for _, idx := range indexes {
+		if idx < 1 || idx > len(values) || values[idx-1] == "" {
+			issues = append(issues, TemplateIssue{Component: component, Index: idx, Code: "missing_parameter", Message: fmt.Sprintf("%s parameter {{%d}} has no value", component, idx)})
+			params = append(params, ParameterObj{Type: "text"})
+			continue
+		}
+		params = append(params, ParameterObj{Type: "text", Text: values[idx-1]})
+	}
+
+	for _, key := range names {
+		value, ok := named[key]
+		usedNamed[key] = true
+		if !ok || value == "" {
+			issues = append(issues, TemplateIssue{Component: component, Code: "missing_parameter", Message: fmt.Sprintf("%s parameter {{%s}} has no value", component, key)})
+		}
+		params = append(params, ParameterObj{Type: "text", ParameterName: key, Text: value})
+	}
+
+	positional := len(indexes)
+	for i := positional; i < len(values); i++ {
+		issues = append(issues, TemplateIssue{Component: component, Index: i + 1, Code: "unused_parameter", Message: fmt.Sprintf("%s parameter %d is not used by the template", component, i+1)})
+	}
+
+	return params, issues
+}
+
+// --- Rendering ---
+
+type RenderedTemplate struct {
+	Name     string           `json:"name"`
+	Language string           `json:"language"`
+	Category string           `json:"category"`
+	Header   *RenderedHeader  `json:"header,omitempty"`
+	Body     string           `json:"body"`
+	Footer   string           `json:"footer,omitempty"`
+	Buttons  []RenderedButton `json:"buttons,omitempty"`
+	Text     string           `json:"text"`
+	Issues   []TemplateIssue  `json:"issues"`
+	Valid    bool             `json:"valid"`
+}
+
+type RenderedHeader struct {
+	Format    string `json:"format"`
+	Text      string `json:"text,omitempty"`
+	MediaID   string `json:"media_id,omitempty"`
+	MediaLink string `json:"media_link,omitempty"`
+}
+
+type RenderedButton struct {
+	Index       int    `json:"index"`
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	URL         string `json:"url,omitempty"`
+	PhoneNumber string `json:"phone_number,omitempty"`
+	Payload     string `json:"payload,omitempty"`
+	CouponCode  string `json:"coupon_code,omitempty"`
+}
+
+// RenderTemplate binds params to a stored template and returns what the
+// recipient would see, along with any binding or length problems found by
+// BindTemplate.
+func RenderTemplate(tmpl models.Template, params TemplateParams) (*RenderedTemplate, error) {
+	definition, err := ParseTemplateComponents(tmpl.Components)
+	if err != nil {
+		return nil, err
+	}
+
+	bound, issues := BindTemplate(definition, params)
+
+	// Index bound parameters by component so rendering reads exactly what is sent
+	var headerParams, bodyParams []ParameterObj
+	buttonParams := map[int]ParameterObj{}
+	for _, comp := range bound {
+		switch comp.Type {
+		case "header":
+			headerParams = comp.Parameters
+		case "body":
+			bodyParams = comp.Parameters
+		case "button":
+			if idx, err := strconv.Atoi(comp.Index); err == nil && len(comp.Parameters) > 0 {
+				buttonParams[idx] = comp.Parameters[0]
+			}
+		}
+	}
+
+	out := &RenderedTemplate{
+		Name:     tmpl.Name,
+		Language: tmpl.Language,
+		Category: tmpl.Category,
+		Issues:   issues,
+	}
+
+	for _, def := range definition {
+		switch strings.ToUpper(def.Type) {
+		case "HEADER":
+			format := strings.ToUpper(def.Format)
+			if format == "" {
+				format = "TEXT"
+			}
+			header := &RenderedHeader{Format: format}
+			if format == "TEXT" {
+				header.Text = fillPlaceholders(def.Text, headerParams)
+			} else if len(headerParams) > 0 {
+				if media := headerMedia(headerParams[0]); media != nil {
+					header.MediaID = media.ID
+					header.MediaLink = media.Link
+				}
+			}
+			out.Header = header
+
+		case "BODY":
+			out.Body = fillPlaceholders(def.Text, bodyParams)
+
+		case "FOOTER":
+			out.Footer = def.Text
+
+		case "BUTTONS":
+			for i, btn := range def.Buttons {
+				rb := RenderedButton{Index: i, Type: strings.ToUpper(btn.Type), Text: btn.Text, PhoneNumber: btn.PhoneNumber}
+				p, hasParam := buttonParams[i]
+				switch rb.Type {
+				case "URL":
+					rb.URL = btn.URL
+					if hasParam {
+						rb.URL = placeholderPattern.ReplaceAllString(btn.URL, p.Text)
+					}
+				case "QUICK_REPLY":
+					rb.Payload = p.Payload
+				case "COPY_CODE":
+					rb.CouponCode = p.CouponCode
+				}
+				out.Buttons = append(out.Buttons, rb)
+			}
+		}
+	}
+
+	out.Text = out.plainText()
+	out.Valid = true
+	for _, issue := range out.Issues {
+		if issue.Code != "unused_parameter" {
+			out.Valid = false
+			break
+		}
+	}
+	if out.Issues == nil {
+		out.Issues = []TemplateIssue{}
+	}
+
+	return out, nil
+}
+
+func (r *RenderedTemplate) plainText() string {
+	var parts []string
+	if r.Header != nil {
+		if r.Header.Format == "TEXT" {
+			if r.Header.Text != "" {
+				parts = append(parts, r.Header.Text)
+			}
+		} else {
+			ref := r.Header.MediaID
+			if ref == "" {
+				ref = r.Header.MediaLink
+			}
+			parts = append(parts, fmt.Sprintf("[%s]:%s", strings.ToLower(r.Header.Format), ref))
+		}
+	}
+	if r.Body != "" {
+		parts = append(parts, r.Body)
+	}
+	if r.Footer != "" {
+		parts = append(parts, r.Footer)
+	}
+	if len(r.Buttons) > 0 {
+		labels := []string{}
+		for _, b := range r.Buttons {
+			switch b.Type {
+			case "URL":
+				labels = append(labels, fmt.Sprintf("[%s] %s", b.Text, b.URL))
+			case "PHONE_NUMBER":
+				labels = append(labels, fmt.Sprintf("[%s] %s", b.Text, b.PhoneNumber))
+			default:
+				labels = append(labels, fmt.Sprintf("[%s]", b.Text))
+			}
+		}
+		parts = append(parts, strings.Join(labels, "\n"))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// fillPlaceholders substitutes bound parameters into a component's text.
+// Positional parameters are consumed in placeholder order, named ones by name.
+func fillPlaceholders(text string, params []ParameterObj) string {
+	named := map[string]string{}
+	var positional []string
+	for _, p := range params {
+		if p.ParameterName != "" {
+			named[p.ParameterName] = p.Text
+		} else {
+			positional = append(positional, p.Text)
+		}
+	}
+
+	indexes, _ := placeholderKeys(text)
+	order := map[string]int{}
+	for pos, idx := range indexes {
+		order[strconv.Itoa(idx)] = pos
+	}
+
+	return placeholderPattern.ReplaceAllStringFunc(text, func(match string) string {
+		key := placeholderPattern.FindStringSubmatch(match)[1]
+		if pos, ok := order[key]; ok {
+			if pos < len(positional) && positional[pos] != "" {
+				return positional[pos]
+			}
+			return match
+		}
+		if v, ok := named[key]; ok && v != "" {
+			return v
+		}
+		return match
+	})
+}
+
+// placeholderKeys returns the distinct positional indexes (sorted) and
+// named keys (in order of appearance) used in text
+func placeholderKeys(text string) ([]int, []string) {
+	var indexes []int
+	var names []string
+	seen := map[string]bool{}
+	for _, m := range placeholderPattern.FindAllStringSubmatch(text, -1) {
+		key := m[1]
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if idx, err := strconv.Atoi(key); err == nil {
+			indexes = append(indexes, idx)
+		} else {
+			names = append(names, key)
+		}
+	}
+	sort.Ints(indexes)
+	return indexes, names
+}
+
+func headerMedia(p ParameterObj) *MediaObj {
+	switch {
+	case p.Image != nil:
+		return p.Image
+	case p.Video != nil:
+		return p.Video
+	case p.Document != nil:
+		return p.Document
+	}
+	return nil
+}
@@ -31,8 +31,8 @@ type ScheduledMessage struct {
 	MessageContent string `json:"message_content"`
 	TemplateID     string `json:"template_id"`
 	ScheduledTime  string `json:"scheduled_time"`
-	Recurrence     string `json:"recurrence"` // once, daily, weekly, monthly
-	Status         string `json:"status"`     // pending, sent, failed, cancelled
+	Recurrence     string `json:"recurrence"` // once, daily, weekly, monthly or a cron expression
+	Status         string `json:"status"`     // pending, sending, sent, failed, missed
 	SentAt         string `json:"sent_at"`
 	CreatedAt      string `json:"created_at"`
 }
@@ -24,6 +24,8 @@ type WebhookPayload struct {
 					Audio       *MediaMessage       `json:"audio,omitempty"`
 					Document    *MediaMessage       `json:"document,omitempty"`
 					Interactive *InteractiveMessage `json:"interactive,omitempty"`
+					Button      *ButtonMessage      `json:"button,omitempty"`  // Template quick reply clicks
+					Context     *MessageContext     `json:"context,omitempty"` // The message this one replies to
 					Type        string              `json:"type"`
 				} `json:"messages,omitempty"`
 				Statuses []struct {
@@ -31,6 +33,20 @@ type WebhookPayload struct {
 					Status      string `json:"status"`
 					Timestamp   string `json:"timestamp"`
 					RecipientId string `json:"recipient_id"`
+					// Conversation and Pricing are sent with sent/delivered statuses
+					Conversation *struct {
+						ID                  string `json:"id"`
+						ExpirationTimestamp string `json:"expiration_timestamp,omitempty"`
+						Origin              struct {
+							Type string `json:"type"`
+						} `json:"origin"`
+					} `json:"conversation,omitempty"`
+					Pricing *struct {
+						Billable     bool   `json:"billable"`
+						PricingModel string `json:"pricing_model"`
+						Category     string `json:"category"`
+					} `json:"pricing,omitempty"`
+					Errors []StatusError `json:"errors,omitempty"` // Sent with failed statuses
 				} `json:"statuses,omitempty"`
 			} `json:"value"`
 			Field string `json:"field"`
@@ -47,6 +63,28 @@ type MediaMessage struct {
 	Filename string `json:"filename,omitempty"`
 }
 
+// ButtonMessage is a click on a template's quick reply button
+type ButtonMessage struct {
+	Payload string `json:"payload"`
+	Text    string `json:"text"`
+}
+
+// MessageContext identifies the message an incoming message replies to
+type MessageContext struct {
+	From string `json:"from"`
+	ID   string `json:"id"`
+}
+
+// StatusError explains why a message could not be delivered
+type StatusError struct {
+	Code      int    `json:"code"`
+	Title     string `json:"title"`
+	Message   string `json:"message"`
+	ErrorData struct {
+		Details string `json:"details"`
+	} `json:"error_data"`
+}
+
 // InteractiveMessage represents an interactive message response (buttons, flows)
 type InteractiveMessage struct {
 	Type        string       `json:"type"`